@@ -0,0 +1,104 @@
+package auditlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+)
+
+const (
+	ResultApplied = "applied"
+	ResultFailed  = "failed"
+	ResultAborted = "aborted"
+)
+
+// Record describes one privileged change made through shctl.
+type Record struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	UID        int       `json:"uid"`
+	Subsystem  string    `json:"subsystem"`
+	Target     string    `json:"target"`
+	Changes    []string  `json:"changes,omitempty"`
+	DiffSHA256 string    `json:"diff_sha256"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// Sink delivers records to the system log. Tests replace it.
+var Sink = systemLog
+
+// New builds a record for a change to target described by a unified diff.
+// The changed lines are kept so the record says which rule was touched.
+func New(subsystem, target, diff string) Record {
+	sum := sha256.Sum256([]byte(diff))
+	r := Record{
+		Time:       time.Now().UTC(),
+		Actor:      actor(),
+		UID:        os.Getuid(),
+		Subsystem:  subsystem,
+		Target:     target,
+		DiffSHA256: hex.EncodeToString(sum[:]),
+	}
+	for _, l := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(l, "+++") || strings.HasPrefix(l, "---") {
+			continue
+		}
+		// blank lines carry no rule
+		if (strings.HasPrefix(l, "+") || strings.HasPrefix(l, "-")) && strings.TrimSpace(l[1:]) != "" {
+			r.Changes = append(r.Changes, l)
+		}
+	}
+	return r
+}
+
+// actor is the human behind the change: the sudo/doas caller when
+// escalated, otherwise the user of the real uid. The caller variables are
+// only believed when running as root, as sudo and doas set them; anyone
+// else could set them to blame another user.
+func actor() string {
+	if os.Geteuid() == 0 {
+		for _, env := range []string{"SUDO_USER", "DOAS_USER"} {
+			if v := os.Getenv(env); v != "" {
+				return v
+			}
+		}
+	}
+	if u, err := user.LookupId(fmt.Sprint(os.Getuid())); err == nil {
+		return u.Username
+	}
+	return fmt.Sprint(os.Getuid())
+}
+
+// Finish sets the result from err and sends the record to Sink.
+func (r *Record) Finish(err error, aborted bool) error {
+	switch {
+	case aborted:
+		r.Result = ResultAborted
+	case err != nil:
+		r.Result, r.Error = ResultFailed, err.Error()
+	default:
+		r.Result = ResultApplied
+	}
+	return Sink(*r)
+}
+
+// Message is the one-line human summary of r.
+func (r Record) Message() string {
+	msg := fmt.Sprintf("%s %s change to %s by %s (uid %d, diff sha256 %s)",
+		r.Result, r.Subsystem, r.Target, r.Actor, r.UID, r.DiffSHA256[:12])
+	if r.Error != "" {
+		msg += ": " + r.Error
+	}
+	return msg
+}
+
+func (r Record) json() string {
+	b, _ := json.Marshal(r)
+	return string(b)
+}
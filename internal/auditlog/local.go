@@ -0,0 +1,296 @@
+package auditlog
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Entry is one mutating shctl command in the local operation log.
+type Entry struct {
+	Time       time.Time `json:"time"`
+	Actor      string    `json:"actor"`
+	UID        int       `json:"uid"`
+	Command    string    `json:"command"`
+	Files      []string  `json:"files,omitempty"`
+	DiffSHA256 string    `json:"diff_sha256,omitempty"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// current is the command being run, between Start and Done.
+var current *command
+
+type command struct {
+	entry  Entry
+	before map[string][]byte // nil for a file that did not exist
+}
+
+// LogPath is the local operation log, one JSON object per line. shctl only
+// ever appends to it.
+func LogPath() string {
+	return filepath.Join(util.StateDir(), "operations.log")
+}
+
+// Start begins logging the mutating command args. The root command calls
+// it before running the command and Done after.
+func Start(args []string) {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		quoted[i] = util.ShellQuote(a)
+	}
+	current = &command{
+		entry:  Entry{Time: time.Now().UTC(), Actor: actor(), UID: os.Getuid(), Command: strings.Join(quoted, " ")},
+		before: map[string][]byte{},
+	}
+}
+
+// Touch records that the running command is about to write path, whose
+// content is before (nil when it does not exist). Only the first call for
+// a path counts.
+func Touch(path string, before []byte) {
+	if current == nil {
+		return
+	}
+	if _, ok := current.before[path]; ok {
+		return
+	}
+	current.before[path] = before
+	current.entry.Files = append(current.entry.Files, path)
+}
+
+// Done finishes the running command with err, or as aborted, and appends
+// it to the log. The diff hash covers the changes made to every touched
+// file. Nothing is logged in a dry run.
+func Done(err error, aborted bool) error {
+	c := current
+	current = nil
+	if c == nil || !fsys.IsOS() {
+		return nil
+	}
+	e := c.entry
+	switch {
+	case aborted:
+		e.Result = ResultAborted
+	case err != nil:
+		e.Result, e.Error = ResultFailed, err.Error()
+	default:
+		e.Result = ResultApplied
+	}
+	if len(e.Files) > 0 {
+		h := sha256.New()
+		sums := map[string]string{}
+		for _, p := range e.Files {
+			after, err := fsys.Current.ReadFile(p)
+			sums[p] = ""
+			if err == nil {
+				sums[p] = checksum(after)
+			}
+			io.WriteString(h, util.UnifiedDiff(p, p, c.before[p], after))
+		}
+		e.DiffSHA256 = hex.EncodeToString(h.Sum(nil))
+		if err := recordSeen(sums, e.Time); err != nil {
+			return err
+		}
+	}
+	return appendEntry(e)
+}
+
+// Seen is how the last shctl command that wrote a file left it.
+type Seen struct {
+	SHA256 string    `json:"sha256"` // empty when it removed the file
+	Time   time.Time `json:"time"`
+}
+
+func seenPath() string {
+	return filepath.Join(util.StateDir(), "files.json")
+}
+
+func checksum(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func readSeen() (map[string]Seen, error) {
+	out := map[string]Seen{}
+	b, err := os.ReadFile(seenPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", seenPath(), err)
+	}
+	return out, nil
+}
+
+// recordSeen stores the checksums sums of files a command wrote at t.
+func recordSeen(sums map[string]string, t time.Time) error {
+	if err := os.MkdirAll(util.StateDir(), 0o700); err != nil {
+		return err
+	}
+	unlock, err := util.Lock(seenPath()+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	all, err := readSeen()
+	if err != nil {
+		return err
+	}
+	for p, sum := range sums {
+		all[p] = Seen{sum, t}
+	}
+	b, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.OS.WriteFile(seenPath(), append(b, '\n'), 0o600)
+}
+
+// EditedOutside reports whether path changed since the last logged shctl
+// command that wrote it, and when that was. known is false when no
+// logged command wrote path.
+func EditedOutside(path string) (edited, known bool, last Seen, err error) {
+	all, err := readSeen()
+	if err != nil {
+		return false, false, Seen{}, err
+	}
+	last, known = all[path]
+	if !known {
+		return false, false, Seen{}, nil
+	}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return last.SHA256 != "", true, last, nil
+	case err != nil:
+		return false, true, last, err
+	}
+	return checksum(b) != last.SHA256, true, last, nil
+}
+
+func appendEntry(e Entry) error {
+	if err := os.MkdirAll(util.StateDir(), 0o700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(LogPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Filter selects log entries; zero fields match everything.
+type Filter struct {
+	Since  time.Time
+	Until  time.Time
+	File   string // a touched file
+	Actor  string
+	Result string
+	Match  string // substring of the command line
+}
+
+func (f Filter) matches(e Entry) bool {
+	switch {
+	case !f.Since.IsZero() && e.Time.Before(f.Since),
+		!f.Until.IsZero() && e.Time.After(f.Until),
+		f.Actor != "" && e.Actor != f.Actor,
+		f.Result != "" && e.Result != f.Result,
+		f.Match != "" && !strings.Contains(e.Command, f.Match):
+		return false
+	}
+	if f.File == "" {
+		return true
+	}
+	for _, p := range e.Files {
+		if p == f.File {
+			return true
+		}
+	}
+	return false
+}
+
+// Entries returns the logged commands that match f, oldest first.
+func Entries(f Filter) ([]Entry, error) {
+	file, err := os.Open(LogPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var out []Entry
+	sc := bufio.NewScanner(file)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	n := 0
+	for sc.Scan() {
+		n++
+		var e Entry
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return out, fmt.Errorf("%s:%d: %w", LogPath(), n, err)
+		}
+		if f.matches(e) {
+			out = append(out, e)
+		}
+	}
+	return out, sc.Err()
+}
+
+// WriteEntries prints entries as a table, or as JSON or YAML, narrowed
+// and ordered by output.List on their commands, first files and times.
+func WriteEntries(w io.Writer, format string, entries []Entry) error {
+	idx, err := output.List.Apply(len(entries), func(i int) output.Key {
+		k := output.Key{Name: entries[i].Command, Mtime: entries[i].Time}
+		if len(entries[i].Files) > 0 {
+			k.File = entries[i].Files[0]
+		}
+		return k
+	})
+	if err != nil {
+		return err
+	}
+	all := entries
+	entries = make([]Entry, len(idx))
+	for i, j := range idx {
+		entries[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, entries)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tUSER\tRESULT\tCOMMAND\tFILES")
+	for _, e := range entries {
+		result := e.Result
+		if e.Error != "" {
+			result += ": " + e.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", e.Time.Local().Format("2006-01-02 15:04:05"), e.Actor, result, e.Command, strings.Join(e.Files, ","))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,72 @@
+//go:build unix
+
+package auditlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log/syslog"
+	"net"
+	"strconv"
+	"strings"
+)
+
+var journalSocket = "/run/systemd/journal/socket"
+
+// systemLog sends r to journald with structured fields, falling back to
+// syslog (authpriv) with the record as JSON.
+func systemLog(r Record) error {
+	if err := journal(r); err == nil {
+		return nil
+	}
+	prio := syslog.LOG_AUTHPRIV | syslog.LOG_NOTICE
+	if r.Result == ResultFailed {
+		prio = syslog.LOG_AUTHPRIV | syslog.LOG_ERR
+	}
+	w, err := syslog.New(prio, "shctl")
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	_, err = w.Write([]byte(r.Message() + " " + r.json()))
+	return err
+}
+
+func journal(r Record) error {
+	conn, err := net.Dial("unixgram", journalSocket)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	prio := "5"
+	if r.Result == ResultFailed {
+		prio = "3"
+	}
+	var buf bytes.Buffer
+	field := func(k, v string) {
+		if !strings.Contains(v, "\n") {
+			buf.WriteString(k + "=" + v + "\n")
+			return
+		}
+		// multi-line values use the binary length-prefixed form
+		buf.WriteString(k + "\n")
+		binary.Write(&buf, binary.LittleEndian, uint64(len(v)))
+		buf.WriteString(v + "\n")
+	}
+	field("MESSAGE", r.Message())
+	field("PRIORITY", prio)
+	field("SYSLOG_IDENTIFIER", "shctl")
+	field("SYSLOG_FACILITY", "10")
+	field("SHCTL_ACTOR", r.Actor)
+	field("SHCTL_UID", strconv.Itoa(r.UID))
+	field("SHCTL_SUBSYSTEM", r.Subsystem)
+	field("SHCTL_TARGET", r.Target)
+	field("SHCTL_CHANGES", strings.Join(r.Changes, "\n"))
+	field("SHCTL_DIFF_SHA256", r.DiffSHA256)
+	field("SHCTL_RESULT", r.Result)
+	if r.Error != "" {
+		field("SHCTL_ERROR", r.Error)
+	}
+	_, err = conn.Write(buf.Bytes())
+	return err
+}
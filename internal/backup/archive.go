@@ -0,0 +1,177 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+)
+
+// archiveCompression picks the archive compression from its name:
+// .tar.zst, .tar.gz or .tgz, or a plain .tar.
+func archiveCompression(path string) (string, error) {
+	switch {
+	case strings.HasSuffix(path, ".tar.zst"):
+		return CompressZstd, nil
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return CompressGzip, nil
+	case strings.HasSuffix(path, ".tar"):
+		return CompressNone, nil
+	}
+	return "", fmt.Errorf("%s: archive name must end in .tar.zst, .tar.gz, .tgz or .tar", path)
+}
+
+// Export writes every object in s (backups, sidecars, snapshots and the
+// manifest) to one archive at path and returns how many it wrote.
+func Export(ctx context.Context, s Store, path string) (int, error) {
+	c, err := archiveCompression(path)
+	if err != nil {
+		return 0, err
+	}
+	objs, err := s.List(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, o := range objs {
+		data, err := s.Get(ctx, o.Name)
+		if err != nil {
+			return 0, err
+		}
+		h := &tar.Header{Name: o.Name, Mode: 0o600, Size: int64(len(data)), ModTime: o.ModTime}
+		if err := tw.WriteHeader(h); err != nil {
+			return 0, err
+		}
+		if _, err := tw.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return 0, err
+	}
+	data, err := compress(ctx, c, buf.Bytes())
+	if err != nil {
+		return 0, err
+	}
+	return len(objs), os.WriteFile(path, data, 0o600)
+}
+
+// Import adds the objects in the archive at path to s and merges the
+// archive's manifest into the existing one. Objects s already has are
+// kept; imported backups whose names clash with different local ones are
+// renamed. It returns the number of objects added.
+func Import(ctx context.Context, s Store, path string) (int, error) {
+	c, err := archiveCompression(path)
+	if err != nil {
+		return 0, err
+	}
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	data, err := decompress(ctx, c, raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", path, err)
+	}
+	entries := map[string][]byte{}
+	names := []string{}
+	tr := tar.NewReader(bytes.NewReader(data))
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			continue
+		}
+		if h.Name == "" || strings.ContainsAny(h.Name, `/\`) || h.Name == "." || h.Name == ".." {
+			return 0, fmt.Errorf("%s: refusing archive entry %q", path, h.Name)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return 0, fmt.Errorf("%s: %w", path, err)
+		}
+		entries[h.Name] = b
+		names = append(names, h.Name)
+	}
+
+	unlock, err := lockManifest(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	defer unlock()
+	m, err := ReadManifest(ctx, s)
+	if err != nil {
+		return 0, err
+	}
+	var im Manifest
+	if b, ok := entries[ManifestName]; ok {
+		if err := json.Unmarshal(b, &im); err != nil {
+			return 0, fmt.Errorf("%s: %s: %w", path, ManifestName, err)
+		}
+	}
+	renamed := map[string]string{}
+	for name, r := range im {
+		if m.has(r) {
+			continue
+		}
+		to := uniqueName(name, func(n string) bool { _, taken := m[n]; return taken })
+		m[to], renamed[name] = r, to
+	}
+
+	added := 0
+	for _, name := range names {
+		to := name
+		if backup, ok := strings.CutSuffix(name, metaSuffix); ok {
+			if r, ok := renamed[backup]; ok {
+				to = r + metaSuffix
+			}
+		}
+		if name == ManifestName {
+			continue
+		}
+		if _, err := s.Get(ctx, to); err == nil {
+			continue
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			return added, err
+		}
+		if err := s.Put(ctx, to, entries[name]); err != nil {
+			return added, err
+		}
+		added++
+	}
+	if err := writeManifest(ctx, s, m); err != nil {
+		return added, err
+	}
+	return added, Commit(ctx, s, fmt.Sprintf("import: %d object(s) from %s", added, path))
+}
+
+// has reports whether m records the same backup as r under any name.
+func (m Manifest) has(r Record) bool {
+	for _, have := range m {
+		if have.Source == r.Source && have.Time.Equal(r.Time) && have.ContentSHA256 == r.ContentSHA256 {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueName returns name, or name with a -2, -3, ... suffix before its
+// compression extension, whichever is not taken yet.
+func uniqueName(name string, taken func(string) bool) string {
+	base, c := splitCompression(name)
+	for n := 2; taken(name); n++ {
+		name = fmt.Sprintf("%s-%d%s", base, n, compressExt[c])
+	}
+	return name
+}
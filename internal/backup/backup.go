@@ -0,0 +1,203 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// TimeFormat is the timestamp suffix of backup file names
+// (<file>.bak.<time>).
+const TimeFormat = "20060102_150405"
+
+// Info describes one backup. IDs number backups newest first. Backups
+// with identical content share one stored object; SameAs lists the IDs of
+// the others.
+type Info struct {
+	ID     int       `json:"id"`
+	Name   string    `json:"name"`
+	Object string    `json:"object"` // stored object holding the content
+	Path   string    `json:"path"`
+	Source string    `json:"source"`
+	Op     string    `json:"op,omitempty"`
+	Time   time.Time `json:"time"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"` // of the stored, possibly compressed, file
+
+	Compression string `json:"compression"`
+	SameAs      []int  `json:"same_as,omitempty"`
+	Meta        *Meta  `json:"meta,omitempty"`
+	Pinned      bool   `json:"pinned,omitempty"`
+
+	content string // checksum identifying equal content
+	legacy  bool   // a .bak file without a manifest record
+}
+
+func Dir() string {
+	return config.Get("backup_dir")
+}
+
+// sources maps backup base names to the managed files they came from.
+func sources() map[string]string {
+	out := map[string]string{}
+	for _, key := range []string{"rc_file", "sudoers_file", "doas_file"} {
+		if p := config.Get(key); p != "" {
+			out[filepath.Base(p)] = p
+		}
+	}
+	return out
+}
+
+// List returns the backups in s, newest first: those recorded in the
+// manifest plus older .bak files written before it existed.
+func List(ctx context.Context, s Store) ([]Info, error) {
+	objs, err := s.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := ReadManifest(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	out := []Info{}
+	for name, r := range manifest {
+		obj := r.object(name)
+		_, c := splitCompression(obj)
+		out = append(out, Info{
+			Name: name, Object: obj, Path: s.Location(obj), Source: r.Source, Op: r.Op, Time: r.Time,
+			Size: r.Size, SHA256: r.SHA256, Compression: c, Pinned: r.Pinned, content: r.ContentSHA256,
+		})
+	}
+	src := sources()
+	for _, o := range objs {
+		i := strings.LastIndex(o.Name, ".bak.")
+		if _, recorded := manifest[o.Name]; recorded || i < 0 || strings.HasPrefix(o.Name, blobPrefix) ||
+			strings.HasSuffix(o.Name, metaSuffix) {
+			continue
+		}
+		base, stamp := o.Name[:i], o.Name[i+len(".bak."):]
+		stamp, c := splitCompression(stamp)
+		t, err := time.ParseInLocation(TimeFormat, stamp, time.Local)
+		if err != nil {
+			t = o.ModTime
+		}
+		data, err := s.Get(ctx, o.Name)
+		if err != nil {
+			return nil, err
+		}
+		source := src[base]
+		if source == "" {
+			source = base
+		}
+		sum := sha256Hex(data)
+		out = append(out, Info{
+			Name: o.Name, Object: o.Name, Path: s.Location(o.Name), Source: source, Time: t,
+			Size: o.Size, SHA256: sum, Compression: c, content: c + ":" + sum, legacy: true,
+		})
+	}
+	sort.SliceStable(out, func(i, j int) bool {
+		if !out[i].Time.Equal(out[j].Time) {
+			return out[i].Time.After(out[j].Time)
+		}
+		return out[i].Name < out[j].Name
+	})
+	byContent := map[string][]int{}
+	for i := range out {
+		if out[i].Meta, err = ReadMeta(ctx, s, out[i]); err != nil {
+			return nil, err
+		}
+		out[i].ID = i + 1
+		byContent[out[i].content] = append(byContent[out[i].content], i+1)
+	}
+	for i := range out {
+		for _, id := range byContent[out[i].content] {
+			if id != out[i].ID {
+				out[i].SameAs = append(out[i].SameAs, id)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Latest returns the newest backup of the file src. Backups recorded in
+// the manifest match on their source path, so files sharing a base name,
+// such as two crontabs, are told apart; older .bak files only have their
+// name to go by.
+func Latest(ctx context.Context, s Store, src string) (Info, error) {
+	list, err := List(ctx, s)
+	if err != nil {
+		return Info{}, err
+	}
+	prefix := filepath.Base(src) + ".bak."
+	for _, b := range list {
+		if b.Source == src && !b.legacy || b.legacy && strings.HasPrefix(b.Name, prefix) {
+			return b, nil
+		}
+	}
+	return Info{}, &NoBackupError{Source: src, Location: s.Location("")}
+}
+
+// ErrNoBackup matches a NoBackupError with errors.Is.
+var ErrNoBackup = util.ErrNoBackup
+
+// NoBackupError is returned when a file has no backup to restore.
+type NoBackupError struct {
+	Source   string
+	Location string // where the store keeps its backups
+}
+
+func (e *NoBackupError) Error() string {
+	return fmt.Sprintf("no backup of %s found in %s", filepath.Base(e.Source), e.Location)
+}
+
+func (e *NoBackupError) Is(target error) bool {
+	return target == ErrNoBackup || target == util.ErrNotFound
+}
+
+// Write prints backups as text, JSON or YAML, narrowed and ordered by
+// output.List on their names, sources and times.
+func Write(w io.Writer, format string, backups []Info) error {
+	idx, err := output.List.Apply(len(backups), func(i int) output.Key {
+		return output.Key{Name: backups[i].Name, File: backups[i].Source, Mtime: backups[i].Time}
+	})
+	if err != nil {
+		return err
+	}
+	all := backups
+	backups = make([]Info, len(idx))
+	for i, j := range idx {
+		backups[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, backups)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSOURCE\tTIME\tSIZE\tSHA256\tSAME AS\tBY\tOP")
+	for _, b := range backups {
+		same := make([]string, len(b.SameAs))
+		for i, id := range b.SameAs {
+			same[i] = strconv.Itoa(id)
+		}
+		by := ""
+		if b.Meta != nil {
+			by = b.Meta.User + "@" + b.Meta.Host
+		}
+		op := b.Op
+		if b.Pinned {
+			op += " (pinned)"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%s\t%s\t%s\t%s\n", b.ID, b.Source, b.Time.Format("2006-01-02 15:04:05"),
+			b.Size, b.SHA256[:12], strings.Join(same, ","), by, op)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,136 @@
+package backup
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+)
+
+const (
+	CompressNone = "none"
+	CompressGzip = "gzip"
+	CompressZstd = "zstd"
+)
+
+// compressExt maps a compression to the suffix added to backup names.
+var compressExt = map[string]string{CompressGzip: ".gz", CompressZstd: ".zst"}
+
+// Compression is the configured backup compression.
+func Compression() (string, error) {
+	c := strings.ToLower(config.Get("backup_compress"))
+	switch c {
+	case "", CompressNone:
+		return CompressNone, nil
+	case CompressGzip, CompressZstd:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown backup compression %q (want none, gzip or zstd)", c)
+}
+
+// splitCompression strips a compression suffix from a backup name.
+func splitCompression(name string) (string, string) {
+	for c, ext := range compressExt {
+		if base, ok := strings.CutSuffix(name, ext); ok {
+			return base, c
+		}
+	}
+	return name, CompressNone
+}
+
+// compress returns data encoded with c. zstd is not in the standard
+// library, so it goes through the zstd command.
+func compress(ctx context.Context, c string, data []byte) ([]byte, error) {
+	switch c {
+	case CompressGzip:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case CompressZstd:
+		return zstd(ctx, data, "-q", "-c")
+	}
+	return data, nil
+}
+
+func decompress(ctx context.Context, c string, data []byte) ([]byte, error) {
+	switch c {
+	case CompressGzip:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		return io.ReadAll(zr)
+	case CompressZstd:
+		return zstd(ctx, data, "-q", "-d", "-c")
+	}
+	return data, nil
+}
+
+func zstd(ctx context.Context, data []byte, args ...string) ([]byte, error) {
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "zstd", args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(data), &out, &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("zstd: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return out.Bytes(), nil
+}
+
+// Read returns the original content of backup b, decompressing it when
+// needed.
+func Read(ctx context.Context, s Store, b Info) ([]byte, error) {
+	data, err := s.Get(ctx, b.Object)
+	if err != nil {
+		return nil, fmt.Errorf("read backup %d (%s): %w", b.ID, b.Name, err)
+	}
+	out, err := decompress(ctx, b.Compression, data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", b.Path, err)
+	}
+	return out, nil
+}
+
+// Extract writes the original content of backup b to dst.
+func Extract(ctx context.Context, s Store, b Info, dst string) error {
+	data, err := Read(ctx, s, b)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(dst, data, 0o644)
+}
+
+// ExtractToTemp writes the original content of backup b to a new temp
+// file and returns its name.
+func ExtractToTemp(ctx context.Context, s Store, b Info) (string, error) {
+	data, err := Read(ctx, s, b)
+	if err != nil {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "shctl_restore_*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
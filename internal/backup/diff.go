@@ -0,0 +1,90 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// ByID returns the backup numbered id by List; 0 is the newest.
+func ByID(ctx context.Context, s Store, id int) (Info, error) {
+	list, err := List(ctx, s)
+	if err != nil {
+		return Info{}, err
+	}
+	if len(list) == 0 {
+		return Info{}, fmt.Errorf("no backups in %s", s.Location(""))
+	}
+	if id == 0 {
+		id = 1
+	}
+	if id < 1 || id > len(list) {
+		return Info{}, fmt.Errorf("no backup with id %d (have 1-%d)", id, len(list))
+	}
+	return list[id-1], nil
+}
+
+// Diff writes a unified diff from backup id (0 for the newest) to the live
+// file it was taken from. A deleted live file diffs against nothing.
+func Diff(ctx context.Context, w io.Writer, s Store, id int) error {
+	b, err := ByID(ctx, s, id)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(b.Source) {
+		return fmt.Errorf("backup %s does not belong to a managed file", b.Name)
+	}
+	old, err := Read(ctx, s, b)
+	if err != nil {
+		return err
+	}
+	cur, err := os.ReadFile(b.Source)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	d := util.UnifiedDiff(b.Name, b.Source, old, cur)
+	if d == "" {
+		_, err = fmt.Fprintf(w, "%s is unchanged since backup %d (%s)\n", b.Source, b.ID, b.Name)
+		return err
+	}
+	err = output.WriteDiff(w, d)
+	return err
+}
+
+// Announce tells w that backup b is being restored over dest, along with
+// the backup's metadata.
+func Announce(w io.Writer, b Info, dest string) error {
+	fmt.Fprintf(w, "restoring %s from backup %d (%s, %s)\n", dest, b.ID, b.Name, b.Time.Format("2006-01-02 15:04:05"))
+	return WriteMeta(w, b)
+}
+
+// Preview describes restoring backup b over dest without touching
+// anything: which file, from which backup, and the diff.
+func Preview(ctx context.Context, w io.Writer, s Store, b Info, dest string) error {
+	data, err := Read(ctx, s, b)
+	if err != nil {
+		return err
+	}
+	cur, err := os.ReadFile(dest)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	fmt.Fprintf(w, "would overwrite %s with backup %d (%s, %s)\n", dest, b.ID, b.Name, b.Time.Format("2006-01-02 15:04:05"))
+	if err := WriteMeta(w, b); err != nil {
+		return err
+	}
+	d := util.UnifiedDiff(dest, b.Name, cur, data)
+	if d == "" {
+		_, err = fmt.Fprintln(w, "no changes: the backup matches the current file")
+		return err
+	}
+	err = output.WriteDiff(w, d)
+	return err
+}
@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// HistoryKeep is how many operations the history holds.
+var HistoryKeep = 200
+
+// Operation is one mutating shctl operation, as seen by AutoSave: the
+// files it was about to write and their content before it did.
+type Operation struct {
+	ID     int         `json:"id"`
+	Run    string      `json:"run"` // the shctl process that made it
+	Time   time.Time   `json:"time"`
+	Op     string      `json:"op"`
+	Files  []FileState `json:"files"`
+	Undone bool        `json:"undone,omitempty"`
+}
+
+// FileState is a file's content before an operation.
+type FileState struct {
+	Path    string `json:"path"`
+	Existed bool   `json:"existed"`
+	// SHA256 of the previous content; empty when no backup was taken.
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// thisRun identifies this process, so the files one operation writes
+// group.
+var thisRun = strconv.Itoa(os.Getpid()) + "-" + strconv.FormatInt(time.Now().UnixNano(), 36)
+
+// HistoryPath is the file holding the operation history.
+func HistoryPath() string {
+	return filepath.Join(util.StateDir(), "history.json")
+}
+
+// Operations returns the recorded operations, oldest first.
+func Operations() ([]Operation, error) {
+	b, err := os.ReadFile(HistoryPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var ops []Operation
+	if err := json.Unmarshal(b, &ops); err != nil {
+		return nil, fmt.Errorf("%s: %w", HistoryPath(), err)
+	}
+	return ops, nil
+}
+
+// remember adds src's state to the current operation op. A new one is
+// started unless the previous entry is the same op of this run and has not
+// touched src yet.
+func remember(src, op string, st FileState) error {
+	if !fsys.IsOS() {
+		return nil
+	}
+	return updateHistory(func(ops []Operation) []Operation {
+		if n := len(ops); n > 0 && ops[n-1].Run == thisRun && ops[n-1].Op == op && !ops[n-1].Undone && !touches(ops[n-1], src) {
+			ops[n-1].Files = append(ops[n-1].Files, st)
+			return ops
+		}
+		id := 1
+		if len(ops) > 0 {
+			id = ops[len(ops)-1].ID + 1
+		}
+		ops = append(ops, Operation{ID: id, Run: thisRun, Time: time.Now().UTC(), Op: op, Files: []FileState{st}})
+		if len(ops) > HistoryKeep {
+			ops = ops[len(ops)-HistoryKeep:]
+		}
+		return ops
+	})
+}
+
+func touches(op Operation, path string) bool {
+	for _, f := range op.Files {
+		if f.Path == path {
+			return true
+		}
+	}
+	return false
+}
+
+// MarkUndone flags the operations ids as undone.
+func MarkUndone(ids ...int) error {
+	undone := map[int]bool{}
+	for _, id := range ids {
+		undone[id] = true
+	}
+	return updateHistory(func(ops []Operation) []Operation {
+		for i := range ops {
+			if undone[ops[i].ID] {
+				ops[i].Undone = true
+			}
+		}
+		return ops
+	})
+}
+
+// updateHistory rewrites the history under its lock.
+func updateHistory(fn func([]Operation) []Operation) error {
+	if err := os.MkdirAll(util.StateDir(), 0o700); err != nil {
+		return err
+	}
+	unlock, err := util.Lock(HistoryPath()+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	ops, err := Operations()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(fn(ops), "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.OS.WriteFile(HistoryPath(), append(b, '\n'), 0o600)
+}
+
+// changed holds what files had before this process first wrote them, for
+// WriteChanges; nil content means the file did not exist.
+var (
+	changed      = map[string][]byte{}
+	changedOrder []string
+)
+
+func noteChange(path string, before []byte) {
+	if _, ok := changed[path]; ok {
+		return
+	}
+	changed[path] = before
+	changedOrder = append(changedOrder, path)
+}
+
+// WriteChanges prints a diff of every file written since the last call,
+// from its content before the first write to what it holds now, and
+// forgets them. The root command calls it after a successful mutation
+// when show_diff is on.
+func WriteChanges(w io.Writer) error {
+	defer func() {
+		changed, changedOrder = map[string][]byte{}, nil
+	}()
+	for _, p := range changedOrder {
+		after, err := fsys.Current.ReadFile(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if err := output.WriteDiff(w, util.UnifiedDiff(p+" (before)", p, changed[p], after)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadState returns the content of st from the backup taken of it.
+func ReadState(ctx context.Context, s Store, st FileState) ([]byte, error) {
+	if st.SHA256 == "" {
+		return nil, fmt.Errorf("no backup of %s was taken (auto_backup was off)", st.Path)
+	}
+	list, err := List(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	for _, b := range list {
+		if b.Source == st.Path && b.content == st.SHA256 {
+			return Read(ctx, s, b)
+		}
+	}
+	return nil, &NoBackupError{Source: st.Path, Location: s.Location("")}
+}
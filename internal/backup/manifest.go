@@ -0,0 +1,225 @@
+package backup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// ManifestName is the store object recording a checksum for every backup.
+const ManifestName = "shctl-manifest.json"
+
+// Record is the manifest entry for one backup.
+type Record struct {
+	Source string    `json:"source"`
+	Op     string    `json:"op,omitempty"` // operation that took the backup
+	Time   time.Time `json:"time"`
+	Size   int64     `json:"size"`
+	SHA256 string    `json:"sha256"` // of the stored bytes
+	// ContentSHA256 is the checksum of the original, uncompressed file.
+	ContentSHA256 string `json:"content_sha256"`
+	// Object is the stored object holding the content, shared by backups
+	// with identical content. Empty means the object is named like the
+	// backup.
+	Object string `json:"object,omitempty"`
+	// Pinned backups are never pruned without --force.
+	Pinned bool `json:"pinned,omitempty"`
+}
+
+// blobPrefix names content-addressed objects: blob.<sha256>[.gz|.zst].
+const blobPrefix = "blob."
+
+func (r Record) object(name string) string {
+	if r.Object != "" {
+		return r.Object
+	}
+	return name
+}
+
+// latest returns the name of the newest recorded backup of src.
+func (m Manifest) latest(src string) (string, bool) {
+	best, found := "", false
+	for name, r := range m {
+		if r.Source == src && (!found || r.Time.After(m[best].Time)) {
+			best, found = name, true
+		}
+	}
+	return best, found
+}
+
+// Manifest maps backup names to their records.
+type Manifest map[string]Record
+
+// ReadManifest loads the manifest from s; a missing one is empty.
+func ReadManifest(ctx context.Context, s Store) (Manifest, error) {
+	m := Manifest{}
+	b, err := s.Get(ctx, ManifestName)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Location(ManifestName), err)
+	}
+	return m, nil
+}
+
+func writeManifest(ctx context.Context, s Store, m Manifest) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, ManifestName, append(b, '\n'))
+}
+
+// updateManifest applies fn to the manifest and writes it back, holding
+// the manifest's lock so concurrent runs do not drop each other's records.
+func updateManifest(ctx context.Context, s Store, fn func(Manifest) error) error {
+	unlock, err := lockManifest(ctx, s)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	m, err := ReadManifest(ctx, s)
+	if err != nil {
+		return err
+	}
+	if err := fn(m); err != nil {
+		return err
+	}
+	return writeManifest(ctx, s, m)
+}
+
+// lockManifest takes the lock every manifest update holds from reading
+// the manifest to writing it.
+func lockManifest(ctx context.Context, s Store) (func(), error) {
+	return util.LockTarget(ctx, s.Location(ManifestName))
+}
+
+// record adds a backup to the manifest.
+func record(ctx context.Context, s Store, name string, r Record) error {
+	return updateManifest(ctx, s, func(m Manifest) error {
+		m[name] = r
+		return nil
+	})
+}
+
+// Pin pins backup id, numbered by List, so pruning keeps it, or unpins it.
+func Pin(ctx context.Context, s Store, id int, pinned bool) error {
+	b, err := ByID(ctx, s, id)
+	if err != nil {
+		return err
+	}
+	return updateManifest(ctx, s, func(m Manifest) error {
+		r, ok := m[b.Name]
+		if !ok {
+			return fmt.Errorf("backup %d (%s) predates the manifest and cannot be pinned", id, b.Name)
+		}
+		r.Pinned = pinned
+		m[b.Name] = r
+		return nil
+	})
+}
+
+// forget drops backups from the manifest.
+func forget(ctx context.Context, s Store, names ...string) error {
+	return updateManifest(ctx, s, func(m Manifest) error {
+		for _, n := range names {
+			delete(m, n)
+		}
+		return nil
+	})
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+// Check is the verification result for one backup.
+type Check struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message"`
+}
+
+// Verify re-reads every backup in s and compares it with the manifest.
+// Backups that are missing, truncated, corrupted or do not decompress
+// fail; backups taken before the manifest existed only get the
+// decompression check.
+func Verify(ctx context.Context, s Store) ([]Check, error) {
+	list, err := List(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ReadManifest(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Check, 0, len(list))
+	for _, b := range list {
+		out = append(out, verifyOne(ctx, s, b, m))
+	}
+	return out, nil
+}
+
+func verifyOne(ctx context.Context, s Store, b Info, m Manifest) Check {
+	c := Check{Name: b.Name}
+	data, err := s.Get(ctx, b.Object)
+	if errors.Is(err, fs.ErrNotExist) {
+		c.Message = "stored object " + b.Path + " is missing"
+		return c
+	}
+	if err != nil {
+		c.Message = err.Error()
+		return c
+	}
+	content, derr := decompress(ctx, b.Compression, data)
+	r, ok := m[b.Name]
+	switch {
+	case !ok && derr != nil:
+		c.Message = "no checksum recorded and content is unreadable: " + derr.Error()
+	case !ok:
+		c.OK, c.Message = true, "no checksum recorded; content is readable"
+	case int64(len(data)) != r.Size:
+		c.Message = fmt.Sprintf("size %d, manifest says %d (truncated?)", len(data), r.Size)
+	case sha256Hex(data) != r.SHA256:
+		c.Message = "checksum mismatch (corrupted)"
+	case derr != nil:
+		c.Message = "cannot decompress: " + derr.Error()
+	case r.ContentSHA256 != "" && sha256Hex(content) != r.ContentSHA256:
+		c.Message = "decompressed content checksum mismatch"
+	default:
+		c.OK, c.Message = true, "ok"
+	}
+	return c
+}
+
+// WriteChecks prints verification results one per line, or as JSON or
+// YAML.
+func WriteChecks(w io.Writer, format string, checks []Check) error {
+	if output.Structured(format) {
+		return output.Write(w, format, checks)
+	}
+	for _, c := range checks {
+		status := "ok  "
+		if !c.OK {
+			status = "FAIL"
+		}
+		if _, err := fmt.Fprintf(w, "%s  %s: %s\n", status, c.Name, c.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
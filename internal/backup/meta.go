@@ -0,0 +1,106 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+)
+
+// metaSuffix names the JSON sidecar stored next to each backup.
+const metaSuffix = ".meta.json"
+
+// Meta is the sidecar describing where a backup came from.
+type Meta struct {
+	Host    string      `json:"host"`
+	User    string      `json:"user"`
+	Version string      `json:"version"` // of the shctl that took it
+	Commit  string      `json:"commit,omitempty"`
+	Source  string      `json:"source"`
+	Mode    fs.FileMode `json:"mode"`
+	Owner   string      `json:"owner,omitempty"` // user:group
+	Op      string      `json:"op"`
+	Time    time.Time   `json:"time"`
+}
+
+// newMeta describes a backup of src taken now by operation op.
+func newMeta(src, op string, now time.Time) Meta {
+	m := Meta{Version: config.Version, Commit: config.BuildInfo().Commit, Source: src, Op: op, Time: now}
+	m.Host, _ = os.Hostname()
+	if u, err := user.Current(); err == nil {
+		m.User = u.Username
+	}
+	if v := os.Getenv("SUDO_USER"); v != "" {
+		m.User = v
+	}
+	fi, err := fsys.Current.Stat(src)
+	if err != nil {
+		return m
+	}
+	m.Mode = fi.Mode().Perm()
+	if uid, gid, ok := fsys.FileOwner(fi); ok {
+		owner, group := strconv.Itoa(uid), strconv.Itoa(gid)
+		if u, err := user.LookupId(owner); err == nil {
+			owner = u.Username
+		}
+		if g, err := user.LookupGroupId(group); err == nil {
+			group = g.Name
+		}
+		m.Owner = owner + ":" + group
+	}
+	return m
+}
+
+func writeMeta(ctx context.Context, s Store, name string, m Meta) error {
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, name+metaSuffix, append(b, '\n'))
+}
+
+// ReadMeta loads the sidecar of backup b. Backups taken before sidecars
+// existed have none and return nil.
+func ReadMeta(ctx context.Context, s Store, b Info) (*Meta, error) {
+	data, err := s.Get(ctx, b.Name+metaSuffix)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var m Meta
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("%s: %w", s.Location(b.Name+metaSuffix), err)
+	}
+	return &m, nil
+}
+
+// WriteMeta prints the metadata of b, if it has any, as indented lines.
+func WriteMeta(w io.Writer, b Info) error {
+	m := b.Meta
+	if m == nil {
+		return nil
+	}
+	by := m.User
+	if m.Host != "" {
+		by += "@" + m.Host
+	}
+	file := []string{fmt.Sprintf("mode %04o", m.Mode)}
+	if m.Owner != "" {
+		file = append(file, "owner "+m.Owner)
+	}
+	_, err := fmt.Fprintf(w, "  taken by %s with shctl %s during %q\n  from %s (%s)\n",
+		by, m.Version, m.Op, m.Source, strings.Join(file, ", "))
+	return err
+}
@@ -0,0 +1,83 @@
+package backup
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// LegacyDir is where backups went before they moved under the state
+// directory.
+var LegacyDir = "/tmp"
+
+// defaultDir creates the default backup dir private to the user and, the
+// first time, moves over the backups this user left in LegacyDir. It
+// does nothing when backup_dir is configured explicitly.
+func defaultDir() (string, error) {
+	dir := Dir()
+	if e, _ := config.Explain("backup_dir"); e.Winner != "default" {
+		return dir, nil
+	}
+	_, err := os.Stat(dir)
+	if err == nil || !errors.Is(err, fs.ErrNotExist) {
+		return dir, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return dir, err
+	}
+	if err := migrate(LegacyDir, dir); err != nil {
+		return dir, fmt.Errorf("move backups from %s to %s: %w", LegacyDir, dir, err)
+	}
+	return dir, nil
+}
+
+// migrate moves shctl's backups, blobs, sidecars and manifest from old to
+// dir. Files owned by other users are left alone: anyone can write to
+// /tmp.
+func migrate(old, dir string) error {
+	entries, err := os.ReadDir(old)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	bases := sources()
+	for _, e := range entries {
+		name := e.Name()
+		base, _, isBackup := strings.Cut(name, ".bak.")
+		_, managed := bases[base]
+		if !e.Type().IsRegular() || !(name == ManifestName || strings.HasPrefix(name, blobPrefix) || isBackup && managed) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			return err
+		}
+		if uid, _, ok := fsys.FileOwner(fi); ok && uid != os.Getuid() {
+			continue
+		}
+		if err := move(filepath.Join(old, name), filepath.Join(dir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// move renames src to dst, copying across file systems.
+func move(src, dst string) error {
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	}
+	if err := util.CopyFilePreserve(src, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,251 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/shctl/internal/auditlog"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/prompt"
+)
+
+// Save stores src as <name>.bak.<time>, compressed as configured, in
+// the configured store and then prunes old backups according to
+// backup_keep. It returns where the backup went.
+func Save(ctx context.Context, src string) (string, error) {
+	return save(ctx, src, "backup")
+}
+
+// AutoSave takes the pre-change backup of src before operation op writes
+// it, unless auto_backup is off or src does not exist yet, and adds src
+// to op in the operation history and the running command's log entry.
+func AutoSave(ctx context.Context, src, op string) error {
+	on, err := strconv.ParseBool(config.Get("auto_backup"))
+	if err != nil {
+		return fmt.Errorf("auto_backup must be true or false, not %q", config.Get("auto_backup"))
+	}
+	st := FileState{Path: src}
+	raw, err := fsys.Current.ReadFile(src)
+	if err == nil || errors.Is(err, fs.ErrNotExist) {
+		auditlog.Touch(src, raw)
+		noteChange(src, raw)
+	}
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return fmt.Errorf("automatic backup before %s: %w", op, err)
+	default:
+		st.Existed = true
+		if on {
+			if _, err := save(ctx, src, op); err != nil {
+				return fmt.Errorf("automatic backup before %s: %w", op, err)
+			}
+			st.SHA256 = sha256Hex(raw)
+		}
+	}
+	if err := remember(src, op, st); err != nil {
+		return fmt.Errorf("record %s in the history: %w", op, err)
+	}
+	return nil
+}
+
+// errUnchanged stops save when the newest backup already matches.
+var errUnchanged = errors.New("backup unchanged")
+
+// save stores src content-addressed: the content goes to a blob shared by
+// every backup with the same content, and nothing is added at all when
+// the newest backup of src already matches.
+func save(ctx context.Context, src, op string) (string, error) {
+	s, err := Default(ctx)
+	if err != nil {
+		return "", err
+	}
+	c, err := Compression()
+	if err != nil {
+		return "", err
+	}
+	raw, err := fsys.Current.ReadFile(src)
+	if err != nil {
+		return "", err
+	}
+	// the manifest stays locked from choosing the name to recording it
+	content := sha256Hex(raw)
+	now := time.Now()
+	blob := blobPrefix + content + compressExt[c]
+	var name, same string
+	err = updateManifest(ctx, s, func(m Manifest) error {
+		if latest, ok := m.latest(src); ok && m[latest].ContentSHA256 == content {
+			same = s.Location(m[latest].object(latest))
+			return errUnchanged
+		}
+		rec := Record{Source: src, Op: op, Time: now, ContentSHA256: content, Object: blob}
+		for _, r := range m {
+			if r.Object == blob {
+				rec.Size, rec.SHA256 = r.Size, r.SHA256
+				break
+			}
+		}
+		if rec.SHA256 == "" {
+			data, err := compress(ctx, c, raw)
+			if err != nil {
+				return err
+			}
+			if err := s.Put(ctx, blob, data); err != nil {
+				return err
+			}
+			rec.Size, rec.SHA256 = int64(len(data)), sha256Hex(data)
+		}
+		// several backups in one second get -2, -3, ... suffixes
+		name = uniqueName(filepath.Base(src)+".bak."+now.Format(TimeFormat)+compressExt[c], func(n string) bool {
+			_, taken := m[n]
+			return taken
+		})
+		m[name] = rec
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return same, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if err := writeMeta(ctx, s, name, newMeta(src, op, now)); err != nil {
+		return s.Location(blob), fmt.Errorf("write backup metadata: %w", err)
+	}
+	if g, ok := s.(*gitStore); ok {
+		if err := g.track(ctx, src, raw); err != nil {
+			return s.Location(blob), err
+		}
+	}
+	if err := Commit(ctx, s, fmt.Sprintf("%s: %s", op, src)); err != nil {
+		return s.Location(blob), err
+	}
+	if _, err := AutoPrune(ctx); err != nil {
+		return s.Location(blob), fmt.Errorf("prune backups: %w", err)
+	}
+	return s.Location(blob), nil
+}
+
+// Keep is the configured number of backups retained per source; 0 turns
+// automatic pruning off.
+func Keep() (int, error) {
+	v := config.Get("backup_keep")
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("backup_keep must be a non-negative number, not %q", v)
+	}
+	return n, nil
+}
+
+// ConfiguredPolicy is backup_retention when set, otherwise the newest
+// backup_keep backups. A zero policy means no automatic pruning.
+func ConfiguredPolicy() (Policy, error) {
+	if v := config.Get("backup_retention"); v != "" {
+		return ParsePolicy(v)
+	}
+	keep, err := Keep()
+	return Policy{Last: keep}, err
+}
+
+// AutoPrune prunes the backup store by the configured policy.
+func AutoPrune(ctx context.Context) ([]Info, error) {
+	p, err := ConfiguredPolicy()
+	if err != nil || p.zero() {
+		return nil, err
+	}
+	s, err := Default(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return prune(ctx, s, p, false)
+}
+
+// Prune deletes all but the newest keep backups of each source file and
+// returns the ones removed.
+func Prune(ctx context.Context, s Store, keep int) ([]Info, error) {
+	if keep < 1 {
+		return nil, fmt.Errorf("refusing to prune down to %d backups", keep)
+	}
+	return PrunePolicy(ctx, s, Policy{Last: keep})
+}
+
+// PrunePolicy deletes the backups of each source file that p does not
+// keep, once the user confirms, and returns them. Pinned backups are kept
+// unless forced. Shared objects are only deleted once no kept backup
+// refers to them.
+func PrunePolicy(ctx context.Context, s Store, p Policy) ([]Info, error) {
+	return prune(ctx, s, p, true)
+}
+
+func prune(ctx context.Context, s Store, p Policy, confirm bool) ([]Info, error) {
+	if p.zero() {
+		return nil, fmt.Errorf("refusing to prune with a retention policy that keeps nothing")
+	}
+	list, err := List(ctx, s)
+	if err != nil {
+		return nil, err
+	}
+	bySource := map[string][]Info{}
+	order := []string{}
+	for _, b := range list {
+		if _, ok := bySource[b.Source]; !ok {
+			order = append(order, b.Source)
+		}
+		bySource[b.Source] = append(bySource[b.Source], b)
+	}
+	removed := []Info{}
+	inUse := map[string]bool{}
+	for _, src := range order {
+		backups := bySource[src]
+		keep := p.keep(backups)
+		for i, b := range backups {
+			if keep[i] || b.Pinned && !prompt.Force {
+				inUse[b.Object] = true
+			} else {
+				removed = append(removed, b)
+			}
+		}
+	}
+	if len(removed) == 0 {
+		return nil, nil
+	}
+	if confirm {
+		for _, b := range removed {
+			fmt.Fprintf(prompt.Out, "  %d  %s  %s\n", b.ID, b.Source, b.Time.Format("2006-01-02 15:04:05"))
+		}
+		ok, err := prompt.Confirm(fmt.Sprintf("Delete these %d backup(s)?", len(removed)))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, prompt.ErrAborted
+		}
+	}
+	names := make([]string, len(removed))
+	for i, b := range removed {
+		names[i] = b.Name
+	}
+	if err := forget(ctx, s, names...); err != nil {
+		return nil, err
+	}
+	deleted := map[string]bool{}
+	for _, b := range removed {
+		if err := s.Delete(ctx, b.Name+metaSuffix); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		if inUse[b.Object] || deleted[b.Object] {
+			continue
+		}
+		if err := s.Delete(ctx, b.Object); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return removed, err
+		}
+		deleted[b.Object] = true
+	}
+	return removed, Commit(ctx, s, fmt.Sprintf("prune: %d backup(s), keeping %s", len(removed), p))
+}
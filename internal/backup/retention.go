@@ -0,0 +1,119 @@
+package backup
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Policy is a grandfather-father-son retention policy. Each field keeps
+// the newest backup of that many distinct periods (or, for Last, that many
+// backups); a backup kept by any rule survives.
+type Policy struct {
+	Last    int
+	Hourly  int
+	Daily   int
+	Weekly  int
+	Monthly int
+	Yearly  int
+}
+
+// ParsePolicy reads "daily=7,weekly=4,monthly=12" style policies. A bare
+// number is the same as last=N.
+func ParsePolicy(s string) (Policy, error) {
+	var p Policy
+	if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+		p.Last = n
+		return p, p.check()
+	}
+	for _, part := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(strings.TrimSpace(part), "=")
+		n, err := strconv.Atoi(strings.TrimSpace(v))
+		if !ok || err != nil || n < 0 {
+			return p, fmt.Errorf("invalid retention rule %q (want e.g. daily=7)", part)
+		}
+		switch strings.TrimSpace(k) {
+		case "last":
+			p.Last = n
+		case "hourly":
+			p.Hourly = n
+		case "daily":
+			p.Daily = n
+		case "weekly":
+			p.Weekly = n
+		case "monthly":
+			p.Monthly = n
+		case "yearly":
+			p.Yearly = n
+		default:
+			return p, fmt.Errorf("unknown retention period %q (want last, hourly, daily, weekly, monthly or yearly)", k)
+		}
+	}
+	return p, p.check()
+}
+
+func (p Policy) check() error {
+	if p.Last < 0 {
+		return fmt.Errorf("retention counts must not be negative")
+	}
+	return nil
+}
+
+func (p Policy) zero() bool { return p == Policy{} }
+
+func (p Policy) String() string {
+	parts := []string{}
+	for _, r := range p.rules() {
+		if r.n > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", r.name, r.n))
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+type rule struct {
+	name string
+	n    int
+	key  func(time.Time) string
+}
+
+func (p Policy) rules() []rule {
+	return []rule{
+		{"last", p.Last, nil},
+		{"hourly", p.Hourly, func(t time.Time) string { return t.Format("2006-01-02T15") }},
+		{"daily", p.Daily, func(t time.Time) string { return t.Format("2006-01-02") }},
+		{"weekly", p.Weekly, func(t time.Time) string {
+			y, w := t.ISOWeek()
+			return fmt.Sprintf("%d-W%02d", y, w)
+		}},
+		{"monthly", p.Monthly, func(t time.Time) string { return t.Format("2006-01") }},
+		{"yearly", p.Yearly, func(t time.Time) string { return t.Format("2006") }},
+	}
+}
+
+// keep marks which of backups, sorted newest first, the policy retains.
+func (p Policy) keep(backups []Info) map[int]bool {
+	keep := map[int]bool{}
+	for _, r := range p.rules() {
+		if r.n == 0 {
+			continue
+		}
+		seen := map[string]bool{}
+		for i, b := range backups {
+			if r.key == nil {
+				if i < r.n {
+					keep[i] = true
+				}
+				continue
+			}
+			k := r.key(b.Time.Local())
+			if seen[k] || len(seen) >= r.n {
+				continue
+			}
+			seen[k] = true
+			keep[i] = true
+		}
+	}
+	return keep
+}
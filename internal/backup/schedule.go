@@ -0,0 +1,182 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// scheduleMarker tags the crontab line Schedule manages.
+const scheduleMarker = "# shctl:backup-schedule"
+
+const scheduleUnit = "shctl-snapshot"
+
+var cronSpecs = map[string]string{
+	"hourly": "0 * * * *",
+	"daily":  "30 3 * * *",
+	"weekly": "30 3 * * 0",
+}
+
+// ScheduleCron returns the crontab line running `shctl snapshot create`
+// at the given frequency (hourly, daily or weekly).
+func ScheduleCron(bin, every string) (string, error) {
+	spec, ok := cronSpecs[every]
+	if !ok {
+		return "", fmt.Errorf("unknown schedule %q (want hourly, daily or weekly)", every)
+	}
+	// cron turns an unescaped % into a newline
+	bin = strings.ReplaceAll(util.ShellQuote(bin), "%", `\%`)
+	return fmt.Sprintf("%s %s snapshot create %s", spec, bin, scheduleMarker), nil
+}
+
+// ScheduleSystemd returns a user service and timer running
+// `shctl snapshot create`. Persistent timers catch up after downtime.
+func ScheduleSystemd(bin, every string) (service, timer string, err error) {
+	if _, ok := cronSpecs[every]; !ok {
+		return "", "", fmt.Errorf("unknown schedule %q (want hourly, daily or weekly)", every)
+	}
+	service = fmt.Sprintf(`[Unit]
+Description=shctl snapshot of managed files
+
+[Service]
+Type=oneshot
+ExecStart=%s snapshot create
+`, util.ExecQuote(bin))
+	timer = fmt.Sprintf(`[Unit]
+Description=Take shctl snapshots %s
+
+[Timer]
+OnCalendar=%s
+Persistent=true
+
+[Install]
+WantedBy=timers.target
+`, every, every)
+	return service, timer, nil
+}
+
+func userUnitDir() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "systemd", "user")
+}
+
+// Schedule installs periodic snapshots as a user systemd timer or, when
+// systemd is false, a line in the user's crontab. Installing again
+// replaces the previous schedule.
+func Schedule(ctx context.Context, bin, every string, systemd bool) error {
+	if !systemd {
+		line, err := ScheduleCron(bin, every)
+		if err != nil {
+			return err
+		}
+		lines, err := crontabLines(ctx)
+		if err != nil {
+			return err
+		}
+		return writeCrontab(ctx, append(lines, line))
+	}
+	service, timer, err := ScheduleSystemd(bin, every)
+	if err != nil {
+		return err
+	}
+	dir := userUnitDir()
+	if err := fsys.Current.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	if err := fsys.Current.WriteFile(filepath.Join(dir, scheduleUnit+".service"), []byte(service), 0o644); err != nil {
+		return err
+	}
+	if err := fsys.Current.WriteFile(filepath.Join(dir, scheduleUnit+".timer"), []byte(timer), 0o644); err != nil {
+		return err
+	}
+	if err := run(ctx, "systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	return run(ctx, "systemctl", "--user", "enable", "--now", scheduleUnit+".timer")
+}
+
+// Unschedule removes what Schedule installed.
+func Unschedule(ctx context.Context, systemd bool) error {
+	if !systemd {
+		lines, err := crontabLines(ctx)
+		if err != nil {
+			return err
+		}
+		return writeCrontab(ctx, lines)
+	}
+	if err := run(ctx, "systemctl", "--user", "disable", "--now", scheduleUnit+".timer"); err != nil {
+		return err
+	}
+	for _, ext := range []string{".service", ".timer"} {
+		if err := fsys.Current.Remove(filepath.Join(userUnitDir(), scheduleUnit+ext)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return run(ctx, "systemctl", "--user", "daemon-reload")
+}
+
+// crontabLines returns the user's crontab without the managed line. The
+// other lines, blank ones included, are kept as they are.
+func crontabLines(ctx context.Context) ([]string, error) {
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "crontab", "-l")
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	if err := cmd.Run(); err != nil {
+		// an empty crontab is reported as an error
+		if !strings.Contains(stderr.String(), "no crontab") {
+			return nil, fmt.Errorf("crontab -l: %s: %w", strings.TrimSpace(stderr.String()), err)
+		}
+	}
+	lines := []string{}
+	if out.Len() == 0 {
+		return lines, nil
+	}
+	for _, l := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if !strings.HasSuffix(l, scheduleMarker) {
+			lines = append(lines, l)
+		}
+	}
+	return lines, nil
+}
+
+func writeCrontab(ctx context.Context, lines []string) error {
+	text := ""
+	if len(lines) > 0 {
+		text = strings.Join(lines, "\n") + "\n"
+	}
+	if dryrun.Skip("crontab", "-") {
+		return nil
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "crontab", "-")
+	cmd.Stdin, cmd.Stderr = strings.NewReader(text), &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("crontab: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return nil
+}
+
+func run(ctx context.Context, name string, args ...string) error {
+	if dryrun.Skip(append([]string{name}, args...)...) {
+		return nil
+	}
+	out, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %s: %w", name, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
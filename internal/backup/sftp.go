@@ -0,0 +1,157 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SFTP transfers are retried this many times, waiting SFTPBackoff and
+// then twice as long after each failure.
+var (
+	SFTPRetries = 3
+	SFTPBackoff = time.Second
+)
+
+// sftpStore copies backups to another machine with the OpenSSH sftp
+// client in batch mode. Host keys must already be in known_hosts;
+// unknown or changed keys are refused rather than accepted.
+type sftpStore struct {
+	dest string // [user@]host
+	port string
+	dir  string
+}
+
+func newSFTP(raw string) (Store, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("ssh backup URL needs a host: ssh://user@host/path")
+	}
+	dest := u.Hostname()
+	if u.User != nil && u.User.Username() != "" {
+		dest = u.User.Username() + "@" + dest
+	}
+	dir := u.Path
+	if dir == "" {
+		dir = "."
+	}
+	return &sftpStore{dest: dest, port: u.Port(), dir: dir}, nil
+}
+
+func (s *sftpStore) Location(name string) string {
+	host := s.dest
+	if s.port != "" {
+		host += ":" + s.port
+	}
+	return "ssh://" + host + path.Join("/", s.dir, name)
+}
+
+func (s *sftpStore) remote(name string) string { return path.Join(s.dir, name) }
+
+// batch runs sftp commands, retrying failed sessions.
+func (s *sftpStore) batch(ctx context.Context, cmds ...string) (string, error) {
+	args := []string{"-b", "-", "-o", "BatchMode=yes", "-o", "StrictHostKeyChecking=yes"}
+	if s.port != "" {
+		args = append(args, "-P", s.port)
+	}
+	args = append(args, s.dest)
+	script := strings.Join(cmds, "\n") + "\n"
+	wait := SFTPBackoff
+	var err error
+	for attempt := 1; ; attempt++ {
+		var out, stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "sftp", args...)
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = strings.NewReader(script), &out, &stderr
+		if err = cmd.Run(); err == nil {
+			return out.String(), nil
+		}
+		msg := strings.TrimSpace(stderr.String())
+		if strings.Contains(msg, "No such file") || strings.Contains(msg, "not found") {
+			// retrying will not make it appear
+			return "", fmt.Errorf("sftp %s: %s: %w", s.dest, msg, fs.ErrNotExist)
+		}
+		err = fmt.Errorf("sftp %s: %s: %w", s.dest, msg, err)
+		if attempt >= SFTPRetries {
+			return "", err
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+func sftpQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func (s *sftpStore) Put(ctx context.Context, name string, data []byte) error {
+	f, err := os.CreateTemp("", "shctl_sftp_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	// "-mkdir" ignores an existing directory
+	_, err = s.batch(ctx, "-mkdir "+sftpQuote(s.dir), "put "+sftpQuote(f.Name())+" "+sftpQuote(s.remote(name)))
+	return err
+}
+
+func (s *sftpStore) Get(ctx context.Context, name string) ([]byte, error) {
+	f, err := os.CreateTemp("", "shctl_sftp_*")
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+	if _, err := s.batch(ctx, "get "+sftpQuote(s.remote(name))+" "+sftpQuote(f.Name())); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(f.Name())
+}
+
+func (s *sftpStore) Delete(ctx context.Context, name string) error {
+	_, err := s.batch(ctx, "rm "+sftpQuote(s.remote(name)))
+	return err
+}
+
+// List parses `ls -ln` output; the sizes are what List needs, times come
+// from the backup names.
+func (s *sftpStore) List(ctx context.Context) ([]Object, error) {
+	out, err := s.batch(ctx, "-ls -ln "+sftpQuote(s.dir))
+	if err != nil {
+		return nil, err
+	}
+	objs := []Object{}
+	for _, line := range strings.Split(out, "\n") {
+		f := strings.Fields(line)
+		if len(f) < 9 || !strings.HasPrefix(f[0], "-") {
+			continue
+		}
+		size, err := strconv.ParseInt(f[4], 10, 64)
+		if err != nil {
+			continue
+		}
+		objs = append(objs, Object{Name: path.Base(f[len(f)-1]), Size: size})
+	}
+	return objs, nil
+}
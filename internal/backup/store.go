@@ -0,0 +1,132 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yourusername/shctl/internal/config"
+)
+
+// Object is one stored backup file.
+type Object struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Store holds backup files by name. Names are flat: <file>.bak.<time>.
+type Store interface {
+	Put(ctx context.Context, name string, data []byte) error
+	Get(ctx context.Context, name string) ([]byte, error)
+	List(ctx context.Context) ([]Object, error)
+	Delete(ctx context.Context, name string) error
+	// Location is a human-readable place for name, for messages.
+	Location(name string) string
+}
+
+// Default opens the store named by backup_url, or the local backup dir
+// when it is unset.
+func Default(ctx context.Context) (Store, error) {
+	return Open(ctx, config.Get("backup_url"))
+}
+
+// Open returns the store for a backup URL: a directory path, file://dir,
+// s3://bucket/prefix, ssh://user@host/path or git:///path/to/repo. An
+// empty URL is the local backup dir.
+func Open(ctx context.Context, url string) (Store, error) {
+	scheme, rest, ok := strings.Cut(url, "://")
+	switch {
+	case url == "":
+		dir, err := defaultDir()
+		if err != nil {
+			return nil, err
+		}
+		return Local(dir), nil
+	case !ok:
+		return Local(url), nil
+	case scheme == "file":
+		return Local(rest), nil
+	case scheme == "s3":
+		return newS3(rest)
+	case scheme == "ssh" || scheme == "sftp":
+		return newSFTP(url)
+	case scheme == "git":
+		return newGit(ctx, rest)
+	}
+	return nil, fmt.Errorf("unsupported backup URL %q (want a path, file://, s3://, ssh:// or git://)", url)
+}
+
+type localStore struct{ dir string }
+
+// putTempPrefix names the files Put writes before renaming them into place.
+const putTempPrefix = ".shctl-put-"
+
+// Local stores backups as files in dir.
+func Local(dir string) Store { return localStore{dir} }
+
+func (l localStore) Location(name string) string { return filepath.Join(l.dir, name) }
+
+func (l localStore) Put(ctx context.Context, name string, data []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(l.dir, 0o700); err != nil {
+		return err
+	}
+	// renamed into place, so a concurrent Get never sees half an object
+	f, err := os.CreateTemp(l.dir, putTempPrefix+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), l.Location(name))
+}
+
+func (l localStore) Get(ctx context.Context, name string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return os.ReadFile(l.Location(name))
+}
+
+func (l localStore) Delete(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return os.Remove(l.Location(name))
+}
+
+func (l localStore) List(ctx context.Context) ([]Object, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	ents, err := os.ReadDir(l.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := []Object{}
+	for _, e := range ents {
+		fi, err := e.Info()
+		if err != nil || !fi.Mode().IsRegular() || strings.HasPrefix(e.Name(), putTempPrefix) {
+			continue
+		}
+		out = append(out, Object{e.Name(), fi.Size(), fi.ModTime()})
+	}
+	return out, nil
+}
@@ -0,0 +1,473 @@
+// Package batch runs many shctl subcommands as one change, for
+// provisioning scripts that would otherwise start shctl once per entry.
+// The commands edit an in-memory overlay; the files they changed are then
+// backed up once and written in a single journaled transaction, so either
+// every command takes effect or none does. Lines removed by a batch are
+// not kept in the trash.
+package batch
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yourusername/shctl/internal/auditlog"
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/cron"
+	"github.com/yourusername/shctl/internal/dotsync"
+	"github.com/yourusername/shctl/internal/editor"
+	"github.com/yourusername/shctl/internal/envdir"
+	"github.com/yourusername/shctl/internal/envscope"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/gitconf"
+	"github.com/yourusername/shctl/internal/hosts"
+	"github.com/yourusername/shctl/internal/journal"
+	"github.com/yourusername/shctl/internal/link"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/profile"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/sshconf"
+	"github.com/yourusername/shctl/internal/sudoers"
+	"github.com/yourusername/shctl/internal/sysenv"
+	"github.com/yourusername/shctl/internal/tmux"
+	"github.com/yourusername/shctl/internal/undo"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Func runs one subcommand with its arguments.
+type Func func(ctx context.Context, args []string) error
+
+// Commands maps subcommand names, as typed after "shctl", to what they
+// run. The root command adds the rest of its mutating commands.
+var Commands = map[string]Func{
+	"alias add": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, 2, "NAME COMMAND"); err != nil {
+			return err
+		}
+		return rc.AddAlias(ctx, a[0], a[1])
+	},
+	"alias remove": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "NAME..."); err != nil {
+			return err
+		}
+		return rc.RemoveAliases(ctx, a)
+	},
+	"envdir set": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 3, 3, "DIR NAME VALUE"); err != nil {
+			return err
+		}
+		return envdir.Set(ctx, a[0], a[1], a[2])
+	},
+	"export add": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, 3, "NAME VALUE [SCOPE]"); err != nil {
+			return err
+		}
+		return envscope.AddExport(ctx, strings.Join(a[2:], ""), a[0], a[1])
+	},
+	"export add-secret": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, 2, "NAME BACKEND:REF"); err != nil {
+			return err
+		}
+		return rc.AddSecretExport(ctx, a[0], a[1], "shctl")
+	},
+	"export remove": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "NAME..."); err != nil {
+			return err
+		}
+		return rc.RemoveExports(ctx, a)
+	},
+	"cron add": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, 2, "SCHEDULE COMMAND"); err != nil {
+			return err
+		}
+		return cron.Add(ctx, a[0], a[1])
+	},
+	"cron remove": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, 1, "COMMAND"); err != nil {
+			return err
+		}
+		return cron.Remove(ctx, a[0])
+	},
+	"git alias add": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, 2, "NAME COMMAND"); err != nil {
+			return err
+		}
+		return gitconf.AddAlias(ctx, a[0], a[1])
+	},
+	"git alias remove": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "NAME..."); err != nil {
+			return err
+		}
+		return gitconf.RemoveAliases(ctx, a)
+	},
+	"git config set": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, 3, "KEY VALUE [DIR]"); err != nil {
+			return err
+		}
+		return gitconf.Set(ctx, a[0], a[1], strings.Join(a[2:], ""))
+	},
+	"gitignore add": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "PATTERN..."); err != nil {
+			return err
+		}
+		return gitconf.AddIgnore(ctx, a)
+	},
+	"gitignore remove": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "PATTERN..."); err != nil {
+			return err
+		}
+		return gitconf.RemoveIgnore(ctx, a)
+	},
+	"hosts add": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, -1, "IP HOSTNAME..."); err != nil {
+			return err
+		}
+		return hosts.Add(ctx, a[0], a[1:])
+	},
+	"hosts remove": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "IP [HOSTNAME...]"); err != nil {
+			return err
+		}
+		return hosts.Remove(ctx, a[0], a[1:])
+	},
+	"link add": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, 2, "SOURCE [TARGET]"); err != nil {
+			return err
+		}
+		return link.Add(ctx, a[0], strings.Join(a[1:], ""), false)
+	},
+	"link remove": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "TARGET..."); err != nil {
+			return err
+		}
+		return link.Remove(ctx, a)
+	},
+	"link apply": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 0, 0, "no arguments"); err != nil {
+			return err
+		}
+		return link.Apply(ctx, false)
+	},
+	"profile switch": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 0, 1, "[NAME]"); err != nil {
+			return err
+		}
+		return profile.Switch(ctx, strings.Join(a, ""))
+	},
+	"ssh host add": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "NAME [KEY=VALUE...]"); err != nil {
+			return err
+		}
+		return sshconf.Add(ctx, a[0], a[1:])
+	},
+	"ssh host edit": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, -1, "NAME KEY=VALUE..."); err != nil {
+			return err
+		}
+		return sshconf.Edit(ctx, a[0], a[1:])
+	},
+	"ssh host remove": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "NAME..."); err != nil {
+			return err
+		}
+		return sshconf.Remove(ctx, a)
+	},
+	"sudoers add": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "ENTRY"); err != nil {
+			return err
+		}
+		return sudoers.Add(ctx, strings.Join(a, " "))
+	},
+	"sudoers remove": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, 2, "USER COMMAND"); err != nil {
+			return err
+		}
+		return sudoers.Remove(ctx, a[0], a[1])
+	},
+	"sysenv set": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, 2, "NAME VALUE"); err != nil {
+			return err
+		}
+		return sysenv.Set(ctx, a[0], a[1])
+	},
+	"sysenv unset": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 1, -1, "NAME..."); err != nil {
+			return err
+		}
+		return sysenv.Unset(ctx, a)
+	},
+	"sync pull": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 0, 0, "no arguments"); err != nil {
+			return err
+		}
+		return dotsync.Pull(ctx)
+	},
+	"tmux option set": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, 2, "NAME VALUE"); err != nil {
+			return err
+		}
+		return tmux.SetOption(ctx, a[0], a[1])
+	},
+	"tmux bind add": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, -1, "KEY COMMAND..."); err != nil {
+			return err
+		}
+		return tmux.AddBind(ctx, "", a[0], strings.Join(a[1:], " "))
+	},
+	"editor set": func(ctx context.Context, a []string) error {
+		if err := nargs(a, 2, 2, "vim|nvim OPTION"); err != nil {
+			return err
+		}
+		return editor.Set(ctx, a[0], a[1])
+	},
+}
+
+// nargs checks that there are between min and max arguments; max < 0
+// means no limit.
+func nargs(a []string, min, max int, usage string) error {
+	if len(a) < min || max >= 0 && len(a) > max {
+		return fmt.Errorf("want %s, got %d argument(s): %w", usage, len(a), util.ErrUsage)
+	}
+	return nil
+}
+
+// Statuses of a line.
+const (
+	OK      = "ok"
+	Failed  = "failed"
+	Skipped = "skipped" // not run because an earlier line failed
+)
+
+// Result is the outcome of one line.
+type Result struct {
+	Line    int    `json:"line"`
+	Command string `json:"command"`
+	Status  string `json:"status"`
+	Error   string `json:"error,omitempty"`
+}
+
+type line struct {
+	n     int
+	text  string
+	words []string
+	err   error
+}
+
+// parse reads one subcommand per line, split into words like the shell
+// does. Blank lines and # comments are skipped, and a leading "shctl" is
+// optional.
+func parse(r io.Reader) ([]line, error) {
+	var out []line
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	n := 0
+	for sc.Scan() {
+		n++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		words, err := util.Shell.Words(text)
+		if err == nil && len(words) > 0 && words[0] == "shctl" {
+			words = words[1:]
+		}
+		if err == nil && len(words) == 0 {
+			err = fmt.Errorf("no command: %w", util.ErrUsage)
+		}
+		out = append(out, line{n, text, words, err})
+	}
+	return out, sc.Err()
+}
+
+// lookup finds the longest command name words start with.
+func lookup(words []string) (Func, []string, error) {
+	for k := len(words); k > 0; k-- {
+		if fn, ok := Commands[strings.Join(words[:k], " ")]; ok {
+			return fn, words[k:], nil
+		}
+	}
+	return nil, nil, fmt.Errorf("unknown command %q: %w", words[0], util.ErrUsage)
+}
+
+// Run executes the subcommands read from r and applies what they changed
+// as one transaction with one automatic backup per file. The first line
+// that fails stops the batch and nothing is written; its error is
+// returned with the line number. The combined change is confirmed once.
+// With undo_script set, a standalone sh script putting the files back as
+// they were is written there, for hosts that will not have shctl.
+func Run(ctx context.Context, r io.Reader) ([]Result, error) {
+	lines, err := parse(r)
+	if err != nil {
+		return nil, err
+	}
+	results, changes, records, err := stage(ctx, lines)
+	if err != nil {
+		return results, err
+	}
+	if len(changes) == 0 {
+		return results, nil
+	}
+	if !prompt.AssumeYes {
+		for _, c := range changes {
+			if err := output.WriteDiff(prompt.Out, util.UnifiedDiff(c.Path, c.Path+" (batch)", c.Before, c.After)); err != nil {
+				return results, err
+			}
+		}
+	}
+	ok, err := prompt.Confirm(fmt.Sprintf("Apply %d command(s) changing %d file(s)?", len(lines), len(changes)))
+	if err != nil {
+		return results, err
+	}
+	if !ok {
+		return results, prompt.ErrAborted
+	}
+	var script undo.Script
+	undoPath := config.Get("undo_script")
+	if undoPath != "" {
+		for _, c := range changes {
+			if err := script.Record(c.Path); err != nil {
+				return results, fmt.Errorf("undo script: %w", err)
+			}
+		}
+	}
+	err = commit(ctx, changes)
+	for _, rec := range records {
+		if ferr := rec.Finish(err, false); ferr != nil && err == nil {
+			output.Warn(prompt.Out, "audit: %v", ferr)
+		}
+	}
+	if err == nil && undoPath != "" {
+		if err := script.WriteFile(undoPath); err != nil {
+			return results, fmt.Errorf("undo script: %w", err)
+		}
+	}
+	return results, err
+}
+
+// stage runs lines against an overlay and returns the changes they made
+// and the audit records they would have sent. Confirmations are answered
+// yes and their diffs are not shown, since Run confirms the whole batch.
+func stage(ctx context.Context, lines []line) ([]Result, []fsys.Change, []auditlog.Record, error) {
+	overlay := fsys.NewOverlay(fsys.Current)
+	restoreFS := fsys.Use(overlay)
+	yes, out, sink := prompt.AssumeYes, prompt.Out, auditlog.Sink
+	var records []auditlog.Record
+	prompt.AssumeYes, prompt.Out = true, io.Discard
+	auditlog.Sink = func(r auditlog.Record) error {
+		records = append(records, r)
+		return nil
+	}
+	autoBackup, _ := config.Explain("auto_backup")
+	config.SetFlag("auto_backup", "false")
+	defer func() {
+		restoreFS()
+		prompt.AssumeYes, prompt.Out, auditlog.Sink = yes, out, sink
+		config.SetFlag("auto_backup", autoBackup.Layers[0].Value)
+	}()
+
+	results := make([]Result, len(lines))
+	var failed error
+	for i, l := range lines {
+		results[i] = Result{Line: l.n, Command: l.text, Status: Skipped}
+		if failed != nil {
+			continue
+		}
+		err := l.err
+		if err == nil {
+			var fn Func
+			var args []string
+			if fn, args, err = lookup(l.words); err == nil {
+				err = fn(ctx, args)
+			}
+		}
+		if err != nil {
+			results[i].Status, results[i].Error = Failed, err.Error()
+			failed = fmt.Errorf("line %d: %w; nothing was changed", l.n, err)
+			continue
+		}
+		results[i].Status = OK
+	}
+	if failed != nil {
+		return results, nil, nil, failed
+	}
+	return results, overlay.Changes(), records, nil
+}
+
+// commit writes changes in one transaction, holding the target locks and
+// backing every file up first. A file changed by someone else since the
+// batch read it fails the commit.
+func commit(ctx context.Context, changes []fsys.Change) error {
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	for _, c := range changes {
+		unlock, err := util.LockTarget(ctx, c.Path)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	tx := journal.New("batch")
+	defer tx.Discard()
+	var remove []string
+	for _, c := range changes {
+		cur, err := fsys.Current.ReadFile(c.Path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		if !bytes.Equal(cur, c.Before) {
+			return fmt.Errorf("%s changed while the batch ran; nothing was changed", c.Path)
+		}
+		if err := backup.AutoSave(ctx, c.Path, "batch"); err != nil {
+			return err
+		}
+		if c.Removed {
+			remove = append(remove, c.Path)
+			continue
+		}
+		perm := fs.FileMode(0o644)
+		if fi, err := fsys.Current.Stat(c.Path); err == nil {
+			perm = fi.Mode().Perm()
+		}
+		if err := tx.WriteWith(c.Path, c.After, perm, snapshot.Writer(c.Path)); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("batch: %w", err)
+	}
+	for _, p := range remove {
+		if err := fsys.Current.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return fmt.Errorf("batch: remove %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// Write prints results as a table, or as JSON or YAML.
+func Write(w io.Writer, format string, results []Result) error {
+	if output.Structured(format) {
+		if results == nil {
+			results = []Result{}
+		}
+		return output.Write(w, format, results)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tSTATUS\tCOMMAND")
+	for _, r := range results {
+		status := r.Status
+		if r.Error != "" {
+			status += ": " + r.Error
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\n", r.Line, status, r.Command)
+	}
+	return tw.Flush()
+}
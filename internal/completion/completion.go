@@ -0,0 +1,156 @@
+// Package completion generates shell completion scripts for shctl. The
+// scripts hand the words being completed back to `shctl __complete`,
+// which answers from the command tree and, for arguments naming existing
+// entries, from the rc file and the backup store.
+package completion
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/rc"
+)
+
+// Kinds of dynamic arguments a command can take.
+const (
+	Aliases = "aliases"
+	Exports = "exports"
+	Backups = "backups"
+)
+
+// Command is one node of the command tree, described by the root command.
+type Command struct {
+	Name  string
+	Flags []string // long flag names, without dashes
+	Args  string   // kind of the positional arguments, if dynamic
+	Subs  []Command
+}
+
+// Shells lists the shells Script supports.
+var Shells = []string{"bash", "zsh", "fish"}
+
+var scripts = map[string]string{
+	"bash": `# bash completion for %[1]s
+_%[1]s() {
+	local IFS=$'\n'
+	COMPREPLY=($(%[1]s __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null))
+}
+complete -o default -F _%[1]s %[1]s
+`,
+	"zsh": `#compdef %[1]s
+_%[1]s() {
+	local -a reply
+	reply=("${(@f)$(%[1]s __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	compadd -a reply
+}
+compdef _%[1]s %[1]s
+`,
+	"fish": `# fish completion for %[1]s
+function __%[1]s_complete
+	%[1]s __complete (commandline -opc)[2..-1] (commandline -ct) 2>/dev/null
+end
+complete -c %[1]s -f -a '(__%[1]s_complete)'
+`,
+}
+
+// Script writes the completion script of shell for the program prog.
+func Script(w io.Writer, shell, prog string) error {
+	s, ok := scripts[shell]
+	if !ok {
+		return fmt.Errorf("no completion for shell %q (want %s)", shell, strings.Join(Shells, ", "))
+	}
+	_, err := fmt.Fprintf(w, s, prog)
+	return err
+}
+
+// Complete returns the candidates for the last of words, the arguments
+// typed so far after the program name. Flags of root apply everywhere.
+func Complete(ctx context.Context, root Command, words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cmd := root
+	for _, w := range words[:len(words)-1] {
+		for _, sub := range cmd.Subs {
+			if sub.Name == w {
+				cmd = sub
+				break
+			}
+		}
+	}
+	partial := words[len(words)-1]
+	var out []string
+	switch {
+	case strings.HasPrefix(partial, "-"):
+		for _, f := range cmd.Flags {
+			out = append(out, "--"+f)
+		}
+		for _, f := range root.Flags {
+			out = append(out, "--"+f)
+		}
+	case len(cmd.Subs) > 0:
+		for _, sub := range cmd.Subs {
+			out = append(out, sub.Name)
+		}
+	case cmd.Args != "":
+		out, _ = Values(ctx, cmd.Args)
+	}
+	return matching(out, partial)
+}
+
+func matching(candidates []string, prefix string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, c := range candidates {
+		if strings.HasPrefix(c, prefix) && !seen[c] {
+			seen[c] = true
+			out = append(out, c)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Values lists the existing entries of kind: alias or export names, or
+// backup IDs.
+func Values(ctx context.Context, kind string) ([]string, error) {
+	var out []string
+	switch kind {
+	case Aliases:
+		aliases, err := rc.Aliases()
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range aliases {
+			out = append(out, a.Name)
+		}
+	case Exports:
+		exports, err := rc.Exports()
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range exports {
+			out = append(out, e.Name)
+		}
+	case Backups:
+		store, err := backup.Default(ctx)
+		if err != nil {
+			return nil, err
+		}
+		list, err := backup.List(ctx, store)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range list {
+			out = append(out, strconv.Itoa(b.ID))
+		}
+	default:
+		return nil, fmt.Errorf("unknown completion kind %q", kind)
+	}
+	return out, nil
+}
@@ -0,0 +1,486 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// setting describes one resolvable value and where it may come from, in
+// order of increasing precedence: default, config file, env, flag.
+type setting struct {
+	key   string
+	flag  string
+	env   []string // highest precedence first
+	def   func() string
+	usage string
+}
+
+var settings []setting
+
+// The table is filled in init because some defaults read other settings.
+func init() {
+	settings = []setting{
+		{"rc_file", "rc-file", []string{"SHCTL_RC_FILE", "BASM_RC_FILE"}, defaultRCFile, "shell rc file to manage"},
+		{"sudoers_file", "sudoers-file", []string{"SHCTL_SUDOERS_FILE", "BASM_SUDOERS_PATH"}, constant("/etc/sudoers"), "sudoers file to manage"},
+		{"backup_dir", "backup-dir", []string{"SHCTL_BACKUP_DIR", "BASM_BACKUP_DIR"}, defaultBackupDir, "directory for local backups"},
+		{"auto_backup", "auto-backup", []string{"SHCTL_AUTO_BACKUP"}, constant("true"), "back files up before changing them"},
+		{"backup_url", "backup-url", []string{"SHCTL_BACKUP_URL", "BASM_BACKUP_URL"}, constant(""), "backup store: a path, file://, s3://, ssh:// or git:// URL"},
+		{"backup_compress", "compress", []string{"SHCTL_BACKUP_COMPRESS"}, constant("none"), "backup compression (none, gzip or zstd)"},
+		{"backup_retention", "retention", []string{"SHCTL_BACKUP_RETENTION"}, constant(""), "backup retention policy, e.g. daily=7,weekly=4"},
+		{"backup_keep", "backup-keep", []string{"SHCTL_BACKUP_KEEP"}, constant("20"), "backups kept per file"},
+		{"symlink_policy", "symlinks", []string{"SHCTL_SYMLINKS"}, constant("refuse"), "symlinked rc files: refuse, follow or replace"},
+		{"grants_file", "grants-file", []string{"SHCTL_GRANTS_FILE"}, constant("/var/lib/shctl/sudoers-grants.json"), "root-owned record of the sudoers grants that expire"},
+		{"doas_file", "doas-file", []string{"SHCTL_DOAS_FILE"}, constant("/etc/doas.conf"), "doas.conf to manage"},
+		{"escalator", "escalator", []string{"SHCTL_ESCALATOR"}, constant("auto"), "privilege escalation: auto, sudo, doas, run0 or none"},
+		{"cron_dir", "cron-dir", []string{"SHCTL_CRON_DIR"}, constant("/etc/cron.d"), "directory of system cron drop-ins to manage"},
+		{"system_crontab", "system-crontab", []string{"SHCTL_SYSTEM_CRONTAB"}, constant("/etc/crontab"), "system crontab to manage"},
+		{"systemd_dir", "systemd-dir", []string{"SHCTL_SYSTEMD_DIR"}, constant("/etc/systemd/system"), "directory of systemd unit drop-ins to manage"},
+		{"hosts_file", "hosts-file", []string{"SHCTL_HOSTS_FILE"}, constant("/etc/hosts"), "hosts file to manage"},
+		{"environment_file", "environment-file", []string{"SHCTL_ENVIRONMENT_FILE"}, constant("/etc/environment"), "pam_env environment file to manage"},
+		{"environment_d_dir", "environment-d-dir", []string{"SHCTL_ENVIRONMENT_D_DIR"}, defaultEnvironmentDDir, "environment.d directory of the systemd user session"},
+		{"launch_agents_dir", "launch-agents-dir", []string{"SHCTL_LAUNCH_AGENTS_DIR"}, defaultLaunchAgentsDir, "directory of macOS LaunchAgents for GUI exports"},
+		{"git_config", "git-config", []string{"SHCTL_GIT_CONFIG"}, defaultGitConfig, "global git config to manage"},
+		{"ssh_config", "ssh-config", []string{"SHCTL_SSH_CONFIG"}, defaultSSHConfig, "ssh client config to manage"},
+		{"tmux_conf", "tmux-conf", []string{"SHCTL_TMUX_CONF"}, defaultTmuxConf, "tmux config to manage"},
+		{"vimrc", "vimrc", []string{"SHCTL_VIMRC"}, defaultVimrc, "vim config to manage"},
+		{"nvim_init", "nvim-init", []string{"SHCTL_NVIM_INIT"}, defaultNvimInit, "neovim init.lua to manage"},
+		{"profile_dir", "profile-dir", []string{"SHCTL_PROFILE_DIR"}, defaultProfileDir, "directory of environment profiles"},
+		{"sync_dir", "sync-dir", []string{"SHCTL_SYNC_DIR"}, defaultSyncDir, "local git repository of the synced files"},
+		{"sync_mode", "sync-mode", []string{"SHCTL_SYNC_MODE"}, constant("files"), "what sync keeps: whole files or only shctl blocks"},
+		{"link_dir", "link-dir", []string{"SHCTL_LINK_DIR"}, defaultLinkDir, "dotfiles repository that link deploys"},
+		{"shell", "shell", []string{"SHCTL_SHELL"}, defaultShell, "target shell: bash or zsh"},
+		{"rc_files", "rc-files", []string{"SHCTL_RC_FILES"}, constant(""), "more rc files to manage, comma separated"},
+		{"sudoers_mode", "sudoers-mode", []string{"SHCTL_SUDOERS_MODE"}, constant("0440"), "mode of the sudoers files shctl writes"},
+		{"undo_script", "undo-script", []string{"SHCTL_UNDO_SCRIPT"}, constant(""), "write a standalone sh script reverting a batch to this path"},
+		{"output", "output", []string{"SHCTL_OUTPUT"}, constant(output.Text), "output format: text, json or yaml"},
+		{"color", "color", []string{"SHCTL_COLOR", "SHCTL_COLOUR"}, constant("auto"), "color output: auto, always or never"},
+		{"pager", "pager", []string{"SHCTL_PAGER", "PAGER"}, constant("less -FRX"), "pager for list output on a terminal, or never"},
+		{"show_diff", "show-diff", []string{"SHCTL_SHOW_DIFF"}, constant("false"), "print the diff of every file a command changed"},
+		{"update_channel", "channel", []string{"SHCTL_UPDATE_CHANNEL"}, constant("stable"), "release channel for self-update: stable or edge"},
+		{"update_url", "update-url", []string{"SHCTL_UPDATE_URL"}, constant("https://github.com/yourusername/shctl/releases/download/feed"), "base URL of the self-update release feed"},
+	}
+}
+
+// checks validate values before Set stores them.
+var checks = map[string]func(string) error{
+	"shell":           oneOf("bash", "zsh"),
+	"symlink_policy":  oneOf("refuse", "follow", "replace"),
+	"escalator":       oneOf("auto", "sudo", "doas", "run0", "none"),
+	"backup_compress": oneOf("none", "gzip", "zstd"),
+	"auto_backup":     oneOf("true", "false"),
+	"show_diff":       oneOf("true", "false"),
+	"color":           oneOf("auto", "always", "never"),
+	"update_channel":  oneOf("stable", "edge"),
+	"sync_mode":       oneOf("files", "blocks"),
+	"output":          output.Check,
+	"sudoers_mode": func(v string) error {
+		_, err := SudoersMode(v)
+		return err
+	},
+}
+
+func oneOf(values ...string) func(string) error {
+	return func(v string) error {
+		for _, ok := range values {
+			if v == ok {
+				return nil
+			}
+		}
+		return fmt.Errorf("%q is not one of %s", v, strings.Join(values, ", "))
+	}
+}
+
+var flags = map[string]string{}
+
+func constant(v string) func() string { return func() string { return v } }
+
+func defaultRCFile() string {
+	home, _ := os.UserHomeDir()
+	if Get("shell") == "zsh" {
+		return filepath.Join(home, ".zshrc")
+	}
+	return filepath.Join(home, ".bashrc")
+}
+
+// defaultGitConfig is ~/.gitconfig, or git's XDG config when only that
+// exists, as git itself picks.
+func defaultGitConfig() string {
+	home, _ := os.UserHomeDir()
+	p := filepath.Join(home, ".gitconfig")
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		xdg = filepath.Join(home, ".config")
+	}
+	if _, err := os.Stat(p); err != nil {
+		if _, err := os.Stat(filepath.Join(xdg, "git", "config")); err == nil {
+			return filepath.Join(xdg, "git", "config")
+		}
+	}
+	return p
+}
+
+func defaultEnvironmentDDir() string {
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		home, _ := os.UserHomeDir()
+		xdg = filepath.Join(home, ".config")
+	}
+	return filepath.Join(xdg, "environment.d")
+}
+
+func defaultLaunchAgentsDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "Library", "LaunchAgents")
+}
+
+func defaultTmuxConf() string {
+	home, _ := os.UserHomeDir()
+	p := filepath.Join(home, ".tmux.conf")
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		xdg = filepath.Join(home, ".config")
+	}
+	if _, err := os.Stat(p); err != nil {
+		if _, err := os.Stat(filepath.Join(xdg, "tmux", "tmux.conf")); err == nil {
+			return filepath.Join(xdg, "tmux", "tmux.conf")
+		}
+	}
+	return p
+}
+
+func defaultVimrc() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".vimrc")
+}
+
+func defaultNvimInit() string {
+	xdg := os.Getenv("XDG_CONFIG_HOME")
+	if xdg == "" {
+		home, _ := os.UserHomeDir()
+		xdg = filepath.Join(home, ".config")
+	}
+	return filepath.Join(xdg, "nvim", "init.lua")
+}
+
+func defaultProfileDir() string {
+	return filepath.Join(filepath.Dir(FilePath()), "profiles")
+}
+
+func defaultSSHConfig() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".ssh", "config")
+}
+
+// defaultShell is the login shell when shctl supports it, else bash.
+func defaultShell() string {
+	if filepath.Base(os.Getenv("SHELL")) == "zsh" {
+		return "zsh"
+	}
+	return "bash"
+}
+
+// RCFiles returns the rc file followed by the extra ones of rc_files.
+func RCFiles() []string {
+	out := []string{Get("rc_file")}
+	for _, f := range strings.Split(Get("rc_files"), ",") {
+		if f = strings.TrimSpace(f); f != "" && f != out[0] {
+			out = append(out, f)
+		}
+	}
+	return out
+}
+
+// SudoersMode parses an octal sudoers_mode value. sudo refuses files
+// that are writable by group or others, so such modes are rejected.
+func SudoersMode(v string) (fs.FileMode, error) {
+	n, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || n > 0o777 {
+		return 0, fmt.Errorf("invalid file mode %q (want octal, e.g. 0440)", v)
+	}
+	if n&0o022 != 0 {
+		return 0, fmt.Errorf("mode %s lets others write the file, which sudo refuses", v)
+	}
+	return fs.FileMode(n), nil
+}
+
+// defaultBackupDir keeps backups private and across reboots, unlike the
+// /tmp used before.
+func defaultBackupDir() string {
+	return filepath.Join(util.StateDir(), "backups")
+}
+
+func defaultLinkDir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "dotfiles")
+}
+
+func defaultSyncDir() string {
+	return filepath.Join(util.StateDir(), "sync")
+}
+
+func lookup(key string) (setting, bool) {
+	for _, s := range settings {
+		if s.key == key {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// Flag is the command-line flag of a setting. The root command registers
+// each as a persistent flag and passes what was given to SetFlag.
+type Flag struct {
+	Name  string // without the leading dashes
+	Key   string
+	Usage string
+	// Value is set for switches, which take no argument: passing one sets
+	// Key to Value.
+	Value string
+}
+
+// switches are flags without an argument. A setting whose flag is also a
+// switch only has the switch.
+var switches = []Flag{
+	{Name: "no-color", Key: "color", Usage: "same as --color=never", Value: "never"},
+	{Name: "no-pager", Key: "pager", Usage: "same as --pager=never", Value: "never"},
+	{Name: "show-diff", Key: "show_diff", Usage: "print the diff of every file a command changed", Value: "true"},
+}
+
+// Flags lists the flag of every setting, in table order, then the
+// switches.
+func Flags() []Flag {
+	out := make([]Flag, 0, len(settings)+len(switches))
+	for _, s := range settings {
+		if !isSwitch(s.flag) {
+			out = append(out, Flag{Name: s.flag, Key: s.key, Usage: s.usage})
+		}
+	}
+	return append(out, switches...)
+}
+
+func isSwitch(name string) bool {
+	for _, f := range switches {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// SetFlag records a value given on the command line for key. An empty
+// value clears it.
+func SetFlag(key, value string) {
+	if value == "" {
+		delete(flags, key)
+		return
+	}
+	flags[key] = value
+}
+
+// Keys lists every setting, in table order.
+func Keys() []string {
+	out := make([]string, len(settings))
+	for i, s := range settings {
+		out[i] = s.key
+	}
+	return out
+}
+
+// Environ returns NAME=value for every setting, under its first
+// environment variable and with its effective value, so a child shctl or
+// plugin resolves the same settings.
+func Environ() []string {
+	out := make([]string, 0, len(settings))
+	for _, s := range settings {
+		out = append(out, s.env[0]+"="+Get(s.key))
+	}
+	return out
+}
+
+// Set stores key = value in the config file, keeping its other lines
+// and comments. An empty value removes key from the file.
+func Set(key, value string) error {
+	if _, ok := lookup(key); !ok {
+		return fmt.Errorf("unknown config key %q", key)
+	}
+	if check := checks[key]; check != nil && value != "" {
+		if err := check(value); err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	path := FilePath()
+	b, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	entry := key + " = " + strconv.Quote(value) + "\n"
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		lines[len(lines)-1] += "\n"
+	}
+	out := []string{}
+	done := value == ""
+	top := true
+	for _, l := range lines {
+		t := strings.TrimSpace(l)
+		if top && strings.HasPrefix(t, "[") {
+			// settings live above the first table
+			top = false
+			if !done {
+				out, done = append(out, entry), true
+			}
+		}
+		if k, _, ok := strings.Cut(t, "="); top && ok && !strings.HasPrefix(t, "#") && strings.TrimSpace(k) == key {
+			if !done {
+				out, done = append(out, entry), true
+			}
+			continue
+		}
+		out = append(out, l)
+	}
+	if !done {
+		out = append(out, entry)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return fsys.OS.WriteFile(path, []byte(strings.Join(out, "")), 0o644)
+}
+
+// FilePath is the location of the shctl config file.
+func FilePath() string {
+	if v := os.Getenv("SHCTL_CONFIG"); v != "" {
+		return v
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, _ := os.UserHomeDir()
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, "shctl", "config.toml")
+}
+
+// Get returns the effective value of key. An unreadable config file is
+// treated as absent here; Explain reports it.
+func Get(key string) string {
+	e, _ := Explain(key)
+	return e.Value
+}
+
+// Layer is one place a setting was looked up.
+type Layer struct {
+	Source string `json:"source"`
+	Value  string `json:"value"`
+	Set    bool   `json:"set"`
+}
+
+// Explanation lists every layer consulted for a key, highest precedence
+// first, and which one provided the final value.
+type Explanation struct {
+	Key    string  `json:"key"`
+	Value  string  `json:"value"`
+	Winner string  `json:"winner"`
+	Layers []Layer `json:"layers"`
+}
+
+func Explain(key string) (Explanation, error) {
+	s, ok := lookup(key)
+	if !ok {
+		return Explanation{}, fmt.Errorf("unknown config key %q", key)
+	}
+	e := Explanation{Key: key}
+	v, set := flags[key]
+	e.Layers = append(e.Layers, Layer{"flag --" + s.flag, v, set})
+	for _, name := range s.env {
+		v := os.Getenv(name)
+		e.Layers = append(e.Layers, Layer{"env " + name, v, v != ""})
+	}
+	file, ferr := readFile(FilePath())
+	v, set = file[key]
+	e.Layers = append(e.Layers, Layer{"config " + FilePath(), v, set})
+	e.Layers = append(e.Layers, Layer{"default", s.def(), true})
+
+	for _, l := range e.Layers {
+		if l.Set {
+			e.Value, e.Winner = l.Value, l.Source
+			break
+		}
+	}
+	return e, ferr
+}
+
+// WriteExplanation prints e as a small table marking the winning layer,
+// or as JSON or YAML.
+func WriteExplanation(w io.Writer, format string, e Explanation) error {
+	if output.Structured(format) {
+		return output.Write(w, format, e)
+	}
+	fmt.Fprintf(w, "%s = %s (from %s)\n", e.Key, e.Value, e.Winner)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, l := range e.Layers {
+		v := "(not set)"
+		if l.Set {
+			v = l.Value
+		}
+		mark := ""
+		if l.Source == e.Winner {
+			mark = "<- wins"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\n", l.Source, v, mark)
+	}
+	return tw.Flush()
+}
+
+// readFile reads top-level `key = value` pairs from a TOML config file.
+func readFile(path string) (map[string]string, error) {
+	out := map[string]string{}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return out, err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	n := 0
+	for sc.Scan() {
+		n++
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			// tables are not used yet; stop at the first one
+			break
+		}
+		k, v, ok := strings.Cut(line, "=")
+		if !ok {
+			return out, fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, `"`) {
+			uq, err := strconv.Unquote(v)
+			if err != nil {
+				return out, fmt.Errorf("%s:%d: %w", path, n, err)
+			}
+			v = uq
+		}
+		out[strings.TrimSpace(k)] = v
+	}
+	return out, sc.Err()
+}
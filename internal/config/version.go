@@ -0,0 +1,78 @@
+package config
+
+import (
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/yourusername/shctl/internal/output"
+)
+
+// Build metadata, set at build time with
+// -ldflags "-X github.com/yourusername/shctl/internal/config.Version=...".
+// Commit and Date fall back to what the Go toolchain recorded.
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Build identifies the running binary.
+type Build struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	Date      string `json:"date"`
+	Modified  bool   `json:"modified,omitempty"` // built from a dirty tree
+	GoVersion string `json:"go_version"`
+	Platform  string `json:"platform"`
+}
+
+// BuildInfo returns the metadata of the running binary.
+func BuildInfo() Build {
+	b := Build{Version: Version, Commit: Commit, Date: Date, GoVersion: runtime.Version(), Platform: runtime.GOOS + "/" + runtime.GOARCH}
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return b
+	}
+	if b.Version == "dev" && info.Main.Version != "" && info.Main.Version != "(devel)" {
+		b.Version = info.Main.Version
+	}
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			if b.Commit == "" {
+				b.Commit = s.Value
+			}
+		case "vcs.time":
+			if b.Date == "" {
+				b.Date = s.Value
+			}
+		case "vcs.modified":
+			b.Modified = s.Value == "true"
+		}
+	}
+	return b
+}
+
+// WriteVersion prints b on one line, or as JSON or YAML.
+func WriteVersion(w io.Writer, format string, b Build) error {
+	if output.Structured(format) {
+		return output.Write(w, format, b)
+	}
+	commit, date := b.Commit, b.Date
+	if commit == "" {
+		commit = "unknown"
+	}
+	if len(commit) > 12 {
+		commit = commit[:12]
+	}
+	if b.Modified {
+		commit += "-dirty"
+	}
+	if date == "" {
+		date = "unknown"
+	}
+	_, err := fmt.Fprintf(w, "shctl %s (commit %s, built %s, %s %s)\n", b.Version, commit, date, b.GoVersion, b.Platform)
+	return err
+}
@@ -0,0 +1,438 @@
+// Package cron manages the invoking user's crontab. The crontab lives in
+// the cron spool, out of reach, so shctl reads it with crontab -l and
+// installs it with crontab -. A copy kept in the state directory stands
+// in for it as the managed file, which is what backups, snapshots, the
+// history and the watcher see; every command refreshes the copy first.
+// System crontabs, /etc/crontab and the drop-ins of /etc/cron.d, are
+// edited in place like the sudoers file.
+package cron
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/sysfile"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name:     "cron",
+		Files:    files,
+		Validate: func(_ context.Context, _, staged string) error { return ValidateFile(staged) },
+		Apply:    install,
+	})
+	trash.Register("cron", restoreLines)
+}
+
+// ErrJobExists is returned when adding a job the crontab already has.
+var ErrJobExists = errors.New("cron job already exists")
+
+// CrontabPath is the copy of the user's crontab shctl manages.
+func CrontabPath() string {
+	return filepath.Join(util.StateDir(), "cron", "crontab."+username())
+}
+
+func username() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if v := os.Getenv("USER"); v != "" {
+		return v
+	}
+	return strconv.Itoa(os.Getuid())
+}
+
+// files lists the copy once shctl has started managing the crontab,
+// refreshed when crontab is installed.
+func files() ([]string, error) {
+	path := CrontabPath()
+	if _, err := fsys.Current.Stat(path); err != nil {
+		return nil, nil
+	}
+	if _, err := exec.LookPath("crontab"); err == nil {
+		if err := sync(context.Background()); err != nil {
+			logging.Warn("cannot refresh the crontab copy", "err", err)
+		}
+	}
+	return []string{path}, nil
+}
+
+// read returns the installed crontab; a user without one has an empty
+// crontab.
+func read(ctx context.Context) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "crontab", "-l")
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if strings.Contains(stderr.String(), "no crontab for") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("crontab -l: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return out, nil
+}
+
+// sync refreshes the copy from the installed crontab.
+func sync(ctx context.Context) error {
+	data, err := read(ctx)
+	if err != nil {
+		return err
+	}
+	path := CrontabPath()
+	cur, err := fsys.Current.ReadFile(path)
+	if err == nil && bytes.Equal(cur, data) {
+		return nil
+	}
+	if err := fsys.Current.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(path, data, 0o600)
+}
+
+// install makes staged the user's crontab and updates the copy at path.
+// Outside the real file system, as in a dry run, only the copy changes.
+func install(ctx context.Context, staged, path string) error {
+	data, err := os.ReadFile(staged)
+	if err != nil {
+		return err
+	}
+	if !fsys.IsOS() {
+		dryrun.Skip("crontab", "-")
+	} else {
+		var stderr bytes.Buffer
+		cmd := exec.CommandContext(ctx, "crontab", "-")
+		cmd.Stdin, cmd.Stderr = bytes.NewReader(data), &stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("crontab -: %s: %w", strings.TrimSpace(stderr.String()), err)
+		}
+	}
+	if err := fsys.Current.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(path, data, 0o600)
+}
+
+// Jobs returns the entries of the installed crontab.
+func Jobs(ctx context.Context) ([]Job, error) {
+	data, err := read(ctx)
+	if err != nil {
+		return nil, err
+	}
+	jobs := parse(data, false)
+	if jobs == nil {
+		jobs = []Job{}
+	}
+	return jobs, nil
+}
+
+// List prints every entry as "number  line: entry", with the number
+// RemoveNumber accepts.
+func List(ctx context.Context, w io.Writer) error {
+	jobs, err := Jobs(ctx)
+	if err != nil {
+		return err
+	}
+	idx := make([]int, len(jobs))
+	for i := range idx {
+		idx[i] = i
+	}
+	return printJobs(w, jobs, idx)
+}
+
+func printJobs(w io.Writer, jobs []Job, idx []int) error {
+	for _, i := range idx {
+		if _, err := fmt.Fprintf(w, "%3d  %d: %s\n", i+1, jobs[i].Line, jobs[i].Raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListFormat prints entries as text, JSON or YAML, narrowed and ordered
+// by output.List on their commands, or names for environment lines.
+func ListFormat(ctx context.Context, w io.Writer, format string) error {
+	jobs, err := Jobs(ctx)
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(jobs), func(i int) output.Key {
+		name := jobs[i].Command
+		if jobs[i].Kind != KindJob {
+			name = jobs[i].Name
+		}
+		return output.Key{Name: name, File: CrontabPath()}
+	})
+	if err != nil {
+		return err
+	}
+	if !output.Structured(format) {
+		return printJobs(w, jobs, idx)
+	}
+	out := make([]Job, len(idx))
+	for i, j := range idx {
+		out[i] = jobs[j]
+	}
+	return output.Write(w, format, out)
+}
+
+// Add appends a job running command on schedule, five time fields or an
+// @ nickname such as @daily. A job with the same schedule and command is
+// reported as ErrJobExists.
+func Add(ctx context.Context, schedule, command string) error {
+	if strings.ContainsAny(command, "\n\r") {
+		return fmt.Errorf("cron command must be one line: %w", util.ErrUsage)
+	}
+	j, _ := parseLine(1, strings.TrimSpace(schedule)+" "+strings.TrimSpace(command), false)
+	if j.Kind != KindJob {
+		msg := j.Err
+		if msg == "" {
+			msg = "not a job"
+		}
+		return fmt.Errorf("invalid cron job: %s: %w", msg, util.ErrUsage)
+	}
+	return change(ctx, "add", func(tmp string) error {
+		data, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		for _, e := range parse(data, false) {
+			if e.Kind == KindJob && e.Schedule == j.Schedule && e.Command == j.Command {
+				return fmt.Errorf("%s %s: %w", j.Schedule, j.Command, ErrJobExists)
+			}
+		}
+		return appendLines(tmp, data, []string{j.Raw})
+	})
+}
+
+// appendLines writes lines after data to path. cron ignores a last line
+// without a newline, so every line gets one.
+func appendLines(path string, data []byte, lines []string) error {
+	if len(data) > 0 && !bytes.HasSuffix(data, []byte("\n")) {
+		data = append(data, '\n')
+	}
+	data = append(data, strings.Join(lines, "\n")+"\n"...)
+	return os.WriteFile(path, data, 0o600)
+}
+
+// Remove deletes every job running command, keeping them in the trash.
+func Remove(ctx context.Context, command string) error {
+	command = strings.TrimSpace(command)
+	return remove(ctx, func(jobs []Job) ([]Job, error) {
+		var gone []Job
+		for _, j := range jobs {
+			if j.Kind == KindJob && j.Command == command {
+				gone = append(gone, j)
+			}
+		}
+		if len(gone) == 0 {
+			return nil, util.NotFound(fmt.Sprintf("no cron job runs %q", command))
+		}
+		return gone, nil
+	})
+}
+
+// RemoveNumber deletes the entry numbered n by List, keeping it in the
+// trash.
+func RemoveNumber(ctx context.Context, n int) error {
+	return remove(ctx, func(jobs []Job) ([]Job, error) {
+		if n < 1 || n > len(jobs) {
+			return nil, util.NotFound(fmt.Sprintf("no cron entry numbered %d (have %d)", n, len(jobs)))
+		}
+		return jobs[n-1 : n], nil
+	})
+}
+
+// remove drops the entries pick chooses and keeps them in the trash once
+// the change went through.
+func remove(ctx context.Context, pick func([]Job) ([]Job, error)) error {
+	var removed []string
+	err := change(ctx, "remove", func(tmp string) error {
+		data, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		gone, err := pick(parse(data, false))
+		if err != nil {
+			return err
+		}
+		drop := map[int]bool{}
+		removed = nil
+		for _, j := range gone {
+			drop[j.Line] = true
+			removed = append(removed, j.Raw)
+		}
+		_, err = util.EditLines(fsys.OS, tmp, func(n int, l string) (string, bool) {
+			return l, !drop[n]
+		})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if terr := trash.Put("cron", CrontabPath(), "cron remove", removed); terr != nil {
+		output.Warn(prompt.Out, "trash: %v", terr)
+	}
+	return nil
+}
+
+// restoreLines appends entries from the trash back to the crontab.
+func restoreLines(ctx context.Context, path string, lines []string) error {
+	if path != CrontabPath() {
+		return fmt.Errorf("%s is not the crontab of %s", path, username())
+	}
+	return change(ctx, "trash restore", func(tmp string) error {
+		data, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		return appendLines(tmp, data, lines)
+	})
+}
+
+// change refreshes the copy, lets fn edit a temporary copy of it,
+// validates the result and then applies it, holding the target lock
+// throughout.
+func change(ctx context.Context, op string, fn func(tmp string) error) error {
+	orig := CrontabPath()
+	unlock, err := util.LockTarget(ctx, orig)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := sync(ctx); err != nil {
+		return err
+	}
+	tmp, err := util.CopyToTemp(orig)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	logging.Info("crontab", "op", op, "path", orig, "tmp", tmp)
+
+	if err := fn(tmp); err != nil {
+		return err
+	}
+	if err := ValidateFile(tmp); err != nil {
+		return fmt.Errorf("crontab validation failed: %w", err)
+	}
+	return apply(ctx, op, tmp, orig)
+}
+
+// Backup saves the installed crontab.
+func Backup(ctx context.Context) error {
+	if err := sync(ctx); err != nil {
+		return err
+	}
+	_, err := backup.Save(ctx, CrontabPath())
+	return err
+}
+
+// Diff prints how the installed crontab differs from backup id, 0 for
+// the newest.
+func Diff(ctx context.Context, w io.Writer, id int) error {
+	if err := sync(ctx); err != nil {
+		return err
+	}
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return err
+	}
+	if id == 0 {
+		latest, err := backup.Latest(ctx, store, CrontabPath())
+		if err != nil {
+			return err
+		}
+		id = latest.ID
+	}
+	return backup.Diff(ctx, w, store, id)
+}
+
+// PreviewRestore prints what Restore would do without changing anything.
+func PreviewRestore(ctx context.Context, w io.Writer) error {
+	if err := sync(ctx); err != nil {
+		return err
+	}
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return err
+	}
+	latest, err := backup.Latest(ctx, store, CrontabPath())
+	if err != nil {
+		return err
+	}
+	return backup.Preview(ctx, w, store, latest, CrontabPath())
+}
+
+// Restore installs the newest backup of the crontab.
+func Restore(ctx context.Context) error {
+	unlock, err := util.LockTarget(ctx, CrontabPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := sync(ctx); err != nil {
+		return err
+	}
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return err
+	}
+	latest, err := backup.Latest(ctx, store, CrontabPath())
+	if err != nil {
+		return err
+	}
+	if err := backup.Announce(prompt.Out, latest, CrontabPath()); err != nil {
+		return err
+	}
+	tmp, err := backup.ExtractToTemp(ctx, store, latest)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := ValidateFile(tmp); err != nil {
+		return fmt.Errorf("backup crontab failed validation: %w", err)
+	}
+	return apply(ctx, "restore", tmp, CrontabPath())
+}
+
+// apply shows the pending change as a unified diff and installs tmp once
+// the user confirms it, backing the crontab up first. op names the
+// operation in the backup.
+func apply(ctx context.Context, op, tmp, dest string) error {
+	cur, err := fsys.Current.ReadFile(dest)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	next, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	diff := util.UnifiedDiff("crontab", "crontab (proposed)", cur, next)
+	if diff == "" {
+		fmt.Fprintln(prompt.Out, "no changes to the crontab")
+		return nil
+	}
+	return sysfile.Confirm(ctx, "cron", op, dest, diff, "Install this crontab for "+username()+"?", func() error {
+		return install(ctx, tmp, dest)
+	})
+}
@@ -0,0 +1,106 @@
+package cron
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+var errorLineRe = regexp.MustCompile(`:(\d+): `)
+
+// Edit opens a copy of the crontab in $VISUAL/$EDITOR, as crontab -e
+// does. After each save the copy is validated; on failure the editor is
+// reopened at the offending line. The change is backed up and confirmed
+// like any other.
+func Edit(ctx context.Context) error {
+	orig := CrontabPath()
+	unlock, err := util.LockTarget(ctx, orig)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := sync(ctx); err != nil {
+		return err
+	}
+	tmp, err := util.CopyToTemp(orig)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	changed, err := editLoop(ctx, tmp, false)
+	if err != nil || !changed {
+		return err
+	}
+	return apply(ctx, "edit", tmp, orig)
+}
+
+// editLoop runs the editor on tmp until the result validates or the user
+// gives up, and reports whether tmp was changed.
+func editLoop(ctx context.Context, tmp string, system bool) (bool, error) {
+	before, err := os.ReadFile(tmp)
+	if err != nil {
+		return false, err
+	}
+	line := 0
+	for {
+		if err := runEditor(ctx, tmp, line); err != nil {
+			return false, err
+		}
+		after, err := os.ReadFile(tmp)
+		if err != nil {
+			return false, err
+		}
+		if bytes.Equal(before, after) {
+			fmt.Fprintln(prompt.Out, "no changes made")
+			return false, nil
+		}
+		verr := validate(tmp, system)
+		if verr == nil {
+			if len(after) > 0 && !bytes.HasSuffix(after, []byte("\n")) {
+				return true, os.WriteFile(tmp, append(after, '\n'), 0o600)
+			}
+			return true, nil
+		}
+		fmt.Fprintln(prompt.Out, verr)
+		again, err := prompt.Confirm("Edit again?")
+		if err != nil {
+			return false, err
+		}
+		if !again {
+			return false, fmt.Errorf("crontab left unchanged: %w", verr)
+		}
+		line = 0
+		if m := errorLineRe.FindStringSubmatch(verr.Error()); m != nil {
+			line, _ = strconv.Atoi(m[1])
+		}
+	}
+}
+
+func runEditor(ctx context.Context, path string, line int) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := strings.Fields(editor)
+	if line > 0 {
+		args = append(args, "+"+strconv.Itoa(line))
+	}
+	args = append(args, path)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s: %w", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package cron
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Kinds of crontab entries.
+const (
+	KindJob     = "job"
+	KindEnv     = "env"
+	KindInvalid = "invalid"
+)
+
+// Job is one entry of a crontab: a scheduled command or an environment
+// assignment. Comments and blank lines are not entries.
+type Job struct {
+	Kind     string `json:"kind"`
+	Source   string `json:"source,omitempty"` // system crontabs only
+	Line     int    `json:"line"`
+	Raw      string `json:"raw"`
+	Err      string `json:"error,omitempty"` // why a KindInvalid entry failed to parse
+	Schedule string `json:"schedule,omitempty"`
+	User     string `json:"user,omitempty"` // system crontabs only
+	Command  string `json:"command,omitempty"`
+	Name     string `json:"name,omitempty"` // KindEnv only
+	Value    string `json:"value,omitempty"`
+}
+
+var envRe = regexp.MustCompile(`^([A-Za-z_][A-Za-z0-9_]*)\s*=\s*(.*)$`)
+
+// nicknames are the @ schedules cron accepts in place of five fields.
+var nicknames = map[string]bool{
+	"@reboot": true, "@yearly": true, "@annually": true, "@monthly": true,
+	"@weekly": true, "@daily": true, "@midnight": true, "@hourly": true,
+}
+
+// fields are the five time fields in order, with their ranges and the
+// names they accept.
+var fields = []struct {
+	name     string
+	min, max int
+	names    []string
+}{
+	{"minute", 0, 59, nil},
+	{"hour", 0, 23, nil},
+	{"day of month", 1, 31, nil},
+	{"month", 1, 12, []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}},
+	{"day of week", 0, 7, []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}},
+}
+
+// parse splits crontab text into entries. Lines of a system crontab
+// have a user field between the schedule and the command.
+func parse(data []byte, system bool) []Job {
+	var out []Job
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	for n := 1; sc.Scan(); n++ {
+		if j, ok := parseLine(n, sc.Text(), system); ok {
+			out = append(out, j)
+		}
+	}
+	return out
+}
+
+func parseLine(n int, raw string, system bool) (Job, bool) {
+	line := strings.TrimSpace(raw)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return Job{}, false
+	}
+	j := Job{Kind: KindJob, Line: n, Raw: raw}
+	if m := envRe.FindStringSubmatch(line); m != nil {
+		j.Kind, j.Name, j.Value = KindEnv, m[1], m[2]
+		return j, true
+	}
+	words := strings.Fields(line)
+	n5, want := 5, "five time fields and a command"
+	if strings.HasPrefix(words[0], "@") {
+		n5, want = 1, "a command"
+	}
+	if system {
+		want = strings.Replace(want, "a command", "a user and a command", 1)
+	}
+	var err error
+	switch {
+	case n5 == 1 && !nicknames[words[0]]:
+		err = fmt.Errorf("unknown schedule %s", words[0])
+	case len(words) < n5+1 || system && len(words) < n5+2:
+		err = errors.New("want " + want)
+	case n5 == 5:
+		err = checkSchedule(words[:5])
+	}
+	if err != nil {
+		j.Kind, j.Err = KindInvalid, err.Error()
+		return j, true
+	}
+	j.Schedule = strings.Join(words[:n5], " ")
+	if system {
+		j.User = words[n5]
+		n5++
+	}
+	j.Command = commandAfter(line, n5)
+	return j, true
+}
+
+// commandAfter returns line from its n+1-th word on, spacing intact.
+func commandAfter(line string, n int) string {
+	rest := line
+	for i := 0; i < n; i++ {
+		rest = strings.TrimLeft(rest, " \t")
+		rest = rest[strings.IndexAny(rest+" ", " \t"):]
+	}
+	return strings.TrimSpace(rest)
+}
+
+// checkSchedule validates the five time fields.
+func checkSchedule(words []string) error {
+	for i, w := range words {
+		f := fields[i]
+		for _, item := range strings.Split(w, ",") {
+			if err := checkItem(item, f.min, f.max, f.names); err != nil {
+				return fmt.Errorf("%s %q: %w", f.name, w, err)
+			}
+		}
+	}
+	return nil
+}
+
+// checkItem validates one element of a field list: *, a value or a range,
+// optionally followed by /step.
+func checkItem(item string, min, max int, names []string) error {
+	item, step, hasStep := strings.Cut(item, "/")
+	if hasStep {
+		if n, err := strconv.Atoi(step); err != nil || n < 1 {
+			return fmt.Errorf("bad step %q", step)
+		}
+	}
+	if item == "*" {
+		return nil
+	}
+	lo, hi, isRange := strings.Cut(item, "-")
+	a, err := value(lo, min, max, names)
+	if err != nil {
+		return err
+	}
+	if !isRange {
+		return nil
+	}
+	b, err := value(hi, min, max, names)
+	if err != nil {
+		return err
+	}
+	if a > b {
+		return fmt.Errorf("range %s is backwards", item)
+	}
+	return nil
+}
+
+func value(s string, min, max int, names []string) (int, error) {
+	for i, name := range names {
+		if strings.EqualFold(s, name) {
+			return i + min, nil
+		}
+	}
+	n, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a number", s)
+	}
+	if n < min || n > max {
+		return 0, fmt.Errorf("%d is outside %d-%d", n, min, max)
+	}
+	return n, nil
+}
+
+// ValidateFile checks every entry of a user crontab file.
+func ValidateFile(path string) error {
+	return validate(path, false)
+}
+
+func validate(path string, system bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, j := range parse(data, system) {
+		if j.Kind == KindInvalid {
+			errs = append(errs, fmt.Errorf("%s:%d: %s", path, j.Line, j.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
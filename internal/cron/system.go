@@ -0,0 +1,555 @@
+package cron
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/escalate"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/sysfile"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name:     "cron-system",
+		Files:    SystemFiles,
+		Validate: func(_ context.Context, _, staged string) error { return validate(staged, true) },
+		Apply:    writeSystem,
+	})
+	trash.Register("cron-system", restoreSystemLines)
+}
+
+// SystemMode is the mode of the system crontabs shctl writes. cron
+// ignores files that others can write.
+const SystemMode fs.FileMode = 0o644
+
+// dropInRe matches the names cron reads from cron.d; like run-parts it
+// skips anything else, such as editor backups and .dpkg-old files.
+var dropInRe = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// SystemDir is the directory of system cron drop-ins.
+func SystemDir() string {
+	return config.Get("cron_dir")
+}
+
+// SystemCrontab is the system crontab, /etc/crontab.
+func SystemCrontab() string {
+	return config.Get("system_crontab")
+}
+
+// DropInPath returns the path of drop-in name, or of the system crontab
+// when name is empty. Names cron would ignore are refused.
+func DropInPath(name string) (string, error) {
+	if name == "" {
+		return SystemCrontab(), nil
+	}
+	if !dropInRe.MatchString(name) {
+		return "", fmt.Errorf("cron ignores %q in %s; use only letters, digits, - and _: %w", name, SystemDir(), util.ErrUsage)
+	}
+	return filepath.Join(SystemDir(), name), nil
+}
+
+// SystemFiles returns the system crontab, when present, followed by the
+// drop-ins cron reads, in name order.
+func SystemFiles() ([]string, error) {
+	var out []string
+	if _, err := fsys.Current.Stat(SystemCrontab()); err == nil {
+		out = append(out, SystemCrontab())
+	}
+	entries, err := fsys.Current.ReadDir(SystemDir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && dropInRe.MatchString(e.Name()) {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		out = append(out, filepath.Join(SystemDir(), n))
+	}
+	return out, nil
+}
+
+// SystemJobs returns the entries of every system crontab, each with the
+// file it came from.
+func SystemJobs() ([]Job, error) {
+	files, err := SystemFiles()
+	if err != nil {
+		return nil, err
+	}
+	out := []Job{}
+	for _, f := range files {
+		data, err := fsys.Current.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, j := range parse(data, true) {
+			j.Source = f
+			out = append(out, j)
+		}
+	}
+	return out, nil
+}
+
+// ListSystem prints the system jobs as "file:line: entry", or as JSON or
+// YAML records, narrowed and ordered by output.List on their commands.
+func ListSystem(w io.Writer, format string) error {
+	jobs, err := SystemJobs()
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(jobs), func(i int) output.Key {
+		name := jobs[i].Command
+		if jobs[i].Kind != KindJob {
+			name = jobs[i].Name
+		}
+		return output.Key{Name: name, File: jobs[i].Source}
+	})
+	if err != nil {
+		return err
+	}
+	out := make([]Job, len(idx))
+	for i, j := range idx {
+		out[i] = jobs[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, out)
+	}
+	for _, j := range out {
+		if _, err := fmt.Fprintf(w, "%s:%d: %s\n", j.Source, j.Line, j.Raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddSystem appends a job running command as user on schedule to drop-in
+// name, creating it, or to the system crontab when name is empty. The
+// user must exist.
+func AddSystem(ctx context.Context, name, schedule, username, command string) error {
+	path, err := DropInPath(name)
+	if err != nil {
+		return err
+	}
+	if strings.ContainsAny(command, "\n\r") {
+		return fmt.Errorf("cron command must be one line: %w", util.ErrUsage)
+	}
+	if _, err := user.Lookup(username); err != nil {
+		return fmt.Errorf("cron job user %q: %v: %w", username, err, util.ErrUsage)
+	}
+	j, _ := parseLine(1, strings.TrimSpace(schedule)+" "+username+" "+strings.TrimSpace(command), true)
+	if j.Kind != KindJob {
+		msg := j.Err
+		if msg == "" {
+			msg = "not a job"
+		}
+		return fmt.Errorf("invalid cron job: %s: %w", msg, util.ErrUsage)
+	}
+	return changeSystem(ctx, path, "add", func(tmp string) error {
+		data, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		for _, e := range parse(data, true) {
+			if e.Kind == KindJob && e.Schedule == j.Schedule && e.User == j.User && e.Command == j.Command {
+				return fmt.Errorf("%s: %s: %w", path, j.Raw, ErrJobExists)
+			}
+		}
+		return appendLines(tmp, data, []string{j.Raw})
+	})
+}
+
+// RemoveSystem deletes the jobs running command from drop-in name, or
+// from the system crontab when name is empty, keeping them in the trash.
+func RemoveSystem(ctx context.Context, name, command string) error {
+	path, err := DropInPath(name)
+	if err != nil {
+		return err
+	}
+	command = strings.TrimSpace(command)
+	var removed []string
+	err = changeSystem(ctx, path, "remove", func(tmp string) error {
+		data, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		drop := map[int]bool{}
+		removed = nil
+		for _, j := range parse(data, true) {
+			if j.Kind == KindJob && j.Command == command {
+				drop[j.Line] = true
+				removed = append(removed, j.Raw)
+			}
+		}
+		if len(drop) == 0 {
+			return util.NotFound(fmt.Sprintf("no job in %s runs %q", path, command))
+		}
+		_, err = util.EditLines(fsys.OS, tmp, func(n int, l string) (string, bool) {
+			return l, !drop[n]
+		})
+		return err
+	})
+	return discardSystem(path, "remove", removed, err)
+}
+
+// DeleteSystem removes drop-in name altogether after confirming, backing
+// it up first and keeping its entries in the trash.
+func DeleteSystem(ctx context.Context, name string) error {
+	if name == "" {
+		return fmt.Errorf("refusing to delete the system crontab: %w", util.ErrUsage)
+	}
+	path, err := DropInPath(name)
+	if err != nil {
+		return err
+	}
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := fsys.Current.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return util.NotFound(fmt.Sprintf("no cron drop-in %s", path))
+	}
+	if err != nil {
+		return err
+	}
+	diff := util.UnifiedDiff(path, "/dev/null", data, nil)
+	err = sysfile.Confirm(ctx, "cron", "delete", path, diff, "Delete "+path+"?", func() error {
+		return removeSystem(ctx, path)
+	})
+	var removed []string
+	for _, j := range parse(data, true) {
+		removed = append(removed, j.Raw)
+	}
+	return discardSystem(path, "delete", removed, err)
+}
+
+// discardSystem keeps the entries op removed from path in the trash once
+// the change went through; err is the change's result.
+func discardSystem(path, op string, removed []string, err error) error {
+	if err != nil {
+		return err
+	}
+	if len(removed) == 0 {
+		return nil
+	}
+	if terr := trash.Put("cron-system", path, "cron "+op, removed); terr != nil {
+		output.Warn(prompt.Out, "trash: %v", terr)
+	}
+	return nil
+}
+
+// restoreSystemLines appends entries from the trash back to the file they
+// were removed from, recreating a deleted drop-in.
+func restoreSystemLines(ctx context.Context, path string, lines []string) error {
+	return changeSystem(ctx, path, "trash restore", func(tmp string) error {
+		data, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		return appendLines(tmp, data, lines)
+	})
+}
+
+// EditSystem opens a copy of drop-in name, or of the system crontab when
+// name is empty, in $VISUAL/$EDITOR and applies it once it validates.
+func EditSystem(ctx context.Context, name string) error {
+	path, err := DropInPath(name)
+	if err != nil {
+		return err
+	}
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tmp, err := stageSystem(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	changed, err := editLoop(ctx, tmp, true)
+	if err != nil || !changed {
+		return err
+	}
+	return sysfile.Apply(ctx, "cron", "edit", tmp, path, writeSystem)
+}
+
+// CheckSystem validates every system crontab without changing anything.
+// Entries that do not parse and files cron would refuse to read are
+// errors; jobs for users that do not exist are warnings.
+func CheckSystem(w io.Writer) error {
+	files, err := SystemFiles()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	jobs, warnings := 0, 0
+	if entries, err := fsys.Current.ReadDir(SystemDir()); err == nil {
+		for _, e := range entries {
+			if !e.IsDir() && !dropInRe.MatchString(e.Name()) && !strings.HasPrefix(e.Name(), ".") {
+				output.Warn(w, "%s: cron ignores this file because of its name", filepath.Join(SystemDir(), e.Name()))
+				warnings++
+			}
+		}
+	}
+	for _, f := range files {
+		if err := checkOwnership(f); err != nil {
+			errs = append(errs, err)
+		}
+		if err := validate(f, true); err != nil {
+			errs = append(errs, err)
+		}
+		data, err := fsys.Current.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		for _, j := range parse(data, true) {
+			if j.Kind != KindJob {
+				continue
+			}
+			jobs++
+			if _, err := user.Lookup(j.User); err != nil {
+				output.Warn(w, "%s:%d: no user %s to run the job as", f, j.Line, j.User)
+				warnings++
+			}
+		}
+	}
+	for _, e := range errs {
+		output.Error(w, "%v", e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("system crontabs: %d problem(s) found: %w", len(errs), errors.Join(errs...))
+	}
+	fmt.Fprintf(w, "system crontabs: ok (%d file(s), %d job(s), %d warning(s))\n", len(files), jobs, warnings)
+	return nil
+}
+
+// checkOwnership reports a file cron skips: not owned by root, or
+// writable by group or others.
+func checkOwnership(path string) error {
+	fi, err := fsys.Current.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.Mode().Perm()&0o022 != 0 {
+		return fmt.Errorf("%s: mode %04o lets others write it, so cron ignores it", path, fi.Mode().Perm())
+	}
+	if uid, _, ok := fsys.FileOwner(fi); ok && uid != 0 {
+		return fmt.Errorf("%s: owned by uid %d, not root, so cron ignores it", path, uid)
+	}
+	return nil
+}
+
+// BackupSystem saves drop-in name, or the system crontab when name is
+// empty.
+func BackupSystem(ctx context.Context, name string) error {
+	path, err := DropInPath(name)
+	if err != nil {
+		return err
+	}
+	_, err = backup.Save(ctx, path)
+	return err
+}
+
+// PreviewRestoreSystem prints what RestoreSystem would do without
+// changing anything.
+func PreviewRestoreSystem(ctx context.Context, w io.Writer, name string) error {
+	path, err := DropInPath(name)
+	if err != nil {
+		return err
+	}
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return err
+	}
+	latest, err := backup.Latest(ctx, store, path)
+	if err != nil {
+		return err
+	}
+	return backup.Preview(ctx, w, store, latest, path)
+}
+
+// RestoreSystem puts back the newest backup of drop-in name, or of the
+// system crontab when name is empty.
+func RestoreSystem(ctx context.Context, name string) error {
+	path, err := DropInPath(name)
+	if err != nil {
+		return err
+	}
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return err
+	}
+	latest, err := backup.Latest(ctx, store, path)
+	if err != nil {
+		return err
+	}
+	if err := backup.Announce(prompt.Out, latest, path); err != nil {
+		return err
+	}
+	tmp, err := backup.ExtractToTemp(ctx, store, latest)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := validate(tmp, true); err != nil {
+		return fmt.Errorf("backup %s failed validation: %w", path, err)
+	}
+	return sysfile.Apply(ctx, "cron", "restore", tmp, path, writeSystem)
+}
+
+// stageSystem copies path, or nothing when it does not exist yet, to a
+// temporary file.
+func stageSystem(path string) (string, error) {
+	if _, err := fsys.Current.Stat(path); err == nil {
+		return util.CopyToTemp(path)
+	}
+	f, err := os.CreateTemp("", "shctl_cron_*")
+	if err != nil {
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// changeSystem applies fn to a temporary copy of path, validates the copy
+// and then applies it, holding the target lock throughout.
+func changeSystem(ctx context.Context, path, op string, fn func(tmp string) error) error {
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tmp, err := stageSystem(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	logging.Info("system crontab", "op", op, "path", path, "tmp", tmp)
+
+	if err := fn(tmp); err != nil {
+		return err
+	}
+	if err := validate(tmp, true); err != nil {
+		return fmt.Errorf("crontab validation failed: %w", err)
+	}
+	return sysfile.Apply(ctx, "cron", op, tmp, path, writeSystem)
+}
+
+// writeSystem writes tmp over dest as a root-owned SystemMode file, going
+// through the escalator when the current user cannot.
+func writeSystem(ctx context.Context, tmp, dest string) error {
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	if !fsys.IsOS() {
+		if os.Geteuid() != 0 {
+			if esc, err := escalate.Get(); err == nil && esc != escalate.None {
+				dryrun.Skip(esc.Command(ctx, "install", "-m", "0644", "-o", "root", "-g", "root", tmp, dest).Args...)
+			}
+		}
+		return fsys.Current.WriteFile(dest, data, SystemMode)
+	}
+	if os.Geteuid() == 0 {
+		return writeRoot(data, dest)
+	}
+	esc, err := escalate.Get()
+	if err != nil {
+		return err
+	}
+	if esc == escalate.None {
+		return fmt.Errorf("cannot write %s as uid %d; re-run as root or configure an escalator: %w", dest, os.Geteuid(), util.ErrPermission)
+	}
+	out, err := esc.Command(ctx, "install", "-m", "0644", "-o", "root", "-g", "root", tmp, dest).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s install: %s: %w", esc.Name(), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// writeRoot replaces dest with data, owned by root with SystemMode, by
+// renaming a sibling temp file into place. The temp file's name starts
+// with a dot, which cron skips.
+func writeRoot(data []byte, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".shctl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(SystemMode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chown(0, 0); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), dest); err != nil {
+		return err
+	}
+	return util.RestoreSecurityContext(dest)
+}
+
+// removeSystem deletes dest, through the escalator when needed.
+func removeSystem(ctx context.Context, dest string) error {
+	if !fsys.IsOS() || os.Geteuid() == 0 {
+		return fsys.Current.Remove(dest)
+	}
+	esc, err := escalate.Get()
+	if err != nil {
+		return err
+	}
+	if esc == escalate.None {
+		return fmt.Errorf("cannot remove %s as uid %d; re-run as root or configure an escalator: %w", dest, os.Geteuid(), util.ErrPermission)
+	}
+	out, err := esc.Command(ctx, "rm", "-f", dest).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s rm: %s: %w", esc.Name(), strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+package doas
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/sysfile"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name:     "doas",
+		Files:    func() ([]string, error) { return []string{DoasPath()}, nil },
+		Validate: func(ctx context.Context, _, staged string) error { return validate(ctx, staged) },
+		Apply:    copyBack,
+	})
+}
+
+func DoasPath() string {
+	return config.Get("doas_file")
+}
+
+func BackupDir() string {
+	return config.Get("backup_dir")
+}
+
+// RequireDoas makes validation fail when doas is not installed instead of
+// falling back to the built-in parser.
+var RequireDoas = false
+
+// List prints every rule as "line: rule", with the rule number used by
+// RemoveNumber.
+func List(w io.Writer) error {
+	rules, err := Rules()
+	if err != nil {
+		return err
+	}
+	idx := make([]int, len(rules))
+	for i := range idx {
+		idx[i] = i
+	}
+	return printRules(w, rules, idx)
+}
+
+// printRules prints rules[i] for every i of idx, numbered by position in
+// the file.
+func printRules(w io.Writer, rules []Rule, idx []int) error {
+	for _, i := range idx {
+		if _, err := fmt.Fprintf(w, "%3d  %d: %s\n", i+1, rules[i].Line, rules[i].Raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListFormat prints rules as text, JSON or YAML, narrowed and ordered by
+// output.List on their text.
+func ListFormat(w io.Writer, format string) error {
+	rules, err := Rules()
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(rules), func(i int) output.Key {
+		return output.Key{Name: rules[i].Raw, File: DoasPath()}
+	})
+	if err != nil {
+		return err
+	}
+	if !output.Structured(format) {
+		return printRules(w, rules, idx)
+	}
+	out := make([]Rule, len(idx))
+	for i, j := range idx {
+		out[i] = rules[j]
+	}
+	return output.Write(w, format, out)
+}
+
+// Add appends a rule after checking it parses.
+func Add(ctx context.Context, rule string) error {
+	tokens, err := tokenize(rule)
+	if err != nil {
+		return err
+	}
+	if len(tokens) == 0 {
+		return fmt.Errorf("empty doas rule")
+	}
+	var r Rule
+	if err := parseRule(tokens, &r); err != nil {
+		return fmt.Errorf("invalid doas rule %q: %w", rule, err)
+	}
+	return change(ctx, "add", "doas validation failed", func(tmp string) error {
+		return util.AppendFileAtomic(tmp, []byte(r.String()+"\n"))
+	})
+}
+
+// Remove deletes rules for identity, limited to cmd when it is not empty.
+func Remove(ctx context.Context, identity, cmd string) error {
+	return change(ctx, "remove", "doas validation failed after removal", func(tmp string) error {
+		rules, err := parseFile(tmp)
+		if err != nil {
+			return err
+		}
+		drop := map[int]bool{}
+		for _, r := range rules {
+			if r.Identity == identity && (cmd == "" || r.Cmd == cmd) {
+				drop[r.Line] = true
+			}
+		}
+		if len(drop) == 0 {
+			return fmt.Errorf("no doas rule for %s in %s", identity, DoasPath())
+		}
+		return dropLines(tmp, rules, drop)
+	})
+}
+
+// RemoveNumber deletes the n-th rule as numbered by List.
+func RemoveNumber(ctx context.Context, n int) error {
+	return change(ctx, "remove", "doas validation failed after removal", func(tmp string) error {
+		rules, err := parseFile(tmp)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > len(rules) {
+			return fmt.Errorf("no doas rule number %d (have %d)", n, len(rules))
+		}
+		return dropLines(tmp, rules, map[int]bool{rules[n-1].Line: true})
+	})
+}
+
+// dropLines removes the rules starting at the given lines, including
+// their continuation lines.
+func dropLines(path string, rules []Rule, starts map[int]bool) error {
+	skipping := false
+	_, err := util.EditLines(fsys.OS, path, func(n int, l string) (string, bool) {
+		if starts[n] {
+			skipping = true
+		}
+		keep := !skipping
+		if skipping && !strings.HasSuffix(l, "\\") {
+			skipping = false
+		}
+		return l, keep
+	})
+	return err
+}
+
+// Check validates doas.conf without changing it. Errors are returned;
+// risky but valid rules are printed to w as warnings.
+func Check(ctx context.Context, w io.Writer) error {
+	path := DoasPath()
+	rules, err := Rules()
+	if err != nil {
+		return err
+	}
+	errs := validateRules(path, rules)
+	if _, lerr := exec.LookPath("doas"); lerr == nil {
+		if err := doasCheck(ctx, path); err != nil {
+			errs = append(errs, err)
+		}
+	} else if RequireDoas {
+		errs = append(errs, fmt.Errorf("doas not found and strict validation is required: %w", lerr))
+	}
+	warnings := 0
+	for _, r := range rules {
+		if r.Err != "" || r.Action != "permit" {
+			continue
+		}
+		nopass, keepenv := false, false
+		for _, o := range r.Options {
+			nopass = nopass || o == "nopass"
+			keepenv = keepenv || o == "keepenv"
+		}
+		if nopass && r.Cmd == "" {
+			output.Warn(w, "%s:%d: %s may run any command without a password", path, r.Line, r.Identity)
+			warnings++
+		}
+		if keepenv && r.Cmd == "" {
+			output.Warn(w, "%s:%d: keepenv without cmd lets %s pass its environment to any command", path, r.Line, r.Identity)
+			warnings++
+		}
+	}
+	for _, e := range errs {
+		output.Error(w, "%v", e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %d problem(s) found: %w", path, len(errs), errors.Join(errs...))
+	}
+	fmt.Fprintf(w, "%s: ok (%d rule(s), %d warning(s))\n", path, len(rules), warnings)
+	return nil
+}
+
+// ValidateFile checks a doas.conf with the built-in parser.
+func ValidateFile(path string) error {
+	rules, err := parseFile(path)
+	if err != nil {
+		return err
+	}
+	return errors.Join(validateRules(path, rules)...)
+}
+
+func validateRules(path string, rules []Rule) []error {
+	errs := []error{}
+	for _, r := range rules {
+		if r.Err != "" {
+			errs = append(errs, fmt.Errorf("%s:%d: %s", path, r.Line, r.Err))
+		}
+	}
+	return errs
+}
+
+// doasCheck runs doas -C, which parses the file and reports syntax errors.
+// A dry run uses the built-in parser instead.
+func doasCheck(ctx context.Context, path string) error {
+	if dryrun.Skip("doas", "-C", path) {
+		return ValidateFile(path)
+	}
+	out, err := exec.CommandContext(ctx, "doas", "-C", path).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("doas -C: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+func validate(ctx context.Context, path string) error {
+	if _, err := exec.LookPath("doas"); err != nil {
+		if RequireDoas {
+			return fmt.Errorf("doas not found and strict validation is required: %w", err)
+		}
+		return ValidateFile(path)
+	}
+	return doasCheck(ctx, path)
+}
+
+// change copies doas.conf to a temp file, lets fn edit it, validates the
+// copy and then applies it, holding the target lock throughout.
+func change(ctx context.Context, op, failMsg string, fn func(tmp string) error) error {
+	orig := DoasPath()
+	unlock, err := util.LockTarget(ctx, orig)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tmp, err := util.CopyToTemp(orig)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+
+	if err := fn(tmp); err != nil {
+		return err
+	}
+	if err := validate(ctx, tmp); err != nil {
+		return fmt.Errorf("%s: %w", failMsg, err)
+	}
+	return sysfile.Apply(ctx, "doas", op, tmp, orig, copyBack)
+}
+
+func Backup(ctx context.Context) error {
+	_, err := backup.Save(ctx, DoasPath())
+	return err
+}
+
+// PreviewRestore prints what Restore would do without changing anything.
+func PreviewRestore(ctx context.Context, w io.Writer) error {
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return err
+	}
+	latest, err := backup.Latest(ctx, store, DoasPath())
+	if err != nil {
+		return err
+	}
+	return backup.Preview(ctx, w, store, latest, DoasPath())
+}
+
+func Restore(ctx context.Context) error {
+	unlock, err := util.LockTarget(ctx, DoasPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return err
+	}
+	latest, err := backup.Latest(ctx, store, DoasPath())
+	if err != nil {
+		return err
+	}
+	if err := backup.Announce(prompt.Out, latest, DoasPath()); err != nil {
+		return err
+	}
+	tmp, err := backup.ExtractToTemp(ctx, store, latest)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	if err := validate(ctx, tmp); err != nil {
+		return fmt.Errorf("backup doas.conf failed validation: %w", err)
+	}
+	return sysfile.Apply(ctx, "doas", "restore", tmp, DoasPath(), copyBack)
+}
+
+// copyBack writes tmp over dest through sysfile.Copy.
+func copyBack(ctx context.Context, tmp, dest string) error {
+	return sysfile.Copy(ctx, tmp, dest, 0o600)
+}
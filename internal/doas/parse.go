@@ -0,0 +1,227 @@
+package doas
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Rule is one line of doas.conf:
+//
+//	permit|deny [options] identity [as target] [cmd command [args ...]]
+type Rule struct {
+	Line     int      `json:"line"`
+	Raw      string   `json:"raw"`
+	Err      string   `json:"error,omitempty"`
+	Action   string   `json:"action,omitempty"`
+	Options  []string `json:"options,omitempty"`
+	SetEnv   []string `json:"setenv,omitempty"`
+	Identity string   `json:"identity,omitempty"`
+	Target   string   `json:"target,omitempty"`
+	Cmd      string   `json:"cmd,omitempty"`
+	Args     []string `json:"args,omitempty"`
+	// HasArgs is set when the rule restricts arguments; an empty Args
+	// then means "no arguments".
+	HasArgs bool `json:"has_args,omitempty"`
+}
+
+var knownOptions = map[string]bool{
+	"nopass": true, "nolog": true, "persist": true, "keepenv": true, "insult": true,
+}
+
+// Rules parses the configured doas.conf.
+func Rules() ([]Rule, error) {
+	return parseFile(DoasPath())
+}
+
+func parseFile(path string) ([]Rule, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// Parse reads doas.conf rules. Lines that do not parse are returned with
+// Err set rather than failing the whole file. Backslash continuations are
+// joined.
+func Parse(r io.Reader) ([]Rule, error) {
+	out := []Rule{}
+	sc := bufio.NewScanner(r)
+	n, start := 0, 0
+	pending := ""
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		if pending == "" {
+			start = n
+		}
+		if strings.HasSuffix(line, "\\") {
+			pending += strings.TrimSuffix(line, "\\") + " "
+			continue
+		}
+		line = strings.TrimSpace(pending + line)
+		pending = ""
+		tokens, err := tokenize(line)
+		if err == nil && len(tokens) == 0 {
+			continue
+		}
+		rule := Rule{Line: start, Raw: line}
+		if err == nil {
+			err = parseRule(tokens, &rule)
+		}
+		if err != nil {
+			rule.Err = err.Error()
+		}
+		out = append(out, rule)
+	}
+	return out, sc.Err()
+}
+
+// tokenize splits a line into words, honouring double quotes and
+// dropping a trailing comment.
+func tokenize(line string) ([]string, error) {
+	tokens := []string{}
+	var cur strings.Builder
+	inWord, quoted := false, false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\\' && i+1 < len(line):
+			i++
+			cur.WriteByte(line[i])
+			inWord = true
+		case quoted && c == '"':
+			quoted = false
+		case quoted:
+			cur.WriteByte(c)
+		case c == '"':
+			quoted, inWord = true, true
+		case c == '#':
+			i = len(line)
+		case c == ' ' || c == '\t':
+			if inWord {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		case c == '{' || c == '}':
+			if inWord {
+				tokens = append(tokens, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+			tokens = append(tokens, string(c))
+		default:
+			cur.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inWord {
+		tokens = append(tokens, cur.String())
+	}
+	return tokens, nil
+}
+
+func parseRule(t []string, r *Rule) error {
+	r.Action = t[0]
+	if r.Action != "permit" && r.Action != "deny" {
+		return fmt.Errorf("rule must start with permit or deny, not %q", t[0])
+	}
+	i := 1
+	for ; i < len(t); i++ {
+		switch {
+		case t[i] == "setenv":
+			if i+1 >= len(t) || t[i+1] != "{" {
+				return fmt.Errorf("setenv needs a { list }")
+			}
+			j := i + 2
+			for ; j < len(t) && t[j] != "}"; j++ {
+				r.SetEnv = append(r.SetEnv, t[j])
+			}
+			if j == len(t) {
+				return fmt.Errorf("setenv list is missing }")
+			}
+			r.Options = append(r.Options, "setenv")
+			i = j
+		case knownOptions[t[i]]:
+			r.Options = append(r.Options, t[i])
+		default:
+			goto identity
+		}
+	}
+identity:
+	if len(r.Options) > 0 && r.Action == "deny" {
+		return fmt.Errorf("deny rules take no options")
+	}
+	if i >= len(t) || t[i] == "as" || t[i] == "cmd" {
+		return fmt.Errorf("missing identity")
+	}
+	r.Identity = t[i]
+	i++
+	if i < len(t) && t[i] == "as" {
+		if i+1 >= len(t) {
+			return fmt.Errorf("as needs a target user")
+		}
+		r.Target = t[i+1]
+		i += 2
+	}
+	if i < len(t) && t[i] == "cmd" {
+		if i+1 >= len(t) {
+			return fmt.Errorf("cmd needs a command")
+		}
+		r.Cmd = t[i+1]
+		i += 2
+		if i < len(t) && t[i] == "args" {
+			r.HasArgs = true
+			r.Args = append([]string{}, t[i+1:]...)
+			i = len(t)
+		}
+	}
+	if i < len(t) {
+		return fmt.Errorf("unexpected %q", t[i])
+	}
+	return nil
+}
+
+// String renders the rule in canonical form.
+func (r Rule) String() string {
+	if r.Err != "" {
+		return r.Raw
+	}
+	parts := []string{r.Action}
+	for _, o := range r.Options {
+		if o == "setenv" {
+			parts = append(parts, "setenv { "+strings.Join(r.SetEnv, " ")+" }")
+			continue
+		}
+		parts = append(parts, o)
+	}
+	parts = append(parts, quote(r.Identity))
+	if r.Target != "" {
+		parts = append(parts, "as", quote(r.Target))
+	}
+	if r.Cmd != "" {
+		parts = append(parts, "cmd", quote(r.Cmd))
+		if r.HasArgs {
+			parts = append(parts, "args")
+			for _, a := range r.Args {
+				parts = append(parts, quote(a))
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t#\"\\{}") {
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+	}
+	return s
+}
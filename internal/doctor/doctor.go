@@ -0,0 +1,250 @@
+// Package doctor checks the environment shctl works in: the rc file, the
+// shell it was picked for, visudo, the backup directory, leftovers of
+// runs that died half way, locks and the entries shctl manages. Every
+// problem comes with a suggested fix.
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/journal"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/sudoers"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Statuses of a check.
+const (
+	OK   = "ok"
+	Warn = "warn"
+	Fail = "fail"
+)
+
+// StaleAfter is how old a temporary file must be before it is taken for
+// the leftover of an aborted write rather than one being written.
+var StaleAfter = time.Hour
+
+// Result is the outcome of one check.
+type Result struct {
+	Check  string `json:"check"`
+	Status string `json:"status"`
+	Detail string `json:"detail"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// ErrProblems is returned by Run when a check failed.
+var ErrProblems = errors.New("doctor found problems")
+
+var checks = []struct {
+	name string
+	fn   func(ctx context.Context) Result
+}{
+	{"rc file", checkRC},
+	{"shell", checkShell},
+	{"visudo", checkVisudo},
+	{"backup dir", checkBackupDir},
+	{"temp files", checkTemp},
+	{"locks", checkLocks},
+	{"managed entries", checkEntries},
+}
+
+// Run performs every check. The error is ErrProblems when one of them
+// failed; warnings alone do not count.
+func Run(ctx context.Context) ([]Result, error) {
+	var out []Result
+	var err error
+	for _, c := range checks {
+		r := c.fn(ctx)
+		r.Check = c.name
+		if r.Status == Fail {
+			err = ErrProblems
+		}
+		out = append(out, r)
+	}
+	return out, err
+}
+
+// Write prints results as a table with the fixes below it, or as JSON or
+// YAML.
+func Write(w io.Writer, format string, results []Result) error {
+	if output.Structured(format) {
+		return output.Write(w, format, results)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Status, r.Check, r.Detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	for _, r := range results {
+		if r.Fix == "" {
+			continue
+		}
+		if r.Status == Fail {
+			output.Error(w, "%s: %s", r.Check, r.Fix)
+		} else {
+			output.Warn(w, "%s: %s", r.Check, r.Fix)
+		}
+	}
+	return nil
+}
+
+func checkRC(ctx context.Context) Result {
+	p := rc.RCPath()
+	fi, err := os.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Result{Status: Warn, Detail: p + " does not exist", Fix: "it is created on the first change, or set rc_file to the file your shell reads"}
+	}
+	if err != nil {
+		return Result{Status: Fail, Detail: err.Error(), Fix: "check the permissions of " + filepath.Dir(p)}
+	}
+	if !fi.Mode().IsRegular() {
+		return Result{Status: Fail, Detail: p + " is not a regular file", Fix: "point rc_file at a regular file"}
+	}
+	f, err := os.OpenFile(p, os.O_WRONLY, 0)
+	if err != nil {
+		return Result{Status: Fail, Detail: p + " is not writable: " + err.Error(), Fix: "chmod u+w " + p + ", or run shctl as its owner"}
+	}
+	f.Close()
+	return Result{Status: OK, Detail: p}
+}
+
+func checkShell(ctx context.Context) Result {
+	shell := config.Get("shell")
+	e, _ := config.Explain("shell")
+	detail := fmt.Sprintf("%s (from %s)", shell, e.Winner)
+	if login := filepath.Base(os.Getenv("SHELL")); e.Winner == "default" && login != "bash" && login != "zsh" && login != "." {
+		return Result{Status: Warn, Detail: detail + "; login shell " + login + " is not supported",
+			Fix: "set shell to bash or zsh in the config file to pick the rc file shctl manages"}
+	}
+	return Result{Status: OK, Detail: detail}
+}
+
+func checkVisudo(ctx context.Context) Result {
+	p, err := exec.LookPath("visudo")
+	if err == nil {
+		return Result{Status: OK, Detail: p}
+	}
+	status := Warn
+	if sudoers.RequireVisudo {
+		status = Fail
+	}
+	return Result{Status: status, Detail: "visudo not found; sudoers changes are checked by the built-in parser only",
+		Fix: "install sudo, or add the directory holding visudo (often /usr/sbin) to PATH"}
+}
+
+func checkBackupDir(ctx context.Context) Result {
+	if url := config.Get("backup_url"); strings.Contains(url, "://") && !strings.HasPrefix(url, "file://") {
+		return Result{Status: OK, Detail: "remote store " + url + " (not checked)"}
+	}
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return Result{Status: Fail, Detail: err.Error(), Fix: "set backup_dir to a directory you can write"}
+	}
+	dir := store.Location("")
+	fi, err := os.Stat(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Result{Status: OK, Detail: dir + " (created on the first backup)"}
+	}
+	if err != nil {
+		return Result{Status: Fail, Detail: err.Error(), Fix: "check the permissions of " + filepath.Dir(dir)}
+	}
+	if !fi.IsDir() {
+		return Result{Status: Fail, Detail: dir + " is not a directory", Fix: "set backup_dir to a directory"}
+	}
+	f, err := os.CreateTemp(dir, ".shctl_doctor_*")
+	if err != nil {
+		return Result{Status: Fail, Detail: dir + " is not writable: " + err.Error(), Fix: "chmod u+w " + dir + ", or set backup_dir elsewhere"}
+	}
+	f.Close()
+	os.Remove(f.Name())
+	if fi.Mode().Perm()&0o077 != 0 {
+		return Result{Status: Warn, Detail: fmt.Sprintf("%s is accessible to others (%04o)", dir, fi.Mode().Perm()),
+			Fix: "backups hold your rc and sudoers contents; chmod 700 " + dir}
+	}
+	return Result{Status: OK, Detail: dir}
+}
+
+// tempPatterns are the names of the temporary files shctl writes, next to
+// the file being replaced or in the temp directory.
+func tempPatterns() []string {
+	out := []string{filepath.Join(os.TempDir(), "shctl_*")}
+	for _, p := range append(config.RCFiles(), sudoers.SudoersPath()) {
+		dir, base := filepath.Split(p)
+		out = append(out, filepath.Join(dir, "."+base+".tmp*"), filepath.Join(dir, "."+base+".shctl-*"))
+	}
+	return out
+}
+
+func checkTemp(ctx context.Context) Result {
+	var stale []string
+	cutoff := time.Now().Add(-StaleAfter)
+	for _, pat := range tempPatterns() {
+		matches, _ := filepath.Glob(pat)
+		for _, m := range matches {
+			if fi, err := os.Lstat(m); err == nil && fi.ModTime().Before(cutoff) {
+				stale = append(stale, m)
+			}
+		}
+	}
+	if len(stale) == 0 {
+		return Result{Status: OK, Detail: "no leftovers of aborted writes"}
+	}
+	return Result{Status: Warn, Detail: fmt.Sprintf("%d stale temporary file(s): %s", len(stale), strings.Join(stale, ", ")),
+		Fix: "they are left by runs that died; remove them with rm -r " + strings.Join(stale, " ")}
+}
+
+func checkLocks(ctx context.Context) Result {
+	pending, err := journal.Pending()
+	if err != nil {
+		return Result{Status: Fail, Detail: err.Error(), Fix: "check the permissions of " + journal.Dir()}
+	}
+	if len(pending) > 0 {
+		return Result{Status: Fail, Detail: fmt.Sprintf("%d unfinished transaction(s) in %s", len(pending), journal.Dir()),
+			Fix: "a run died while writing several files; run shctl recover to roll them back"}
+	}
+	// lock files nobody holds are left by runs that died
+	locks, removed, err := util.CleanLocks()
+	if err != nil {
+		return Result{Status: Warn, Detail: err.Error(), Fix: "check the permissions of " + strings.Join(util.LockDirs(), " and ")}
+	}
+	var held []string
+	for _, l := range locks {
+		held = append(held, strings.TrimSuffix(filepath.Base(l), ".lock"))
+	}
+	if len(held) > 0 {
+		return Result{Status: Warn, Detail: "held by another shctl run: " + strings.Join(held, ", "),
+			Fix: "wait for the other run to finish; a run waiting for confirmation holds its lock until answered"}
+	}
+	return Result{Status: OK, Detail: fmt.Sprintf("none held; removed %d stale lock file(s)", removed)}
+}
+
+func checkEntries(ctx context.Context) Result {
+	problems, err := rc.Problems(rc.RCPath())
+	if err != nil {
+		return Result{Status: Fail, Detail: err.Error(), Fix: "check that " + rc.RCPath() + " is readable"}
+	}
+	if len(problems) == 0 {
+		return Result{Status: OK, Detail: "aliases, exports and generated functions are well formed"}
+	}
+	lines := make([]string, len(problems))
+	for i, p := range problems {
+		lines[i] = fmt.Sprintf("line %d: %s", p.Line, p.Reason)
+	}
+	return Result{Status: Warn, Detail: strings.Join(lines, "; "),
+		Fix: "edit " + rc.RCPath() + " at those lines, or remove the entries and add them again with shctl"}
+}
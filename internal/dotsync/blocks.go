@@ -0,0 +1,77 @@
+package dotsync
+
+import "strings"
+
+const (
+	beginPrefix = "# BEGIN shctl "
+	endPrefix   = "# END shctl "
+)
+
+// blocks returns the shctl blocks of data, each with its marker lines.
+// Unclosed blocks are left out.
+func blocks(data []byte) [][]string {
+	var out [][]string
+	var cur []string
+	name := ""
+	for _, l := range strings.Split(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n") {
+		t := strings.TrimSpace(l)
+		if cur == nil {
+			if n, ok := strings.CutPrefix(t, beginPrefix); ok {
+				cur, name = []string{t}, n
+			}
+			continue
+		}
+		cur = append(cur, l)
+		if t == endPrefix+name {
+			cur[len(cur)-1] = t
+			out = append(out, cur)
+			cur = nil
+		}
+	}
+	return out
+}
+
+// blockText returns the blocks of data separated by blank lines, or nil
+// when it has none.
+func blockText(data []byte) []byte {
+	var parts []string
+	for _, b := range blocks(data) {
+		parts = append(parts, strings.Join(b, "\n"))
+	}
+	if parts == nil {
+		return nil
+	}
+	return []byte(strings.Join(parts, "\n\n") + "\n")
+}
+
+// mergeBlock replaces the block of data that has block's begin marker,
+// or appends block after a blank line when data has none.
+func mergeBlock(data []byte, block []string) []byte {
+	text := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	var lines []string
+	if text != "" {
+		lines = strings.Split(text, "\n")
+	}
+	end := endPrefix + strings.TrimPrefix(block[0], beginPrefix)
+	b, e := -1, -1
+	for i, l := range lines {
+		t := strings.TrimSpace(l)
+		if b < 0 && t == block[0] {
+			b = i
+		} else if b >= 0 && t == end {
+			e = i
+			break
+		}
+	}
+	var next []string
+	if e >= 0 {
+		next = append(append(append(next, lines[:b]...), block...), lines[e+1:]...)
+	} else {
+		next = lines
+		if len(next) > 0 && strings.TrimSpace(next[len(next)-1]) != "" {
+			next = append(next, "")
+		}
+		next = append(next, block...)
+	}
+	return []byte(strings.Join(next, "\n") + "\n")
+}
@@ -0,0 +1,381 @@
+// Package dotsync keeps the managed files in a git repository so another
+// machine can take them over. Push copies every file of every snapshot
+// subsystem, or with sync_mode=blocks only its shctl blocks, into the
+// repository and pushes it to origin; pull brings the repository up to
+// date and writes its content back through the subsystems' checks.
+//
+// The repository holds one directory per kind and subsystem, with home
+// directory files kept relative to home so they land in the new home:
+//
+//	files/rc/home/.bashrc
+//	blocks/ssh/home/.ssh/config
+//	hosts/<host>/files/hosts/root/etc/hosts
+//
+// Files under hosts/<host> are overlays: on that machine a whole file
+// replaces the shared one, and blocks replace the shared blocks of the
+// same name. Once a file has an overlay, pushes from its machine update
+// the overlay instead of the shared copy.
+package dotsync
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// The kinds of content the repository keeps.
+const (
+	Files  = "files"
+	Blocks = "blocks"
+)
+
+// Dir is the local sync repository.
+func Dir() string {
+	return config.Get("sync_dir")
+}
+
+// Host is the name of this machine's overlay: the hostname up to its
+// first dot.
+func Host() string {
+	h, _ := os.Hostname()
+	h, _, _ = strings.Cut(h, ".")
+	if h == "" {
+		return "localhost"
+	}
+	return h
+}
+
+// readOnly are the git commands that leave the repository alone.
+var readOnly = map[string]bool{"remote": true, "diff": true, "ls-remote": true}
+
+// git runs git in the sync repository. Commands that change it are
+// skipped in a dry run.
+func git(ctx context.Context, args ...string) (string, error) {
+	argv := append([]string{"git", "-C", Dir()}, args...)
+	if !readOnly[args[0]] && dryrun.Skip(argv...) {
+		return "", nil
+	}
+	var out, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdout, cmd.Stderr = &out, &stderr
+	logging.Debug("running", "argv", argv)
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", fmt.Errorf("sync needs git: %w", err)
+		}
+		return "", fmt.Errorf("git %s: %s: %w", args[0], strings.TrimSpace(stderr.String()), err)
+	}
+	return strings.TrimSpace(out.String()), nil
+}
+
+// open checks the repository is there and locks it.
+func open() (func(), error) {
+	if _, err := os.Stat(filepath.Join(Dir(), ".git")); err != nil {
+		return nil, util.NotFound(fmt.Sprintf("no sync repository in %s; run shctl sync init first", Dir()))
+	}
+	return util.Lock(filepath.Join(Dir(), ".git", "shctl-sync.lock"), true)
+}
+
+// hasOrigin reports whether the repository has a remote to sync with.
+func hasOrigin(ctx context.Context) bool {
+	remotes, _ := git(ctx, "remote")
+	for _, r := range strings.Split(remotes, "\n") {
+		if r == "origin" {
+			return true
+		}
+	}
+	return false
+}
+
+// Init creates the sync repository, or with a remote clones it. A cloned
+// repository that already holds files is pulled right away, which is how
+// a new machine takes over the environment of the others.
+func Init(ctx context.Context, remote string) error {
+	dir := Dir()
+	if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+		return fmt.Errorf("sync repository %s: %w", dir, util.ErrEntryExists)
+	}
+	if remote == "" {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		if _, err := git(ctx, "init", "-q"); err != nil {
+			return err
+		}
+	} else {
+		if err := os.MkdirAll(filepath.Dir(dir), 0o700); err != nil {
+			return err
+		}
+		if dryrun.Skip("git", "clone", "-q", remote, dir) {
+			return nil
+		}
+		cmd := exec.CommandContext(ctx, "git", "clone", "-q", remote, dir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("git clone %s: %s: %w", remote, strings.TrimSpace(string(out)), err)
+		}
+	}
+	// commits must not fail on hosts without a git identity
+	if _, err := git(ctx, "config", "user.email"); err != nil {
+		git(ctx, "config", "user.name", "shctl")
+		git(ctx, "config", "user.email", "shctl@localhost")
+	}
+	if remote == "" {
+		return nil
+	}
+	unlock, err := open()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return apply(ctx)
+}
+
+// key is where path is kept below a kind and subsystem directory.
+func key(path string) string {
+	home, _ := os.UserHomeDir()
+	if rel, err := filepath.Rel(home, path); home != "" && err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		return filepath.Join("home", rel)
+	}
+	return filepath.Join("root", path)
+}
+
+// localPath reverses key.
+func localPath(k string) (string, bool) {
+	if rest, ok := strings.CutPrefix(k, "home"+string(filepath.Separator)); ok {
+		home, err := os.UserHomeDir()
+		return filepath.Join(home, rest), err == nil
+	}
+	if rest, ok := strings.CutPrefix(k, "root"+string(filepath.Separator)); ok {
+		return string(filepath.Separator) + rest, true
+	}
+	return "", false
+}
+
+// Push copies the managed files, or their blocks, into the repository,
+// commits them and pushes to origin. With host they go to this machine's
+// overlay, so other machines do not get them.
+func Push(ctx context.Context, host bool) error {
+	unlock, err := open()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	kind := config.Get("sync_mode")
+	n := 0
+	for _, name := range snapshot.Subsystems() {
+		paths, err := snapshot.Files(name)
+		if skip(name, err) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		for _, p := range paths {
+			data, err := fsys.Current.ReadFile(p)
+			if skip(p, err) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if kind == Blocks {
+				if data = blockText(data); data == nil {
+					continue
+				}
+			}
+			rel := filepath.Join(kind, name, key(p))
+			overlay := filepath.Join("hosts", Host(), rel)
+			if _, err := os.Stat(filepath.Join(Dir(), overlay)); host || err == nil {
+				rel = overlay
+			}
+			dest := filepath.Join(Dir(), rel)
+			if err := fsys.Current.MkdirAll(filepath.Dir(dest), 0o700); err != nil {
+				return err
+			}
+			if err := fsys.Current.WriteFile(dest, data, 0o600); err != nil {
+				return err
+			}
+			n++
+		}
+	}
+	logging.Info("sync push", "files", n, "mode", kind)
+	if _, err := git(ctx, "add", "-A", "."); err != nil {
+		return err
+	}
+	if _, err := git(ctx, "diff", "--cached", "--quiet"); err != nil {
+		if _, err := git(ctx, "commit", "-q", "-m", "shctl sync push from "+Host()); err != nil {
+			return err
+		}
+	}
+	if !hasOrigin(ctx) {
+		return nil
+	}
+	if _, err := git(ctx, "push", "-q", "origin", "HEAD"); err != nil {
+		return fmt.Errorf("%w (run shctl sync pull first if origin has newer changes)", err)
+	}
+	return nil
+}
+
+// skip reports whether err leaves what out of a push: files this machine
+// does not have, and files the user may not read, such as a sudoers file
+// outside a root shell, which get a warning.
+func skip(what string, err error) bool {
+	if errors.Is(err, fs.ErrPermission) {
+		logging.Warn("sync push: skipped", "file", what, "err", err)
+	}
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission)
+}
+
+// Pull brings the repository up to date with origin and writes its
+// content, with this machine's overlays, over the local files.
+func Pull(ctx context.Context) error {
+	unlock, err := open()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if hasOrigin(ctx) {
+		// an empty origin has nothing to pull
+		if head, err := git(ctx, "ls-remote", "origin", "HEAD"); err != nil {
+			return err
+		} else if head != "" {
+			if _, err := git(ctx, "fetch", "-q", "origin", "HEAD"); err != nil {
+				return err
+			}
+			if _, err := git(ctx, "merge", "-q", "--ff-only", "FETCH_HEAD"); err != nil {
+				return fmt.Errorf("%w (the sync repository %s has local commits; resolve them with git)", err, Dir())
+			}
+		}
+	}
+	return apply(ctx)
+}
+
+// entry is what the repository holds for one local file.
+type entry struct {
+	subsystem string
+	full      []byte     // a whole file, or nil
+	blocks    [][]string // blocks to merge in, overlays last
+}
+
+// apply writes the repository content over the local files that differ.
+// Only files the subsystem they are filed under manages on this machine
+// are written; the repository may come from anywhere, so anything else is
+// left alone with a warning.
+func apply(ctx context.Context) error {
+	entries := map[string]*entry{}
+	managed := map[string]map[string]bool{}
+	allowed := func(sub, path string) bool {
+		if managed[sub] == nil {
+			managed[sub] = map[string]bool{}
+			paths, err := snapshot.Files(sub)
+			if err != nil {
+				logging.Warn("sync pull: ignored subsystem", "subsystem", sub, "err", err)
+			}
+			for _, p := range paths {
+				managed[sub][p] = true
+			}
+		}
+		if !managed[sub][path] {
+			logging.Warn("sync pull: ignored a file shctl does not manage", "subsystem", sub, "file", path)
+			return false
+		}
+		return true
+	}
+	for _, base := range []string{".", filepath.Join("hosts", Host())} {
+		for _, kind := range []string{Files, Blocks} {
+			if err := collect(entries, filepath.Join(Dir(), base, kind), kind, allowed); err != nil {
+				return err
+			}
+		}
+	}
+	paths := make([]string, 0, len(entries))
+	for p := range entries {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	var files []snapshot.File
+	data := map[string][]byte{}
+	for _, p := range paths {
+		e := entries[p]
+		cur, err := fsys.Current.ReadFile(p)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		next := cur
+		if e.full != nil {
+			next = e.full
+		}
+		for _, b := range e.blocks {
+			next = mergeBlock(next, b)
+		}
+		if bytes.Equal(next, cur) {
+			continue
+		}
+		mode := fs.FileMode(0o644)
+		if fi, err := fsys.Current.Stat(p); err == nil {
+			mode = fi.Mode().Perm()
+		}
+		files = append(files, snapshot.File{Subsystem: e.subsystem, Path: p, Mode: mode, Size: int64(len(next))})
+		data[p] = next
+	}
+	if len(files) == 0 {
+		logging.Info("sync pull: nothing to change")
+		return nil
+	}
+	return snapshot.Put(ctx, "sync pull", "sync repository "+Dir(), files, data)
+}
+
+// collect adds the files below root, laid out as subsystem/key, to
+// entries, keeping those allowed accepts.
+func collect(entries map[string]*entry, root, kind string, allowed func(sub, path string) bool) error {
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		sub, k, ok := strings.Cut(rel, string(filepath.Separator))
+		if !ok {
+			return nil
+		}
+		path, ok := localPath(k)
+		if !ok || !allowed(sub, path) {
+			return nil
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		e := entries[path]
+		if e == nil {
+			e = &entry{subsystem: sub}
+			entries[path] = e
+		}
+		if kind == Files {
+			e.full, e.blocks = b, nil
+		} else {
+			e.blocks = append(e.blocks, blocks(b)...)
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
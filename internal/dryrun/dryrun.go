@@ -0,0 +1,100 @@
+// Package dryrun runs any shctl operation against an in-memory overlay
+// and reports the diffs it would have applied.
+package dryrun
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/auditlog"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Enabled is set by the global --dry-run flag; Maybe honors it.
+var Enabled = false
+
+// planned collects the commands of the dry run in progress.
+var planned *[]string
+
+// Maybe runs fn as a dry run reporting to w when Enabled, and plainly
+// otherwise. The root command runs every subcommand through it.
+func Maybe(w io.Writer, fn func() error) error {
+	if !Enabled {
+		return fn()
+	}
+	_, err := Run(w, fn)
+	return err
+}
+
+// Skip records argv as a command the dry run in progress would run and
+// reports true, in which case the caller must not run it. Outside a dry
+// run it reports false.
+func Skip(argv ...string) bool {
+	if planned == nil {
+		return false
+	}
+	*planned = append(*planned, shellJoin(argv))
+	return true
+}
+
+// Run calls fn with every managed-file write redirected to an overlay of
+// the current file system, then writes a unified diff per changed file
+// to w, followed by the external commands fn would have run. Callers
+// skip those through Skip. Confirmations are answered yes, and automatic
+// backups and audit records are skipped, since nothing is really changed.
+func Run(w io.Writer, fn func() error) ([]fsys.Change, error) {
+	overlay := fsys.NewOverlay(fsys.Current)
+	restoreFS := fsys.Use(overlay)
+	yes, sink := prompt.AssumeYes, auditlog.Sink
+	prompt.AssumeYes = true
+	auditlog.Sink = func(auditlog.Record) error { return nil }
+	autoBackup, _ := config.Explain("auto_backup")
+	config.SetFlag("auto_backup", "false")
+	cmds := []string{}
+	planned = &cmds
+	defer func() {
+		restoreFS()
+		prompt.AssumeYes, auditlog.Sink = yes, sink
+		config.SetFlag("auto_backup", autoBackup.Layers[0].Value)
+		planned = nil
+	}()
+
+	if err := fn(); err != nil {
+		return nil, err
+	}
+	changes := overlay.Changes()
+	if len(changes) == 0 && len(cmds) == 0 {
+		_, err := fmt.Fprintln(w, "dry run: nothing would change")
+		return changes, err
+	}
+	fmt.Fprintf(w, "dry run: %d file(s) would change\n", len(changes))
+	for _, c := range changes {
+		if c.Removed {
+			fmt.Fprintf(w, "%s would be removed\n", c.Path)
+			continue
+		}
+		if err := output.WriteDiff(w, util.UnifiedDiff(c.Path, c.Path+" (dry run)", c.Before, c.After)); err != nil {
+			return changes, err
+		}
+	}
+	for _, c := range cmds {
+		if _, err := fmt.Fprintf(w, "would run: %s\n", c); err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// shellJoin renders argv for copying into a shell.
+func shellJoin(argv []string) string {
+	out := make([]string, len(argv))
+	for i, a := range argv {
+		out[i] = util.ShellQuote(a)
+	}
+	return strings.Join(out, " ")
+}
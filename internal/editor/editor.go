@@ -0,0 +1,429 @@
+// Package editor keeps the basics of a vim or neovim config in a block
+// shctl owns: the leader key, options, and the bootstrap of a plugin
+// manager. The block goes at the top of ~/.vimrc or init.lua, so the
+// user's own lines below it can use the leader and the plugins and still
+// override any option. The block is written again from its settings on
+// every change; lines in it that shctl does not know are kept at its end.
+package editor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// The editors shctl configures.
+const (
+	Vim    = "vim"
+	Neovim = "nvim"
+)
+
+// Editors lists the editors.
+var Editors = []string{Vim, Neovim}
+
+// The settings that are not options.
+const (
+	Leader    = "leader"
+	Bootstrap = "bootstrap"
+)
+
+var (
+	optionRe  = regexp.MustCompile(`^[a-z][a-z0-9]*$`)
+	vimSetRe  = regexp.MustCompile(`^set\s+([a-z][a-z0-9]*)(?:=(.*))?$`)
+	vimLetRe  = regexp.MustCompile(`^let\s+mapleader\s*=\s*(".*")$`)
+	luaOptRe  = regexp.MustCompile(`^vim\.opt\.([a-z][a-z0-9]*)\s*=\s*(.+)$`)
+	luaLeadRe = regexp.MustCompile(`^vim\.g\.mapleader\s*=\s*(".*")$`)
+)
+
+// bootstraps holds the plugin manager snippets of each editor; the first
+// line of each names it.
+var bootstraps = map[string]map[string][]string{
+	Vim: {
+		"vim-plug": {
+			`" bootstrap: vim-plug`,
+			`let s:plug = (has('win32') ? '~/vimfiles' : '~/.vim') . '/autoload/plug.vim'`,
+			`if empty(glob(s:plug))`,
+			`  silent execute '!curl -fLo ' . s:plug . ' --create-dirs https://raw.githubusercontent.com/junegunn/vim-plug/master/plug.vim'`,
+			`  autocmd VimEnter * PlugInstall --sync | source $MYVIMRC`,
+			`endif`,
+		},
+	},
+	Neovim: {
+		"lazy": {
+			`-- bootstrap: lazy`,
+			`local lazypath = vim.fn.stdpath("data") .. "/lazy/lazy.nvim"`,
+			`if not (vim.uv or vim.loop).fs_stat(lazypath) then`,
+			`  vim.fn.system({ "git", "clone", "--filter=blob:none", "--branch=stable", "https://github.com/folke/lazy.nvim.git", lazypath })`,
+			`end`,
+			`vim.opt.rtp:prepend(lazypath)`,
+		},
+		"vim-plug": {
+			`-- bootstrap: vim-plug`,
+			`local plug = vim.fn.stdpath("data") .. "/site/autoload/plug.vim"`,
+			`if vim.fn.empty(vim.fn.glob(plug)) == 1 then`,
+			`  vim.fn.system({ "curl", "-fLo", plug, "--create-dirs", "https://raw.githubusercontent.com/junegunn/vim-plug/master/plug.vim" })`,
+			`end`,
+		},
+	},
+}
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name: "editor",
+		Files: func() ([]string, error) {
+			var out []string
+			for _, e := range Editors {
+				if _, err := fsys.Current.Stat(ConfigPath(e)); err == nil {
+					out = append(out, ConfigPath(e))
+				}
+			}
+			return out, nil
+		},
+	})
+}
+
+// Setting is one setting of the block.
+type Setting struct {
+	Name  string `json:"name"`
+	Value string `json:"value"` // true or false for a boolean option
+}
+
+// ConfigPath is the config file of editor.
+func ConfigPath(editor string) string {
+	if editor == Neovim {
+		return config.Get("nvim_init")
+	}
+	return config.Get("vimrc")
+}
+
+func check(editor string) error {
+	if editor != Vim && editor != Neovim {
+		return fmt.Errorf("unknown editor %q (want %s): %w", editor, strings.Join(Editors, " or "), util.ErrUsage)
+	}
+	return nil
+}
+
+// comment is the line comment leader of editor's config.
+func comment(editor string) string {
+	if editor == Neovim {
+		return "--"
+	}
+	return `"`
+}
+
+func markers(editor string) (string, string) {
+	return comment(editor) + " BEGIN shctl editor", comment(editor) + " END shctl editor"
+}
+
+// ParseOption reads an option as given on the command line: name turns
+// a boolean option on, noname turns it off, and name=value sets it.
+// leader=KEY and bootstrap=MANAGER set the leader and the plugin manager.
+func ParseOption(s string) (Setting, error) {
+	name, value, ok := strings.Cut(s, "=")
+	if !ok {
+		value = "true"
+		if n, off := strings.CutPrefix(name, "no"); off && optionRe.MatchString(n) {
+			name, value = n, "false"
+		}
+	}
+	if !optionRe.MatchString(name) {
+		return Setting{}, fmt.Errorf("invalid option %q (want name, noname or name=value): %w", s, util.ErrUsage)
+	}
+	if (name == Leader || name == Bootstrap) && !ok {
+		return Setting{}, fmt.Errorf("%s needs a value, as in %s=VALUE: %w", name, name, util.ErrUsage)
+	}
+	if strings.ContainsAny(value, "\n\r\x00") {
+		return Setting{}, fmt.Errorf("%s: values are one line: %w", name, util.ErrUsage)
+	}
+	return Setting{Name: name, Value: value}, nil
+}
+
+// render writes the lines of the block for settings: the leader first,
+// then the options, then the plugin manager bootstrap, then extra lines.
+func render(editor string, settings []Setting, extra []string) []string {
+	var lead, opts, boot []string
+	for _, s := range settings {
+		switch s.Name {
+		case Leader:
+			if editor == Neovim {
+				lead = append(lead, "vim.g.mapleader = "+strconv.Quote(s.Value))
+			} else {
+				lead = append(lead, "let mapleader = "+vimString(s.Value))
+			}
+		case Bootstrap:
+			boot = append(boot, bootstraps[editor][s.Value]...)
+		default:
+			opts = append(opts, optionLine(editor, s))
+		}
+	}
+	begin, end := markers(editor)
+	out := append([]string{begin}, lead...)
+	out = append(append(append(out, opts...), boot...), extra...)
+	return append(out, end)
+}
+
+func optionLine(editor string, s Setting) string {
+	if editor == Neovim {
+		v := strconv.Quote(s.Value)
+		switch {
+		case s.Value == "true" || s.Value == "false":
+			v = s.Value
+		case isNumber(s.Value):
+			v = s.Value
+		}
+		return "vim.opt." + s.Name + " = " + v
+	}
+	switch s.Value {
+	case "true":
+		return "set " + s.Name
+	case "false":
+		return "set no" + s.Name
+	}
+	return "set " + s.Name + "=" + strings.NewReplacer(`\`, `\\`, " ", `\ `, "|", `\|`, `"`, `\"`).Replace(s.Value)
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+// vimString writes s as a vim double-quoted string.
+func vimString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// parse reads the settings back from the lines of a block and returns
+// the lines it does not know.
+func parse(editor string, lines []string) ([]Setting, []string) {
+	var settings []Setting
+	var extra []string
+	for i := 0; i < len(lines); i++ {
+		if name, n := bootstrapAt(editor, lines[i:]); n > 0 {
+			settings = append(settings, Setting{Bootstrap, name})
+			i += n - 1
+			continue
+		}
+		t := strings.TrimSpace(lines[i])
+		if s, ok := parseLine(editor, t); ok {
+			settings = append(settings, s)
+			continue
+		}
+		if t != "" {
+			extra = append(extra, lines[i])
+		}
+	}
+	return settings, extra
+}
+
+// bootstrapAt reports the plugin manager whose snippet starts lines, and
+// its length.
+func bootstrapAt(editor string, lines []string) (string, int) {
+	for name, snippet := range bootstraps[editor] {
+		if len(lines) < len(snippet) {
+			continue
+		}
+		match := true
+		for i, l := range snippet {
+			match = match && strings.TrimRight(lines[i], " \t") == l
+		}
+		if match {
+			return name, len(snippet)
+		}
+	}
+	return "", 0
+}
+
+func parseLine(editor, t string) (Setting, bool) {
+	if editor == Neovim {
+		if m := luaLeadRe.FindStringSubmatch(t); m != nil {
+			if v, err := strconv.Unquote(m[1]); err == nil {
+				return Setting{Leader, v}, true
+			}
+		}
+		if m := luaOptRe.FindStringSubmatch(t); m != nil {
+			v := strings.TrimSpace(m[2])
+			if u, err := strconv.Unquote(v); err == nil {
+				return Setting{m[1], u}, true
+			}
+			if v == "true" || v == "false" || isNumber(v) {
+				return Setting{m[1], v}, true
+			}
+		}
+		return Setting{}, false
+	}
+	if m := vimLetRe.FindStringSubmatch(t); m != nil {
+		q := m[1][1 : len(m[1])-1]
+		return Setting{Leader, strings.NewReplacer(`\\`, `\`, `\"`, `"`).Replace(q)}, true
+	}
+	if m := vimSetRe.FindStringSubmatch(t); m != nil {
+		if !strings.Contains(t, "=") {
+			if n, off := strings.CutPrefix(m[1], "no"); off {
+				return Setting{n, "false"}, true
+			}
+			return Setting{m[1], "true"}, true
+		}
+		v := strings.NewReplacer(`\\`, `\`, `\ `, " ", `\|`, "|", `\"`, `"`).Replace(m[2])
+		return Setting{m[1], v}, true
+	}
+	return Setting{}, false
+}
+
+// read returns the lines of editor's config and the indexes of the
+// block's markers, -1 when there is no block.
+func read(editor string) ([]string, int, int, error) {
+	path := ConfigPath(editor)
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, 0, 0, err
+	}
+	var lines []string
+	if s := strings.TrimSuffix(string(data), "\n"); s != "" {
+		lines = strings.Split(s, "\n")
+	}
+	begin, end := markers(editor)
+	b, e := -1, -1
+	for i, l := range lines {
+		switch strings.TrimSpace(l) {
+		case begin:
+			if b >= 0 {
+				return nil, 0, 0, fmt.Errorf("%s: more than one shctl block", path)
+			}
+			b = i
+		case end:
+			if b < 0 || e >= 0 {
+				return nil, 0, 0, fmt.Errorf("%s:%d: stray end of the shctl block", path, i+1)
+			}
+			e = i
+		}
+	}
+	if b >= 0 && e < 0 {
+		return nil, 0, 0, fmt.Errorf("%s:%d: the shctl block is not closed", path, b+1)
+	}
+	return lines, b, e, nil
+}
+
+// Settings returns the settings of editor's block.
+func Settings(editor string) ([]Setting, error) {
+	if err := check(editor); err != nil {
+		return nil, err
+	}
+	lines, b, e, err := read(editor)
+	if err != nil {
+		return nil, err
+	}
+	if b < 0 {
+		return []Setting{}, nil
+	}
+	settings, _ := parse(editor, lines[b+1:e])
+	if settings == nil {
+		settings = []Setting{}
+	}
+	return settings, nil
+}
+
+// List prints the settings of editor's block as name=value, or as JSON or
+// YAML records. output.List narrows and orders them by name.
+func List(w io.Writer, format, editor string) error {
+	all, err := Settings(editor)
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name, File: ConfigPath(editor)}
+	})
+	if err != nil {
+		return err
+	}
+	settings := make([]Setting, len(idx))
+	for i, j := range idx {
+		settings[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, settings)
+	}
+	for _, s := range settings {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", output.Name(w, s.Name), output.Value(w, s.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set applies option, as ParseOption reads it, to editor's block,
+// replacing the setting of the same name.
+func Set(ctx context.Context, editor, option string) error {
+	if err := check(editor); err != nil {
+		return err
+	}
+	s, err := ParseOption(option)
+	if err != nil {
+		return err
+	}
+	if s.Name == Bootstrap {
+		if _, ok := bootstraps[editor][s.Value]; !ok {
+			var names []string
+			for n := range bootstraps[editor] {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return fmt.Errorf("no %s bootstrap for %q (want %s): %w", editor, s.Value, strings.Join(names, ", "), util.ErrUsage)
+		}
+	}
+	path := ConfigPath(editor)
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	lines, b, e, err := read(editor)
+	if err != nil {
+		return err
+	}
+	var settings []Setting
+	var extra []string
+	if b >= 0 {
+		settings, extra = parse(editor, lines[b+1:e])
+	}
+	replaced := false
+	for i := range settings {
+		if settings[i].Name == s.Name {
+			settings[i], replaced = s, true
+		}
+	}
+	if !replaced {
+		settings = append(settings, s)
+	}
+	block := render(editor, settings, extra)
+	var next []string
+	if b >= 0 {
+		next = append(append(append(next, lines[:b]...), block...), lines[e+1:]...)
+	} else {
+		next = block
+		if len(lines) > 0 {
+			next = append(append(next, ""), lines...)
+		}
+	}
+	if err := backup.AutoSave(ctx, path, "editor set"); err != nil {
+		return err
+	}
+	logging.Info("editor config", "editor", editor, "path", path, "option", s.Name)
+	if err := fsys.Current.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(path, []byte(strings.Join(next, "\n")+"\n"), 0o644)
+}
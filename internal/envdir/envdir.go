@@ -0,0 +1,338 @@
+// Package envdir gives directories environments of their own, as direnv
+// does: a .shctl-env file of exports is loaded by a shell hook when cd
+// enters the directory or one below it, and unloaded when cd leaves.
+// Only files allowed with their current content are loaded, so a file
+// that arrives with a checkout does nothing until the user allows it.
+package envdir
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// FileName is the environment file a directory may hold.
+const FileName = ".shctl-env"
+
+// Statuses of a directory's environment file.
+const (
+	Allowed = "allowed"
+	Changed = "changed" // edited since it was allowed
+	Missing = "missing"
+	Denied  = "not allowed"
+)
+
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Var is one variable of an environment file.
+type Var struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Line  int    `json:"line"`
+}
+
+// Dir is a directory with an allowed environment file.
+type Dir struct {
+	Path   string `json:"path"`
+	Status string `json:"status"`
+}
+
+// allowPath is where the allowed files and their digests are kept.
+func allowPath() string {
+	return filepath.Join(util.StateDir(), "envdir-allowed.json")
+}
+
+// allowed returns the allowed directories and the SHA-256 of the file
+// content each was allowed with.
+func allowed() (map[string]string, error) {
+	out := map[string]string{}
+	b, err := os.ReadFile(allowPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return out, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", allowPath(), err)
+	}
+	return out, nil
+}
+
+// updateAllowed rewrites the allow list under its lock.
+func updateAllowed(fn func(map[string]string)) error {
+	if err := os.MkdirAll(util.StateDir(), 0o700); err != nil {
+		return err
+	}
+	unlock, err := util.Lock(allowPath()+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	m, err := allowed()
+	if err != nil {
+		return err
+	}
+	fn(m)
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.OS.WriteFile(allowPath(), append(b, '\n'), 0o600)
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// abs returns dir as a clean absolute path.
+func abs(dir string) (string, error) {
+	if dir == "" {
+		dir = "."
+	}
+	return filepath.Abs(dir)
+}
+
+// status reports whether the environment file of dir may be loaded.
+func status(m map[string]string, dir string) (string, []byte, error) {
+	data, err := fsys.Current.ReadFile(filepath.Join(dir, FileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Missing, nil, nil
+	}
+	if err != nil {
+		return "", nil, err
+	}
+	switch sum, ok := m[dir]; {
+	case !ok:
+		return Denied, data, nil
+	case sum != digest(data):
+		return Changed, data, nil
+	}
+	return Allowed, data, nil
+}
+
+// Allow trusts the environment file of dir with its current content.
+func Allow(dir string) error {
+	dir, err := abs(dir)
+	if err != nil {
+		return err
+	}
+	data, err := fsys.Current.ReadFile(filepath.Join(dir, FileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return util.NotFound(fmt.Sprintf("no %s in %s", FileName, dir))
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := parse(data); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Join(dir, FileName), err)
+	}
+	return updateAllowed(func(m map[string]string) { m[dir] = digest(data) })
+}
+
+// Deny stops loading the environment file of dir.
+func Deny(dir string) error {
+	dir, err := abs(dir)
+	if err != nil {
+		return err
+	}
+	return updateAllowed(func(m map[string]string) { delete(m, dir) })
+}
+
+// parse reads the NAME=value or export NAME=value lines of an
+// environment file. Values are taken literally after shell unquoting;
+// nothing is expanded or run.
+func parse(data []byte) ([]Var, error) {
+	var out []Var
+	var errs []error
+	for i, l := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		t := strings.TrimSpace(l)
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		words, err := util.Shell.Words(t)
+		if err == nil && len(words) == 2 && words[0] == "export" {
+			words = words[1:]
+		}
+		if err != nil || len(words) != 1 {
+			errs = append(errs, fmt.Errorf("line %d: want NAME=value", i+1))
+			continue
+		}
+		k, v, ok := strings.Cut(words[0], "=")
+		if !ok || !nameRe.MatchString(k) {
+			errs = append(errs, fmt.Errorf("line %d: want NAME=value", i+1))
+			continue
+		}
+		out = append(out, Var{Name: k, Value: v, Line: i + 1})
+	}
+	return out, errors.Join(errs...)
+}
+
+// Vars returns the variables of dir's environment file.
+func Vars(dir string) ([]Var, error) {
+	dir, err := abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fsys.Current.ReadFile(filepath.Join(dir, FileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Var{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	vars, err := parse(data)
+	if vars == nil {
+		vars = []Var{}
+	}
+	return vars, err
+}
+
+// Set sets name to value in the environment file of dir, creating it, and
+// allows the result: a file shctl wrote needs no second look.
+func Set(ctx context.Context, dir, name, value string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid variable name %q: %w", name, util.ErrUsage)
+	}
+	if strings.ContainsAny(value, "\n") {
+		return fmt.Errorf("%s: values are one line: %w", name, util.ErrUsage)
+	}
+	dir, err := abs(dir)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(dir, FileName)
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	m, err := allowed()
+	if err != nil {
+		return err
+	}
+	if st, _, err := status(m, dir); err != nil {
+		return err
+	} else if st == Denied || st == Changed {
+		return fmt.Errorf("%s is %s; review it and run shctl envdir allow first: %w", path, st, util.ErrPermission)
+	}
+	vars, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if len(data) == 0 {
+		lines = nil
+	}
+	line := "export " + name + "=" + util.ShellQuote(value)
+	replaced := false
+	for _, v := range vars {
+		if v.Name == name {
+			lines[v.Line-1] = line
+			replaced = true
+		}
+	}
+	if !replaced {
+		lines = append(lines, line)
+	}
+	next := []byte(strings.Join(lines, "\n") + "\n")
+	if err := backup.AutoSave(ctx, path, "envdir set"); err != nil {
+		return err
+	}
+	if err := fsys.Current.WriteFile(path, next, 0o644); err != nil {
+		return err
+	}
+	return updateAllowed(func(m map[string]string) { m[dir] = digest(next) })
+}
+
+// Dirs returns the allowed directories with the status of their files.
+func Dirs() ([]Dir, error) {
+	m, err := allowed()
+	if err != nil {
+		return nil, err
+	}
+	out := []Dir{}
+	for dir := range m {
+		st, _, err := status(m, dir)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, Dir{Path: dir, Status: st})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out, nil
+}
+
+// List prints the allowed directories with their status, or with dir the
+// variables of its environment file, in text or as JSON or YAML records.
+// output.List narrows and orders them.
+func List(w io.Writer, format, dir string) error {
+	if dir == "" {
+		all, err := Dirs()
+		if err != nil {
+			return err
+		}
+		idx, err := output.List.Apply(len(all), func(i int) output.Key {
+			return output.Key{Name: all[i].Path, File: filepath.Join(all[i].Path, FileName)}
+		})
+		if err != nil {
+			return err
+		}
+		dirs := make([]Dir, len(idx))
+		for i, j := range idx {
+			dirs[i] = all[j]
+		}
+		if output.Structured(format) {
+			return output.Write(w, format, dirs)
+		}
+		for _, d := range dirs {
+			if _, err := fmt.Fprintf(w, "%s (%s)\n", output.Name(w, d.Path), d.Status); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	all, err := Vars(dir)
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name}
+	})
+	if err != nil {
+		return err
+	}
+	vars := make([]Var, len(idx))
+	for i, j := range idx {
+		vars[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, vars)
+	}
+	for _, v := range vars {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", output.Name(w, v.Name), output.Value(w, v.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package envdir
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// The shell variables that record the loaded environment: its directory,
+// the digest of its file, and the code that undoes it.
+const (
+	dirVar     = "SHCTL_ENVDIR"
+	sumVar     = "SHCTL_ENVDIR_SUM"
+	restoreVar = "SHCTL_ENVDIR_RESTORE"
+	warnedVar  = "SHCTL_ENVDIR_WARNED"
+)
+
+// Hook returns the shell code that runs `prog envdir export` before each
+// prompt, so the environment follows the current directory.
+func Hook(shell, prog string) (string, error) {
+	run := `eval "$(command ` + prog + ` envdir export)"`
+	switch shell {
+	case "bash":
+		return "_shctl_envdir() { " + run + "; }\n" +
+			`case ";${PROMPT_COMMAND:-};" in *";_shctl_envdir;"*) ;; *) PROMPT_COMMAND="_shctl_envdir${PROMPT_COMMAND:+;$PROMPT_COMMAND}" ;; esac` + "\n", nil
+	case "zsh":
+		return "_shctl_envdir() { " + run + "; }\n" +
+			"autoload -Uz add-zsh-hook\nadd-zsh-hook precmd _shctl_envdir\n", nil
+	}
+	return "", fmt.Errorf("no envdir hook for shell %q", shell)
+}
+
+// InstallHook puts the hook for the configured shell in a block of the rc
+// file, replacing an older one.
+func InstallHook(ctx context.Context, prog string) error {
+	hook, err := Hook(config.Get("shell"), prog)
+	if err != nil {
+		return err
+	}
+	return rc.SetBlock(ctx, "envdir hook", "envdir", strings.Split(strings.TrimSuffix(hook, "\n"), "\n"))
+}
+
+// Export writes the shell code that brings the environment in line with
+// cwd: it undoes the loaded environment file when cwd left its directory
+// or the file changed, and loads the nearest allowed file above cwd.
+// A file that is not allowed is reported on errw once per directory.
+func Export(w, errw io.Writer, prog, cwd string) error {
+	cwd, err := abs(cwd)
+	if err != nil {
+		return err
+	}
+	m, err := allowed()
+	if err != nil {
+		return err
+	}
+	target, st, data := "", Missing, []byte(nil)
+	for d := cwd; ; d = filepath.Dir(d) {
+		if st, data, err = status(m, d); err != nil {
+			return err
+		}
+		if st != Missing {
+			target = d
+			break
+		}
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+	var out []string
+	if st == Denied || st == Changed {
+		if os.Getenv(warnedVar) != target {
+			fmt.Fprintf(errw, "shctl: %s is %s; run `%s envdir allow %s` to load it\n", filepath.Join(target, FileName), st, prog, target)
+			out = append(out, "export "+warnedVar+"="+util.ShellQuote(target))
+		}
+		target = ""
+	}
+
+	loaded := os.Getenv(dirVar)
+	if loaded != "" && (loaded != target || os.Getenv(sumVar) != digest(data)) {
+		out = append(out, `eval "$`+restoreVar+`"`, "unset "+dirVar+" "+sumVar+" "+restoreVar)
+		if target != "" {
+			// load again once the old values are back
+			out = append(out, `eval "$(command `+prog+` envdir export)"`)
+		}
+		return writeLines(w, out)
+	}
+	if target == "" || loaded == target {
+		return writeLines(w, out)
+	}
+
+	vars, err := parse(data)
+	if err != nil {
+		fmt.Fprintf(errw, "shctl: %s: %v\n", filepath.Join(target, FileName), err)
+		return writeLines(w, out)
+	}
+	values := map[string]string{}
+	for _, v := range vars {
+		values[v.Name] = v.Value
+	}
+	names := make([]string, 0, len(values))
+	for n := range values {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	var restore []string
+	for _, n := range names {
+		if old, ok := os.LookupEnv(n); ok {
+			restore = append(restore, "export "+n+"="+util.ShellQuote(old))
+		} else {
+			restore = append(restore, "unset "+n)
+		}
+		out = append(out, "export "+n+"="+util.ShellQuote(values[n]))
+	}
+	out = append(out,
+		"export "+dirVar+"="+util.ShellQuote(target),
+		"export "+sumVar+"="+digest(data),
+		"export "+restoreVar+"="+util.ShellQuote(strings.Join(restore, "; ")))
+	return writeLines(w, out)
+}
+
+func writeLines(w io.Writer, lines []string) error {
+	for _, l := range lines {
+		if _, err := fmt.Fprintln(w, l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
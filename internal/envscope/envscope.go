@@ -0,0 +1,74 @@
+// Package envscope sends an export to where the programs that need it
+// will read it: the shell rc file for interactive shells, environment.d
+// for the systemd user session and the graphical apps it starts, or on
+// macOS a LaunchAgent for apps started from Finder and the Dock.
+package envscope
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/launchenv"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/sessionenv"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// The scopes an export can target.
+const (
+	Shell   = "shell"
+	Session = "session"
+	GUI     = "gui"
+)
+
+// Scopes lists the scopes, the default first.
+var Scopes = []string{Shell, Session, GUI}
+
+func check(scope string) error {
+	if scope == GUI && runtime.GOOS != "darwin" {
+		return fmt.Errorf("the gui scope is for macOS; on %s use the session scope: %w", runtime.GOOS, util.ErrUsage)
+	}
+	for _, s := range Scopes {
+		if scope == s {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown export scope %q (want %s): %w", scope, strings.Join(Scopes, ", "), util.ErrUsage)
+}
+
+// AddExport exports name=value in scope; an empty scope is Shell.
+func AddExport(ctx context.Context, scope, name, value string) error {
+	if scope == "" {
+		scope = Shell
+	}
+	if err := check(scope); err != nil {
+		return err
+	}
+	switch scope {
+	case Session:
+		return sessionenv.Set(ctx, name, value)
+	case GUI:
+		return launchenv.Set(ctx, name, value)
+	}
+	return rc.AddExport(ctx, name, value)
+}
+
+// RemoveExports removes the exports of names from scope; an empty scope
+// is Shell.
+func RemoveExports(ctx context.Context, scope string, names []string) error {
+	if scope == "" {
+		scope = Shell
+	}
+	if err := check(scope); err != nil {
+		return err
+	}
+	switch scope {
+	case Session:
+		return sessionenv.Unset(ctx, names)
+	case GUI:
+		return launchenv.Unset(ctx, names)
+	}
+	return rc.RemoveExports(ctx, names)
+}
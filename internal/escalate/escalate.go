@@ -0,0 +1,88 @@
+package escalate
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+)
+
+// Escalator runs commands with root privileges.
+type Escalator interface {
+	Name() string
+	Command(ctx context.Context, name string, args ...string) *exec.Cmd
+}
+
+// prefix escalates by running the command through a helper such as sudo.
+type prefix struct {
+	name string
+	args []string
+}
+
+func (p prefix) Name() string { return p.name }
+
+func (p prefix) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	argv := append(append(append([]string{}, p.args...), name), args...)
+	return exec.CommandContext(ctx, p.name, argv...)
+}
+
+// none runs commands unchanged, for root or when nothing is available.
+type none struct{}
+
+func (none) Name() string { return "none" }
+
+func (none) Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
+
+var (
+	Sudo Escalator = prefix{"sudo", nil}
+	Doas Escalator = prefix{"doas", nil}
+	Run0 Escalator = prefix{"run0", nil}
+	None Escalator = none{}
+)
+
+// detectOrder is tried by auto detection.
+var detectOrder = []Escalator{Sudo, Doas, Run0}
+
+// ByName returns the escalator called name ("sudo", "doas", "run0" or
+// "none").
+func ByName(name string) (Escalator, error) {
+	for _, e := range append(detectOrder, None) {
+		if e.Name() == name {
+			return e, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown escalator %q (want auto, sudo, doas, run0 or none)", name)
+}
+
+// Get returns the configured escalator. With "auto" root gets None and
+// everyone else the first of sudo, doas and run0 found on PATH.
+func Get() (Escalator, error) {
+	name := strings.ToLower(config.Get("escalator"))
+	if name != "auto" && name != "" {
+		return ByName(name)
+	}
+	if os.Geteuid() == 0 {
+		return None, nil
+	}
+	for _, e := range detectOrder {
+		if _, err := exec.LookPath(e.Name()); err == nil {
+			return e, nil
+		}
+	}
+	return None, nil
+}
+
+// Command builds name args under the configured escalator. The command
+// is killed when ctx is done.
+func Command(ctx context.Context, name string, args ...string) (*exec.Cmd, error) {
+	e, err := Get()
+	if err != nil {
+		return nil, err
+	}
+	return e.Command(ctx, name, args...), nil
+}
@@ -0,0 +1,144 @@
+package fleet
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// StaleAfter is how old a host state may be before the report flags it.
+var StaleAfter = 7 * 24 * time.Hour
+
+// HostState is the status document an agent collects on one host.
+type HostState struct {
+	Host      string    `json:"host"`
+	Group     string    `json:"group"`
+	Version   string    `json:"version"`
+	Collected time.Time `json:"collected"`
+	Drift     []Drift   `json:"drift"`
+	Errors    []string  `json:"errors"`
+}
+
+// Drift is one managed item whose live state differs from the desired one.
+type Drift struct {
+	File     string `json:"file"`
+	Kind     string `json:"kind"` // alias, export, sudoers, ...
+	Name     string `json:"name"`
+	Expected string `json:"expected,omitempty"`
+	Actual   string `json:"actual,omitempty"`
+}
+
+func (h HostState) Compliant() bool {
+	return len(h.Drift) == 0 && len(h.Errors) == 0
+}
+
+// Load reads every *.json state under dir. Files that fail to parse are
+// reported in the returned error, a *util.PartialError when others were
+// read; the rest are still returned.
+func Load(dir string) ([]HostState, error) {
+	var states []HostState
+	var errs []error
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		var st HostState
+		if err := json.Unmarshal(b, &st); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+			return nil
+		}
+		if st.Host == "" {
+			st.Host = strings.TrimSuffix(filepath.Base(path), ".json")
+		}
+		if st.Group == "" {
+			st.Group = "ungrouped"
+		}
+		states = append(states, st)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 && len(states) > 0 {
+		return states, &util.PartialError{Done: len(states), Failed: len(errs), Err: errors.Join(errs...)}
+	}
+	return states, errors.Join(errs...)
+}
+
+// Report renders a per-group compliance summary followed by the drift of
+// every non-compliant or stale host.
+func Report(w io.Writer, states []HostState, now time.Time) error {
+	groups := map[string][]HostState{}
+	names := []string{}
+	for _, st := range states {
+		if _, ok := groups[st.Group]; !ok {
+			names = append(names, st.Group)
+		}
+		groups[st.Group] = append(groups[st.Group], st)
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "GROUP\tHOSTS\tCOMPLIANT\tDRIFTED\tSTALE")
+	for _, g := range names {
+		compliant, drifted, stale := 0, 0, 0
+		for _, st := range groups[g] {
+			if st.Compliant() {
+				compliant++
+			} else {
+				drifted++
+			}
+			if now.Sub(st.Collected) > StaleAfter {
+				stale++
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\n", g, len(groups[g]), compliant, drifted, stale)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, g := range names {
+		hosts := groups[g]
+		sort.Slice(hosts, func(i, j int) bool { return hosts[i].Host < hosts[j].Host })
+		for _, st := range hosts {
+			isStale := now.Sub(st.Collected) > StaleAfter
+			if st.Compliant() && !isStale {
+				continue
+			}
+			fmt.Fprintf(w, "\n[%s] %s (collected %s)\n", g, st.Host, st.Collected.Format(time.RFC3339))
+			if isStale {
+				fmt.Fprintf(w, "  stale: no state for %s\n", now.Sub(st.Collected).Round(time.Hour))
+			}
+			for _, d := range st.Drift {
+				fmt.Fprintf(w, "  drift: %s %s in %s", d.Kind, d.Name, d.File)
+				if d.Expected != "" || d.Actual != "" {
+					fmt.Fprintf(w, " (expected %q, found %q)", d.Expected, d.Actual)
+				}
+				fmt.Fprintln(w)
+			}
+			for _, e := range st.Errors {
+				fmt.Fprintf(w, "  error: %s\n", e)
+			}
+		}
+	}
+	return nil
+}
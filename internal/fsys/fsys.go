@@ -0,0 +1,45 @@
+// Package fsys is the file system shctl reads and edits managed files
+// through. The real one is OS; Memory keeps everything in memory so
+// callers can run without touching the host.
+package fsys
+
+import (
+	"io"
+	"io/fs"
+)
+
+// FS is a writable file system addressed by OS paths.
+type FS interface {
+	Open(name string) (fs.File, error)
+	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	// WriteFile replaces name atomically. An existing file keeps its mode
+	// and owner; perm applies to new files.
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	// WriteStream is WriteFile for content produced by write, so large
+	// files need not be held in memory.
+	WriteStream(name string, perm fs.FileMode, write func(io.Writer) error) error
+	// AppendFile appends data to name, creating it with perm if needed.
+	AppendFile(name string, data []byte, perm fs.FileMode) error
+	Remove(name string) error
+	MkdirAll(name string, perm fs.FileMode) error
+}
+
+// Current is the file system in use.
+var Current FS = OS
+
+// Use makes f the current file system and returns a function restoring
+// the previous one.
+func Use(f FS) func() {
+	prev := Current
+	Current = f
+	return func() { Current = prev }
+}
+
+// IsOS reports whether the current file system is the host's.
+func IsOS() bool {
+	_, ok := Current.(osFS)
+	return ok
+}
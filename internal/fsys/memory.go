@@ -0,0 +1,203 @@
+package fsys
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is an in-memory FS. The root directory always exists; files
+// need their parent directory, as on a real file system. It is safe for
+// concurrent use.
+type Memory struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+}
+
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode // includes fs.ModeDir for directories
+	modTime time.Time
+}
+
+// NewMemory returns an empty in-memory file system.
+func NewMemory() *Memory {
+	root := filepath.VolumeName("/") + string(filepath.Separator)
+	return &Memory{files: map[string]*memFile{root: {mode: fs.ModeDir | 0o755}}}
+}
+
+func clean(name string) string { return filepath.Clean(name) }
+
+func (m *Memory) lookup(op, name string) (*memFile, error) {
+	f, ok := m.files[clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	return f, nil
+}
+
+func (m *Memory) Open(name string) (fs.File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return &memHandle{bytes.NewReader(append([]byte{}, f.data...)), info(name, f)}, nil
+}
+
+func (m *Memory) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.lookup("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return append([]byte{}, f.data...), nil
+}
+
+func (m *Memory) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	dir, err := m.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrInvalid}
+	}
+	out := []fs.DirEntry{}
+	for p, f := range m.files {
+		if filepath.Dir(p) == clean(name) && p != clean(name) {
+			out = append(out, fs.FileInfoToDirEntry(info(p, f)))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+func (m *Memory) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return info(name, f), nil
+}
+
+// Lstat is Stat; Memory has no symlinks.
+func (m *Memory) Lstat(name string) (fs.FileInfo, error) { return m.Stat(name) }
+
+func (m *Memory) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.write("write", name, data, perm, false)
+}
+
+func (m *Memory) WriteStream(name string, perm fs.FileMode, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	return m.WriteFile(name, buf.Bytes(), perm)
+}
+
+func (m *Memory) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.write("append", name, data, perm, true)
+}
+
+func (m *Memory) write(op, name string, data []byte, perm fs.FileMode, appending bool) error {
+	parent, err := m.lookup(op, filepath.Dir(clean(name)))
+	if err != nil || !parent.mode.IsDir() {
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+	f, ok := m.files[clean(name)]
+	switch {
+	case ok && f.mode.IsDir():
+		return &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	case !ok:
+		f = &memFile{mode: perm.Perm()}
+		m.files[clean(name)] = f
+	}
+	if appending {
+		f.data = append(f.data, data...)
+	} else {
+		f.data = append([]byte{}, data...)
+	}
+	f.modTime = time.Now()
+	return nil
+}
+
+func (m *Memory) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	f, err := m.lookup("remove", name)
+	if err != nil {
+		return err
+	}
+	if f.mode.IsDir() {
+		prefix := clean(name) + string(filepath.Separator)
+		for p := range m.files {
+			if strings.HasPrefix(p, prefix) {
+				return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+			}
+		}
+	}
+	delete(m.files, clean(name))
+	return nil
+}
+
+func (m *Memory) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	missing := []string{}
+	for p := clean(name); ; p = filepath.Dir(p) {
+		if f, ok := m.files[p]; ok {
+			if !f.mode.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: p, Err: fs.ErrExist}
+			}
+			break
+		}
+		missing = append(missing, p)
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+	for _, p := range missing {
+		m.files[p] = &memFile{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	}
+	return nil
+}
+
+type memInfo struct {
+	name string
+	f    memFile
+}
+
+func info(name string, f *memFile) fs.FileInfo { return memInfo{filepath.Base(name), *f} }
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return int64(len(i.f.data)) }
+func (i memInfo) Mode() fs.FileMode  { return i.f.mode }
+func (i memInfo) ModTime() time.Time { return i.f.modTime }
+func (i memInfo) IsDir() bool        { return i.f.mode.IsDir() }
+func (i memInfo) Sys() any           { return nil }
+
+type memHandle struct {
+	*bytes.Reader
+	fi fs.FileInfo
+}
+
+func (h *memHandle) Stat() (fs.FileInfo, error) { return h.fi, nil }
+func (h *memHandle) Close() error               { return nil }
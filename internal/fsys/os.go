@@ -0,0 +1,131 @@
+package fsys
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// OS is the host file system.
+var OS FS = osFS{}
+
+type osFS struct{}
+
+func (osFS) Open(name string) (fs.File, error)          { return os.Open(name) }
+func (osFS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+func (osFS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (osFS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (osFS) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+func (osFS) Remove(name string) error                   { return os.Remove(name) }
+
+func (osFS) MkdirAll(name string, perm fs.FileMode) error { return os.MkdirAll(name, perm) }
+
+func (osFS) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	f, err := os.OpenFile(name, os.O_APPEND|os.O_WRONLY|os.O_CREATE, perm)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (o osFS) WriteFile(path string, data []byte, perm fs.FileMode) error {
+	return o.WriteStream(path, perm, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// WriteStream replaces path with what write produces so readers see
+// either the old or the new content, even across a crash. The new file
+// keeps the old one's mode and owner; both it and its directory are
+// synced before and after the rename. When no temp file can be made next
+// to path, the content is staged elsewhere and copied over path instead.
+func (osFS) WriteStream(path string, perm fs.FileMode, write func(io.Writer) error) error {
+	mode := perm
+	uid, gid, owned := -1, -1, false
+	if fi, err := os.Stat(path); err == nil {
+		mode = fi.Mode().Perm()
+		uid, gid, owned = FileOwner(fi)
+	}
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		if f, err = os.CreateTemp("", "shctl_write_*"); err != nil {
+			return err
+		}
+	}
+	tmp := f.Name()
+	defer os.Remove(tmp)
+	if err := writeSynced(f, write, mode); err != nil {
+		return err
+	}
+	if owned {
+		if err := os.Chown(tmp, uid, gid); err != nil && !errors.Is(err, fs.ErrPermission) {
+			return err
+		}
+	}
+	if filepath.Dir(tmp) != dir {
+		return copyInPlace(tmp, path, mode)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		// a bind-mounted file, such as /etc/hosts in a container, cannot
+		// be replaced, only overwritten
+		if errors.Is(err, syscall.EBUSY) {
+			return copyInPlace(tmp, path, mode)
+		}
+		return err
+	}
+	return syncDir(dir)
+}
+
+func writeSynced(f *os.File, write func(io.Writer) error, mode fs.FileMode) error {
+	bw := bufio.NewWriter(f)
+	if err := write(bw); err != nil {
+		f.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// copyInPlace overwrites path with the staged file tmp when it cannot be
+// renamed into place, e.g. because its directory is not writable.
+func copyInPlace(tmp, path string, mode fs.FileMode) error {
+	in, err := os.Open(tmp)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, in); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
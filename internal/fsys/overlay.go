@@ -0,0 +1,235 @@
+package fsys
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// Overlay reads through to a base file system but keeps every write and
+// removal in memory, so the base is never modified. Changes reports what
+// would have been written.
+type Overlay struct {
+	mu      sync.Mutex
+	base    FS
+	upper   *Memory
+	removed map[string]bool
+}
+
+// NewOverlay returns an overlay over base.
+func NewOverlay(base FS) *Overlay {
+	return &Overlay{base: base, upper: NewMemory(), removed: map[string]bool{}}
+}
+
+// Change is one file an overlay holds a different version of.
+type Change struct {
+	Path    string
+	Before  []byte // nil when the file is new
+	After   []byte // nil when the file was removed
+	Removed bool
+}
+
+// Changes lists the files whose content differs from the base, sorted by
+// path.
+func (o *Overlay) Changes() []Change {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	out := []Change{}
+	for p, f := range o.upper.files {
+		if f.mode.IsDir() {
+			continue
+		}
+		before, _ := o.base.ReadFile(p)
+		if !bytes.Equal(before, f.data) || before == nil {
+			out = append(out, Change{Path: p, Before: before, After: append([]byte{}, f.data...)})
+		}
+	}
+	for p := range o.removed {
+		if before, err := o.base.ReadFile(p); err == nil {
+			out = append(out, Change{Path: p, Before: before, Removed: true})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// layer picks where name currently lives: upper, base, or nowhere.
+func (o *Overlay) layer(name string) (FS, bool) {
+	p := clean(name)
+	if o.removed[p] {
+		return nil, false
+	}
+	if _, err := o.upper.Lstat(p); err == nil {
+		return o.upper, true
+	}
+	return o.base, true
+}
+
+func notExist(op, name string) error {
+	return &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+}
+
+func (o *Overlay) Open(name string) (fs.File, error) {
+	o.mu.Lock()
+	l, ok := o.layer(name)
+	o.mu.Unlock()
+	if !ok {
+		return nil, notExist("open", name)
+	}
+	return l.Open(name)
+}
+
+func (o *Overlay) ReadFile(name string) ([]byte, error) {
+	o.mu.Lock()
+	l, ok := o.layer(name)
+	o.mu.Unlock()
+	if !ok {
+		return nil, notExist("read", name)
+	}
+	return l.ReadFile(name)
+}
+
+func (o *Overlay) Stat(name string) (fs.FileInfo, error) {
+	o.mu.Lock()
+	l, ok := o.layer(name)
+	o.mu.Unlock()
+	if !ok {
+		return nil, notExist("stat", name)
+	}
+	return l.Stat(name)
+}
+
+func (o *Overlay) Lstat(name string) (fs.FileInfo, error) {
+	o.mu.Lock()
+	l, ok := o.layer(name)
+	o.mu.Unlock()
+	if !ok {
+		return nil, notExist("lstat", name)
+	}
+	return l.Lstat(name)
+}
+
+// ReadDir merges the entries of both layers.
+func (o *Overlay) ReadDir(name string) ([]fs.DirEntry, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.removed[clean(name)] {
+		return nil, notExist("readdir", name)
+	}
+	base, berr := o.base.ReadDir(name)
+	upper, uerr := o.upper.ReadDir(name)
+	if berr != nil && uerr != nil {
+		return nil, berr
+	}
+	seen := map[string]bool{}
+	out := []fs.DirEntry{}
+	for _, e := range upper {
+		seen[e.Name()] = true
+		out = append(out, e)
+	}
+	for _, e := range base {
+		if !seen[e.Name()] && !o.removed[filepath.Join(clean(name), e.Name())] {
+			out = append(out, e)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name() < out[j].Name() })
+	return out, nil
+}
+
+// copyUp makes sure the upper layer has name's directory and, for an
+// existing file, its current content and mode. It returns the mode.
+func (o *Overlay) copyUp(op, name string, perm fs.FileMode) (fs.FileMode, error) {
+	p := clean(name)
+	dir := filepath.Dir(p)
+	if l, ok := o.layer(dir); !ok {
+		return 0, notExist(op, name)
+	} else if fi, err := l.Stat(dir); err != nil || !fi.IsDir() {
+		return 0, notExist(op, name)
+	}
+	if err := o.upper.MkdirAll(dir, 0o755); err != nil {
+		return 0, err
+	}
+	if _, err := o.upper.Stat(p); err == nil {
+		fi, _ := o.upper.Stat(p)
+		return fi.Mode().Perm(), nil
+	}
+	if o.removed[p] {
+		return perm, nil
+	}
+	fi, err := o.base.Stat(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return perm, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	data, err := o.base.ReadFile(p)
+	if err != nil {
+		return 0, err
+	}
+	mode := fi.Mode().Perm()
+	return mode, o.upper.WriteFile(p, data, mode)
+}
+
+func (o *Overlay) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, err := o.copyUp("write", name, perm); err != nil {
+		return err
+	}
+	delete(o.removed, clean(name))
+	return o.upper.WriteFile(name, data, perm)
+}
+
+func (o *Overlay) WriteStream(name string, perm fs.FileMode, write func(io.Writer) error) error {
+	var buf bytes.Buffer
+	if err := write(&buf); err != nil {
+		return err
+	}
+	return o.WriteFile(name, buf.Bytes(), perm)
+}
+
+func (o *Overlay) AppendFile(name string, data []byte, perm fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, err := o.copyUp("append", name, perm); err != nil {
+		return err
+	}
+	delete(o.removed, clean(name))
+	return o.upper.AppendFile(name, data, perm)
+}
+
+func (o *Overlay) Remove(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	l, ok := o.layer(name)
+	if !ok {
+		return notExist("remove", name)
+	}
+	if _, err := l.Lstat(name); err != nil {
+		return err
+	}
+	if l == o.upper {
+		if err := o.upper.Remove(name); err != nil {
+			return err
+		}
+	}
+	o.removed[clean(name)] = true
+	return nil
+}
+
+func (o *Overlay) MkdirAll(name string, perm fs.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for p := clean(name); ; p = filepath.Dir(p) {
+		delete(o.removed, p)
+		if filepath.Dir(p) == p {
+			break
+		}
+	}
+	return o.upper.MkdirAll(name, perm)
+}
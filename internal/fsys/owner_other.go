@@ -0,0 +1,15 @@
+//go:build !unix
+
+package fsys
+
+import "os"
+
+// FileOwner is not supported on this platform.
+func FileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	return 0, 0, false
+}
+
+// syncDir is a no-op; directories cannot be synced on this platform.
+func syncDir(dir string) error {
+	return nil
+}
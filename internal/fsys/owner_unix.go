@@ -0,0 +1,27 @@
+//go:build unix
+
+package fsys
+
+import (
+	"os"
+	"syscall"
+)
+
+// FileOwner returns the uid and gid owning fi.
+func FileOwner(fi os.FileInfo) (uid, gid int, ok bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, false
+	}
+	return int(st.Uid), int(st.Gid), true
+}
+
+// syncDir flushes dir so a rename inside it survives a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
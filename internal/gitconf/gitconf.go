@@ -0,0 +1,428 @@
+// Package gitconf manages the global git config: aliases, and settings
+// such as the identity, either global or scoped to a directory tree
+// through an includeIf "gitdir:" section and a file of its own, and the
+// global gitignore named by core.excludesFile. Config edits are made by
+// git config on a staged copy, so git's own quoting and section layout
+// are kept.
+package gitconf
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name:  "git",
+		Files: files,
+	})
+	trash.Register("git", restoreLines)
+}
+
+var (
+	aliasRe = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+	keyRe   = regexp.MustCompile(`^[A-Za-z0-9-]+(\.[^\n]+)?\.[A-Za-z][A-Za-z0-9-]*$`)
+)
+
+// Alias is one git alias and the file defining it. Scope is the includeIf
+// condition under which the file applies, empty for the global config.
+type Alias struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	Source  string `json:"source"`
+	Scope   string `json:"scope,omitempty"`
+}
+
+// Include is an include.path or includeIf.<condition>.path entry of the
+// global config, with Path resolved.
+type Include struct {
+	Condition string `json:"condition,omitempty"`
+	Path      string `json:"path"`
+}
+
+// ConfigPath is the global git config to manage.
+func ConfigPath() string {
+	return config.Get("git_config")
+}
+
+// files lists the global config and the existing files it includes,
+// then the global gitignore.
+func files() ([]string, error) {
+	ctx := context.Background()
+	out := []string{ConfigPath()}
+	incs, err := Includes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, inc := range incs {
+		if _, err := fsys.Current.Stat(inc.Path); err == nil {
+			out = append(out, inc.Path)
+		}
+	}
+	if path, _, err := ExcludesFile(ctx); err != nil {
+		return nil, err
+	} else if _, err := fsys.Current.Stat(path); err == nil {
+		out = append(out, path)
+	}
+	return out, nil
+}
+
+// gitConfig runs git config on file with args and returns its output.
+// Exit status 1 means a key was not found; it is returned as ErrNotFound.
+func gitConfig(ctx context.Context, file string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"config", "--file", file}, args...)...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	logging.Debug("running", "argv", cmd.Args)
+	err := cmd.Run()
+	var exit *exec.ExitError
+	switch {
+	case errors.As(err, &exit) && exit.ExitCode() == 1 && stderr.Len() == 0:
+		return "", util.ErrNotFound
+	case errors.Is(err, exec.ErrNotFound):
+		return "", fmt.Errorf("git is not installed: %w", err)
+	case err != nil:
+		return "", fmt.Errorf("git config: %s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return stdout.String(), nil
+}
+
+// stage copies path from the current file system to a temp file git can
+// work on; a missing path gives an empty one. The caller removes it.
+func stage(path string) (string, error) {
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return "", err
+	}
+	f, err := os.CreateTemp("", "shctl_git_*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// entries returns the key, value pairs of file whose key matches re, in
+// file order. Keys come back lowercased, except for subsections.
+func entries(ctx context.Context, file, re string) ([][2]string, error) {
+	tmp, err := stage(file)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp)
+	out, err := gitConfig(ctx, tmp, "--null", "--get-regexp", re)
+	if errors.Is(err, util.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var kv [][2]string
+	for _, rec := range strings.Split(strings.TrimSuffix(out, "\x00"), "\x00") {
+		k, v, _ := strings.Cut(rec, "\n")
+		kv = append(kv, [2]string{k, v})
+	}
+	return kv, nil
+}
+
+// Get returns the value of key in the global config.
+func Get(ctx context.Context, key string) (string, bool, error) {
+	kv, err := entries(ctx, ConfigPath(), "^"+regexp.QuoteMeta(strings.ToLower(key))+"$")
+	if err != nil || len(kv) == 0 {
+		return "", false, err
+	}
+	return kv[len(kv)-1][1], true, nil
+}
+
+// Includes returns the files the global config includes, in file order.
+// Relative paths are taken from the config's directory and ~/ from the
+// home directory, as git does.
+func Includes(ctx context.Context) ([]Include, error) {
+	kv, err := entries(ctx, ConfigPath(), `^include(if\..*)?\.path$`)
+	if err != nil {
+		return nil, err
+	}
+	out := []Include{}
+	for _, e := range kv {
+		cond := ""
+		if strings.HasPrefix(e[0], "includeif.") {
+			cond = strings.TrimSuffix(strings.TrimPrefix(e[0], "includeif."), ".path")
+		}
+		out = append(out, Include{Condition: cond, Path: resolve(e[1])})
+	}
+	return out, nil
+}
+
+func resolve(p string) string {
+	if strings.HasPrefix(p, "~/") {
+		home, _ := os.UserHomeDir()
+		return filepath.Join(home, p[2:])
+	}
+	if !filepath.IsAbs(p) {
+		return filepath.Join(filepath.Dir(ConfigPath()), p)
+	}
+	return p
+}
+
+// Aliases returns the aliases of the global config and of the files it
+// includes, sorted by name.
+func Aliases(ctx context.Context) ([]Alias, error) {
+	sources := []Include{{Path: ConfigPath()}}
+	incs, err := Includes(ctx)
+	if err != nil {
+		return nil, err
+	}
+	sources = append(sources, incs...)
+	out := []Alias{}
+	for _, src := range sources {
+		kv, err := entries(ctx, src.Path, `^alias\.`)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range kv {
+			out = append(out, Alias{Name: strings.TrimPrefix(e[0], "alias."), Command: e[1], Source: src.Path, Scope: src.Condition})
+		}
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// ListAliases prints the aliases as "name = command", scoped ones with
+// their condition, or as JSON or YAML records. output.List narrows and
+// orders them by name and file.
+func ListAliases(ctx context.Context, w io.Writer, format string) error {
+	all, err := Aliases(ctx)
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name, File: all[i].Source}
+	})
+	if err != nil {
+		return err
+	}
+	aliases := make([]Alias, len(idx))
+	for i, j := range idx {
+		aliases[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, aliases)
+	}
+	for _, a := range aliases {
+		line := output.Name(w, a.Name) + " = " + output.Value(w, a.Command)
+		if a.Scope != "" {
+			line += " (" + a.Scope + ")"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AddAlias defines git alias name in the global config. A name already
+// defined there is reported as ErrEntryExists.
+func AddAlias(ctx context.Context, name, command string) error {
+	if !aliasRe.MatchString(name) {
+		return fmt.Errorf("invalid alias name %q: %w", name, util.ErrUsage)
+	}
+	if command == "" || strings.Contains(command, "\n") {
+		return fmt.Errorf("alias %s needs a one-line command: %w", name, util.ErrUsage)
+	}
+	return change(ctx, ConfigPath(), "alias add", func(tmp string) error {
+		if _, err := gitConfig(ctx, tmp, "--get", "alias."+name); err == nil {
+			return fmt.Errorf("%s: %w in %s", name, util.ErrEntryExists, ConfigPath())
+		}
+		_, err := gitConfig(ctx, tmp, "alias."+name, command)
+		return err
+	})
+}
+
+// RemoveAliases deletes git aliases names from the global config in one
+// change, keeping them in the trash. Nothing is removed when one of them
+// is not defined there.
+func RemoveAliases(ctx context.Context, names []string) error {
+	var removed []string
+	err := change(ctx, ConfigPath(), "alias remove", func(tmp string) error {
+		removed = nil
+		for _, name := range names {
+			cur, err := gitConfig(ctx, tmp, "--get", "alias."+name)
+			if errors.Is(err, util.ErrNotFound) {
+				return util.NotFound(fmt.Sprintf("no git alias %s in %s", name, ConfigPath()))
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := gitConfig(ctx, tmp, "--unset-all", "alias."+name); err != nil {
+				return err
+			}
+			removed = append(removed, "alias."+name+"="+strings.TrimSuffix(cur, "\n"))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := trash.Put("git", ConfigPath(), "git alias remove", removed); err != nil {
+		output.Warn(prompt.Out, "trash: %v", err)
+	}
+	return nil
+}
+
+// restoreLines sets the key=value pairs of removed entries again in the
+// file they came from.
+func restoreLines(ctx context.Context, path string, lines []string) error {
+	return change(ctx, path, "trash restore", func(tmp string) error {
+		for _, l := range lines {
+			k, v, _ := strings.Cut(l, "=")
+			if _, err := gitConfig(ctx, tmp, "--get", k); err == nil {
+				return fmt.Errorf("%s: %w in %s", k, util.ErrEntryExists, path)
+			}
+			if _, err := gitConfig(ctx, tmp, k, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Set sets key to value in the global config, or, when dir is not empty,
+// only for repositories under dir: in a file of its own, which the global
+// config includes with includeIf "gitdir:dir/".
+func Set(ctx context.Context, key, value, dir string) error {
+	if !keyRe.MatchString(key) {
+		return fmt.Errorf("invalid key %q, want section.name: %w", key, util.ErrUsage)
+	}
+	if strings.Contains(value, "\n") {
+		return fmt.Errorf("%s: values are one line: %w", key, util.ErrUsage)
+	}
+	set := func(tmp string) error {
+		_, err := gitConfig(ctx, tmp, "--replace-all", key, value)
+		return err
+	}
+	if dir == "" {
+		return change(ctx, ConfigPath(), "config set", set)
+	}
+	cond, path, err := scope(ctx, dir)
+	if err != nil {
+		return err
+	}
+	if err := change(ctx, path, "config set", set); err != nil {
+		return err
+	}
+	return change(ctx, ConfigPath(), "config set", func(tmp string) error {
+		incs, err := Includes(ctx)
+		if err != nil {
+			return err
+		}
+		for _, inc := range incs {
+			if inc.Condition == cond && inc.Path == path {
+				return nil
+			}
+		}
+		_, err = gitConfig(ctx, tmp, "--add", "includeIf."+cond+".path", path)
+		return err
+	})
+}
+
+// ScopePath returns the file holding the settings for repositories under
+// dir: the one an includeIf "gitdir:dir/" already names, or a new one in
+// .gitconfig.d next to the global config.
+func ScopePath(ctx context.Context, dir string) (string, error) {
+	_, path, err := scope(ctx, dir)
+	return path, err
+}
+
+func scope(ctx context.Context, dir string) (string, string, error) {
+	if strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", "", err
+		}
+		dir = filepath.Join(home, dir[2:])
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", "", err
+	}
+	cond := "gitdir:" + strings.TrimSuffix(filepath.ToSlash(dir), "/") + "/"
+	incs, err := Includes(ctx)
+	if err != nil {
+		return "", "", err
+	}
+	for _, inc := range incs {
+		if strings.EqualFold(inc.Condition, cond) {
+			return inc.Condition, inc.Path, nil
+		}
+	}
+	slug := strings.Trim(strings.ReplaceAll(filepath.ToSlash(dir), "/", "-"), "-")
+	if slug == "" {
+		slug = "root"
+	}
+	return cond, filepath.Join(filepath.Dir(ConfigPath()), ".gitconfig.d", slug), nil
+}
+
+// change stages path, lets fn edit the staged copy with git config, and
+// writes the result back after git has read it without complaint,
+// backing path up first. An unchanged copy is not written.
+func change(ctx context.Context, path, op string, fn func(tmp string) error) error {
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tmp, err := stage(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	logging.Info("git config", "op", op, "path", path, "tmp", tmp)
+	if err := fn(tmp); err != nil {
+		return err
+	}
+	if _, err := gitConfig(ctx, tmp, "--list"); err != nil && !errors.Is(err, util.ErrNotFound) {
+		return fmt.Errorf("git config validation failed: %w", err)
+	}
+	cur, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	next, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	if bytes.Equal(cur, next) {
+		return nil
+	}
+	if err := backup.AutoSave(ctx, path, "git "+op); err != nil {
+		return err
+	}
+	if err := fsys.Current.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(path, next, 0o644)
+}
@@ -0,0 +1,229 @@
+package gitconf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func init() {
+	trash.Register("gitignore", restorePatterns)
+}
+
+// Pattern is one pattern of the global gitignore.
+type Pattern struct {
+	Pattern string `json:"pattern"`
+	Line    int    `json:"line"`
+}
+
+// ExcludesFile returns the global gitignore named by core.excludesFile,
+// and whether the key is set. When it is not, the file shctl would
+// create, .gitignore_global next to the global config, is returned.
+func ExcludesFile(ctx context.Context) (string, bool, error) {
+	v, ok, err := Get(ctx, "core.excludesFile")
+	if err != nil {
+		return "", false, err
+	}
+	if !ok || v == "" {
+		return filepath.Join(filepath.Dir(ConfigPath()), ".gitignore_global"), false, nil
+	}
+	return resolve(v), true, nil
+}
+
+// Patterns returns the patterns of the global gitignore in file order.
+func Patterns(ctx context.Context) ([]Pattern, error) {
+	path, _, err := ExcludesFile(ctx)
+	if err != nil {
+		return nil, err
+	}
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	out := []Pattern{}
+	for i, l := range splitLines(data) {
+		if t := strings.TrimSpace(l); t != "" && !strings.HasPrefix(t, "#") {
+			out = append(out, Pattern{Pattern: l, Line: i + 1})
+		}
+	}
+	return out, nil
+}
+
+// ListIgnore prints the patterns of the global gitignore, one per line,
+// or as JSON or YAML records. output.List narrows and orders them.
+func ListIgnore(ctx context.Context, w io.Writer, format string) error {
+	all, err := Patterns(ctx)
+	if err != nil {
+		return err
+	}
+	path, _, err := ExcludesFile(ctx)
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Pattern, File: path}
+	})
+	if err != nil {
+		return err
+	}
+	patterns := make([]Pattern, len(idx))
+	for i, j := range idx {
+		patterns[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, patterns)
+	}
+	for _, p := range patterns {
+		if _, err := fmt.Fprintln(w, output.Name(w, p.Pattern)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func checkPattern(p string) error {
+	if strings.TrimSpace(p) == "" || strings.HasPrefix(p, "#") || strings.ContainsAny(p, "\r\n") {
+		return fmt.Errorf("invalid gitignore pattern %q (escape a leading # as \\#): %w", p, util.ErrUsage)
+	}
+	return nil
+}
+
+// AddIgnore appends patterns to the global gitignore. When
+// core.excludesFile is not set, the file is created and the key pointed
+// at it. A pattern already there is reported as ErrEntryExists.
+func AddIgnore(ctx context.Context, patterns []string) error {
+	if len(patterns) == 0 {
+		return fmt.Errorf("no pattern to add: %w", util.ErrUsage)
+	}
+	for _, p := range patterns {
+		if err := checkPattern(p); err != nil {
+			return err
+		}
+	}
+	path, set, err := ExcludesFile(ctx)
+	if err != nil {
+		return err
+	}
+	err = editIgnore(ctx, path, "gitignore add", func(lines []string) ([]string, error) {
+		for i, p := range patterns {
+			if contains(lines, p) || contains(patterns[:i], p) {
+				return nil, fmt.Errorf("%s: %w in %s", p, util.ErrEntryExists, path)
+			}
+		}
+		return append(lines, patterns...), nil
+	})
+	if err != nil || set {
+		return err
+	}
+	return Set(ctx, "core.excludesFile", path, "")
+}
+
+// RemoveIgnore deletes patterns from the global gitignore in one change,
+// keeping them in the trash. Nothing is removed when one of them is not
+// there.
+func RemoveIgnore(ctx context.Context, patterns []string) error {
+	path, _, err := ExcludesFile(ctx)
+	if err != nil {
+		return err
+	}
+	err = editIgnore(ctx, path, "gitignore remove", func(lines []string) ([]string, error) {
+		for _, p := range patterns {
+			if !contains(lines, p) {
+				return nil, util.NotFound(fmt.Sprintf("no pattern %s in %s", p, path))
+			}
+		}
+		var keep []string
+		for _, l := range lines {
+			if !contains(patterns, l) {
+				keep = append(keep, l)
+			}
+		}
+		return keep, nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := trash.Put("gitignore", path, "gitignore remove", patterns); err != nil {
+		output.Warn(prompt.Out, "trash: %v", err)
+	}
+	return nil
+}
+
+// restorePatterns appends patterns from the trash back to the gitignore
+// they came from.
+func restorePatterns(ctx context.Context, path string, patterns []string) error {
+	return editIgnore(ctx, path, "trash restore", func(lines []string) ([]string, error) {
+		for _, p := range patterns {
+			if !contains(lines, p) {
+				lines = append(lines, p)
+			}
+		}
+		return lines, nil
+	})
+}
+
+func contains(lines []string, p string) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) == strings.TrimSpace(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// editIgnore lets fn rewrite the lines of the gitignore at path and
+// writes them back, backing the file up first.
+func editIgnore(ctx context.Context, path, op string, fn func(lines []string) ([]string, error)) error {
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	lines, err := fn(splitLines(data))
+	if err != nil {
+		return err
+	}
+	next := joinLines(lines)
+	if string(next) == string(data) {
+		return nil
+	}
+	if err := backup.AutoSave(ctx, path, op); err != nil {
+		return err
+	}
+	logging.Info("gitignore", "op", op, "path", path)
+	if err := fsys.Current.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(path, next, 0o644)
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
@@ -0,0 +1,339 @@
+// Package hosts manages static host name entries in /etc/hosts. The
+// entries shctl adds live between two marker comments, and only those are
+// changed or removed; hand-written lines are read to catch duplicates and
+// conflicts but never touched. Changes go through the same diff, confirm,
+// backup and audit steps as sudoers.
+package hosts
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/sysfile"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name:  "hosts",
+		Files: func() ([]string, error) { return []string{Path()}, nil },
+		Validate: func(_ context.Context, _, staged string) error {
+			return ValidateFile(staged)
+		},
+		Apply: sysfile.Write,
+	})
+	trash.Register("hosts", restoreLines)
+}
+
+// The markers around the entries shctl manages.
+const (
+	beginMarker = "# BEGIN shctl managed hosts"
+	endMarker   = "# END shctl managed hosts"
+)
+
+// Errors callers can match with errors.Is.
+var (
+	// ErrConflict reports a name already mapped to another address of
+	// the same family.
+	ErrConflict = errors.New("host name maps to another address")
+	// ErrUnmanaged reports an entry outside the managed block.
+	ErrUnmanaged = errors.New("entry is not managed by shctl")
+)
+
+// Path is the hosts file to manage.
+func Path() string {
+	return config.Get("hosts_file")
+}
+
+// Entries returns the entries of the hosts file in file order.
+func Entries() ([]Entry, error) {
+	data, err := fsys.Current.ReadFile(Path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	entries, err := parse(data)
+	if entries == nil {
+		entries = []Entry{}
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", Path(), err)
+	}
+	return entries, err
+}
+
+// List prints the entries as "address names...", hand-written ones
+// marked with their line, or as JSON or YAML records. output.List
+// narrows and orders them by first name.
+func List(w io.Writer, format string) error {
+	all, err := Entries()
+	if err != nil {
+		if all == nil {
+			return err
+		}
+		output.Warn(prompt.Out, "%v", err)
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Names[0], File: Path()}
+	})
+	if err != nil {
+		return err
+	}
+	entries := make([]Entry, len(idx))
+	for i, j := range idx {
+		entries[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, entries)
+	}
+	for _, e := range entries {
+		line := fmt.Sprintf("%-15s %s", output.Value(w, e.IP), output.Name(w, strings.Join(e.Names, " ")))
+		if !e.Managed {
+			line += fmt.Sprintf(" (line %d)", e.Line)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add maps names to ip in the managed block, joining the line shctl
+// already keeps for ip. A name already mapped to ip is reported as
+// ErrEntryExists, one mapped to another address of the same family as
+// ErrConflict.
+func Add(ctx context.Context, ip string, names []string) error {
+	addr, err := checkAddr(ip)
+	if err != nil {
+		return fmt.Errorf("%w: %w", err, util.ErrUsage)
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no host name for %s: %w", ip, util.ErrUsage)
+	}
+	for _, n := range names {
+		if err := checkName(n); err != nil {
+			return fmt.Errorf("%w: %w", err, util.ErrUsage)
+		}
+	}
+	return change(ctx, "add", func(lines []string, entries []Entry) ([]string, error) {
+		return add(lines, entries, addr, names)
+	})
+}
+
+func add(lines []string, entries []Entry, addr netip.Addr, names []string) ([]string, error) {
+	for i, n := range names {
+		for _, prev := range names[:i] {
+			if strings.EqualFold(prev, n) {
+				return nil, fmt.Errorf("%s given twice: %w", n, util.ErrUsage)
+			}
+		}
+		for _, e := range entries {
+			if !hasName(e, n) {
+				continue
+			}
+			other, _ := netip.ParseAddr(e.IP)
+			switch {
+			case other.WithZone("") == addr.WithZone(""):
+				return nil, fmt.Errorf("%s %s: %w at line %d", addr, n, util.ErrEntryExists, e.Line)
+			case other.Is4() == addr.Is4():
+				return nil, fmt.Errorf("%s: %w %s at line %d", n, ErrConflict, e.IP, e.Line)
+			}
+		}
+	}
+	begin, end, err := markers(lines)
+	if err != nil {
+		return nil, err
+	}
+	if i := managedLine(lines, begin, end, addr); i >= 0 {
+		lines[i] = strings.TrimRight(lines[i], " \t") + " " + strings.Join(names, " ")
+		return lines, nil
+	}
+	line := addr.String() + "\t" + strings.Join(names, " ")
+	if begin >= 0 {
+		return append(lines[:end], append([]string{line}, lines[end:]...)...), nil
+	}
+	if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+		lines = append(lines, "")
+	}
+	return append(lines, beginMarker, line, endMarker), nil
+}
+
+// Remove unmaps names from ip in the managed block, or drops the line
+// for ip when names is empty, keeping what it removed in the trash.
+func Remove(ctx context.Context, ip string, names []string) error {
+	addr, err := checkAddr(ip)
+	if err != nil {
+		return fmt.Errorf("%w: %w", err, util.ErrUsage)
+	}
+	var removed string
+	err = change(ctx, "remove", func(lines []string, entries []Entry) ([]string, error) {
+		begin, end, err := markers(lines)
+		if err != nil {
+			return nil, err
+		}
+		i := managedLine(lines, begin, end, addr)
+		if i < 0 {
+			for _, e := range entries {
+				if a, _ := netip.ParseAddr(e.IP); a == addr {
+					return nil, fmt.Errorf("%s at line %d: %w", ip, e.Line, ErrUnmanaged)
+				}
+			}
+			return nil, util.NotFound(fmt.Sprintf("no entry for %s in %s", ip, Path()))
+		}
+		f := fields(lines[i])
+		if len(names) == 0 {
+			removed = strings.Join(f, " ")
+			return append(lines[:i], lines[i+1:]...), nil
+		}
+		keep := f[1:]
+		for _, n := range names {
+			j := -1
+			for k, have := range keep {
+				if strings.EqualFold(have, n) {
+					j = k
+				}
+			}
+			if j < 0 {
+				return nil, util.NotFound(fmt.Sprintf("%s is not mapped to %s by shctl", n, ip))
+			}
+			keep = append(keep[:j:j], keep[j+1:]...)
+		}
+		removed = f[0] + " " + strings.Join(names, " ")
+		if len(keep) == 0 {
+			return append(lines[:i], lines[i+1:]...), nil
+		}
+		lines[i] = f[0] + "\t" + strings.Join(keep, " ")
+		return lines, nil
+	})
+	if err != nil {
+		return err
+	}
+	if terr := trash.Put("hosts", Path(), "hosts remove", []string{removed}); terr != nil {
+		output.Warn(prompt.Out, "trash: %v", terr)
+	}
+	return nil
+}
+
+// restoreLines maps the names of removed "address names..." lines again.
+func restoreLines(ctx context.Context, path string, removed []string) error {
+	if path != Path() {
+		return fmt.Errorf("%s is no longer the hosts file in use (%s)", path, Path())
+	}
+	return change(ctx, "trash restore", func(lines []string, entries []Entry) ([]string, error) {
+		for _, r := range removed {
+			f := fields(r)
+			addr, err := checkAddr(f[0])
+			if err != nil {
+				return nil, err
+			}
+			if lines, err = add(lines, entries, addr, f[1:]); err != nil {
+				return nil, err
+			}
+			if entries, err = parse(joinLines(lines)); err != nil {
+				return nil, err
+			}
+		}
+		return lines, nil
+	})
+}
+
+func hasName(e Entry, name string) bool {
+	for _, n := range e.Names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// markers returns the line indexes of the managed block's markers, -1
+// when there is no block.
+func markers(lines []string) (int, int, error) {
+	begin, end := -1, -1
+	for i, l := range lines {
+		switch strings.TrimSpace(l) {
+		case beginMarker:
+			if begin >= 0 {
+				return 0, 0, fmt.Errorf("%s: more than one shctl block", Path())
+			}
+			begin = i
+		case endMarker:
+			if begin < 0 || end >= 0 {
+				return 0, 0, fmt.Errorf("%s:%d: stray end of the shctl block", Path(), i+1)
+			}
+			end = i
+		}
+	}
+	if begin >= 0 && end < 0 {
+		return 0, 0, fmt.Errorf("%s:%d: the shctl block is not closed", Path(), begin+1)
+	}
+	return begin, end, nil
+}
+
+// managedLine returns the index of the line for addr in the managed
+// block, or -1.
+func managedLine(lines []string, begin, end int, addr netip.Addr) int {
+	for i := begin + 1; begin >= 0 && i < end; i++ {
+		if f := fields(lines[i]); len(f) > 0 {
+			if a, err := netip.ParseAddr(f[0]); err == nil && a == addr {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// change lets fn rewrite the lines of the hosts file, given its entries,
+// then validates the result and applies it.
+func change(ctx context.Context, op string, fn func(lines []string, entries []Entry) ([]string, error)) error {
+	path := Path()
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	entries, _ := parse(data)
+	lines, err := fn(splitLines(data), entries)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "shctl_hosts_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(joinLines(lines)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	logging.Info("hosts file", "op", op, "path", path, "tmp", tmp.Name())
+	// a file already broken by hand is not shctl's to refuse
+	if _, err := parse(data); err == nil {
+		if err := ValidateFile(tmp.Name()); err != nil {
+			return fmt.Errorf("hosts validation failed: %w", err)
+		}
+	}
+	return sysfile.Apply(ctx, "hosts", op, tmp.Name(), path, sysfile.Write)
+}
@@ -0,0 +1,114 @@
+package hosts
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"strings"
+)
+
+// Entry is one line of the hosts file: an address and its names.
+type Entry struct {
+	IP      string   `json:"ip"`
+	Names   []string `json:"names"`
+	Line    int      `json:"line"`
+	Managed bool     `json:"managed"` // inside the block shctl manages
+}
+
+// fields splits a hosts line into its words, without the comment.
+func fields(line string) []string {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	return strings.Fields(line)
+}
+
+// parse reads the entries of a hosts file. Lines that do not start with
+// an address, or have no name, are reported and left out.
+func parse(data []byte) ([]Entry, error) {
+	var out []Entry
+	var errs []error
+	managed := false
+	for i, line := range splitLines(data) {
+		n := i + 1
+		switch strings.TrimSpace(line) {
+		case beginMarker:
+			managed = true
+			continue
+		case endMarker:
+			managed = false
+			continue
+		}
+		f := fields(line)
+		if len(f) == 0 {
+			continue
+		}
+		if _, err := netip.ParseAddr(f[0]); err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %q is not an IP address", n, f[0]))
+			continue
+		}
+		if len(f) == 1 {
+			errs = append(errs, fmt.Errorf("line %d: %s has no host name", n, f[0]))
+			continue
+		}
+		out = append(out, Entry{IP: f[0], Names: f[1:], Line: n, Managed: managed})
+	}
+	return out, errors.Join(errs...)
+}
+
+// checkAddr parses an address to add.
+func checkAddr(s string) (netip.Addr, error) {
+	ip, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, fmt.Errorf("%q is not an IP address", s)
+	}
+	return ip, nil
+}
+
+// checkName validates a host name as hosts(5) wants it: dot separated
+// labels of letters, digits and inner hyphens.
+func checkName(name string) error {
+	if name == "" || len(name) > 253 {
+		return fmt.Errorf("invalid host name %q", name)
+	}
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		ok := label != "" && len(label) <= 63 && label[0] != '-' && label[len(label)-1] != '-'
+		for i := 0; ok && i < len(label); i++ {
+			c := label[i]
+			ok = c == '-' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+		}
+		if !ok {
+			return fmt.Errorf("invalid host name %q", name)
+		}
+	}
+	return nil
+}
+
+// ValidateFile checks that every line of a hosts file is an address
+// followed by names.
+func ValidateFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := parse(data); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
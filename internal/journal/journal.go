@@ -0,0 +1,339 @@
+// Package journal applies changes to several files as one unit. The
+// intended writes and the files' previous contents are recorded under
+// the state directory before anything is written, so a failed step rolls
+// back the ones before it and a run that died half way can be rolled back
+// later by Recover.
+package journal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// ApplyFunc writes the file src over dest.
+type ApplyFunc func(ctx context.Context, src, dest string) error
+
+// Tx is a set of file writes applied together. Callers hold the target
+// locks (util.LockTarget) of every path from staging until Commit returns.
+type Tx struct {
+	op    string
+	steps []step
+	temps []string
+}
+
+type step struct {
+	path   string
+	staged string
+	apply  ApplyFunc
+}
+
+// record is the on-disk journal of one Tx.
+type record struct {
+	Op      string    `json:"op"`
+	Created time.Time `json:"created"`
+	Entries []entry   `json:"entries"`
+	// Started counts the entries whose write has begun; only those are
+	// rolled back.
+	Started int `json:"started"`
+}
+
+type entry struct {
+	Path    string      `json:"path"`
+	Existed bool        `json:"existed"`
+	Mode    fs.FileMode `json:"mode,omitempty"`
+	Saved   string      `json:"saved,omitempty"` // previous content, in the journal directory
+}
+
+// New starts a transaction for operation op.
+func New(op string) *Tx {
+	return &Tx{op: op}
+}
+
+// Write schedules data to be written to path, with perm for a new file.
+func (t *Tx) Write(path string, data []byte, perm fs.FileMode) error {
+	return t.WriteWith(path, data, perm, nil)
+}
+
+// WriteWith is Write with data written over path by apply, as Stage does.
+func (t *Tx) WriteWith(path string, data []byte, perm fs.FileMode, apply ApplyFunc) error {
+	f, err := os.CreateTemp("", "shctl_tx_*")
+	if err != nil {
+		return err
+	}
+	t.temps = append(t.temps, f.Name())
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), perm)
+	}
+	if err != nil {
+		return err
+	}
+	t.Stage(path, f.Name(), apply)
+	return nil
+}
+
+// Stage schedules the staged file to be written over path by apply, or
+// copied in place when apply is nil. The caller keeps ownership of staged.
+func (t *Tx) Stage(path, staged string, apply ApplyFunc) {
+	if apply == nil {
+		apply = copyFile
+	}
+	t.steps = append(t.steps, step{path, staged, apply})
+}
+
+// Discard drops the scheduled writes and their temporary files. Commit
+// calls it itself.
+func (t *Tx) Discard() {
+	for _, tmp := range t.temps {
+		os.Remove(tmp)
+	}
+	t.steps, t.temps = nil, nil
+}
+
+// Commit applies the writes in order. When one fails, those already made
+// are undone in reverse order and the error says so; the journal is kept
+// only if the rollback fails too, for Recover to finish it.
+func (t *Tx) Commit(ctx context.Context) error {
+	defer t.Discard()
+	if !fsys.IsOS() {
+		// a dry run records the writes; there is nothing to undo
+		for _, s := range t.steps {
+			if err := s.apply(ctx, s.staged, s.path); err != nil {
+				return fmt.Errorf("write %s: %w", s.path, err)
+			}
+		}
+		return nil
+	}
+	dir, rec, unlock, err := t.begin()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	for i, s := range t.steps {
+		err = ctx.Err()
+		if err == nil {
+			rec.Started = i + 1
+			err = save(dir, rec)
+		}
+		if err == nil {
+			err = s.apply(ctx, s.staged, s.path)
+		}
+		if err != nil {
+			err = fmt.Errorf("write %s: %w", s.path, err)
+			undone, rerr := rollback(context.WithoutCancel(ctx), dir, rec, func(i int) ApplyFunc { return t.steps[i].apply })
+			if rerr != nil {
+				return fmt.Errorf("%w; rollback failed, run recovery to finish it (journal %s): %w", err, dir, rerr)
+			}
+			return fmt.Errorf("%w; rolled back %d file(s)", err, undone)
+		}
+	}
+	return os.RemoveAll(dir)
+}
+
+// begin saves the current content of every target and writes the
+// journal. The returned function releases the journal to Recover.
+func (t *Tx) begin() (string, *record, func(), error) {
+	base := Dir()
+	if err := os.MkdirAll(base, 0o700); err != nil {
+		return "", nil, nil, err
+	}
+	dir, err := os.MkdirTemp(base, "tx-")
+	if err != nil {
+		return "", nil, nil, err
+	}
+	unlock, err := util.Lock(filepath.Join(dir, "lock"), true)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, nil, err
+	}
+	rec := &record{Op: t.op, Created: time.Now().UTC()}
+	for i, s := range t.steps {
+		e, err := snapshotFile(dir, i, s.path)
+		if err != nil {
+			unlock()
+			os.RemoveAll(dir)
+			return "", nil, nil, err
+		}
+		rec.Entries = append(rec.Entries, e)
+	}
+	if err := save(dir, rec); err != nil {
+		unlock()
+		os.RemoveAll(dir)
+		return "", nil, nil, err
+	}
+	return dir, rec, unlock, nil
+}
+
+// snapshotFile copies path into the journal directory as entry i.
+func snapshotFile(dir string, i int, path string) (entry, error) {
+	e := entry{Path: path}
+	fi, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return e, nil
+	}
+	if err != nil {
+		return e, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return e, fmt.Errorf("save %s before writing it: %w", path, err)
+	}
+	e.Existed, e.Mode, e.Saved = true, fi.Mode().Perm(), strconv.Itoa(i)+".orig"
+	return e, os.WriteFile(filepath.Join(dir, e.Saved), b, 0o600)
+}
+
+func save(dir string, rec *record) error {
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.OS.WriteFile(filepath.Join(dir, "journal.json"), b, 0o600)
+}
+
+// rollback undoes the started entries of rec, newest first, and removes
+// the journal when all of them were undone.
+func rollback(ctx context.Context, dir string, rec *record, apply func(i int) ApplyFunc) (int, error) {
+	var errs []error
+	undone := 0
+	for i := rec.Started - 1; i >= 0; i-- {
+		e := rec.Entries[i]
+		var err error
+		if e.Existed {
+			saved := filepath.Join(dir, e.Saved)
+			if err = os.Chmod(saved, e.Mode); err == nil {
+				err = apply(i)(ctx, saved, e.Path)
+			}
+		} else if err = os.Remove(e.Path); errors.Is(err, fs.ErrNotExist) {
+			err = nil
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("restore %s: %w", e.Path, err))
+			continue
+		}
+		undone++
+	}
+	if len(errs) > 0 {
+		return undone, errors.Join(errs...)
+	}
+	return undone, os.RemoveAll(dir)
+}
+
+// Dir is where journals of unfinished transactions are kept.
+func Dir() string {
+	return filepath.Join(util.StateDir(), "journal")
+}
+
+// Recover rolls back every transaction a previous run left unfinished,
+// copying the saved contents back in place, and returns the paths it
+// restored. Journals of transactions still running are skipped. When some
+// journals were rolled back and others could not be, the error is a
+// *util.PartialError.
+func Recover(ctx context.Context) ([]string, error) {
+	dirs, err := filepath.Glob(filepath.Join(Dir(), "tx-*"))
+	if err != nil {
+		return nil, err
+	}
+	var restored []string
+	var errs []error
+	done := 0
+	for _, d := range dirs {
+		unlock, err := util.Lock(filepath.Join(d, "lock"), true)
+		var locked *util.LockedError
+		switch {
+		case errors.As(err, &locked):
+			continue
+		case errors.Is(err, fs.ErrNotExist):
+			continue // finished while we waited for the lock
+		case err != nil:
+			errs = append(errs, err)
+			continue
+		}
+		rec, err := load(d)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			// died before recording anything, so nothing was written
+			err = os.RemoveAll(d)
+		case err == nil:
+			if _, err = rollback(ctx, d, rec, func(int) ApplyFunc { return copyFile }); err == nil {
+				for _, e := range rec.Entries[:rec.Started] {
+					restored = append(restored, e.Path)
+				}
+			}
+		}
+		unlock()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", d, err))
+			continue
+		}
+		done++
+	}
+	if len(errs) > 0 && done > 0 {
+		return restored, &util.PartialError{Done: done, Failed: len(errs), Err: errors.Join(errs...)}
+	}
+	return restored, errors.Join(errs...)
+}
+
+// Pending returns the journals of unfinished transactions that no running
+// shctl holds, which Recover would roll back.
+func Pending() ([]string, error) {
+	dirs, err := filepath.Glob(filepath.Join(Dir(), "tx-*"))
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, d := range dirs {
+		held, err := util.Held(filepath.Join(d, "lock"))
+		if err != nil {
+			return out, err
+		}
+		if !held {
+			out = append(out, d)
+		}
+	}
+	return out, nil
+}
+
+func load(dir string) (*record, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "journal.json"))
+	if err != nil {
+		return nil, err
+	}
+	rec := &record{}
+	if err := json.Unmarshal(b, rec); err != nil {
+		return nil, fmt.Errorf("%s: %w", dir, err)
+	}
+	if rec.Started > len(rec.Entries) {
+		return nil, fmt.Errorf("%s: corrupt journal", dir)
+	}
+	return rec, nil
+}
+
+// copyFile writes src over dest on the current file system, keeping an
+// existing file's mode and owner.
+func copyFile(_ context.Context, src, dest string) error {
+	b, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := fsys.Current.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(dest, b, fi.Mode().Perm())
+}
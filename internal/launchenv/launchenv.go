@@ -0,0 +1,313 @@
+// Package launchenv sets environment variables for macOS GUI apps, which
+// launchd starts without reading any shell rc file. The variables live
+// in a LaunchAgent that runs `launchctl setenv` for each of them at
+// login; changes are also applied to the running session right away.
+// Apps already open keep their old environment until restarted.
+package launchenv
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Label is the label of the LaunchAgent, and the name of its plist.
+const Label = "shctl.environment"
+
+// script sets each name and value pair of its arguments, so the plist
+// holds the variables as plain strings.
+const script = `while [ $# -gt 1 ]; do /bin/launchctl setenv "$1" "$2"; shift 2; done`
+
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name: "launchd",
+		Files: func() ([]string, error) {
+			if _, err := fsys.Current.Stat(Path()); err != nil {
+				return nil, nil
+			}
+			return []string{Path()}, nil
+		},
+		Validate: func(_ context.Context, _, staged string) error {
+			data, err := os.ReadFile(staged)
+			if err != nil {
+				return err
+			}
+			_, err = parse(data)
+			return err
+		},
+	})
+	trash.Register("launchd", restoreLines)
+}
+
+// Var is one variable of the LaunchAgent.
+type Var struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Path is the plist of the LaunchAgent.
+func Path() string {
+	return filepath.Join(config.Get("launch_agents_dir"), Label+".plist")
+}
+
+// render writes the LaunchAgent plist for vars.
+func render(vars []Var) []byte {
+	var b bytes.Buffer
+	str := func(indent, s string) {
+		b.WriteString(indent + "<string>")
+		xml.EscapeText(&b, []byte(s))
+		b.WriteString("</string>\n")
+	}
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	b.WriteString("\t<key>Label</key>\n")
+	str("\t", Label)
+	b.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, a := range []string{"/bin/sh", "-c", script, "shctl"} {
+		str("\t\t", a)
+	}
+	for _, v := range vars {
+		str("\t\t", v.Name)
+		str("\t\t", v.Value)
+	}
+	b.WriteString("\t</array>\n\t<key>RunAtLoad</key>\n\t<true/>\n</dict>\n</plist>\n")
+	return b.Bytes()
+}
+
+// parse reads the variables back from a plist render wrote.
+func parse(data []byte) ([]Var, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	dec.Strict = false
+	var args []string
+	key, inArgs, text := "", false, ""
+	for {
+		tok, err := dec.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			text = ""
+			if t.Name.Local == "array" && key == "ProgramArguments" {
+				inArgs = true
+			}
+		case xml.CharData:
+			text += string(t)
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "key":
+				key = text
+			case "string":
+				if inArgs {
+					args = append(args, text)
+				}
+			case "array":
+				inArgs = false
+			}
+		}
+	}
+	if len(args) < 4 || args[2] != script || len(args[4:])%2 != 0 {
+		return nil, errors.New("not a LaunchAgent written by shctl")
+	}
+	vars := []Var{}
+	for i := 4; i < len(args); i += 2 {
+		vars = append(vars, Var{Name: args[i], Value: args[i+1]})
+	}
+	return vars, nil
+}
+
+// Vars returns the variables of the LaunchAgent.
+func Vars() ([]Var, error) {
+	data, err := fsys.Current.ReadFile(Path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Var{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	vars, err := parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", Path(), err)
+	}
+	return vars, nil
+}
+
+// List prints the variables as NAME=value, or as JSON or YAML records.
+// output.List narrows and orders them by name.
+func List(w io.Writer, format string) error {
+	all, err := Vars()
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name, File: Path()}
+	})
+	if err != nil {
+		return err
+	}
+	vars := make([]Var, len(idx))
+	for i, j := range idx {
+		vars[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, vars)
+	}
+	for _, v := range vars {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", output.Name(w, v.Name), output.Value(w, v.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set sets name to value in the LaunchAgent and in the running session.
+func Set(ctx context.Context, name, value string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid variable name %q: %w", name, util.ErrUsage)
+	}
+	if strings.ContainsRune(value, 0) {
+		return fmt.Errorf("%s: values cannot hold NUL: %w", name, util.ErrUsage)
+	}
+	err := change(ctx, "gui env set", func(vars []Var) ([]Var, error) {
+		return set(vars, name, value), nil
+	})
+	if err != nil {
+		return err
+	}
+	return launchctl(ctx, "setenv", name, value)
+}
+
+func set(vars []Var, name, value string) []Var {
+	for i, v := range vars {
+		if v.Name == name {
+			vars[i].Value = value
+			return vars
+		}
+	}
+	return append(vars, Var{name, value})
+}
+
+// Unset removes names from the LaunchAgent and the running session,
+// keeping them in the trash.
+func Unset(ctx context.Context, names []string) error {
+	var removed []string
+	err := change(ctx, "gui env unset", func(vars []Var) ([]Var, error) {
+		for _, n := range names {
+			i := -1
+			for j, v := range vars {
+				if v.Name == n {
+					i = j
+				}
+			}
+			if i < 0 {
+				return nil, util.NotFound(fmt.Sprintf("%s is not set in %s", n, Path()))
+			}
+			removed = append(removed, vars[i].Name+"="+vars[i].Value)
+			vars = append(vars[:i], vars[i+1:]...)
+		}
+		return vars, nil
+	})
+	if err != nil {
+		return err
+	}
+	if terr := trash.Put("launchd", Path(), "gui env unset", removed); terr != nil {
+		output.Warn(prompt.Out, "trash: %v", terr)
+	}
+	for _, n := range names {
+		if err := launchctl(ctx, "unsetenv", n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreLines sets the variables of removed NAME=value entries again.
+func restoreLines(ctx context.Context, path string, removed []string) error {
+	if path != Path() {
+		return fmt.Errorf("%s is no longer the LaunchAgent in use (%s)", path, Path())
+	}
+	err := change(ctx, "trash restore", func(vars []Var) ([]Var, error) {
+		for _, r := range removed {
+			k, v, _ := strings.Cut(r, "=")
+			vars = set(vars, k, v)
+		}
+		return vars, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, r := range removed {
+		k, v, _ := strings.Cut(r, "=")
+		if err := launchctl(ctx, "setenv", k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// change lets fn rewrite the variables and writes the plist again.
+func change(ctx context.Context, op string, fn func([]Var) ([]Var, error)) error {
+	path := Path()
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	vars, err := Vars()
+	if err != nil {
+		return err
+	}
+	if vars, err = fn(vars); err != nil {
+		return err
+	}
+	if err := backup.AutoSave(ctx, path, op); err != nil {
+		return err
+	}
+	if err := fsys.Current.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(path, render(vars), 0o644)
+}
+
+// launchctl applies a change to the running session. Without launchctl,
+// as on other systems, only the LaunchAgent is written.
+func launchctl(ctx context.Context, args ...string) error {
+	if _, err := exec.LookPath("launchctl"); err != nil {
+		logging.Debug("no launchctl; the change applies at the next login", "args", args)
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "launchctl", args...)
+	if dryrun.Skip(cmd.Args...) {
+		return nil
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("launchctl %s: %s: %w", args[0], strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
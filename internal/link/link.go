@@ -0,0 +1,378 @@
+// Package link deploys a dotfiles repository the way GNU Stow does: each
+// managed file of the repository gets a symlink in the home directory.
+// The mapping lives in the repository as shctl-links.json, with targets
+// relative to home, so cloning the repository and running apply sets up
+// another machine.
+package link
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// MapFile is the mapping file at the top of the repository.
+const MapFile = "shctl-links.json"
+
+// Statuses of a link.
+const (
+	Linked   = "linked"
+	Missing  = "missing"  // no target yet
+	Conflict = "conflict" // the target is a file or another link
+	Broken   = "broken"   // the repository file is gone
+)
+
+// ErrConflict reports a target that exists and is not the link.
+var ErrConflict = errors.New("target exists and is not a shctl link")
+
+// Link maps a repository file to a symlink.
+type Link struct {
+	Source string `json:"source"` // relative to the repository
+	Target string `json:"target"` // ~/ for home
+	Status string `json:"status,omitempty"`
+}
+
+// Dir is the dotfiles repository.
+func Dir() string {
+	return config.Get("link_dir")
+}
+
+// home expands a leading ~/ of p.
+func home(p string) string {
+	if rest, ok := strings.CutPrefix(p, "~/"); ok {
+		h, _ := os.UserHomeDir()
+		return filepath.Join(h, rest)
+	}
+	return p
+}
+
+// tilde writes p under the home directory as ~/..., so the mapping
+// suits other homes.
+func tilde(p string) string {
+	h, _ := os.UserHomeDir()
+	if rel, err := filepath.Rel(h, p); h != "" && err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		return "~/" + filepath.ToSlash(rel)
+	}
+	return p
+}
+
+// SourcePath is the absolute path of l's repository file.
+func (l Link) SourcePath() string {
+	return filepath.Join(Dir(), filepath.FromSlash(l.Source))
+}
+
+// TargetPath is the absolute path of l's symlink.
+func (l Link) TargetPath() string {
+	return home(filepath.FromSlash(l.Target))
+}
+
+// check makes sure l stays inside the repository and the home directory:
+// the mapping may come from a cloned repository, and a ../ in it must not
+// link files elsewhere or point links at files outside the repository.
+func (l Link) check() error {
+	if !inside(l.Source) || l.Source == MapFile {
+		return fmt.Errorf("link source %q is not a file of the dotfiles repository: %w", l.Source, util.ErrUsage)
+	}
+	rest, ok := strings.CutPrefix(l.Target, "~/")
+	if !ok || !inside(rest) {
+		return fmt.Errorf("link target %q is not below the home directory: %w", l.Target, util.ErrUsage)
+	}
+	return nil
+}
+
+// inside reports whether the slash-separated relative path p names
+// something below the directory it is relative to.
+func inside(p string) bool {
+	p = filepath.Clean(filepath.FromSlash(p))
+	return p != "." && !filepath.IsAbs(p) && p != ".." && !strings.HasPrefix(p, ".."+string(filepath.Separator))
+}
+
+// read returns the mapping in file order.
+func read() ([]Link, error) {
+	p := filepath.Join(Dir(), MapFile)
+	b, err := fsys.Current.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Link{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var links []Link
+	if err := json.Unmarshal(b, &links); err != nil {
+		return nil, fmt.Errorf("%s: %w", p, err)
+	}
+	return links, nil
+}
+
+// update rewrites the mapping under its lock.
+func update(ctx context.Context, op string, fn func([]Link) ([]Link, error)) error {
+	p := filepath.Join(Dir(), MapFile)
+	unlock, err := util.LockTarget(ctx, p)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	links, err := read()
+	if err != nil {
+		return err
+	}
+	if links, err = fn(links); err != nil {
+		return err
+	}
+	for i := range links {
+		links[i].Status = ""
+	}
+	b, err := json.MarshalIndent(links, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := backup.AutoSave(ctx, p, op); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(p, append(b, '\n'), 0o644)
+}
+
+// status reports how the target of l stands.
+func status(l Link) string {
+	if _, err := os.Stat(l.SourcePath()); err != nil {
+		return Broken
+	}
+	fi, err := os.Lstat(l.TargetPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return Missing
+	}
+	if err != nil || fi.Mode()&fs.ModeSymlink == 0 {
+		return Conflict
+	}
+	if dest, err := os.Readlink(l.TargetPath()); err != nil || dest != l.SourcePath() {
+		return Conflict
+	}
+	return Linked
+}
+
+// Links returns the mapping with the status of every link.
+func Links() ([]Link, error) {
+	links, err := read()
+	if err != nil {
+		return nil, err
+	}
+	for i := range links {
+		links[i].Status = status(links[i])
+	}
+	return links, nil
+}
+
+// List prints the links with their status, or as JSON or YAML records.
+// output.List narrows and orders them by target and source.
+func List(w io.Writer, format string) error {
+	all, err := Links()
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Target, File: all[i].Source}
+	})
+	if err != nil {
+		return err
+	}
+	links := make([]Link, len(idx))
+	for i, j := range idx {
+		links[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, links)
+	}
+	for _, l := range links {
+		if _, err := fmt.Fprintf(w, "%s -> %s (%s)\n", output.Name(w, l.Target), l.Source, l.Status); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Add maps source, a file of the repository, to target, by default the
+// same path below home, and links it. A target that exists is reported
+// as ErrConflict, with the mapping kept for a later apply, unless
+// replace is set, in which case it is backed up first.
+func Add(ctx context.Context, source, target string, replace bool) error {
+	src, err := filepath.Abs(source)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(Dir(), src)
+	if err != nil || rel == "." || rel == MapFile || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("%s is not a file of the dotfiles repository %s: %w", source, Dir(), util.ErrUsage)
+	}
+	if fi, err := os.Stat(src); err != nil {
+		return err
+	} else if fi.IsDir() {
+		return fmt.Errorf("%s is a directory; link its files one by one: %w", source, util.ErrUsage)
+	}
+	if target == "" {
+		target = "~/" + filepath.ToSlash(rel)
+	} else if t, err := filepath.Abs(home(target)); err == nil {
+		target = tilde(t)
+	}
+	l := Link{Source: filepath.ToSlash(rel), Target: target}
+	if err := l.check(); err != nil {
+		return err
+	}
+	err = update(ctx, "link add", func(links []Link) ([]Link, error) {
+		for _, o := range links {
+			if o.TargetPath() == l.TargetPath() {
+				return nil, fmt.Errorf("%s -> %s: %w", o.Target, o.Source, util.ErrEntryExists)
+			}
+		}
+		return append(links, l), nil
+	})
+	if err != nil {
+		return err
+	}
+	return place(ctx, l, replace)
+}
+
+// Remove drops the links of the given targets from the mapping and
+// deletes the symlinks that still point into the repository.
+func Remove(ctx context.Context, targets []string) error {
+	var gone []Link
+	err := update(ctx, "link remove", func(links []Link) ([]Link, error) {
+		want := map[string]bool{}
+		for _, t := range targets {
+			p, err := filepath.Abs(home(t))
+			if err != nil {
+				return nil, err
+			}
+			want[p] = true
+		}
+		var keep []Link
+		for _, l := range links {
+			if want[l.TargetPath()] {
+				gone = append(gone, l)
+				delete(want, l.TargetPath())
+				continue
+			}
+			keep = append(keep, l)
+		}
+		if len(want) > 0 {
+			var missing []string
+			for p := range want {
+				missing = append(missing, tilde(p))
+			}
+			sort.Strings(missing)
+			return nil, util.NotFound(fmt.Sprintf("no link at %s", strings.Join(missing, ", ")))
+		}
+		if keep == nil {
+			keep = []Link{}
+		}
+		return keep, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, l := range gone {
+		if l.check() != nil || status(l) != Linked || dryrun.Skip("rm", l.TargetPath()) {
+			continue
+		}
+		if err := os.Remove(l.TargetPath()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply creates the missing links. Conflicting targets are all reported
+// in one ErrConflict unless replace is set, which backs them up and
+// replaces them.
+func Apply(ctx context.Context, replace bool) error {
+	links, err := Links()
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, l := range links {
+		switch {
+		case l.Status == Broken:
+			logging.Warn("link source is missing", "source", l.SourcePath(), "target", l.Target)
+		case l.Status == Conflict && !replace:
+			errs = append(errs, fmt.Errorf("%s: %w", l.Target, ErrConflict))
+		case l.Status != Linked:
+			if err := place(ctx, l, replace); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// place links l. A file in the way is backed up and replaced when
+// replace is set; a directory never is. Entries leaving the repository
+// or the home directory are refused.
+func place(ctx context.Context, l Link, replace bool) error {
+	if err := l.check(); err != nil {
+		return err
+	}
+	src, dest := l.SourcePath(), l.TargetPath()
+	unlock, err := util.LockTarget(ctx, dest)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	switch status(l) {
+	case Linked:
+		return nil
+	case Broken:
+		return fmt.Errorf("%s: %w", src, fs.ErrNotExist)
+	case Conflict:
+		fi, err := os.Lstat(dest)
+		if err != nil {
+			return err
+		}
+		if !replace || fi.IsDir() {
+			return fmt.Errorf("%s: %w", l.Target, ErrConflict)
+		}
+		if dryrun.Skip("ln", "-sf", src, dest) {
+			return nil
+		}
+		if fi.Mode().IsRegular() {
+			where, err := backup.Save(ctx, dest)
+			if err != nil {
+				return fmt.Errorf("back up %s: %w", dest, err)
+			}
+			logging.Info("replaced by a link", "file", dest, "backup", where)
+		}
+		// link beside the file and rename over it, so the target never
+		// goes missing
+		tmp := dest + ".shctl-link"
+		os.Remove(tmp)
+		if err := os.Symlink(src, tmp); err != nil {
+			return err
+		}
+		if err := os.Rename(tmp, dest); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+		return nil
+	}
+	if dryrun.Skip("ln", "-s", src, dest) {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	return os.Symlink(src, dest)
+}
@@ -0,0 +1,44 @@
+// Package logging is shctl's diagnostic log: which files were chosen,
+// which temporary paths and external commands were used. Only warnings
+// show by default; -v adds informational messages and -vv debug ones.
+// Normal command output does not go through it.
+package logging
+
+import (
+	"io"
+	"log/slog"
+	"os"
+)
+
+// Logger receives the log. Until Setup only warnings and errors reach
+// stderr.
+var Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+
+// Level maps the -v count and --quiet to a level: errors only when quiet,
+// warnings by default, info for -v and debug for -vv.
+func Level(verbose int, quiet bool) slog.Level {
+	switch {
+	case quiet:
+		return slog.LevelError
+	case verbose >= 2:
+		return slog.LevelDebug
+	case verbose == 1:
+		return slog.LevelInfo
+	}
+	return slog.LevelWarn
+}
+
+// Setup sends records of level and above to w, as text, or as JSON
+// lines when json is set.
+func Setup(w io.Writer, level slog.Level, json bool) {
+	opts := &slog.HandlerOptions{Level: level}
+	if json {
+		Logger = slog.New(slog.NewJSONHandler(w, opts))
+		return
+	}
+	Logger = slog.New(slog.NewTextHandler(w, opts))
+}
+
+func Debug(msg string, args ...any) { Logger.Debug(msg, args...) }
+func Info(msg string, args ...any)  { Logger.Info(msg, args...) }
+func Warn(msg string, args ...any)  { Logger.Warn(msg, args...) }
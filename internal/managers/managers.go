@@ -0,0 +1,163 @@
+package managers
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Finding reports a file that another dotfile manager already owns.
+type Finding struct {
+	Manager  string
+	File     string
+	Source   string // the manager's copy of the file, when known
+	Guidance string
+}
+
+const firstRunMarker = "first-run"
+
+// FirstRun reports whether shctl has not yet recorded a completed first run.
+func FirstRun() bool {
+	_, err := os.Stat(filepath.Join(util.StateDir(), firstRunMarker))
+	return errors.Is(err, os.ErrNotExist)
+}
+
+func MarkFirstRun() error {
+	dir := util.StateDir()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, firstRunMarker), nil, 0o644)
+}
+
+// Detect checks each file against chezmoi, stow, yadm and home-manager.
+func Detect(ctx context.Context, files []string) []Finding {
+	out := []Finding{}
+	for _, f := range files {
+		for _, d := range detectors {
+			if fd, ok := d(ctx, f); ok {
+				out = append(out, fd)
+			}
+		}
+	}
+	return out
+}
+
+var detectors = []func(context.Context, string) (Finding, bool){
+	detectChezmoi,
+	detectHomeManager,
+	detectStow,
+	detectYadm,
+}
+
+func home() string {
+	h, _ := os.UserHomeDir()
+	return h
+}
+
+func dataHome() string {
+	if v := os.Getenv("XDG_DATA_HOME"); v != "" {
+		return v
+	}
+	return filepath.Join(home(), ".local", "share")
+}
+
+func detectChezmoi(_ context.Context, file string) (Finding, bool) {
+	src := filepath.Join(dataHome(), "chezmoi")
+	rel, err := filepath.Rel(home(), file)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return Finding{}, false
+	}
+	// chezmoi encodes ".bashrc" as "dot_bashrc", optionally with attribute
+	// prefixes (private_, readonly_, ...) and a ".tmpl" suffix.
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	for i, p := range parts {
+		if strings.HasPrefix(p, ".") {
+			parts[i] = "dot_" + p[1:]
+		}
+	}
+	dir := filepath.Join(append([]string{src}, parts[:len(parts)-1]...)...)
+	matches, _ := filepath.Glob(filepath.Join(dir, "*"+parts[len(parts)-1]+"*"))
+	for _, m := range matches {
+		base := filepath.Base(m)
+		if strings.HasSuffix(strings.TrimSuffix(base, ".tmpl"), parts[len(parts)-1]) {
+			return Finding{
+				Manager:  "chezmoi",
+				File:     file,
+				Source:   m,
+				Guidance: "edit the source with `chezmoi edit " + file + "` or point shctl at " + m + " so `chezmoi apply` keeps its changes",
+			}, true
+		}
+	}
+	return Finding{}, false
+}
+
+func detectHomeManager(_ context.Context, file string) (Finding, bool) {
+	target, err := filepath.EvalSymlinks(file)
+	if err != nil || target == file || !strings.HasPrefix(target, "/nix/store/") {
+		return Finding{}, false
+	}
+	return Finding{
+		Manager:  "home-manager",
+		File:     file,
+		Source:   target,
+		Guidance: "the file is a read-only nix store link; add aliases/exports to your home-manager configuration instead",
+	}, true
+}
+
+func detectStow(_ context.Context, file string) (Finding, bool) {
+	fi, err := os.Lstat(file)
+	if err != nil || fi.Mode()&os.ModeSymlink == 0 {
+		return Finding{}, false
+	}
+	target, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return Finding{}, false
+	}
+	// a stow package lives under a stow directory marked with .stow, or in
+	// a tree that carries .stowrc/.stow-local-ignore files.
+	for dir := filepath.Dir(target); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		for _, marker := range []string{".stow", ".stowrc", ".stow-local-ignore"} {
+			if _, err := os.Stat(filepath.Join(dir, marker)); err == nil {
+				return Finding{
+					Manager:  "stow",
+					File:     file,
+					Source:   target,
+					Guidance: "the file is a stow symlink; edit " + target + " in your stow package (or enable symlink write-through) so the link is not replaced",
+				}, true
+			}
+		}
+	}
+	return Finding{}, false
+}
+
+func detectYadm(ctx context.Context, file string) (Finding, bool) {
+	var repo string
+	for _, r := range []string{
+		filepath.Join(dataHome(), "yadm", "repo.git"),
+		filepath.Join(home(), ".config", "yadm", "repo.git"),
+	} {
+		if _, err := os.Stat(r); err == nil {
+			repo = r
+			break
+		}
+	}
+	if repo == "" {
+		return Finding{}, false
+	}
+	cmd := exec.CommandContext(ctx, "git", "--git-dir="+repo, "--work-tree="+home(), "ls-files", "--error-unmatch", file)
+	if err := cmd.Run(); err != nil {
+		return Finding{}, false
+	}
+	return Finding{
+		Manager:  "yadm",
+		File:     file,
+		Source:   repo,
+		Guidance: "the file is tracked by yadm; commit shctl's changes with `yadm add " + file + "` or they will show up as local modifications",
+	}, true
+}
@@ -0,0 +1,303 @@
+package output
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	Text = "text"
+	JSON = "json"
+	YAML = "yaml"
+)
+
+// Format is the output format from the global --output flag or the output
+// setting; list commands pass it to their ListFormat functions.
+var Format = Text
+
+// Color is the color setting: auto, always or never. auto colors output
+// to a terminal unless NO_COLOR is set.
+var Color = "auto"
+
+// Check validates a --output value.
+func Check(format string) error {
+	switch format {
+	case "", Text, JSON, YAML:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q (want text, json or yaml)", format)
+}
+
+// Colorize reports whether output written to w should be colored.
+func Colorize(w io.Writer) bool {
+	switch Color {
+	case "always":
+		return true
+	case "never":
+		return false
+	}
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	if t, ok := w.(interface{ terminal() bool }); ok {
+		return t.terminal()
+	}
+	f, ok := w.(*os.File)
+	return ok && isTerminal(f)
+}
+
+// ANSI colors used by the painters below.
+const (
+	bold   = "1"
+	red    = "31"
+	green  = "32"
+	yellow = "33"
+	cyan   = "36"
+)
+
+// paint wraps s in color code when w is colored.
+func paint(w io.Writer, code, s string) string {
+	if s == "" || !Colorize(w) {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// Name colors the name of a listed entry.
+func Name(w io.Writer, s string) string { return paint(w, cyan, s) }
+
+// Value colors the value of a listed entry.
+func Value(w io.Writer, s string) string { return paint(w, green, s) }
+
+// Warn prints a warning line to w.
+func Warn(w io.Writer, format string, args ...any) {
+	fmt.Fprintln(w, paint(w, yellow, "warning:"), fmt.Sprintf(format, args...))
+}
+
+// Error prints an error line to w, for problems reported among others.
+func Error(w io.Writer, format string, args ...any) {
+	fmt.Fprintln(w, paint(w, red, "error:"), fmt.Sprintf(format, args...))
+}
+
+// WriteDiff writes a unified diff, with removed lines in red and added
+// ones in green when w is colored.
+func WriteDiff(w io.Writer, diff string) error {
+	if !Colorize(w) {
+		_, err := io.WriteString(w, diff)
+		return err
+	}
+	var sb strings.Builder
+	for _, l := range strings.SplitAfter(diff, "\n") {
+		code := ""
+		switch {
+		case strings.HasPrefix(l, "---"), strings.HasPrefix(l, "+++"):
+			code = bold
+		case strings.HasPrefix(l, "-"):
+			code = red
+		case strings.HasPrefix(l, "+"):
+			code = green
+		case strings.HasPrefix(l, "@@"):
+			code = cyan
+		}
+		if code == "" {
+			sb.WriteString(l)
+			continue
+		}
+		text, nl := strings.CutSuffix(l, "\n")
+		sb.WriteString("\x1b[" + code + "m" + text + "\x1b[0m")
+		if nl {
+			sb.WriteByte('\n')
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Structured reports whether format is a machine-readable one.
+func Structured(format string) bool {
+	return format == JSON || format == YAML
+}
+
+// Write encodes v as JSON or YAML. Field names come from json tags so both
+// formats agree.
+func Write(w io.Writer, format string, v any) error {
+	switch format {
+	case JSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	case YAML:
+		var sb strings.Builder
+		writeYAML(&sb, reflect.ValueOf(v))
+		_, err := io.WriteString(w, sb.String())
+		return err
+	}
+	return fmt.Errorf("output format %q is not structured", format)
+}
+
+func writeYAML(sb *strings.Builder, v reflect.Value) {
+	v = deref(v)
+	if s, ok := yamlScalar(v); ok {
+		sb.WriteString(s + "\n")
+		return
+	}
+	yamlBlock(sb, v, 0)
+}
+
+func deref(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+type field struct {
+	name string
+	val  reflect.Value
+}
+
+// fields lists a struct's or map's entries in output order, applying json
+// tag names and omitempty.
+func fields(v reflect.Value) []field {
+	out := []field{}
+	if v.Kind() == reflect.Map {
+		keys := v.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j]) })
+		for _, k := range keys {
+			out = append(out, field{fmt.Sprint(k), v.MapIndex(k)})
+		}
+		return out
+	}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fv := v.Field(i)
+		if strings.Contains(opts, "omitempty") && fv.IsZero() {
+			continue
+		}
+		if (fv.Kind() == reflect.Slice || fv.Kind() == reflect.Map) && strings.Contains(opts, "omitempty") && fv.Len() == 0 {
+			continue
+		}
+		out = append(out, field{name, fv})
+	}
+	return out
+}
+
+// yamlBlock writes a mapping or sequence with every line at indent.
+func yamlBlock(sb *strings.Builder, v reflect.Value, indent int) {
+	pad := strings.Repeat(" ", indent)
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			item := deref(v.Index(i))
+			if s, ok := yamlScalar(item); ok {
+				sb.WriteString(pad + "- " + s + "\n")
+				continue
+			}
+			// render the nested block two columns in, then pull its first
+			// line up next to the dash
+			var sub strings.Builder
+			yamlBlock(&sub, item, indent+2)
+			sb.WriteString(pad + "- " + strings.TrimPrefix(sub.String(), pad+"  "))
+		}
+	default:
+		for _, f := range fields(v) {
+			val := deref(f.val)
+			if s, ok := yamlScalar(val); ok {
+				sb.WriteString(pad + yamlString(f.name) + ": " + s + "\n")
+				continue
+			}
+			sb.WriteString(pad + yamlString(f.name) + ":\n")
+			yamlBlock(sb, val, indent+2)
+		}
+	}
+}
+
+// yamlScalar renders v inline when it is a scalar or an empty collection.
+func yamlScalar(v reflect.Value) (string, bool) {
+	if !v.IsValid() {
+		return "null", true
+	}
+	if tm, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, err := tm.MarshalText()
+		if err == nil {
+			return yamlString(string(b)), true
+		}
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return yamlString(v.String()), true
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool()), true
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(v.Int(), 10), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(v.Uint(), 10), true
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(v.Float(), 'g', -1, 64), true
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			b, _ := json.Marshal(v.Interface())
+			return string(b), true
+		}
+		if v.Len() == 0 {
+			return "[]", true
+		}
+	case reflect.Map:
+		if v.Len() == 0 {
+			return "{}", true
+		}
+	case reflect.Struct:
+		if len(fields(v)) == 0 {
+			return "{}", true
+		}
+	}
+	return "", false
+}
+
+var yamlReserved = map[string]bool{
+	"true": true, "false": true, "yes": true, "no": true, "on": true, "off": true,
+	"null": true, "~": true, "y": true, "n": true,
+}
+
+// yamlString quotes s when a plain scalar would be read back differently.
+func yamlString(s string) string {
+	needQuote := s == "" || yamlReserved[strings.ToLower(s)] ||
+		strings.TrimSpace(s) != s ||
+		strings.ContainsAny(s[:1], "-?:,[]{}#&*!|>'\"%@`") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") || strings.HasSuffix(s, ":")
+	if !needQuote {
+		if _, err := strconv.ParseFloat(s, 64); err == nil {
+			needQuote = true
+		}
+	}
+	for _, r := range s {
+		if r < 0x20 || r == 0x7f {
+			needQuote = true
+		}
+	}
+	if needQuote {
+		return strconv.Quote(s)
+	}
+	return s
+}
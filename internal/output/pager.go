@@ -0,0 +1,52 @@
+package output
+
+import (
+	"io"
+	"os"
+	"os/exec"
+)
+
+// Pager is the pager setting: the command long listings on a terminal are
+// piped through, or never.
+var Pager = "less -FRX"
+
+// paged is the pipe to a pager, which shows output on a terminal.
+type paged struct {
+	io.WriteCloser
+}
+
+func (paged) terminal() bool { return true }
+
+// Page starts Pager when w is a terminal and returns the writer to print
+// to in its place, with a func that waits for the pager to quit. When w is
+// not a terminal or the pager does not start, w itself is returned.
+func Page(w io.Writer) (io.Writer, func() error) {
+	done := func() error { return nil }
+	f, ok := w.(*os.File)
+	if Pager == "" || Pager == "never" || !ok || !isTerminal(f) {
+		return w, done
+	}
+	cmd := exec.Command("sh", "-c", Pager)
+	cmd.Stdout, cmd.Stderr = f, os.Stderr
+	if os.Getenv("LESS") == "" {
+		// let less pass colors through and quit on short output even
+		// when the pager setting is plain "less"
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return w, done
+	}
+	if err := cmd.Start(); err != nil {
+		return w, done
+	}
+	return paged{in}, func() error {
+		in.Close()
+		return cmd.Wait()
+	}
+}
+
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
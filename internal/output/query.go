@@ -0,0 +1,137 @@
+package output
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Query narrows and orders what a list command prints.
+type Query struct {
+	// Filter is a glob the whole name must match, or a /regexp/ it must
+	// contain a match of.
+	Filter string `json:"filter,omitempty"`
+	// Sort is name, file or mtime (newest first); empty keeps the order
+	// the command lists in.
+	Sort   string `json:"sort,omitempty"`
+	Limit  int    `json:"limit,omitempty"` // no limit when 0
+	Offset int    `json:"offset,omitempty"`
+}
+
+// List is the query from the --filter, --sort, --limit and --offset
+// flags of list commands.
+var List Query
+
+// Key is what a Query looks at in one record.
+type Key struct {
+	Name string
+	File string
+	// Mtime is when the record last changed; when zero, the modification
+	// time of File is used.
+	Mtime time.Time
+}
+
+// Check validates q, so bad flags fail before anything is listed.
+func (q Query) Check() error {
+	switch q.Sort {
+	case "", "name", "file", "mtime":
+	default:
+		return fmt.Errorf("unknown sort %q (want name, file or mtime): %w", q.Sort, util.ErrUsage)
+	}
+	if q.Limit < 0 || q.Offset < 0 {
+		return fmt.Errorf("--limit and --offset must not be negative: %w", util.ErrUsage)
+	}
+	_, err := q.matcher()
+	return err
+}
+
+// matcher compiles Filter.
+func (q Query) matcher() (*regexp.Regexp, error) {
+	f := q.Filter
+	if f == "" {
+		return nil, nil
+	}
+	expr := "^" + globRegexp(f) + "$"
+	if len(f) > 1 && strings.HasPrefix(f, "/") && strings.HasSuffix(f, "/") {
+		expr = f[1 : len(f)-1]
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("filter %q: %v: %w", f, err, util.ErrUsage)
+	}
+	return re, nil
+}
+
+// globRegexp translates a glob whose * and ? match any character,
+// slashes included, since names here are not paths.
+func globRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// Apply returns the indexes of the n records q selects, in the order it
+// puts them, given the key of each.
+func (q Query) Apply(n int, key func(i int) Key) ([]int, error) {
+	re, err := q.matcher()
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]Key, n)
+	idx := make([]int, 0, n)
+	for i := 0; i < n; i++ {
+		keys[i] = key(i)
+		if re == nil || re.MatchString(keys[i].Name) {
+			idx = append(idx, i)
+		}
+	}
+	switch q.Sort {
+	case "name":
+		sort.SliceStable(idx, func(a, b int) bool { return keys[idx[a]].Name < keys[idx[b]].Name })
+	case "file":
+		sort.SliceStable(idx, func(a, b int) bool { return keys[idx[a]].File < keys[idx[b]].File })
+	case "mtime":
+		for _, i := range idx {
+			if keys[i].Mtime.IsZero() && keys[i].File != "" {
+				if fi, err := fsys.Current.Stat(keys[i].File); err == nil {
+					keys[i].Mtime = fi.ModTime()
+				}
+			}
+		}
+		sort.SliceStable(idx, func(a, b int) bool { return keys[idx[a]].Mtime.After(keys[idx[b]].Mtime) })
+	}
+	if q.Offset >= len(idx) {
+		return []int{}, nil
+	}
+	idx = idx[q.Offset:]
+	if q.Limit > 0 && q.Limit < len(idx) {
+		idx = idx[:q.Limit]
+	}
+	return idx, nil
+}
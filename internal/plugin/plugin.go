@@ -0,0 +1,94 @@
+// Package plugin runs external shctl-<name> executables found on PATH as
+// `shctl <name> ...`, the way git runs git-<name>. Plugins get shctl's
+// resolved settings in the environment so they act on the same files.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Prefix starts the file name of every plugin.
+const Prefix = "shctl-"
+
+// ErrNotFound is returned by Lookup when no plugin has the name.
+var ErrNotFound = util.NotFound("no such plugin")
+
+// Lookup returns the path of plugin name.
+func Lookup(name string) (string, error) {
+	if name == "" || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("%q: %w", name, ErrNotFound)
+	}
+	path, err := exec.LookPath(Prefix + name)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, ErrNotFound)
+	}
+	return path, nil
+}
+
+// List returns the names of the plugins on PATH, sorted. A name found in
+// several directories counts once, as Lookup would pick the first.
+func List() []string {
+	seen := map[string]bool{}
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		matches, _ := filepath.Glob(filepath.Join(dir, Prefix+"*"))
+		for _, m := range matches {
+			name := strings.TrimPrefix(filepath.Base(m), Prefix)
+			if _, err := exec.LookPath(m); err == nil && name != "" {
+				seen[name] = true
+			}
+		}
+	}
+	out := make([]string, 0, len(seen))
+	for name := range seen {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// Environ is what a plugin gets on top of shctl's own environment: every
+// setting's resolved value, plus
+//
+//	SHCTL_OUTPUT     the output format in effect
+//	SHCTL_DRY_RUN    1 in a dry run, when the plugin must not change anything
+//	SHCTL_STATE_DIR  shctl's state directory
+//	SHCTL_VERSION    the running shctl's version
+func Environ() []string {
+	dry := "0"
+	if dryrun.Enabled {
+		dry = "1"
+	}
+	return append(config.Environ(),
+		"SHCTL_OUTPUT="+output.Format,
+		"SHCTL_DRY_RUN="+dry,
+		"SHCTL_STATE_DIR="+util.StateDir(),
+		"SHCTL_VERSION="+config.Version,
+	)
+}
+
+// Run runs plugin name with args on the terminal shctl was started on. A
+// plugin failing with an exit status is returned as an *exec.ExitError
+// so the root command can exit with the same status.
+func Run(ctx context.Context, name string, args ...string) error {
+	path, err := Lookup(name)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), Environ()...)
+	logging.Debug("running plugin", "argv", cmd.Args)
+	return cmd.Run()
+}
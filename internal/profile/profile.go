@@ -0,0 +1,238 @@
+// Package profile keeps named environment profiles, such as "work" and
+// "personal": each is a file of rc lines, limited to aliases, exports,
+// functions and source lines. Switching writes the chosen profile into a
+// block of the rc file, replacing the previous one in a single write.
+// Editing a profile file takes effect at the next switch.
+package profile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// The rc file block holding the active profile, and the start of its
+// first line, which names the profile.
+const (
+	blockName  = "profile"
+	namePrefix = "# profile: "
+)
+
+var (
+	nameRe     = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+	functionRe = regexp.MustCompile(`^(function\s+[A-Za-z_][A-Za-z0-9_:.-]*(\s*\(\))?|[A-Za-z_][A-Za-z0-9_:.-]*\s*\(\))\s*(\{.*)?$`)
+)
+
+// Profile describes one profile file.
+type Profile struct {
+	Name    string `json:"name"`
+	Path    string `json:"path"`
+	Entries int    `json:"entries"`
+	Active  bool   `json:"active"`
+}
+
+// Dir is the directory of the profile files.
+func Dir() string {
+	return config.Get("profile_dir")
+}
+
+// Path is the file of profile name.
+func Path(name string) string {
+	return filepath.Join(Dir(), name+".sh")
+}
+
+func checkName(name string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q: %w", name, util.ErrUsage)
+	}
+	return nil
+}
+
+// Create writes profile name with lines. An existing profile is reported
+// as ErrEntryExists.
+func Create(ctx context.Context, name string, lines []string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	if _, err := check(lines); err != nil {
+		return fmt.Errorf("profile %s: %w: %w", name, err, util.ErrUsage)
+	}
+	path := Path(name)
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if _, err := fsys.Current.Stat(path); err == nil {
+		return fmt.Errorf("profile %s: %w in %s", name, util.ErrEntryExists, Dir())
+	}
+	if err := fsys.Current.MkdirAll(Dir(), 0o755); err != nil {
+		return err
+	}
+	var data []byte
+	if len(lines) > 0 {
+		data = []byte(strings.Join(lines, "\n") + "\n")
+	}
+	return fsys.Current.WriteFile(path, data, 0o644)
+}
+
+// read returns the lines of profile name.
+func read(name string) ([]string, error) {
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	data, err := fsys.Current.ReadFile(Path(name))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, util.NotFound(fmt.Sprintf("no profile %s in %s", name, Dir()))
+	}
+	if err != nil {
+		return nil, err
+	}
+	s := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	if s == "" {
+		return []string{}, nil
+	}
+	return strings.Split(s, "\n"), nil
+}
+
+// Active returns the name of the profile in the rc file, or "" when none
+// is.
+func Active() (string, error) {
+	lines, ok, err := rc.Block(blockName)
+	if err != nil || !ok || len(lines) == 0 {
+		return "", err
+	}
+	name, _ := strings.CutPrefix(strings.TrimSpace(lines[0]), strings.TrimSpace(namePrefix))
+	return strings.TrimSpace(name), nil
+}
+
+// Profiles returns the profiles sorted by name.
+func Profiles() ([]Profile, error) {
+	active, err := Active()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := fsys.Current.ReadDir(Dir())
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	out := []Profile{}
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), ".sh")
+		if !ok || e.IsDir() || !nameRe.MatchString(name) {
+			continue
+		}
+		lines, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		n, _ := check(lines)
+		out = append(out, Profile{Name: name, Path: Path(name), Entries: n, Active: name == active})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out, nil
+}
+
+// List prints the profiles, the active one marked with "*", or as JSON or
+// YAML records. output.List narrows and orders them by name and file.
+func List(w io.Writer, format string) error {
+	all, err := Profiles()
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name, File: all[i].Path}
+	})
+	if err != nil {
+		return err
+	}
+	profiles := make([]Profile, len(idx))
+	for i, j := range idx {
+		profiles[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, profiles)
+	}
+	for _, p := range profiles {
+		mark := " "
+		if p.Active {
+			mark = "*"
+		}
+		if _, err := fmt.Fprintf(w, "%s %s (%d entries)\n", mark, output.Name(w, p.Name), p.Entries); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Switch makes name the active profile by rewriting the rc file's
+// profile block with its lines. An empty name removes the block.
+func Switch(ctx context.Context, name string) error {
+	if name == "" {
+		return rc.SetBlock(ctx, "profile switch", blockName, nil)
+	}
+	lines, err := read(name)
+	if err != nil {
+		return err
+	}
+	if _, err := check(lines); err != nil {
+		return fmt.Errorf("profile %s (%s): %w", name, Path(name), err)
+	}
+	return rc.SetBlock(ctx, "profile switch", blockName, append([]string{namePrefix + name}, lines...))
+}
+
+// check counts the entries of a profile and rejects lines that are not
+// aliases, exports, functions or source lines. Function bodies are
+// followed by counting braces.
+func check(lines []string) (int, error) {
+	n, depth, open := 0, 0, false // open: a function waits for its body
+	for i, l := range lines {
+		t := strings.TrimSpace(l)
+		switch {
+		case depth > 0:
+			depth += braces(t)
+			continue
+		case open && t != "":
+			if !strings.HasPrefix(t, "{") {
+				return n, fmt.Errorf("line %d: function body must open with {", i+1)
+			}
+			depth, open = braces(t), false
+			continue
+		case t == "" || strings.HasPrefix(t, "#"):
+			continue
+		case functionRe.MatchString(t):
+			n++
+			depth, open = braces(t), !strings.Contains(t, "{")
+			continue
+		}
+		words, err := util.Shell.Words(t)
+		if err != nil {
+			return n, fmt.Errorf("line %d: %w", i+1, err)
+		}
+		if !util.HasWords(words, "alias") && !util.HasWords(words, "export") &&
+			!util.HasWords(words, "source") && !util.HasWords(words, ".") {
+			return n, fmt.Errorf("line %d: only aliases, exports, functions and source lines belong in a profile", i+1)
+		}
+		n++
+	}
+	if depth != 0 || open {
+		return n, errors.New("a function body is not closed")
+	}
+	return n, nil
+}
+
+func braces(s string) int {
+	return strings.Count(s, "{") - strings.Count(s, "}")
+}
@@ -0,0 +1,96 @@
+package prompt
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// ErrNoTerminal is returned by Pick when stdin is not a terminal: entries
+// then have to be named on the command line.
+var ErrNoTerminal = fmt.Errorf("choosing entries needs a terminal; name them instead: %w", util.ErrUsage)
+
+// Pick lets the user choose any number of items. Typing text narrows the
+// list to items matching it fuzzily; numbers and ranges like "1,3-4"
+// toggle the items shown with those numbers, "all" and "none" select or
+// clear every item shown, "/text" filters on text that looks like a
+// number and "/" alone clears the filter. An empty answer finishes. The
+// chosen indexes are returned in item order. --yes does not choose for
+// the user.
+func Pick(question string, items []string) ([]int, error) {
+	if !interactive() {
+		return nil, ErrNoTerminal
+	}
+	chosen := make([]bool, len(items))
+	query := ""
+	for {
+		shown := Fuzzy(query, items)
+		for i, it := range shown {
+			mark := " "
+			if chosen[it] {
+				mark = "x"
+			}
+			fmt.Fprintf(Out, "%3d [%s] %s\n", i+1, mark, items[it])
+		}
+		if len(shown) == 0 {
+			fmt.Fprintf(Out, "no entries match %q\n", query)
+		}
+		fmt.Fprintf(Out, "%s [filter/1,3-4/all/none, enter when done]: ", question)
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		answer := strings.TrimSpace(line)
+		switch strings.ToLower(answer) {
+		case "":
+			var out []int
+			for i, c := range chosen {
+				if c {
+					out = append(out, i)
+				}
+			}
+			return out, nil
+		case "all", "none":
+			for _, it := range shown {
+				chosen[it] = strings.EqualFold(answer, "all")
+			}
+			continue
+		}
+		if q, ok := strings.CutPrefix(answer, "/"); ok {
+			query = q
+			continue
+		}
+		sel, err := parseSelection(answer, len(shown))
+		if err != nil && answer[0] >= '0' && answer[0] <= '9' {
+			fmt.Fprintln(Out, err)
+			continue
+		}
+		if err != nil {
+			query = answer
+			continue
+		}
+		for _, i := range sel {
+			chosen[shown[i]] = !chosen[shown[i]]
+		}
+	}
+}
+
+// Fuzzy returns the indexes of the items containing the characters of
+// query in order, ignoring case. An empty query matches every item.
+func Fuzzy(query string, items []string) []int {
+	q := []rune(strings.ToLower(query))
+	out := []int{}
+	for i, it := range items {
+		rest := q
+		for _, r := range strings.ToLower(it) {
+			if len(rest) > 0 && r == rest[0] {
+				rest = rest[1:]
+			}
+		}
+		if len(rest) == 0 {
+			out = append(out, i)
+		}
+	}
+	return out
+}
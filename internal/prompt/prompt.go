@@ -0,0 +1,146 @@
+package prompt
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+var (
+	// AssumeYes answers every confirmation with yes (--yes).
+	AssumeYes = false
+	// Force overrides safety checks such as pinned backups (--force).
+	Force = false
+
+	In  io.Reader = os.Stdin
+	Out io.Writer = os.Stdout
+)
+
+// ErrAborted is returned when the user answers no.
+var ErrAborted = util.ErrAborted
+
+// ErrNotInteractive is returned instead of asking when stdin is not a
+// terminal and --yes was not given. It matches ErrAborted.
+var ErrNotInteractive = fmt.Errorf("confirmation needed but stdin is not a terminal; pass --yes: %w", ErrAborted)
+
+// interactive reports whether questions can be asked on In. Readers other
+// than files are answers given by the caller.
+func interactive() bool {
+	f, ok := In.(*os.File)
+	if !ok {
+		return true
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Confirm asks a yes/no question, defaulting to no.
+func Confirm(question string) (bool, error) {
+	if AssumeYes {
+		return true, nil
+	}
+	if !interactive() {
+		return false, ErrNotInteractive
+	}
+	fmt.Fprintf(Out, "%s [y/N]: ", question)
+	line, err := readLine()
+	if err != nil {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+var (
+	reader    *bufio.Reader
+	readerSrc io.Reader
+)
+
+// readLine reads one line from In, keeping a buffered reader across calls
+// so piped answers are not lost between prompts.
+func readLine() (string, error) {
+	if reader == nil || readerSrc != In {
+		reader, readerSrc = bufio.NewReader(In), In
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Select lists items numbered from 1 and asks which to act on. The answer
+// may be "all", "none" (the default) or numbers and ranges like "1,3-4".
+// With AssumeYes every item is selected.
+func Select(question string, items []string) ([]int, error) {
+	all := make([]int, len(items))
+	for i := range items {
+		all[i] = i
+	}
+	for i, it := range items {
+		fmt.Fprintf(Out, "%3d  %s\n", i+1, it)
+	}
+	if AssumeYes {
+		return all, nil
+	}
+	if !interactive() {
+		return nil, ErrNotInteractive
+	}
+	for {
+		fmt.Fprintf(Out, "%s [all/none/1,3-4]: ", question)
+		line, err := readLine()
+		if err != nil {
+			return nil, err
+		}
+		answer := strings.ToLower(strings.TrimSpace(line))
+		switch answer {
+		case "", "n", "none":
+			return nil, nil
+		case "a", "all":
+			return all, nil
+		}
+		sel, err := parseSelection(answer, len(items))
+		if err == nil {
+			return sel, nil
+		}
+		fmt.Fprintln(Out, err)
+	}
+}
+
+func parseSelection(s string, n int) ([]int, error) {
+	seen := map[int]bool{}
+	out := []int{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		lo, hi, isRange := strings.Cut(part, "-")
+		a, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			return nil, fmt.Errorf("invalid selection %q", part)
+		}
+		b := a
+		if isRange {
+			if b, err = strconv.Atoi(strings.TrimSpace(hi)); err != nil {
+				return nil, fmt.Errorf("invalid selection %q", part)
+			}
+		}
+		if a < 1 || b > n || a > b {
+			return nil, fmt.Errorf("selection %q out of range 1-%d", part, n)
+		}
+		for i := a; i <= b; i++ {
+			if !seen[i-1] {
+				seen[i-1] = true
+				out = append(out, i-1)
+			}
+		}
+	}
+	return out, nil
+}
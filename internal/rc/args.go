@@ -0,0 +1,78 @@
+package rc
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// argsMarker tags generated functions so they are listed and removed
+// like aliases; the original template follows it.
+const argsMarker = "# shctl:args "
+
+var (
+	funcNameRe    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_-]*$`)
+	placeholderRe = regexp.MustCompile(`\{\{\s*(\d+)\s*(?::-([^}]*))?\}\}`)
+)
+
+func isArgsFunction(line string) bool {
+	return strings.Contains(line, "() {") && strings.Contains(line, argsMarker)
+}
+
+// argsFunction renders template as a one-line function definition so the
+// existing line-oriented edits keep working on it.
+func argsFunction(name, template string) (string, error) {
+	if !funcNameRe.MatchString(name) {
+		return "", fmt.Errorf("invalid function name %q", name)
+	}
+	if strings.Contains(template, "\n") {
+		return "", fmt.Errorf("template must be a single line")
+	}
+	required := 0
+	optional := map[int]bool{}
+	var perr error
+	body := placeholderRe.ReplaceAllStringFunc(template, func(m string) string {
+		sub := placeholderRe.FindStringSubmatch(m)
+		n, _ := strconv.Atoi(sub[1])
+		if n == 0 {
+			perr = fmt.Errorf("placeholder %s: arguments start at 1", m)
+			return m
+		}
+		if !strings.Contains(m, ":-") {
+			if n > required {
+				required = n
+			}
+			return fmt.Sprintf(`"${%d}"`, n)
+		}
+		if strings.ContainsAny(sub[2], "\"`$\\") {
+			perr = fmt.Errorf("placeholder %s: default may not contain quotes, $ or backslashes", m)
+			return m
+		}
+		optional[n] = true
+		return fmt.Sprintf(`"${%d:-%s}"`, n, sub[2])
+	})
+	if perr != nil {
+		return "", perr
+	}
+	if strings.Contains(body, "{{") || strings.Contains(body, "}}") {
+		return "", fmt.Errorf("malformed placeholder in %q", template)
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s() { ", name)
+	if required > 0 {
+		usage := []string{}
+		for i := 1; i <= required; i++ {
+			if optional[i] {
+				usage = append(usage, fmt.Sprintf("[arg%d]", i))
+			} else {
+				usage = append(usage, fmt.Sprintf("arg%d", i))
+			}
+		}
+		fmt.Fprintf(&sb, `if [ $# -lt %d ]; then echo "usage: %s %s" >&2; return 2; fi; `,
+			required, name, strings.Join(usage, " "))
+	}
+	fmt.Fprintf(&sb, "%s; } %s%s", body, argsMarker, template)
+	return sb.String(), nil
+}
@@ -0,0 +1,110 @@
+package rc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/fsys"
+)
+
+// blockMarkers returns the comment lines around the rc file block name.
+func blockMarkers(name string) (string, string) {
+	return "# BEGIN shctl " + name, "# END shctl " + name
+}
+
+// findBlock returns the line indexes of block name's markers, -1 when
+// the block is not there.
+func findBlock(lines []string, name string) (int, int, error) {
+	begin, end := blockMarkers(name)
+	b, e := -1, -1
+	for i, l := range lines {
+		switch strings.TrimSpace(l) {
+		case begin:
+			if b >= 0 {
+				return 0, 0, fmt.Errorf("%s: more than one shctl %s block", RCPath(), name)
+			}
+			b = i
+		case end:
+			if b < 0 || e >= 0 {
+				return 0, 0, fmt.Errorf("%s:%d: stray end of the shctl %s block", RCPath(), i+1, name)
+			}
+			e = i
+		}
+	}
+	if b >= 0 && e < 0 {
+		return 0, 0, fmt.Errorf("%s:%d: the shctl %s block is not closed", RCPath(), b+1, name)
+	}
+	return b, e, nil
+}
+
+// Block returns the lines of the rc file block name, without its
+// markers, and whether the block is there.
+func Block(name string) ([]string, bool, error) {
+	data, err := fsys.Current.ReadFile(RCPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	b, e, err := findBlock(lines, name)
+	if err != nil || b < 0 {
+		return nil, false, err
+	}
+	return lines[b+1 : e], true, nil
+}
+
+// SetBlock replaces the rc file block name with lines in one write, so a
+// shell starting meanwhile sees either the old block or the new one. A
+// missing block is appended; nil lines remove the block.
+func SetBlock(ctx context.Context, op, name string, lines []string) error {
+	path, unlock, err := prepare(ctx, op, nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	eol := "\n"
+	if strings.Contains(string(data), "\r\n") {
+		eol = "\r\n"
+	}
+	text := strings.TrimSuffix(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n")
+	var cur []string
+	if text != "" {
+		cur = strings.Split(text, "\n")
+	}
+	b, e, err := findBlock(cur, name)
+	if err != nil {
+		return err
+	}
+	var block []string
+	if lines != nil {
+		begin, end := blockMarkers(name)
+		block = append(append([]string{begin}, lines...), end)
+	}
+	var next []string
+	switch {
+	case b >= 0:
+		next = append(append(append(next, cur[:b]...), block...), cur[e+1:]...)
+	case block == nil:
+		return nil
+	default:
+		next = cur
+		if len(next) > 0 && strings.TrimSpace(next[len(next)-1]) != "" {
+			next = append(next, "")
+		}
+		next = append(next, block...)
+	}
+	out := ""
+	if len(next) > 0 {
+		out = strings.Join(next, eol) + eol
+	}
+	return fsys.Current.WriteFile(path, []byte(out), 0o644)
+}
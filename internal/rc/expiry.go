@@ -0,0 +1,58 @@
+package rc
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// expiryMarker is appended as a trailing comment to entries added with a
+// TTL; GC removes them once the time has passed.
+const expiryMarker = "# shctl:expires="
+
+func withExpiry(line string, ttl time.Duration) string {
+	return line + " " + expiryMarker + time.Now().Add(ttl).UTC().Format(time.RFC3339)
+}
+
+// lineExpiry returns the expiry recorded on line, if any.
+func lineExpiry(line string) (time.Time, bool) {
+	i := strings.LastIndex(line, expiryMarker)
+	if i < 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(line[i+len(expiryMarker):]))
+	return t, err == nil
+}
+
+func AddAliasExpiring(ctx context.Context, name, command string, ttl time.Duration) error {
+	path, unlock, err := prepareAlias(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return util.AppendLines(path, withExpiry(aliasLine(name, command), ttl))
+}
+
+func AddExportExpiring(ctx context.Context, varName, value string, ttl time.Duration) error {
+	path, unlock, err := prepare(ctx, "export add", nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return util.AppendLines(path, withExpiry(exportLine(varName, value), ttl))
+}
+
+// GC removes entries whose expiry is before now and returns them.
+func GC(ctx context.Context, now time.Time) ([]string, error) {
+	path, unlock, err := prepare(ctx, "gc", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return util.RemoveLinesFunc(path, func(line string) bool {
+		t, ok := lineExpiry(line)
+		return ok && !now.Before(t)
+	})
+}
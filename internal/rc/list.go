@@ -0,0 +1,251 @@
+package rc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Alias is one alias or generated function in the rc file.
+type Alias struct {
+	Name    string `json:"name"`
+	Command string `json:"command"`
+	// Function marks a function from AddAliasWithArgs; Command is then
+	// its template.
+	Function bool       `json:"function,omitempty"`
+	Line     int        `json:"line"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// Export is one exported variable in the rc file.
+type Export struct {
+	Name    string     `json:"name"`
+	Value   string     `json:"value"`
+	Line    int        `json:"line"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+// Aliases returns the aliases and generated functions in file order.
+func Aliases() ([]Alias, error) {
+	out := []Alias{}
+	err := scanRC(func(n int, line string, words []string) {
+		if isArgsFunction(line) {
+			_, template, _ := strings.Cut(line, argsMarker)
+			out = append(out, Alias{Name: strings.TrimSuffix(words[0], "()"), Command: template, Function: true, Line: n, Expires: expiry(line)})
+			return
+		}
+		if !util.HasWords(words, "alias") {
+			return
+		}
+		for _, w := range words[1:] {
+			if name, cmd, ok := strings.Cut(w, "="); ok {
+				out = append(out, Alias{Name: name, Command: cmd, Line: n, Expires: expiry(line)})
+			}
+		}
+	})
+	return out, err
+}
+
+// Exports returns the exported variables in file order.
+func Exports() ([]Export, error) {
+	out := []Export{}
+	err := scanRC(func(n int, line string, words []string) {
+		if !util.HasWords(words, "export") {
+			return
+		}
+		for _, w := range words[1:] {
+			if name, val, ok := strings.Cut(w, "="); ok {
+				out = append(out, Export{Name: name, Value: val, Line: n, Expires: expiry(line)})
+			}
+		}
+	})
+	return out, err
+}
+
+// ListAliasesFormat prints aliases as the rc file has them, or as JSON or
+// YAML records, narrowed and ordered by output.List.
+func ListAliasesFormat(w io.Writer, format string) error {
+	if !output.Structured(format) && output.List == (output.Query{}) {
+		return ListAliases(w)
+	}
+	aliases, err := Aliases()
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(aliases), func(i int) output.Key {
+		return output.Key{Name: aliases[i].Name, File: RCPath()}
+	})
+	if err != nil {
+		return err
+	}
+	out, lines := make([]Alias, len(idx)), make([]int, len(idx))
+	for i, j := range idx {
+		out[i], lines[i] = aliases[j], aliases[j].Line
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, out)
+	}
+	return printLines(w, lines)
+}
+
+// ListExportsFormat prints exports as the rc file has them, or as JSON or
+// YAML records, narrowed and ordered by output.List.
+func ListExportsFormat(w io.Writer, format string) error {
+	if !output.Structured(format) && output.List == (output.Query{}) {
+		return ListExports(w)
+	}
+	exports, err := Exports()
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(exports), func(i int) output.Key {
+		return output.Key{Name: exports[i].Name, File: RCPath()}
+	})
+	if err != nil {
+		return err
+	}
+	out, lines := make([]Export, len(idx)), make([]int, len(idx))
+	for i, j := range idx {
+		out[i], lines[i] = exports[j], exports[j].Line
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, out)
+	}
+	return printLines(w, lines)
+}
+
+// printLines prints the given lines of the rc file, in that order.
+func printLines(w io.Writer, lines []int) error {
+	text := map[int]string{}
+	if err := util.ScanLines(fsys.Current, RCPath(), func(n int, line string) { text[n] = line }); err != nil {
+		return err
+	}
+	for _, n := range lines {
+		if _, err := fmt.Fprintln(w, paintEntry(w, text[n])); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanRC calls visit with every non-empty line of the rc file and its
+// words. Lines that do not lex are skipped.
+func scanRC(visit func(n int, line string, words []string)) error {
+	if err := ensureFile(); err != nil {
+		return err
+	}
+	return scanFile(RCPath(), visit)
+}
+
+// scanFile is scanRC for any file.
+func scanFile(path string, visit func(n int, line string, words []string)) error {
+	return util.ScanLines(fsys.Current, path, func(n int, line string) {
+		words, err := util.Shell.Words(line)
+		if err == nil && len(words) > 0 {
+			visit(n, line, words)
+		}
+	})
+}
+
+func expiry(line string) *time.Time {
+	if t, ok := lineExpiry(line); ok {
+		return &t
+	}
+	return nil
+}
+
+// Counts is how many entries of each kind a file defines.
+type Counts struct {
+	Aliases   int `json:"aliases"`
+	Exports   int `json:"exports"`
+	Functions int `json:"functions"`
+}
+
+// Count counts the aliases, exports and generated functions of the rc
+// file path, which may be any of the managed rc files. A missing file
+// has none.
+func Count(path string) (Counts, error) {
+	var c Counts
+	err := scanFile(path, func(_ int, line string, words []string) {
+		switch {
+		case isArgsFunction(line):
+			c.Functions++
+		case util.HasWords(words, "alias"), util.HasWords(words, "export"):
+			for _, w := range words[1:] {
+				if !strings.Contains(w, "=") {
+					continue
+				}
+				if words[0] == "alias" {
+					c.Aliases++
+				} else {
+					c.Exports++
+				}
+			}
+		}
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return Counts{}, nil
+	}
+	return c, err
+}
+
+// Problem is an entry of the rc file that shctl cannot manage reliably.
+type Problem struct {
+	Line   int    `json:"line"`
+	Text   string `json:"text"`
+	Reason string `json:"reason"`
+}
+
+// Problems checks the entries shctl manages in the rc file path: alias
+// and export lines must parse, generated functions must be whole, and no
+// alias may be defined twice; exports often are, to extend PATH. A
+// missing file has no problems.
+func Problems(path string) ([]Problem, error) {
+	var out []Problem
+	first := map[string]int{}
+	define := func(n int, line, kind, name string) {
+		key := kind + " " + name
+		if prev, ok := first[key]; ok {
+			out = append(out, Problem{n, line, fmt.Sprintf("%s is already defined on line %d", key, prev)})
+			return
+		}
+		first[key] = n
+	}
+	err := util.ScanLines(fsys.Current, path, func(n int, line string) {
+		if code, _, ok := strings.Cut(line, argsMarker); ok {
+			words, _ := util.Shell.Words(code)
+			if !isArgsFunction(line) || !strings.HasSuffix(strings.TrimSpace(code), "}") || len(words) == 0 {
+				out = append(out, Problem{n, line, "generated function is incomplete"})
+				return
+			}
+			define(n, line, "alias", strings.TrimSuffix(words[0], "()"))
+			return
+		}
+		words, err := util.Shell.Words(line)
+		if err != nil {
+			if fields := strings.Fields(line); len(fields) > 0 && (fields[0] == "alias" || fields[0] == "export") {
+				out = append(out, Problem{n, line, fmt.Sprintf("does not parse: %v", err)})
+			}
+			return
+		}
+		if !util.HasWords(words, "alias") {
+			return
+		}
+		for _, w := range words[1:] {
+			if name, _, ok := strings.Cut(w, "="); ok {
+				define(n, line, "alias", name)
+			}
+		}
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	return out, err
+}
@@ -0,0 +1,69 @@
+package rc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// PickRemoveAliases lets the user choose aliases to remove from a
+// fuzzy-searchable list, for `alias remove` without a name, and removes
+// them. It returns the names removed.
+func PickRemoveAliases(ctx context.Context) ([]string, error) {
+	aliases, err := Aliases()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, len(aliases))
+	for i, a := range aliases {
+		items[i] = fmt.Sprintf("%s=%s", a.Name, a.Command)
+	}
+	names, err := pick("Remove which aliases?", items, func(i int) string { return aliases[i].Name })
+	if err != nil {
+		return nil, err
+	}
+	return names, RemoveAliases(ctx, names)
+}
+
+// PickRemoveExports is PickRemoveAliases for exported variables.
+func PickRemoveExports(ctx context.Context) ([]string, error) {
+	exports, err := Exports()
+	if err != nil {
+		return nil, err
+	}
+	items := make([]string, len(exports))
+	for i, e := range exports {
+		items[i] = fmt.Sprintf("%s=%s", e.Name, e.Value)
+	}
+	names, err := pick("Remove which exports?", items, func(i int) string { return exports[i].Name })
+	if err != nil {
+		return nil, err
+	}
+	return names, RemoveExports(ctx, names)
+}
+
+// pick asks for items and returns the names of those chosen, each once.
+// Choosing nothing aborts.
+func pick(question string, items []string, name func(int) string) ([]string, error) {
+	if len(items) == 0 {
+		return nil, util.NotFound("nothing to remove in " + RCPath())
+	}
+	sel, err := prompt.Pick(question, items)
+	if err != nil {
+		return nil, err
+	}
+	if len(sel) == 0 {
+		return nil, prompt.ErrAborted
+	}
+	seen := map[string]bool{}
+	var names []string
+	for _, i := range sel {
+		if n := name(i); !seen[n] {
+			seen[n] = true
+			names = append(names, n)
+		}
+	}
+	return names, nil
+}
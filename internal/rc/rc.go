@@ -2,46 +2,47 @@ package rc
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 	"strings"
-	"time"
 
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/trash"
 	"github.com/yourusername/shctl/internal/util"
 )
 
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name:  "rc",
+		Files: func() ([]string, error) { return config.RCFiles(), nil },
+		Remap: remapRC,
+	})
+	trash.Register("rc", restoreLines)
+}
+
+// Errors callers can match with errors.Is; see util for their meaning.
 var (
-	DefaultBackupDir = "/tmp"
+	ErrAliasNotFound = util.ErrAliasNotFound
+	ErrEntryExists   = util.ErrEntryExists
+	ErrPermission    = util.ErrPermission
 )
 
-func getenv(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	return def
-}
-
 func RCPath() string {
-	if v := getenv("BASM_RC_FILE", ""); v != "" {
-		return v
-	}
-	home, _ := os.UserHomeDir()
-	shell := getenv("SHELL", "/bin/bash")
-	def := ".bashrc"
-	if strings.HasSuffix(shell, "zsh") {
-		def = ".zshrc"
-	}
-	return filepath.Join(home, def)
+	return config.Get("rc_file")
 }
 
 func BackupDir() string {
-	if v := getenv("BASM_BACKUP_DIR", ""); v != "" {
-		return v
-	}
-	return DefaultBackupDir
+	return config.Get("backup_dir")
 }
 
 // Ensure rc file exists
@@ -51,115 +52,334 @@ func ensureFile() error {
 	if dir == "" {
 		dir = "."
 	}
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+	if err := fsys.Current.MkdirAll(dir, 0o755); err != nil {
 		return err
 	}
-	if _, err := os.Stat(p); errors.Is(err, os.ErrNotExist) {
-		f, err := os.OpenFile(p, os.O_CREATE, 0o644)
-		if err != nil {
-			return err
-		}
-		return f.Close()
+	if _, err := fsys.Current.Stat(p); errors.Is(err, os.ErrNotExist) {
+		return fsys.Current.AppendFile(p, nil, 0o644)
 	}
 	return nil
 }
 
-func AddAlias(name, command string) error {
-	if err := ensureFile(); err != nil {
+// prepare locks the rc file against other shctl runs, creates it if
+// needed and takes the automatic backup before operation op changes it.
+// It returns the path to write, which depends on the symlink policy when
+// the rc file is a symlink, and the function releasing the lock. check,
+// when set, may refuse the change before anything is backed up.
+func prepare(ctx context.Context, op string, check func(path string) error) (string, func(), error) {
+	path, err := writePath()
+	if err != nil {
+		return "", nil, err
+	}
+	unlock, err := util.LockTarget(ctx, RCPath())
+	if err != nil {
+		return "", nil, err
+	}
+	err = ensureFile()
+	if err == nil && check != nil {
+		err = check(path)
+	}
+	if err == nil {
+		err = backup.AutoSave(ctx, RCPath(), "rc "+op)
+	}
+	if err != nil {
+		unlock()
+		return "", nil, err
+	}
+	logging.Info("rc file", "op", op, "path", RCPath(), "write", path)
+	return path, unlock, nil
+}
+
+// AddAlias defines alias name. A name already defined, as an alias or a
+// generated function, is reported as ErrEntryExists.
+func AddAlias(ctx context.Context, name, command string) error {
+	path, unlock, err := prepareAlias(ctx, name)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return util.AppendLines(path, aliasLine(name, command))
+}
+
+func aliasLine(name, command string) string {
+	return fmt.Sprintf("alias %s='%s'", name, command)
+}
+
+// AddAliasWithArgs defines name as a shell function generated from
+// template, where {{N}} expands to positional argument N and {{N:-def}}
+// gives it a default. Arguments without a default are required.
+func AddAliasWithArgs(ctx context.Context, name, template string) error {
+	line, err := argsFunction(name, template)
+	if err != nil {
+		return err
+	}
+	path, unlock, err := prepareAlias(ctx, name)
+	if err != nil {
 		return err
 	}
-	line := fmt.Sprintf("alias %s='%s'\n", name, command)
-	return util.AppendFileAtomic(RCPath(), []byte(line))
+	defer unlock()
+	return util.AppendLines(path, line)
+}
+
+// prepareAlias is prepare for adding alias name; it fails with
+// ErrEntryExists when name is already defined.
+func prepareAlias(ctx context.Context, name string) (string, func(), error) {
+	return prepare(ctx, "alias add", func(path string) error {
+		found := false
+		err := util.ScanLines(fsys.Current, path, func(_ int, l string) {
+			found = found || isAliasOf(l, name)
+		})
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("%s: %w in %s", name, ErrEntryExists, RCPath())
+		}
+		return nil
+	})
+}
+
+// isAliasOf reports whether line defines name as an alias or a generated
+// function.
+func isAliasOf(line, name string) bool {
+	words, _ := util.Shell.Words(line)
+	return defines(words, "alias", name) || (len(words) > 0 && words[0] == name+"()") ||
+		util.HasWords(words, name, "()")
+}
+
+// defines reports whether words assign name with keyword, as in
+// "alias ll=..." or "export PATH=...".
+func defines(words []string, keyword, name string) bool {
+	return len(words) > 1 && words[0] == keyword && strings.HasPrefix(words[1], name+"=")
+}
+
+// startsWith reports whether line's first word is keyword.
+func startsWith(line, keyword string) bool {
+	words, _ := util.Shell.Words(line)
+	return util.HasWords(words, keyword)
 }
 
 func ListAliases(w io.Writer) error {
 	if err := ensureFile(); err != nil {
 		return err
 	}
-	f, err := os.Open(RCPath())
+	f, err := fsys.Current.Open(RCPath())
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	return scanPrintPrefix(f, "alias ", w)
+	return scanPrint(f, w, func(s string) bool {
+		return startsWith(s, "alias") || isArgsFunction(s)
+	})
 }
 
-func RemoveAlias(name string) error {
-	if err := ensureFile(); err != nil {
+// RemoveAlias deletes alias or generated function name, keeping it in the
+// trash; one that is not defined is reported as ErrAliasNotFound.
+func RemoveAlias(ctx context.Context, name string) error {
+	return RemoveAliases(ctx, []string{name})
+}
+
+// RemoveAliases deletes the aliases or generated functions names in one
+// change, keeping them in the trash. Nothing is removed when one of them
+// is not defined.
+func RemoveAliases(ctx context.Context, names []string) error {
+	gone := func(l string) bool {
+		for _, name := range names {
+			if isAliasOf(l, name) {
+				return true
+			}
+		}
+		return false
+	}
+	path, unlock, err := prepare(ctx, "alias remove", func(path string) error {
+		found := map[string]bool{}
+		err := util.ScanLines(fsys.Current, path, func(_ int, l string) {
+			for _, name := range names {
+				found[name] = found[name] || isAliasOf(l, name)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			if !found[name] {
+				return fmt.Errorf("%s: %w in %s", name, ErrAliasNotFound, RCPath())
+			}
+		}
+		return nil
+	})
+	if err != nil {
 		return err
 	}
-	prefix := "alias " + name + "="
-	return util.RemoveLinesWithPrefix(RCPath(), prefix)
+	defer unlock()
+	removed, err := util.RemoveLinesFunc(path, gone)
+	if err != nil {
+		return err
+	}
+	discard("alias remove", removed)
+	return nil
 }
 
-func AddExport(varName, value string) error {
-	if err := ensureFile(); err != nil {
+func AddExport(ctx context.Context, varName, value string) error {
+	path, unlock, err := prepare(ctx, "export add", nil)
+	if err != nil {
 		return err
 	}
+	defer unlock()
+	return util.AppendLines(path, exportLine(varName, value))
+}
+
+func exportLine(varName, value string) string {
 	if strings.Contains(value, " ") {
 		value = fmt.Sprintf("\"%s\"", value)
 	}
-	line := fmt.Sprintf("export %s=%s\n", varName, value)
-	return util.AppendFileAtomic(RCPath(), []byte(line))
+	return fmt.Sprintf("export %s=%s", varName, value)
 }
 
 func ListExports(w io.Writer) error {
 	if err := ensureFile(); err != nil {
 		return err
 	}
-	f, err := os.Open(RCPath())
+	f, err := fsys.Current.Open(RCPath())
 	if err != nil {
 		return err
 	}
 	defer f.Close()
-	return scanPrintPrefix(f, "export ", w)
+	return scanPrint(f, w, func(s string) bool { return startsWith(s, "export") })
 }
 
-func RemoveExport(varName string) error {
-	if err := ensureFile(); err != nil {
+// RemoveExport deletes the exports of varName, keeping them in the trash.
+func RemoveExport(ctx context.Context, varName string) error {
+	return RemoveExports(ctx, []string{varName})
+}
+
+// RemoveExports deletes the exports of every variable in varNames in one
+// change, keeping them in the trash.
+func RemoveExports(ctx context.Context, varNames []string) error {
+	path, unlock, err := prepare(ctx, "export remove", nil)
+	if err != nil {
 		return err
 	}
-	prefix := "export " + varName + "="
-	return util.RemoveLinesWithPrefix(RCPath(), prefix)
+	defer unlock()
+	removed, err := util.RemoveLinesFunc(path, func(l string) bool {
+		words, _ := util.Shell.Words(l)
+		for _, name := range varNames {
+			if defines(words, "export", name) {
+				return true
+			}
+		}
+		return false
+	})
+	if err != nil {
+		return err
+	}
+	discard("export remove", removed)
+	return nil
+}
+
+// discard keeps lines removed by op in the trash. Failing to do so does
+// not undo the removal, so it is only reported.
+func discard(op string, lines []string) {
+	if err := trash.Put("rc", RCPath(), "rc "+op, lines); err != nil {
+		output.Warn(prompt.Out, "trash: %v", err)
+	}
+}
+
+// restoreLines appends lines from the trash back to the rc file.
+func restoreLines(ctx context.Context, rcPath string, lines []string) error {
+	if rcPath != RCPath() {
+		return fmt.Errorf("%s is no longer the rc file in use (%s)", rcPath, RCPath())
+	}
+	path, unlock, err := prepare(ctx, "trash restore", nil)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return util.AppendLines(path, lines...)
 }
 
-func Backup(includeRC bool) error {
-	dir := BackupDir()
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+func Backup(ctx context.Context, includeRC bool) error {
+	if err := os.MkdirAll(BackupDir(), 0o700); err != nil {
 		return err
 	}
 	if includeRC {
-		src := RCPath()
-		dst := filepath.Join(dir, filepath.Base(src)+".bak."+time.Now().Format("20060102_150405"))
-		if err := util.CopyFile(src, dst); err != nil {
+		if _, err := backup.Save(ctx, RCPath()); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
-func Restore() error {
-	// Find latest rc backup in backup dir
-	dir := BackupDir()
-	pattern := filepath.Join(dir, filepath.Base(RCPath())+".bak.*")
-	matches, _ := filepath.Glob(pattern)
-	if len(matches) == 0 {
-		return fmt.Errorf("no rc backup found in %s", dir)
+// PreviewRestore prints what Restore would do without changing anything.
+func PreviewRestore(ctx context.Context, w io.Writer) error {
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return err
+	}
+	latest, err := backup.Latest(ctx, store, RCPath())
+	if err != nil {
+		return err
+	}
+	return backup.Preview(ctx, w, store, latest, RCPath())
+}
+
+func Restore(ctx context.Context) error {
+	unlock, err := util.LockTarget(ctx, RCPath())
+	if err != nil {
+		return err
 	}
-	latest := util.LatestFile(matches)
-	return util.CopyFile(latest, RCPath())
+	defer unlock()
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return err
+	}
+	latest, err := backup.Latest(ctx, store, RCPath())
+	if err != nil {
+		return err
+	}
+	path, err := writePath()
+	if err != nil {
+		return err
+	}
+	if err := backup.Announce(prompt.Out, latest, RCPath()); err != nil {
+		return err
+	}
+	ok, err := prompt.Confirm("Overwrite " + RCPath() + " with this backup?")
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return prompt.ErrAborted
+	}
+	if err := backup.Extract(ctx, store, latest, path); err != nil {
+		return fmt.Errorf("restore %s: %w", path, err)
+	}
+	return nil
 }
 
-// scanning helper
-func scanPrintPrefix(r io.Reader, prefix string, w io.Writer) error {
+func scanPrint(r io.Reader, w io.Writer, match func(string) bool) error {
 	sc := bufio.NewScanner(r)
 	for sc.Scan() {
 		line := sc.Text()
-		if strings.HasPrefix(strings.TrimSpace(line), prefix) {
-			if _, err := fmt.Fprintln(w, line); err != nil {
+		if match(strings.TrimSpace(line)) {
+			if _, err := fmt.Fprintln(w, paintEntry(w, line)); err != nil {
 				return err
 			}
 		}
 	}
 	return sc.Err()
 }
+
+// paintEntry colors the name and value of an alias or export line.
+func paintEntry(w io.Writer, line string) string {
+	t := strings.TrimSpace(line)
+	if !output.Colorize(w) || !startsWith(t, "alias") && !startsWith(t, "export") {
+		return line
+	}
+	eq := strings.IndexByte(line, '=')
+	if eq < 0 {
+		return line
+	}
+	start := strings.LastIndexAny(line[:eq], " \t") + 1
+	return line[:start] + output.Name(w, line[start:eq]) + "=" + output.Value(w, line[eq+1:])
+}
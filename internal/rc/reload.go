@@ -0,0 +1,33 @@
+package rc
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// ReloadCommand is the command that makes the running shell pick up
+// changes to the rc files: it sources each of them.
+func ReloadCommand() string {
+	files := config.RCFiles()
+	cmds := make([]string, len(files))
+	for i, f := range files {
+		cmds[i] = ". " + util.ShellQuote(f)
+	}
+	return strings.Join(cmds, " && ")
+}
+
+// WriteReload prints ReloadCommand, so `eval "$(shctl apply)"` reloads
+// the shell. With wrapper it prints instead a shell function to put in
+// the rc file, which runs prog and reloads after every successful run.
+func WriteReload(w io.Writer, prog string, wrapper bool) error {
+	if !wrapper {
+		_, err := fmt.Fprintln(w, ReloadCommand())
+		return err
+	}
+	_, err := fmt.Fprintf(w, "%[1]s() {\n\tcommand %[1]s \"$@\" && %[2]s\n}\n", prog, ReloadCommand())
+	return err
+}
@@ -0,0 +1,55 @@
+package rc
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/secret"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+var varNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// AddSecretExport exports varName with the secret from, a
+// backend:reference the secret package reads. The rc file gets no
+// plaintext: its export runs `prog secret get` each time the shell
+// starts. The secret is read once now, so a wrong reference fails here
+// rather than in every new shell. A variable already exported is
+// reported as ErrEntryExists.
+func AddSecretExport(ctx context.Context, varName, from, prog string) error {
+	if !varNameRe.MatchString(varName) {
+		return fmt.Errorf("invalid variable name %q: %w", varName, util.ErrUsage)
+	}
+	spec, err := secret.Parse(from)
+	if err != nil {
+		return err
+	}
+	if _, err := secret.Resolve(ctx, spec); err != nil {
+		return err
+	}
+	path, unlock, err := prepare(ctx, "export add-secret", func(path string) error {
+		found := false
+		err := util.ScanLines(fsys.Current, path, func(_ int, l string) {
+			words, _ := util.Shell.Words(l)
+			found = found || defines(words, "export", varName)
+		})
+		if err != nil {
+			return err
+		}
+		if found {
+			return fmt.Errorf("%s: %w in %s", varName, ErrEntryExists, RCPath())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return util.AppendLines(path, secretExportLine(varName, spec, prog))
+}
+
+func secretExportLine(varName string, spec secret.Spec, prog string) string {
+	return fmt.Sprintf(`export %s="$(command %s secret get %s)"`, varName, util.ShellQuote(prog), util.ShellQuote(spec.String()))
+}
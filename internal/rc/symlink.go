@@ -0,0 +1,92 @@
+package rc
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+)
+
+// Symlink policies for an rc file that is a symlink, e.g. into a
+// dotfiles repository.
+const (
+	SymlinkFollow  = "follow"  // edit the file the link points to
+	SymlinkRefuse  = "refuse"  // fail with SymlinkError
+	SymlinkReplace = "replace" // turn the link into a plain file
+)
+
+// FollowSymlinks is set by --follow-symlinks and overrides symlink_policy.
+var FollowSymlinks = false
+
+// SymlinkError is returned for a symlinked rc file under the refuse
+// policy.
+type SymlinkError struct {
+	Path   string
+	Target string
+}
+
+func (e *SymlinkError) Error() string {
+	return fmt.Sprintf("%s is a symlink to %s; refusing to replace it (use --follow-symlinks to edit %s, or set symlink_policy to follow or replace)",
+		e.Path, e.Target, e.Target)
+}
+
+// SymlinkPolicy is the effective policy for symlinked rc files.
+func SymlinkPolicy() (string, error) {
+	if FollowSymlinks {
+		return SymlinkFollow, nil
+	}
+	switch p := config.Get("symlink_policy"); p {
+	case SymlinkFollow, SymlinkRefuse, SymlinkReplace:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown symlink_policy %q (want follow, refuse or replace)", p)
+	}
+}
+
+// writePath is the file rc edits should write: the rc file itself, or
+// what it links to under the follow policy.
+func writePath() (string, error) {
+	p := RCPath()
+	fi, err := fsys.Current.Lstat(p)
+	if errors.Is(err, fs.ErrNotExist) || err == nil && fi.Mode()&fs.ModeSymlink == 0 {
+		return p, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	policy, err := SymlinkPolicy()
+	if err != nil {
+		return "", err
+	}
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return "", fmt.Errorf("%s is a symlink that cannot be resolved: %w", p, err)
+	}
+	switch policy {
+	case SymlinkFollow:
+		return target, nil
+	case SymlinkReplace:
+		return p, unlink(p, target)
+	}
+	return "", &SymlinkError{Path: p, Target: target}
+}
+
+// unlink turns the symlink p into a plain copy of target, so later
+// appends cannot write through it.
+func unlink(p, target string) error {
+	data, err := fsys.Current.ReadFile(target)
+	if err != nil {
+		return err
+	}
+	fi, err := fsys.Current.Stat(target)
+	if err != nil {
+		return err
+	}
+	if err := fsys.Current.Remove(p); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(p, data, fi.Mode().Perm())
+}
@@ -0,0 +1,57 @@
+package rc
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// untranslated marks lines commented out because the target shell does
+// not understand them.
+const untranslated = "# shctl:untranslated "
+
+// shellOnly lists builtins that only one of the supported shells has.
+var shellOnly = map[string][]string{
+	"bash": {"shopt", "complete", "bind", "declare -A"},
+	"zsh":  {"setopt", "unsetopt", "autoload", "compdef", "bindkey", "zstyle", "zmodload"},
+}
+
+// shellOf guesses the shell an rc file belongs to from its name.
+func shellOf(path string) string {
+	base := filepath.Base(path)
+	switch {
+	case strings.Contains(base, "zsh"):
+		return "zsh"
+	case strings.Contains(base, "bash"):
+		return "bash"
+	}
+	return ""
+}
+
+// remapRC puts an rc file from another machine at this machine's rc
+// path, commenting out lines for the old shell that the new one lacks.
+// Files already among this machine's rc files keep their path.
+func remapRC(path string, data []byte) (string, []byte) {
+	dest := RCPath()
+	if slices.Contains(config.RCFiles(), path) {
+		dest = path
+	}
+	from, to := shellOf(path), shellOf(dest)
+	if from == "" || to == "" || from == to {
+		return dest, data
+	}
+	lines := strings.SplitAfter(string(data), "\n")
+	for i, line := range lines {
+		words, _ := util.Shell.Words(line)
+		for _, builtin := range shellOnly[from] {
+			if util.HasWords(words, strings.Fields(builtin)...) {
+				lines[i] = untranslated + line
+				break
+			}
+		}
+	}
+	return dest, []byte(strings.Join(lines, ""))
+}
@@ -0,0 +1,122 @@
+// Package secret reads secrets from password stores, so the rc file can
+// name where a token lives instead of holding it. A secret is written as
+// backend:reference:
+//
+//	pass:work/api                 first line of `pass show work/api`
+//	keychain:service[/account]    macOS Keychain generic password
+//	libsecret:attr=value[,...]    `secret-tool lookup` on the attributes
+//	vault:path#field              `vault kv get -field=field path`
+package secret
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Backends lists the supported backends.
+var Backends = []string{"pass", "keychain", "libsecret", "vault"}
+
+// Spec is a parsed backend:reference.
+type Spec struct {
+	Backend string
+	Ref     string
+}
+
+func (s Spec) String() string {
+	return s.Backend + ":" + s.Ref
+}
+
+// Parse checks a backend:reference string.
+func Parse(s string) (Spec, error) {
+	backend, ref, ok := strings.Cut(s, ":")
+	if !ok || ref == "" {
+		return Spec{}, fmt.Errorf("%q is not backend:reference (backends: %s): %w", s, strings.Join(Backends, ", "), util.ErrUsage)
+	}
+	spec := Spec{backend, ref}
+	if _, err := spec.Command(); err != nil {
+		return Spec{}, err
+	}
+	return spec, nil
+}
+
+// Command returns the argv that prints the secret.
+func (s Spec) Command() ([]string, error) {
+	bad := func(want string) error {
+		return fmt.Errorf("%s: want %s:%s: %w", s, s.Backend, want, util.ErrUsage)
+	}
+	if strings.ContainsAny(s.Ref, "\n\x00") {
+		return nil, bad("reference")
+	}
+	switch s.Backend {
+	case "pass":
+		return []string{"pass", "show", s.Ref}, nil
+	case "keychain":
+		service, account, _ := strings.Cut(s.Ref, "/")
+		if service == "" {
+			return nil, bad("service[/account]")
+		}
+		argv := []string{"security", "find-generic-password", "-s", service, "-w"}
+		if account != "" {
+			argv = append(argv[:4], "-a", account, "-w")
+		}
+		return argv, nil
+	case "libsecret":
+		argv := []string{"secret-tool", "lookup"}
+		for _, kv := range strings.Split(s.Ref, ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || k == "" {
+				return nil, bad("attr=value[,attr=value...]")
+			}
+			argv = append(argv, k, v)
+		}
+		return argv, nil
+	case "vault":
+		path, field, ok := strings.Cut(s.Ref, "#")
+		if !ok || path == "" || field == "" {
+			return nil, bad("path#field")
+		}
+		return []string{"vault", "kv", "get", "-field=" + field, path}, nil
+	}
+	return nil, fmt.Errorf("unknown secret backend %q (want %s): %w", s.Backend, strings.Join(Backends, ", "), util.ErrUsage)
+}
+
+// Resolve runs the backend and returns the secret without its trailing
+// newline; for pass, only the first line, which by convention holds the
+// password.
+func Resolve(ctx context.Context, s Spec) (string, error) {
+	argv, err := s.Command()
+	if err != nil {
+		return "", err
+	}
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	logging.Debug("running", "argv", argv)
+	if err := cmd.Run(); err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", fmt.Errorf("%s: %s is not installed: %w", s, argv[0], err)
+		}
+		return "", fmt.Errorf("%s: %s: %w", s, strings.TrimSpace(stderr.String()), err)
+	}
+	out := stdout.String()
+	if s.Backend == "pass" {
+		out, _, _ = strings.Cut(out, "\n")
+	}
+	return strings.TrimRight(out, "\r\n"), nil
+}
+
+// Get parses and resolves a backend:reference string.
+func Get(ctx context.Context, s string) (string, error) {
+	spec, err := Parse(s)
+	if err != nil {
+		return "", err
+	}
+	return Resolve(ctx, spec)
+}
@@ -0,0 +1,263 @@
+// Package selfupdate replaces the running shctl with the newest release
+// of its channel, for installs that did not come from a package manager.
+// The release feed lists a checksum for every build; the list, with the
+// release's version and channel, must carry a valid signature by the key
+// built into the binary, and only a newer version is installed.
+package selfupdate
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// PublicKey is the base64 ed25519 key releases are signed with,
+// set at build time with
+// -ldflags "-X github.com/yourusername/shctl/internal/selfupdate.PublicKey=...".
+var PublicKey = ""
+
+// MaxSize bounds the download of a release binary.
+var MaxSize int64 = 256 << 20
+
+// Release is a channel's entry in the feed, served as <update_url>/<channel>.json.
+type Release struct {
+	Version string `json:"version"`
+	// Assets maps GOOS/GOARCH to the URL of the binary for it.
+	Assets map[string]string `json:"assets"`
+	// Checksums is sha256sum output covering every asset by file name.
+	Checksums string `json:"checksums"`
+	// Signature is the ed25519 signature of Payload(Version, channel,
+	// Checksums), so a release cannot be replayed under another version
+	// or on another channel.
+	Signature []byte `json:"signature"`
+}
+
+// Payload is what a release's signature covers.
+func Payload(version, channel, checksums string) []byte {
+	return []byte("shctl release\nversion: " + version + "\nchannel: " + channel + "\n\n" + checksums)
+}
+
+// Check fetches the newest release of the configured channel and
+// verifies its signature.
+func Check(ctx context.Context) (Release, error) {
+	channel := config.Get("update_channel")
+	if channel != "stable" && channel != "edge" {
+		return Release{}, fmt.Errorf("unknown update channel %q (want stable or edge): %w", channel, util.ErrUsage)
+	}
+	key, err := base64.StdEncoding.DecodeString(PublicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return Release{}, errors.New("this build has no release signing key; update shctl the way you installed it")
+	}
+	url := strings.TrimSuffix(config.Get("update_url"), "/") + "/" + channel + ".json"
+	body, err := fetch(ctx, url, 1<<20)
+	if err != nil {
+		return Release{}, err
+	}
+	var rel Release
+	if err := json.Unmarshal(body, &rel); err != nil {
+		return Release{}, fmt.Errorf("%s: %w", url, err)
+	}
+	if !ed25519.Verify(key, Payload(rel.Version, channel, rel.Checksums), rel.Signature) {
+		return Release{}, fmt.Errorf("%s: release %s is not signed by the release key", url, rel.Version)
+	}
+	return rel, nil
+}
+
+// Update replaces the binary at exe with the newest release of the
+// configured channel, after confirming. It returns the release and
+// whether exe was replaced. A release no newer than the running version
+// is not installed: the same version is left alone and an older one is
+// refused, so an old signed release cannot be replayed as a downgrade.
+func Update(ctx context.Context, w io.Writer, exe string) (Release, bool, error) {
+	rel, err := Check(ctx)
+	if err != nil {
+		return rel, false, err
+	}
+	switch c, err := compareVersions(rel.Version, config.Version); {
+	case err != nil:
+		return rel, false, err
+	case c == 0:
+		_, err := fmt.Fprintf(w, "shctl %s is the newest %s release\n", rel.Version, config.Get("update_channel"))
+		return rel, false, err
+	case c < 0:
+		return rel, false, fmt.Errorf("the %s release %s is older than shctl %s; refusing to downgrade", config.Get("update_channel"), rel.Version, config.Version)
+	}
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	url, ok := rel.Assets[platform]
+	if !ok {
+		return rel, false, fmt.Errorf("release %s has no build for %s", rel.Version, platform)
+	}
+	want, err := checksum(rel.Checksums, path.Base(url))
+	if err != nil {
+		return rel, false, err
+	}
+	if dryrun.Enabled {
+		_, err := fmt.Fprintf(w, "dry run: would update %s from %s to %s\n", exe, config.Version, rel.Version)
+		return rel, false, err
+	}
+	ok, err = prompt.Confirm(fmt.Sprintf("Update %s from %s to %s?", exe, config.Version, rel.Version))
+	if err != nil {
+		return rel, false, err
+	}
+	if !ok {
+		return rel, false, prompt.ErrAborted
+	}
+	data, err := fetch(ctx, url, MaxSize)
+	if err != nil {
+		return rel, false, err
+	}
+	if sum := sha256.Sum256(data); hex.EncodeToString(sum[:]) != want {
+		return rel, false, fmt.Errorf("%s: checksum mismatch; the download is corrupt or was tampered with", url)
+	}
+	if err := replace(exe, data); err != nil {
+		return rel, false, err
+	}
+	_, err = fmt.Fprintf(w, "updated %s to shctl %s\n", exe, rel.Version)
+	return rel, true, err
+}
+
+// compareVersions compares release versions such as v1.4.0 and 1.5.0-rc1
+// by their numeric parts, a pre-release sorting before its release. A
+// development build, whose version is not a release, is older than any
+// release.
+func compareVersions(a, b string) (int, error) {
+	pa, err := parseVersion(a)
+	if err != nil {
+		return 0, fmt.Errorf("release version %q: %w", a, err)
+	}
+	pb, err := parseVersion(b)
+	if err != nil {
+		return 1, nil
+	}
+	for i := 0; i < 3; i++ {
+		if pa.nums[i] != pb.nums[i] {
+			return cmp.Compare(pa.nums[i], pb.nums[i]), nil
+		}
+	}
+	switch {
+	case pa.pre == pb.pre:
+		return 0, nil
+	case pa.pre == "":
+		return 1, nil
+	case pb.pre == "":
+		return -1, nil
+	}
+	return strings.Compare(pa.pre, pb.pre), nil
+}
+
+type version struct {
+	nums [3]int
+	pre  string
+}
+
+func parseVersion(s string) (version, error) {
+	var v version
+	s, _, _ = strings.Cut(strings.TrimPrefix(s, "v"), "+")
+	s, v.pre, _ = strings.Cut(s, "-")
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return v, errors.New("not a release version")
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, errors.New("not a release version")
+		}
+		v.nums[i] = n
+	}
+	return v, nil
+}
+
+// checksum finds name in sha256sum output.
+func checksum(sums, name string) (string, error) {
+	sc := bufio.NewScanner(strings.NewReader(sums))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("no signed checksum for %s", name)
+}
+
+func fetch(ctx context.Context, url string, max int64) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "shctl/"+config.Version)
+	logging.Debug("fetching", "url", url)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > max {
+		return nil, fmt.Errorf("%s: larger than %d bytes", url, max)
+	}
+	return data, nil
+}
+
+// replace writes data next to exe and renames it into place, so exe is
+// never half written, keeping exe's mode.
+func replace(exe string, data []byte) error {
+	if p, err := filepath.EvalSymlinks(exe); err == nil {
+		exe = p
+	}
+	fi, err := os.Stat(exe)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(exe), "."+filepath.Base(exe)+".update-*")
+	if err != nil {
+		if errors.Is(err, os.ErrPermission) {
+			return fmt.Errorf("cannot write to %s; re-run as its owner: %w", filepath.Dir(exe), util.ErrPermission)
+		}
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(fi.Mode().Perm()); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), exe)
+}
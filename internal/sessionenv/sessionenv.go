@@ -0,0 +1,390 @@
+// Package sessionenv manages the environment of the systemd user session
+// through environment.d(5): the *.conf files of ~/.config/environment.d
+// set variables for every service of the user manager, graphical
+// sessions and apps started from them included, none of which read the
+// shell rc file. shctl writes one file of its own there and reads the
+// others to list and to catch conflicts.
+package sessionenv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// FileName is the file shctl owns in the environment.d directory. Files
+// are read in name order, so later ones such as 90-local.conf still win.
+const FileName = "60-shctl.conf"
+
+// ErrUnmanaged reports a variable set in a file shctl does not own.
+var ErrUnmanaged = errors.New("variable is set in a file shctl does not manage")
+
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name: "sessionenv",
+		Files: func() ([]string, error) {
+			if _, err := fsys.Current.Stat(Path()); err != nil {
+				return nil, nil
+			}
+			return []string{Path()}, nil
+		},
+		Validate: func(_ context.Context, _, staged string) error {
+			data, err := os.ReadFile(staged)
+			if err != nil {
+				return err
+			}
+			_, err = parse(data)
+			return err
+		},
+	})
+	trash.Register("sessionenv", restoreLines)
+}
+
+// Var is one KEY=VALUE assignment of an environment.d file.
+type Var struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	File  string `json:"file"`
+	Line  int    `json:"line"`
+}
+
+// Dir is the environment.d directory.
+func Dir() string {
+	return config.Get("environment_d_dir")
+}
+
+// Path is the file shctl writes.
+func Path() string {
+	return filepath.Join(Dir(), FileName)
+}
+
+// parse reads the assignments of an environment.d file. Values may be
+// quoted as in the shell; $VAR and ${VAR} references are left for
+// systemd to expand.
+func parse(data []byte) ([]Var, error) {
+	var out []Var
+	var errs []error
+	for i, l := range splitLines(data) {
+		t := strings.TrimSpace(l)
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		k, v, ok := strings.Cut(t, "=")
+		if !ok || !nameRe.MatchString(k) {
+			errs = append(errs, fmt.Errorf("line %d: want KEY=VALUE", i+1))
+			continue
+		}
+		v, err := unquote(v)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", i+1, err))
+			continue
+		}
+		out = append(out, Var{Name: k, Value: v, Line: i + 1})
+	}
+	return out, errors.Join(errs...)
+}
+
+// unquote removes the quotes of a value: single quotes keep everything,
+// double quotes and bare values take backslash escapes.
+func unquote(v string) (string, error) {
+	var sb strings.Builder
+	var q byte
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		switch {
+		case q == '\'' && c != '\'':
+			sb.WriteByte(c)
+		case c == '\'' || c == '"':
+			switch q {
+			case 0:
+				q = c
+			case c:
+				q = 0
+			default:
+				sb.WriteByte(c)
+			}
+		case c == '\\' && i+1 < len(v):
+			i++
+			sb.WriteByte(v[i])
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	if q != 0 {
+		return "", errors.New("unterminated quote")
+	}
+	return sb.String(), nil
+}
+
+// quote writes v so parse reads it back, leaving $ references alone.
+func quote(v string) string {
+	if v != "" && !strings.ContainsAny(v, " \t\"'\\#") {
+		return v
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(v) + `"`
+}
+
+// Vars returns the assignments of every *.conf file of the directory, in
+// the order systemd reads them.
+func Vars() ([]Var, error) {
+	entries, err := fsys.Current.ReadDir(Dir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Var{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	names := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), ".conf") {
+			names = append(names, e.Name())
+		}
+	}
+	sort.Strings(names)
+	out := []Var{}
+	var errs []error
+	for _, n := range names {
+		p := filepath.Join(Dir(), n)
+		data, err := fsys.Current.ReadFile(p)
+		if err != nil {
+			return nil, err
+		}
+		vars, err := parse(data)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p, err))
+		}
+		for _, v := range vars {
+			v.File = p
+			out = append(out, v)
+		}
+	}
+	return out, errors.Join(errs...)
+}
+
+// List prints the assignments as KEY=VALUE, the ones of other files
+// marked with their file, or as JSON or YAML records. output.List
+// narrows and orders them by name.
+func List(w io.Writer, format string) error {
+	all, err := Vars()
+	if err != nil {
+		if all == nil {
+			return err
+		}
+		output.Warn(prompt.Out, "%v", err)
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name, File: all[i].File}
+	})
+	if err != nil {
+		return err
+	}
+	vars := make([]Var, len(idx))
+	for i, j := range idx {
+		vars[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, vars)
+	}
+	for _, v := range vars {
+		line := fmt.Sprintf("%s=%s", output.Name(w, v.Name), output.Value(w, v.Value))
+		if v.File != Path() {
+			line += fmt.Sprintf(" (%s:%d)", filepath.Base(v.File), v.Line)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set sets name to value in shctl's file, replacing an earlier value. A
+// variable another file of the directory sets is reported as
+// ErrUnmanaged, since the file read last would win anyway.
+func Set(ctx context.Context, name, value string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid variable name %q: %w", name, util.ErrUsage)
+	}
+	if strings.ContainsAny(value, "\n\x00") {
+		return fmt.Errorf("%s: values are one line: %w", name, util.ErrUsage)
+	}
+	return change(ctx, "session env set", func(lines []string, vars []Var) ([]string, error) {
+		return set(lines, vars, name, value), nil
+	})
+}
+
+// set puts name=value on the line setting name, or at the end.
+func set(lines []string, vars []Var, name, value string) []string {
+	line := name + "=" + quote(value)
+	for _, v := range vars {
+		if v.Name == name {
+			lines[v.Line-1] = line
+			return lines
+		}
+	}
+	return append(lines, line)
+}
+
+// Unset removes names from shctl's file, keeping them in the trash.
+func Unset(ctx context.Context, names []string) error {
+	var removed []string
+	err := change(ctx, "session env unset", func(lines []string, vars []Var) ([]string, error) {
+		drop := map[int]bool{}
+		for _, n := range names {
+			found := false
+			for _, v := range vars {
+				if v.Name == n {
+					drop[v.Line-1], found = true, true
+					removed = append(removed, strings.TrimSpace(lines[v.Line-1]))
+				}
+			}
+			if !found {
+				return nil, util.NotFound(fmt.Sprintf("%s is not set in %s", n, Path()))
+			}
+		}
+		var out []string
+		for i, l := range lines {
+			if !drop[i] {
+				out = append(out, l)
+			}
+		}
+		return out, nil
+	})
+	if err != nil {
+		return err
+	}
+	if terr := trash.Put("sessionenv", Path(), "session env unset", removed); terr != nil {
+		output.Warn(prompt.Out, "trash: %v", terr)
+	}
+	return nil
+}
+
+// restoreLines sets the variables of removed KEY=VALUE lines again.
+func restoreLines(ctx context.Context, path string, removed []string) error {
+	if path != Path() {
+		return fmt.Errorf("%s is no longer the session environment file in use (%s)", path, Path())
+	}
+	return change(ctx, "trash restore", func(lines []string, _ []Var) ([]string, error) {
+		for _, r := range removed {
+			vars, err := parse([]byte(r))
+			if err != nil || len(vars) != 1 {
+				return nil, fmt.Errorf("cannot restore %q", r)
+			}
+			cur, err := parse(joinLines(lines))
+			if err != nil {
+				return nil, err
+			}
+			lines = set(lines, cur, vars[0].Name, vars[0].Value)
+		}
+		return lines, nil
+	})
+}
+
+// change lets fn rewrite the lines of shctl's file, given its
+// assignments, writes the result and asks the user manager to reload.
+// Setting a name another file also sets is refused.
+func change(ctx context.Context, op string, fn func(lines []string, vars []Var) ([]string, error)) error {
+	path := Path()
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	vars, err := parse(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	lines, err := fn(splitLines(data), vars)
+	if err != nil {
+		return err
+	}
+	next := joinLines(lines)
+	if string(next) == string(data) {
+		return nil
+	}
+	mine, err := parse(next)
+	if err != nil {
+		return err
+	}
+	had := map[string]string{}
+	for _, v := range vars {
+		had[v.Name] = v.Value
+	}
+	all, _ := Vars()
+	for _, v := range mine {
+		if old, ok := had[v.Name]; ok && old == v.Value {
+			continue
+		}
+		for _, o := range all {
+			if o.Name == v.Name && o.File != path {
+				return fmt.Errorf("%s at %s:%d: %w", v.Name, o.File, o.Line, ErrUnmanaged)
+			}
+		}
+	}
+	if err := backup.AutoSave(ctx, path, op); err != nil {
+		return err
+	}
+	if err := fsys.Current.MkdirAll(Dir(), 0o755); err != nil {
+		return err
+	}
+	if err := fsys.Current.WriteFile(path, next, 0o644); err != nil {
+		return err
+	}
+	reload(ctx)
+	return nil
+}
+
+// reload reruns the user manager's environment generator, so services
+// started from now on see the change; graphical sessions pick it up at
+// the next login. Hosts without a user manager only get a note.
+func reload(ctx context.Context) {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return
+	}
+	cmd := exec.CommandContext(ctx, "systemctl", "--user", "daemon-reload")
+	if dryrun.Skip(cmd.Args...) {
+		return
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		logging.Info("systemd user manager not reloaded", "err", err, "output", strings.TrimSpace(string(out)))
+	}
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
@@ -0,0 +1,86 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// Change is a managed file that differs from the newest snapshot.
+type Change struct {
+	Path  string `json:"path"`
+	State string `json:"state"` // modified, missing or new
+}
+
+// Managed returns every file of every registered subsystem, once, in
+// subsystem order.
+func Managed() ([]string, error) {
+	seen := map[string]bool{}
+	var out []string
+	for _, name := range Subsystems() {
+		files, err := subsystems[name].Files()
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range files {
+			if !seen[p] {
+				seen[p] = true
+				out = append(out, p)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Drift compares the managed files with the newest snapshot and returns
+// that snapshot and the files changed since. Info is zero when there is
+// no snapshot to compare with.
+func Drift(ctx context.Context) (Info, []Change, error) {
+	list, err := List(ctx)
+	if err != nil || len(list) == 0 {
+		return Info{}, nil, err
+	}
+	info, m, _, err := Load(ctx, 0)
+	if err != nil {
+		return Info{}, nil, err
+	}
+	var out []Change
+	known := map[string]bool{}
+	for _, f := range m.Files {
+		known[f.Path] = true
+		b, err := os.ReadFile(f.Path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			out = append(out, Change{f.Path, "missing"})
+		case err != nil:
+			return info, out, err
+		default:
+			if sum := sha256.Sum256(b); hex.EncodeToString(sum[:]) != f.SHA256 {
+				out = append(out, Change{f.Path, "modified"})
+			}
+		}
+	}
+	files, err := Managed()
+	if err != nil {
+		return info, out, err
+	}
+	for _, p := range files {
+		if _, err := os.Stat(p); err == nil && !known[p] {
+			out = append(out, Change{p, "new"})
+		}
+	}
+	return info, out, nil
+}
+
+// Files returns the files of subsystem name.
+func Files(name string) ([]string, error) {
+	s, ok := subsystems[name]
+	if !ok {
+		return nil, fmt.Errorf("no subsystem %q", name)
+	}
+	return s.Files()
+}
@@ -0,0 +1,83 @@
+package snapshot
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Mapping rewrites paths under From to live under To when restoring a
+// snapshot taken on another machine, e.g. /home/alice=/Users/alice.
+type Mapping struct {
+	From string
+	To   string
+}
+
+// ParseMapping reads a FROM=TO mapping.
+func ParseMapping(s string) (Mapping, error) {
+	from, to, ok := strings.Cut(s, "=")
+	if !ok || !filepath.IsAbs(from) || !filepath.IsAbs(to) {
+		return Mapping{}, fmt.Errorf("invalid mapping %q (want /old/prefix=/new/prefix)", s)
+	}
+	return Mapping{filepath.Clean(from), filepath.Clean(to)}, nil
+}
+
+// remap applies maps, longest prefix first, to the paths and contents of
+// a loaded snapshot, then lets each subsystem adjust its own files (for
+// example to the local rc file and shell).
+func remap(m Manifest, files map[string][]byte, maps []Mapping) (Manifest, map[string][]byte) {
+	maps = append([]Mapping{}, maps...)
+	sort.SliceStable(maps, func(i, j int) bool { return len(maps[i].From) > len(maps[j].From) })
+	out := map[string][]byte{}
+	mf := append([]File{}, m.Files...)
+	for i, f := range mf {
+		path, data := mapPath(f.Path, maps), files[f.Path]
+		for _, mp := range maps {
+			data = mapContent(data, mp)
+		}
+		if s, ok := subsystems[f.Subsystem]; ok && s.Remap != nil {
+			path, data = s.Remap(path, data)
+		}
+		mf[i].Path = path
+		out[path] = data
+	}
+	m.Files = mf
+	return m, out
+}
+
+func mapPath(p string, maps []Mapping) string {
+	for _, mp := range maps {
+		if rest, ok := strings.CutPrefix(p, mp.From); ok && (rest == "" || rest[0] == '/') {
+			return mp.To + rest
+		}
+	}
+	return p
+}
+
+// mapContent replaces whole-prefix occurrences of mp.From in data, so
+// /home/al does not rewrite /home/alice.
+func mapContent(data []byte, mp Mapping) []byte {
+	s := string(data)
+	var sb strings.Builder
+	for {
+		i := strings.Index(s, mp.From)
+		if i < 0 {
+			sb.WriteString(s)
+			break
+		}
+		end := i + len(mp.From)
+		sb.WriteString(s[:i])
+		if end == len(s) || !isPathChar(s[end]) {
+			sb.WriteString(mp.To)
+		} else {
+			sb.WriteString(mp.From)
+		}
+		s = s[end:]
+	}
+	return []byte(sb.String())
+}
+
+func isPathChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-' || c == '.'
+}
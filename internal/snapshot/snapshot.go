@@ -0,0 +1,465 @@
+package snapshot
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/journal"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Subsystem is a group of files shctl manages. Packages register
+// themselves so snapshots pick up every managed file.
+type Subsystem struct {
+	Name  string
+	Files func() ([]string, error)
+	// Validate checks a staged copy of path before it is restored.
+	Validate func(ctx context.Context, path, staged string) error
+	// Apply writes staged over path; the default copies it in place.
+	Apply func(ctx context.Context, staged, path string) error
+	// Remap adjusts a file from another machine to this one after the
+	// user's path mappings have been applied.
+	Remap func(path string, data []byte) (string, []byte)
+}
+
+var subsystems = map[string]Subsystem{}
+
+// Register adds s, replacing any subsystem with the same name.
+func Register(s Subsystem) {
+	subsystems[s.Name] = s
+}
+
+// Writer returns how the subsystem managing path writes it, or nil when
+// a plain copy will do.
+func Writer(path string) func(ctx context.Context, staged, path string) error {
+	s, _ := managing(path)
+	return s.Apply
+}
+
+// Validator returns how the subsystem managing path checks a staged copy
+// of it, or nil when it has no checks.
+func Validator(path string) func(ctx context.Context, path, staged string) error {
+	s, _ := managing(path)
+	return s.Validate
+}
+
+// managing returns the subsystem whose files include path.
+func managing(path string) (Subsystem, bool) {
+	for _, s := range subsystems {
+		files, err := s.Files()
+		if err != nil {
+			continue
+		}
+		for _, f := range files {
+			if f == path {
+				return s, true
+			}
+		}
+	}
+	return Subsystem{}, false
+}
+
+// Subsystems returns the registered subsystem names, sorted.
+func Subsystems() []string {
+	names := make([]string, 0, len(subsystems))
+	for n := range subsystems {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// File is one captured file.
+type File struct {
+	Subsystem string      `json:"subsystem"`
+	Path      string      `json:"path"`
+	Mode      fs.FileMode `json:"mode"`
+	Size      int64       `json:"size"`
+	SHA256    string      `json:"sha256"`
+}
+
+// Manifest is stored as manifest.json at the top of every snapshot.
+type Manifest struct {
+	Created time.Time `json:"created"`
+	Host    string    `json:"host"`
+	Files   []File    `json:"files"`
+}
+
+// Info describes a stored snapshot. IDs number snapshots newest first.
+type Info struct {
+	ID      int       `json:"id"`
+	Name    string    `json:"name"`
+	Created time.Time `json:"created"`
+	Size    int64     `json:"size"`
+}
+
+const prefix = "snapshot."
+
+// Create captures every file of every registered subsystem into one
+// archive in the backup store. When nothing changed since the newest
+// snapshot, that snapshot is returned instead of storing a duplicate.
+func Create(ctx context.Context) (Info, error) {
+	m := Manifest{Created: time.Now()}
+	m.Host, _ = os.Hostname()
+	contents := map[string][]byte{}
+	for _, name := range Subsystems() {
+		files, err := subsystems[name].Files()
+		if err != nil {
+			return Info{}, fmt.Errorf("%s: %w", name, err)
+		}
+		for _, p := range files {
+			if _, dup := contents[p]; dup {
+				continue
+			}
+			fi, err := os.Stat(p)
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return Info{}, err
+			}
+			b, err := os.ReadFile(p)
+			if err != nil {
+				return Info{}, fmt.Errorf("%s: %w", name, err)
+			}
+			sum := sha256.Sum256(b)
+			contents[p] = b
+			m.Files = append(m.Files, File{name, p, fi.Mode().Perm(), int64(len(b)), hex.EncodeToString(sum[:])})
+		}
+	}
+	if len(m.Files) == 0 {
+		return Info{}, fmt.Errorf("no managed files to snapshot")
+	}
+	if prev, pm, _, err := Load(ctx, 0); err == nil && sameFiles(pm.Files, m.Files) {
+		// nothing changed since the newest snapshot
+		return prev, nil
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(zw)
+	mb, _ := json.MarshalIndent(m, "", "  ")
+	add := func(name string, mode int64, data []byte) error {
+		h := &tar.Header{Name: name, Mode: mode, Size: int64(len(data)), ModTime: m.Created}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	if err := add("manifest.json", 0o600, mb); err != nil {
+		return Info{}, err
+	}
+	for _, f := range m.Files {
+		if err := add(entryName(f.Path), int64(f.Mode), contents[f.Path]); err != nil {
+			return Info{}, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return Info{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return Info{}, err
+	}
+
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return Info{}, err
+	}
+	name := prefix + m.Created.Format(backup.TimeFormat) + ".tar.gz"
+	if err := store.Put(ctx, name, buf.Bytes()); err != nil {
+		return Info{}, err
+	}
+	if err := backup.Commit(ctx, store, fmt.Sprintf("snapshot create: %d file(s)", len(m.Files))); err != nil {
+		return Info{}, err
+	}
+	return Info{ID: 1, Name: name, Created: m.Created, Size: int64(buf.Len())}, nil
+}
+
+func sameFiles(a, b []File) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// entryName is the archive name for path.
+func entryName(path string) string {
+	return "files/" + strings.TrimPrefix(filepath.ToSlash(path), "/")
+}
+
+// List returns the stored snapshots, newest first.
+func List(ctx context.Context) ([]Info, error) {
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objs, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := []Info{}
+	for _, o := range objs {
+		stamp, ok := strings.CutPrefix(o.Name, prefix)
+		if !ok {
+			continue
+		}
+		t, err := time.ParseInLocation(backup.TimeFormat, strings.TrimSuffix(stamp, ".tar.gz"), time.Local)
+		if err != nil {
+			t = o.ModTime
+		}
+		out = append(out, Info{Name: o.Name, Created: t, Size: o.Size})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Created.After(out[j].Created) })
+	for i := range out {
+		out[i].ID = i + 1
+	}
+	return out, nil
+}
+
+// Write prints snapshots as text, JSON or YAML, narrowed and ordered by
+// output.List on their names and creation times.
+func Write(w io.Writer, format string, snapshots []Info) error {
+	idx, err := output.List.Apply(len(snapshots), func(i int) output.Key {
+		return output.Key{Name: snapshots[i].Name, Mtime: snapshots[i].Created}
+	})
+	if err != nil {
+		return err
+	}
+	all := snapshots
+	snapshots = make([]Info, len(idx))
+	for i, j := range idx {
+		snapshots[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, snapshots)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tNAME\tCREATED\tSIZE")
+	for _, s := range snapshots {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%d\n", s.ID, s.Name, s.Created.Format("2006-01-02 15:04:05"), s.Size)
+	}
+	return tw.Flush()
+}
+
+// Load reads snapshot id (0 for the newest) and returns its manifest and
+// file contents keyed by path, after checking every checksum.
+func Load(ctx context.Context, id int) (Info, Manifest, map[string][]byte, error) {
+	list, err := List(ctx)
+	if err != nil {
+		return Info{}, Manifest{}, nil, err
+	}
+	if id == 0 {
+		id = 1
+	}
+	if id < 1 || id > len(list) {
+		return Info{}, Manifest{}, nil, fmt.Errorf("no snapshot with id %d (have %d)", id, len(list))
+	}
+	info := list[id-1]
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return info, Manifest{}, nil, err
+	}
+	data, err := store.Get(ctx, info.Name)
+	if err != nil {
+		return info, Manifest{}, nil, err
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return info, Manifest{}, nil, fmt.Errorf("%s: %w", info.Name, err)
+	}
+	tr := tar.NewReader(zr)
+	entries := map[string][]byte{}
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return info, Manifest{}, nil, fmt.Errorf("%s: %w", info.Name, err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return info, Manifest{}, nil, fmt.Errorf("%s: %w", info.Name, err)
+		}
+		entries[h.Name] = b
+	}
+	var m Manifest
+	if err := json.Unmarshal(entries["manifest.json"], &m); err != nil {
+		return info, m, nil, fmt.Errorf("%s: bad manifest: %w", info.Name, err)
+	}
+	files := map[string][]byte{}
+	for _, f := range m.Files {
+		b, ok := entries[entryName(f.Path)]
+		sum := sha256.Sum256(b)
+		if !ok || hex.EncodeToString(sum[:]) != f.SHA256 {
+			return info, m, nil, fmt.Errorf("%s: %s is missing or corrupted", info.Name, f.Path)
+		}
+		files[f.Path] = b
+	}
+	return info, m, files, nil
+}
+
+// PreviewRestore prints the files snapshot id would overwrite, with
+// diffs, without changing anything.
+func PreviewRestore(ctx context.Context, w io.Writer, id int, maps ...Mapping) error {
+	info, m, files, err := Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	m, files = remap(m, files, maps)
+	fmt.Fprintf(w, "snapshot %d (%s, %s) would overwrite %d file(s)\n", info.ID, info.Name, m.Created.Format("2006-01-02 15:04:05"), len(m.Files))
+	for _, f := range m.Files {
+		cur, err := os.ReadFile(f.Path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		d := util.UnifiedDiff(f.Path, info.Name+":"+f.Path, cur, files[f.Path])
+		if d == "" {
+			fmt.Fprintf(w, "%s: unchanged\n", f.Path)
+			continue
+		}
+		output.WriteDiff(w, d)
+	}
+	return nil
+}
+
+// Restore puts every file of snapshot id (0 for the newest) back. Each
+// file is staged and validated by its subsystem first; nothing is written
+// unless all of them pass and the user confirms, and a failed write rolls
+// back the files already restored. Current files are backed
+// up before being overwritten, and other shctl runs wait until the restore
+// is done. maps move files and the paths inside them for snapshots taken
+// on another machine.
+func Restore(ctx context.Context, id int, maps ...Mapping) error {
+	info, m, files, err := Load(ctx, id)
+	if err != nil {
+		return err
+	}
+	m, files = remap(m, files, maps)
+	title := fmt.Sprintf("snapshot %d (%s, %s)", info.ID, info.Name, m.Created.Format("2006-01-02 15:04:05"))
+	if err := Put(ctx, "snapshot restore", title, m.Files, files); err != nil {
+		return fmt.Errorf("restore snapshot %d: %w", info.ID, err)
+	}
+	return nil
+}
+
+// Put writes data over the given files the way Restore does: staged,
+// validated by the subsystem each file names, confirmed, backed up and
+// written in one transaction. title names where the content comes from.
+func Put(ctx context.Context, op, title string, files []File, data map[string][]byte) error {
+	paths := make([]string, 0, len(files))
+	for _, f := range files {
+		paths = append(paths, f.Path)
+	}
+	// sorted so concurrent restores take the locks in the same order
+	sort.Strings(paths)
+	for _, p := range paths {
+		unlock, err := util.LockTarget(ctx, p)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+	}
+	staged := map[string]string{}
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	for _, f := range files {
+		tmp, err := stage(data[f.Path], f.Mode)
+		if err != nil {
+			return err
+		}
+		staged[f.Path] = tmp
+		if s, ok := subsystems[f.Subsystem]; ok && s.Validate != nil {
+			if err := s.Validate(ctx, f.Path, tmp); err != nil {
+				return fmt.Errorf("%s from %s failed validation: %w", f.Path, title, err)
+			}
+		}
+	}
+
+	fmt.Fprintf(prompt.Out, "%s will overwrite:\n", title)
+	for _, f := range files {
+		fmt.Fprintf(prompt.Out, "  %-8s %s\n", f.Subsystem, f.Path)
+	}
+	ok, err := prompt.Confirm(fmt.Sprintf("Restore %d file(s)?", len(files)))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return prompt.ErrAborted
+	}
+	tx := journal.New(op)
+	for _, f := range files {
+		if err := backup.AutoSave(ctx, f.Path, op); err != nil {
+			return err
+		}
+		apply := writeFile
+		if s, ok := subsystems[f.Subsystem]; ok && s.Apply != nil {
+			apply = s.Apply
+		}
+		tx.Stage(f.Path, staged[f.Path], apply)
+	}
+	return tx.Commit(ctx)
+}
+
+func stage(data []byte, mode fs.FileMode) (string, error) {
+	f, err := os.CreateTemp("", "shctl_snapshot_*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// writeFile copies staged over path, keeping the snapshot's mode for
+// new files.
+func writeFile(_ context.Context, staged, path string) error {
+	b, err := os.ReadFile(staged)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(staged)
+	if err != nil {
+		return err
+	}
+	if err := fsys.Current.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(path, b, fi.Mode().Perm())
+}
@@ -0,0 +1,243 @@
+package sshconf
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/fsys"
+)
+
+// Host is one Host block, with the options shctl manages. Name holds the
+// patterns of the Host line.
+type Host struct {
+	Name         string `json:"name"`
+	HostName     string `json:"hostname,omitempty"`
+	User         string `json:"user,omitempty"`
+	Port         string `json:"port,omitempty"`
+	IdentityFile string `json:"identity_file,omitempty"`
+	ProxyJump    string `json:"proxy_jump,omitempty"`
+	Source       string `json:"source"`
+	Line         int    `json:"line"`
+	Managed      bool   `json:"managed"` // inside the block shctl manages
+}
+
+// Options are the Host options shctl sets, in the order it writes them.
+var Options = []string{"HostName", "User", "Port", "IdentityFile", "ProxyJump"}
+
+// field returns the Host field holding option key, matched as ssh does,
+// without regard to case.
+func (h *Host) field(key string) *string {
+	switch strings.ToLower(key) {
+	case "hostname":
+		return &h.HostName
+	case "user":
+		return &h.User
+	case "port":
+		return &h.Port
+	case "identityfile":
+		return &h.IdentityFile
+	case "proxyjump":
+		return &h.ProxyJump
+	}
+	return nil
+}
+
+// maxDepth is how deep ssh follows Include.
+const maxDepth = 16
+
+// directive splits a config line into its keyword and arguments. Blank
+// lines and comments have no keyword.
+func directive(line string) (string, []string, error) {
+	t := strings.TrimSpace(line)
+	if t == "" || strings.HasPrefix(t, "#") {
+		return "", nil, nil
+	}
+	end := strings.IndexAny(t, " \t=")
+	if end < 0 {
+		return t, nil, nil
+	}
+	key, rest := t[:end], strings.TrimLeft(t[end:], " \t")
+	// one "=" may stand between keyword and arguments
+	rest = strings.TrimLeft(strings.TrimPrefix(rest, "="), " \t")
+	args, err := splitArgs(rest)
+	return key, args, err
+}
+
+// splitArgs splits arguments on blanks, honoring double quotes.
+func splitArgs(s string) ([]string, error) {
+	var out []string
+	var sb strings.Builder
+	in, quoted := false, false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			quoted, in = !quoted, true
+		case !quoted && (c == ' ' || c == '\t'):
+			if in {
+				out = append(out, sb.String())
+				sb.Reset()
+				in = false
+			}
+		case !quoted && c == '#' && !in:
+			i = len(s)
+		default:
+			sb.WriteByte(c)
+			in = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote")
+	}
+	if in {
+		out = append(out, sb.String())
+	}
+	return out, nil
+}
+
+// quote renders an argument, quoting it when it has blanks.
+func quote(s string) string {
+	if strings.ContainsAny(s, " \t") {
+		return `"` + s + `"`
+	}
+	return s
+}
+
+// parse reads the Host blocks of path and of the files it includes.
+// Hosts between the markers of the main file are Managed. Missing
+// includes are skipped, as ssh does.
+func parse(path string, data []byte, depth int) ([]Host, error) {
+	var hosts []Host
+	var errs []error
+	var cur *Host
+	managed := false
+	flush := func() {
+		if cur != nil {
+			hosts = append(hosts, *cur)
+			cur = nil
+		}
+	}
+	for i, line := range splitLines(data) {
+		n := i + 1
+		switch strings.TrimSpace(line) {
+		case beginMarker:
+			managed = depth == 0
+			continue
+		case endMarker:
+			flush()
+			managed = false
+			continue
+		}
+		key, args, err := directive(line)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s:%d: %w", path, n, err))
+			continue
+		}
+		if key == "" {
+			continue
+		}
+		if len(args) == 0 {
+			errs = append(errs, fmt.Errorf("%s:%d: %s needs an argument", path, n, key))
+			continue
+		}
+		switch strings.ToLower(key) {
+		case "host":
+			flush()
+			cur = &Host{Name: strings.Join(args, " "), Source: path, Line: n, Managed: managed}
+		case "match":
+			flush()
+		case "include":
+			if depth+1 > maxDepth {
+				errs = append(errs, fmt.Errorf("%s:%d: Include nested too deeply", path, n))
+				continue
+			}
+			for _, pattern := range args {
+				inc, err := includeFiles(pattern)
+				if err != nil {
+					errs = append(errs, fmt.Errorf("%s:%d: %w", path, n, err))
+				}
+				for _, p := range inc {
+					data, err := fsys.Current.ReadFile(p)
+					if err != nil {
+						errs = append(errs, err)
+						continue
+					}
+					more, err := parse(p, data, depth+1)
+					hosts = append(hosts, more...)
+					errs = append(errs, err)
+				}
+			}
+		default:
+			if cur == nil {
+				continue
+			}
+			// the first value obtained wins
+			if f := cur.field(key); f != nil && *f == "" {
+				*f = args[0]
+			}
+		}
+	}
+	flush()
+	return hosts, errors.Join(errs...)
+}
+
+// includeFiles expands an Include argument: relative paths are taken
+// from the directory of the managed config, ~/.ssh by default, and the
+// last element may be a glob.
+func includeFiles(pattern string) ([]string, error) {
+	if strings.HasPrefix(pattern, "~/") {
+		home, err := userHome()
+		if err != nil {
+			return nil, err
+		}
+		pattern = filepath.Join(home, pattern[2:])
+	}
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(filepath.Dir(ConfigPath()), pattern)
+	}
+	dir, base := filepath.Split(pattern)
+	if !strings.ContainsAny(base, "*?[") {
+		if _, err := fsys.Current.Stat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+	entries, err := fsys.Current.ReadDir(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		ok, err := filepath.Match(base, e.Name())
+		if err != nil {
+			return nil, fmt.Errorf("Include %s: %w", pattern, err)
+		}
+		if ok && !e.IsDir() {
+			out = append(out, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
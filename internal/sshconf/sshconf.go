@@ -0,0 +1,437 @@
+// Package sshconf manages Host blocks in the ssh client config. The hosts
+// shctl adds live between two marker comments, and only those are changed
+// or removed; hosts written by hand, in the config or in the files it
+// includes, are listed and checked for clashes but never touched.
+package sshconf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name:  "ssh",
+		Files: func() ([]string, error) { return []string{ConfigPath()}, nil },
+	})
+	trash.Register("ssh", restoreLines)
+}
+
+// The markers around the hosts shctl manages.
+const (
+	beginMarker = "# BEGIN shctl managed hosts"
+	endMarker   = "# END shctl managed hosts"
+)
+
+// ErrUnmanaged reports a host defined outside the managed block.
+var ErrUnmanaged = errors.New("host is not managed by shctl")
+
+// ConfigPath is the ssh client config to manage.
+func ConfigPath() string {
+	return config.Get("ssh_config")
+}
+
+func userHome() (string, error) {
+	return os.UserHomeDir()
+}
+
+// Hosts returns the Host blocks of the config and the files it includes,
+// in the order ssh reads them.
+func Hosts() ([]Host, error) {
+	data, err := fsys.Current.ReadFile(ConfigPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Host{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	hosts, err := parse(ConfigPath(), data, 0)
+	if hosts == nil {
+		hosts = []Host{}
+	}
+	return hosts, err
+}
+
+// List prints the hosts as "name -> [user@]hostname[:port]", hosts shctl
+// does not manage marked with their file and line, or as JSON or YAML
+// records. output.List narrows and orders them by name and file.
+func List(w io.Writer, format string) error {
+	all, err := Hosts()
+	if err != nil {
+		output.Warn(prompt.Out, "%v", err)
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name, File: all[i].Source}
+	})
+	if err != nil {
+		return err
+	}
+	hosts := make([]Host, len(idx))
+	for i, j := range idx {
+		hosts[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, hosts)
+	}
+	for _, h := range hosts {
+		target := h.HostName
+		if target == "" {
+			target = h.Name
+		}
+		if h.User != "" {
+			target = h.User + "@" + target
+		}
+		if h.Port != "" {
+			target += ":" + h.Port
+		}
+		line := output.Name(w, h.Name) + " -> " + output.Value(w, target)
+		if h.ProxyJump != "" {
+			line += " via " + h.ProxyJump
+		}
+		if !h.Managed {
+			line += fmt.Sprintf(" (%s:%d)", h.Source, h.Line)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkName rejects host names that would not make one Host pattern.
+func checkName(name string) error {
+	if name == "" || strings.ContainsAny(name, " \t\"#=\n") {
+		return fmt.Errorf("invalid host name %q: %w", name, util.ErrUsage)
+	}
+	return nil
+}
+
+// parseOptions reads Key=value arguments for the options shctl manages.
+// An empty value clears the option.
+func parseOptions(opts []string) (map[string]string, error) {
+	out := map[string]string{}
+	for _, o := range opts {
+		k, v, ok := strings.Cut(o, "=")
+		var h Host
+		if !ok || h.field(k) == nil {
+			return nil, fmt.Errorf("%q is not KEY=VALUE with KEY one of %s: %w", o, strings.Join(Options, ", "), util.ErrUsage)
+		}
+		for _, name := range Options {
+			if strings.EqualFold(name, k) {
+				k = name
+			}
+		}
+		if err := checkOption(k, v); err != nil {
+			return nil, err
+		}
+		out[k] = v
+	}
+	return out, nil
+}
+
+func checkOption(key, value string) error {
+	bad := strings.ContainsAny(value, "\n\"")
+	switch key {
+	case "Port":
+		if n, err := strconv.Atoi(value); value != "" && (err != nil || n < 1 || n > 65535) {
+			bad = true
+		}
+	case "IdentityFile":
+	default:
+		bad = bad || strings.ContainsAny(value, " \t")
+	}
+	if bad {
+		return fmt.Errorf("invalid %s %q: %w", key, value, util.ErrUsage)
+	}
+	return nil
+}
+
+// render writes the Host block for name with opts.
+func render(name string, opts map[string]string) []string {
+	out := []string{"Host " + name}
+	for _, k := range Options {
+		if v := opts[k]; v != "" {
+			out = append(out, "    "+k+" "+quote(v))
+		}
+	}
+	return out
+}
+
+// Add adds a Host block for name with opts, KEY=VALUE arguments for the
+// options in Options. A name any Host line already lists is reported as
+// ErrEntryExists.
+func Add(ctx context.Context, name string, opts []string) error {
+	if err := checkName(name); err != nil {
+		return err
+	}
+	set, err := parseOptions(opts)
+	if err != nil {
+		return err
+	}
+	return change(ctx, "host add", func(lines []string, hosts []Host) ([]string, error) {
+		if h, ok := find(hosts, name); ok {
+			return nil, fmt.Errorf("%s: %w in %s:%d", name, util.ErrEntryExists, h.Source, h.Line)
+		}
+		return insert(lines, render(name, set))
+	})
+}
+
+// Edit changes the options of managed host name: each KEY=VALUE argument
+// replaces that option, and an empty value removes it. Other options of
+// the block are kept.
+func Edit(ctx context.Context, name string, opts []string) error {
+	set, err := parseOptions(opts)
+	if err != nil {
+		return err
+	}
+	if len(set) == 0 {
+		return fmt.Errorf("nothing to change: %w", util.ErrUsage)
+	}
+	return change(ctx, "host edit", func(lines []string, hosts []Host) ([]string, error) {
+		start, stop, err := locate(lines, hosts, name)
+		if err != nil {
+			return nil, err
+		}
+		block := append([]string{}, lines[start:stop]...)
+		for _, k := range Options {
+			v, ok := set[k]
+			if !ok {
+				continue
+			}
+			var kept []string
+			done := v == ""
+			for i, l := range block {
+				key, _, _ := directive(l)
+				if i == 0 || !strings.EqualFold(key, k) {
+					kept = append(kept, l)
+				} else if !done {
+					kept = append(kept, "    "+k+" "+quote(v))
+					done = true
+				}
+			}
+			if !done {
+				kept = append(kept, "    "+k+" "+quote(v))
+			}
+			block = kept
+		}
+		return append(lines[:start], append(block, lines[stop:]...)...), nil
+	})
+}
+
+// Remove deletes the managed hosts names in one change, keeping their
+// blocks in the trash.
+func Remove(ctx context.Context, names []string) error {
+	var removed [][]string
+	err := change(ctx, "host remove", func(lines []string, hosts []Host) ([]string, error) {
+		removed = nil
+		for _, name := range names {
+			start, stop, err := locate(lines, hosts, name)
+			if err != nil {
+				return nil, err
+			}
+			removed = append(removed, append([]string{}, lines[start:stop]...))
+			// drop the blank line that separated the block too
+			if start > 0 && strings.TrimSpace(lines[start-1]) == "" {
+				start--
+			}
+			lines = append(lines[:start], lines[stop:]...)
+		}
+		return lines, nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, block := range removed {
+		if err := trash.Put("ssh", ConfigPath(), "ssh host remove", block); err != nil {
+			output.Warn(prompt.Out, "trash: %v", err)
+		}
+	}
+	return nil
+}
+
+// restoreLines puts a removed Host block back in the managed block.
+func restoreLines(ctx context.Context, path string, lines []string) error {
+	if path != ConfigPath() {
+		return fmt.Errorf("%s is no longer the ssh config in use (%s)", path, ConfigPath())
+	}
+	_, args, _ := directive(lines[0])
+	name := strings.Join(args, " ")
+	return change(ctx, "trash restore", func(cur []string, hosts []Host) ([]string, error) {
+		if h, ok := find(hosts, name); ok {
+			return nil, fmt.Errorf("%s: %w in %s:%d", name, util.ErrEntryExists, h.Source, h.Line)
+		}
+		return insert(cur, lines)
+	})
+}
+
+// find returns the first host whose Host line lists name as a pattern.
+func find(hosts []Host, name string) (Host, bool) {
+	for _, h := range hosts {
+		for _, p := range strings.Fields(h.Name) {
+			if p == name {
+				return h, true
+			}
+		}
+	}
+	return Host{}, false
+}
+
+// markers returns the line indexes of the managed block's markers, -1
+// when there is no block.
+func markers(lines []string) (int, int, error) {
+	begin, end := -1, -1
+	for i, l := range lines {
+		switch strings.TrimSpace(l) {
+		case beginMarker:
+			if begin >= 0 {
+				return 0, 0, fmt.Errorf("%s: more than one shctl block", ConfigPath())
+			}
+			begin = i
+		case endMarker:
+			if begin < 0 || end >= 0 {
+				return 0, 0, fmt.Errorf("%s:%d: stray end of the shctl block", ConfigPath(), i+1)
+			}
+			end = i
+		}
+	}
+	if begin >= 0 && end < 0 {
+		return 0, 0, fmt.Errorf("%s:%d: the shctl block is not closed", ConfigPath(), begin+1)
+	}
+	return begin, end, nil
+}
+
+// locate returns the lines [start, stop) of the managed Host block whose
+// Host line is exactly name.
+func locate(lines []string, hosts []Host, name string) (int, int, error) {
+	begin, end, err := markers(lines)
+	if err != nil {
+		return 0, 0, err
+	}
+	start := -1
+	for i := begin + 1; begin >= 0 && i < end; i++ {
+		key, args, _ := directive(lines[i])
+		if start >= 0 && (strings.EqualFold(key, "host") || strings.EqualFold(key, "match")) {
+			end = i
+			break
+		}
+		if strings.EqualFold(key, "host") && strings.Join(args, " ") == name {
+			start = i
+		}
+	}
+	if start < 0 {
+		if h, ok := find(hosts, name); ok {
+			return 0, 0, fmt.Errorf("%s is defined in %s:%d: %w", name, h.Source, h.Line, ErrUnmanaged)
+		}
+		return 0, 0, util.NotFound(fmt.Sprintf("no host %s in %s", name, ConfigPath()))
+	}
+	for end > start+1 && strings.TrimSpace(lines[end-1]) == "" {
+		end--
+	}
+	return start, end, nil
+}
+
+// insert adds block at the end of the managed block. A config without
+// one gets it before its first Host or Match line, so the managed hosts
+// are not shadowed by catch-all blocks, which by convention come last.
+func insert(lines, block []string) ([]string, error) {
+	begin, end, err := markers(lines)
+	if err != nil {
+		return nil, err
+	}
+	if begin >= 0 {
+		if end > begin+1 && strings.TrimSpace(lines[end-1]) != "" {
+			block = append([]string{""}, block...)
+		}
+		return append(lines[:end], append(block, lines[end:]...)...), nil
+	}
+	at := len(lines)
+	for i, l := range lines {
+		key, _, _ := directive(l)
+		if strings.EqualFold(key, "host") || strings.EqualFold(key, "match") {
+			at = i
+			break
+		}
+	}
+	var add []string
+	if at > 0 && strings.TrimSpace(lines[at-1]) != "" {
+		add = append(add, "")
+	}
+	add = append(append(append(add, beginMarker), block...), endMarker)
+	if at < len(lines) {
+		add = append(add, "")
+	}
+	return append(lines[:at], append(add, lines[at:]...)...), nil
+}
+
+// validate checks the lines of the config itself; included files are
+// not shctl's to fix.
+func validate(path string, data []byte) error {
+	var errs []error
+	for i, l := range splitLines(data) {
+		key, args, err := directive(l)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s:%d: %w", path, i+1, err))
+		case key != "" && len(args) == 0:
+			errs = append(errs, fmt.Errorf("%s:%d: %s needs an argument", path, i+1, key))
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return &util.ValidationError{Path: path, Output: err.Error(), Err: err}
+	}
+	return nil
+}
+
+// change lets fn rewrite the config's lines, given every host it and its
+// includes define, then validates and writes the result, backing the
+// config up first. ~/.ssh and a new config are created private, as ssh
+// insists.
+func change(ctx context.Context, op string, fn func(lines []string, hosts []Host) ([]string, error)) error {
+	path := ConfigPath()
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	hosts, perr := parse(path, data, 0)
+	if perr != nil {
+		logging.Debug("ssh config", "path", path, "err", perr)
+	}
+	lines, err := fn(splitLines(data), hosts)
+	if err != nil {
+		return err
+	}
+	next := joinLines(lines)
+	if err := validate(path, next); err != nil {
+		return err
+	}
+	if err := backup.AutoSave(ctx, path, "ssh "+op); err != nil {
+		return err
+	}
+	logging.Info("ssh config", "op", op, "path", path)
+	if err := fsys.Current.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	return fsys.Current.WriteFile(path, next, 0o600)
+}
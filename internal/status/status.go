@@ -0,0 +1,140 @@
+// Package status summarizes what shctl manages: the entries of every rc
+// file, the newest backup of every managed file, drift from the newest
+// snapshot and edits made to the files outside shctl.
+package status
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/yourusername/shctl/internal/auditlog"
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/snapshot"
+)
+
+// File is the state of one managed file.
+type File struct {
+	Path       string     `json:"path"`
+	Counts     *rc.Counts `json:"counts,omitempty"` // rc files only
+	LastBackup *time.Time `json:"last_backup,omitempty"`
+	// EditedOutside is yes or no when the file changed or not since the
+	// last shctl command wrote it, and unknown when none has.
+	EditedOutside string `json:"edited_outside"`
+}
+
+// Summary is what Collect found.
+type Summary struct {
+	Files []File `json:"files"`
+	// Snapshot is the newest snapshot, which Drift compares with.
+	Snapshot *snapshot.Info    `json:"snapshot,omitempty"`
+	Drift    []snapshot.Change `json:"drift"`
+}
+
+// Collect summarizes the files of every registered subsystem.
+func Collect(ctx context.Context) (Summary, error) {
+	s := Summary{Files: []File{}, Drift: []snapshot.Change{}}
+	paths, err := snapshot.Managed()
+	if err != nil {
+		return s, err
+	}
+	isRC := map[string]bool{}
+	for _, p := range config.RCFiles() {
+		isRC[p] = true
+	}
+
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return s, err
+	}
+	list, err := backup.List(ctx, store)
+	if err != nil {
+		return s, err
+	}
+	latest := map[string]time.Time{}
+	for _, b := range list {
+		if b.Time.After(latest[b.Source]) {
+			latest[b.Source] = b.Time
+		}
+	}
+
+	for _, p := range paths {
+		f := File{Path: p, EditedOutside: "unknown"}
+		if isRC[p] {
+			c, err := rc.Count(p)
+			if err != nil {
+				return s, err
+			}
+			f.Counts = &c
+		}
+		if t, ok := latest[p]; ok {
+			f.LastBackup = &t
+		}
+		edited, known, _, err := auditlog.EditedOutside(p)
+		if err != nil {
+			return s, err
+		}
+		switch {
+		case known && edited:
+			f.EditedOutside = "yes"
+		case known:
+			f.EditedOutside = "no"
+		}
+		s.Files = append(s.Files, f)
+	}
+
+	info, drift, err := snapshot.Drift(ctx)
+	if err != nil {
+		return s, err
+	}
+	if info.Name != "" {
+		s.Snapshot = &info
+		s.Drift = append(s.Drift, drift...)
+	}
+	return s, nil
+}
+
+// Write prints s as a table of files followed by the drift, or as JSON
+// or YAML.
+func Write(w io.Writer, format string, s Summary) error {
+	if output.Structured(format) {
+		return output.Write(w, format, s)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "FILE\tALIASES\tEXPORTS\tFUNCTIONS\tLAST BACKUP\tEDITED OUTSIDE")
+	for _, f := range s.Files {
+		counts := "-\t-\t-"
+		if f.Counts != nil {
+			counts = fmt.Sprintf("%d\t%d\t%d", f.Counts.Aliases, f.Counts.Exports, f.Counts.Functions)
+		}
+		last := "never"
+		if f.LastBackup != nil {
+			last = f.LastBackup.Local().Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Path, counts, last, f.EditedOutside)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if s.Snapshot == nil {
+		_, err := fmt.Fprintln(w, "no snapshot to check drift against")
+		return err
+	}
+	when := s.Snapshot.Created.Local().Format("2006-01-02 15:04:05")
+	if len(s.Drift) == 0 {
+		_, err := fmt.Fprintf(w, "no drift since snapshot %s\n", when)
+		return err
+	}
+	fmt.Fprintf(w, "%d file(s) drifted since snapshot %s:\n", len(s.Drift), when)
+	for _, c := range s.Drift {
+		if _, err := fmt.Fprintf(w, "  %-8s  %s\n", c.State, c.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
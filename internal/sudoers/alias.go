@@ -0,0 +1,181 @@
+package sudoers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/output"
+)
+
+// AliasKind normalizes user-facing names ("cmnd", "user", "Host_Alias")
+// to the sudoers keyword.
+func AliasKind(s string) (string, error) {
+	switch strings.TrimSuffix(strings.ToLower(s), "_alias") {
+	case "user":
+		return "User_Alias", nil
+	case "runas":
+		return "Runas_Alias", nil
+	case "host":
+		return "Host_Alias", nil
+	case "cmnd", "cmd", "command":
+		return "Cmnd_Alias", nil
+	}
+	return "", fmt.Errorf("unknown alias kind %q (want user, runas, host or cmnd)", s)
+}
+
+// Aliases returns every alias definition from the sudoers file and its
+// includes, keyed by kind and name.
+func Aliases() (map[string]map[string][]string, error) {
+	entries, err := AllEntries()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]map[string][]string{}
+	for _, e := range entries {
+		if e.Kind != KindAlias {
+			continue
+		}
+		kind, defs := parseAliasDefs(e.Raw)
+		if out[kind] == nil {
+			out[kind] = map[string][]string{}
+		}
+		for name, members := range defs {
+			out[kind][name] = members
+		}
+	}
+	return out, nil
+}
+
+// AddAlias defines a new alias. A name already in use is reported as
+// ErrEntryExists.
+func AddAlias(ctx context.Context, kind, name string, members []string) error {
+	kind, err := AliasKind(kind)
+	if err != nil {
+		return err
+	}
+	if !aliasDefRe.MatchString(name) || name == "ALL" {
+		return fmt.Errorf("alias name %q must be upper case letters, digits and underscores", name)
+	}
+	if len(members) == 0 {
+		return fmt.Errorf("alias %s needs at least one member", name)
+	}
+	all, err := Aliases()
+	if err != nil {
+		return err
+	}
+	for k, defs := range all {
+		if _, ok := defs[name]; ok {
+			return fmt.Errorf("%s: %w as a %s", name, ErrEntryExists, k)
+		}
+	}
+	return Add(ctx, fmt.Sprintf("%s %s = %s", kind, name, strings.Join(members, ", ")))
+}
+
+// AliasDef is one alias definition, as listed in structured output.
+type AliasDef struct {
+	Kind    string   `json:"kind"`
+	Name    string   `json:"name"`
+	Members []string `json:"members"`
+}
+
+// AliasDefs returns the alias definitions sorted by kind and name.
+func AliasDefs() ([]AliasDef, error) {
+	all, err := Aliases()
+	if err != nil {
+		return nil, err
+	}
+	out := []AliasDef{}
+	for k, defs := range all {
+		for n, members := range defs {
+			out = append(out, AliasDef{k, n, members})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out, nil
+}
+
+// ListAliases prints alias definitions sorted by kind and name.
+func ListAliases(w io.Writer) error {
+	return ListAliasesFormat(w, output.Text)
+}
+
+// ListAliasesFormat prints alias definitions as text, JSON or YAML,
+// narrowed and ordered by output.List.
+func ListAliasesFormat(w io.Writer, format string) error {
+	all, err := AliasDefs()
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name, File: SudoersPath()}
+	})
+	if err != nil {
+		return err
+	}
+	defs := make([]AliasDef, len(idx))
+	for i, j := range idx {
+		defs[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, defs)
+	}
+	for _, d := range defs {
+		if _, err := fmt.Fprintf(w, "%s %s = %s\n", d.Kind, output.Name(w, d.Name), output.Value(w, strings.Join(d.Members, ", "))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveAlias deletes the definition of name from the sudoers file. Rules
+// still referring to it make validation fail, so they must go first. An
+// undefined name is reported as ErrAliasNotFound. The definition is kept
+// in the trash.
+func RemoveAlias(ctx context.Context, name string) error {
+	var removed []string
+	err := change(ctx, "alias remove", "visudo validation failed after alias removal", func(tmp string) error {
+		removed = nil
+		entries, err := parseFile(tmp)
+		if err != nil {
+			return err
+		}
+		edits := []edit{}
+		for _, e := range entries {
+			if e.Kind != KindAlias {
+				continue
+			}
+			kind, rest, _ := strings.Cut(e.Raw, " ")
+			defs := strings.Split(rest, ":")
+			keep := []string{}
+			for _, d := range defs {
+				n, _, _ := strings.Cut(d, "=")
+				if strings.TrimSpace(n) != name {
+					keep = append(keep, strings.TrimSpace(d))
+				} else {
+					removed = append(removed, kind+" "+strings.TrimSpace(d))
+				}
+			}
+			switch {
+			case len(keep) == len(defs):
+				continue
+			case len(keep) == 0:
+				edits = append(edits, edit{e, ""})
+			default:
+				edits = append(edits, edit{e, kind + " " + strings.Join(keep, " : ")})
+			}
+		}
+		if len(edits) == 0 {
+			return fmt.Errorf("%s: %w in %s", name, ErrAliasNotFound, SudoersPath())
+		}
+		return rewrite(tmp, edits)
+	})
+	return discard("alias remove", removed, err)
+}
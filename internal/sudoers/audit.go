@@ -0,0 +1,184 @@
+package sudoers
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/output"
+)
+
+const (
+	SeverityHigh   = "high"
+	SeverityMedium = "medium"
+	SeverityLow    = "low"
+)
+
+// Finding is one risky configuration reported by Audit.
+type Finding struct {
+	Severity string `json:"severity"`
+	Source   string `json:"source"`
+	Line     int    `json:"line,omitempty"`
+	Rule     string `json:"rule,omitempty"`
+	Message  string `json:"message"`
+}
+
+// Audit inspects the sudoers file and its include directories for risky
+// settings.
+func Audit() ([]Finding, error) {
+	path := SudoersPath()
+	entries, err := AllEntries()
+	if err != nil {
+		return nil, err
+	}
+	out := []Finding{}
+	securePath := false
+	for _, e := range entries {
+		switch e.Kind {
+		case KindDefaults:
+			if strings.Contains(e.Raw, "secure_path") {
+				securePath = true
+			}
+		case KindRule:
+			out = append(out, auditRule(e)...)
+		}
+	}
+	if !securePath {
+		out = append(out, Finding{
+			Severity: SeverityMedium,
+			Source:   path,
+			Message:  "no Defaults secure_path; commands resolve through the invoking user's PATH",
+		})
+	}
+	out = append(out, auditMode(path)...)
+	for _, dir := range includeDirs(entries) {
+		files, _ := fsys.Current.ReadDir(dir)
+		for _, f := range files {
+			if !f.IsDir() {
+				out = append(out, auditMode(filepath.Join(dir, f.Name()))...)
+			}
+		}
+	}
+	return out, nil
+}
+
+// WriteAudit prints findings as text, one per line, or as JSON or YAML.
+func WriteAudit(w io.Writer, format string, findings []Finding) error {
+	if output.Structured(format) {
+		return output.Write(w, format, findings)
+	}
+	for _, f := range findings {
+		where := f.Source
+		if f.Line > 0 {
+			where += ":" + strconv.Itoa(f.Line)
+		}
+		if _, err := fmt.Fprintf(w, "%-6s  %s: %s\n", f.Severity, where, f.Message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteAuditJSON writes findings as an indented JSON array.
+func WriteAuditJSON(w io.Writer, findings []Finding) error {
+	return WriteAudit(w, output.JSON, findings)
+}
+
+func auditRule(e Entry) []Finding {
+	out := []Finding{}
+	add := func(sev, msg string) {
+		out = append(out, Finding{Severity: sev, Source: e.Source, Line: e.Line, Rule: e.Raw, Message: msg})
+	}
+	// tags carry forward across commands as in matchCommands
+	nopasswd := containsStr(e.Tags, "NOPASSWD")
+	for _, c := range e.Commands {
+		tags, c := commandTags(c)
+		for _, t := range tags {
+			switch t {
+			case "NOPASSWD":
+				nopasswd = true
+			case "PASSWD":
+				nopasswd = false
+			}
+		}
+		fields := strings.Fields(c)
+		cmd := strings.TrimPrefix(fields[0], "!")
+		switch {
+		case cmd == "ALL" && nopasswd:
+			add(SeverityHigh, "NOPASSWD: ALL grants passwordless root to "+strings.Join(e.Users, ", "))
+		case strings.ContainsAny(cmd, "*?["):
+			add(SeverityMedium, "wildcard in command path "+cmd)
+		case len(fields) > 1 && strings.ContainsAny(strings.Join(fields[1:], " "), "*?["):
+			add(SeverityLow, "wildcard in arguments of "+cmd+" may allow unintended options")
+		}
+		if strings.HasPrefix(cmd, "/") {
+			for _, u := range e.Users {
+				if writableBy(cmd, u) {
+					add(SeverityHigh, cmd+" (or its directory) is writable by grantee "+u)
+				}
+			}
+		}
+	}
+	return out
+}
+
+func auditMode(path string) []Finding {
+	fi, err := fsys.Current.Stat(path)
+	if err != nil {
+		return nil
+	}
+	switch mode := fi.Mode().Perm(); {
+	case mode&0o002 != 0:
+		return []Finding{{Severity: SeverityHigh, Source: path, Message: "world-writable sudoers file (mode " + mode.String() + ")"}}
+	case mode&0o004 != 0:
+		return []Finding{{Severity: SeverityMedium, Source: path, Message: "world-readable sudoers file (mode " + mode.String() + ")"}}
+	}
+	return nil
+}
+
+// writableBy reports whether the named user could modify cmd or replace
+// it through its directory.
+func writableBy(cmd, name string) bool {
+	if strings.HasPrefix(name, "%") || name == "ALL" || isAliasName(name) {
+		return false
+	}
+	lookup := user.Lookup
+	if strings.HasPrefix(name, "#") {
+		lookup, name = user.LookupId, name[1:]
+	}
+	u, err := lookup(name)
+	if err != nil {
+		return false
+	}
+	uid, _ := strconv.Atoi(u.Uid)
+	gids := map[int]bool{}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, g := range ids {
+			n, _ := strconv.Atoi(g)
+			gids[n] = true
+		}
+	}
+	for _, p := range []string{cmd, filepath.Dir(cmd)} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			continue
+		}
+		mode := fi.Mode().Perm()
+		if mode&0o002 != 0 {
+			return true
+		}
+		fuid, fgid, ok := fsys.FileOwner(fi)
+		if !ok {
+			continue
+		}
+		if (fuid == uid && mode&0o200 != 0) || (gids[fgid] && mode&0o020 != 0) {
+			return true
+		}
+	}
+	return false
+}
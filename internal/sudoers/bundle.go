@@ -0,0 +1,280 @@
+package sudoers
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Bundle is a proposed sudoers change, signed by its requester, that must
+// also be signed by an approver other than the requester before it can be
+// applied.
+type Bundle struct {
+	Target             string            `json:"target"`
+	BaseSHA256         string            `json:"base_sha256"`
+	Proposed           []byte            `json:"proposed"`
+	Diff               string            `json:"diff"`
+	Reason             string            `json:"reason"`
+	Requester          string            `json:"requester"`
+	RequesterKey       ed25519.PublicKey `json:"requester_key"`
+	RequesterSignature []byte            `json:"requester_signature"`
+	Created            time.Time         `json:"created"`
+	Approval           *Approval         `json:"approval,omitempty"`
+}
+
+type Approval struct {
+	Approver  string            `json:"approver"`
+	PublicKey ed25519.PublicKey `json:"public_key"`
+	Signature []byte            `json:"signature"`
+	Approved  time.Time         `json:"approved"`
+}
+
+// TrustedKey is a requester's or approver's public key, bound to their name.
+type TrustedKey struct {
+	Name string
+	Key  ed25519.PublicKey
+}
+
+var (
+	ErrNotApproved  = errors.New("bundle is not approved")
+	ErrUntrustedKey = errors.New("bundle approved with an untrusted key")
+	ErrStaleBundle  = errors.New("sudoers file changed since the bundle was requested")
+)
+
+// Request packages the change from the current sudoers file to proposed,
+// signed with the requester's key.
+func Request(proposed []byte, reason, requester string, key ed25519.PrivateKey) (*Bundle, error) {
+	target := SudoersPath()
+	cur, err := os.ReadFile(target)
+	if err != nil {
+		return nil, err
+	}
+	if reason == "" {
+		return nil, errors.New("a change request needs a reason")
+	}
+	b := &Bundle{
+		Target:       target,
+		BaseSHA256:   sha256Hex(cur),
+		Proposed:     proposed,
+		Diff:         util.UnifiedDiff(target, target+" (proposed)", cur, proposed),
+		Reason:       reason,
+		Requester:    requester,
+		RequesterKey: key.Public().(ed25519.PublicKey),
+		Created:      time.Now().UTC(),
+	}
+	b.RequesterSignature = ed25519.Sign(key, b.request())
+	return b, nil
+}
+
+// RequestAdd is Request for appending a single entry.
+func RequestAdd(entry, reason, requester string, key ed25519.PrivateKey) (*Bundle, error) {
+	cur, err := os.ReadFile(SudoersPath())
+	if err != nil {
+		return nil, err
+	}
+	return Request(append(cur, []byte("\n"+entry+"\n")...), reason, requester, key)
+}
+
+// request is what the requester signs: the bundle without any signatures.
+func (b *Bundle) request() []byte {
+	c := *b
+	c.RequesterSignature = nil
+	c.Approval = nil
+	body, _ := json.Marshal(c)
+	return body
+}
+
+// payload is what the approver signs: everything but the approval itself,
+// bound to the approver's name and time.
+func (b *Bundle) payload(approver string, at time.Time) []byte {
+	c := *b
+	c.Approval = nil
+	body, _ := json.Marshal(c)
+	return append(body, []byte("\napprover="+approver+"\napproved="+at.Format(time.RFC3339Nano))...)
+}
+
+// Approve signs the bundle. The requester cannot approve their own change.
+func (b *Bundle) Approve(approver string, key ed25519.PrivateKey) error {
+	if approver == "" || approver == b.Requester {
+		return fmt.Errorf("bundle must be approved by someone other than the requester %q", b.Requester)
+	}
+	at := time.Now().UTC()
+	b.Approval = &Approval{
+		Approver:  approver,
+		PublicKey: key.Public().(ed25519.PublicKey),
+		Signature: ed25519.Sign(key, b.payload(approver, at)),
+		Approved:  at,
+	}
+	return nil
+}
+
+// Verify checks that the bundle was signed by its requester and approved
+// by someone else, both with trusted keys that belong to the names in the
+// bundle, and that its diff matches the change it would make to the
+// current target.
+func (b *Bundle) Verify(trusted []TrustedKey) error {
+	a := b.Approval
+	if a == nil {
+		return ErrNotApproved
+	}
+	requester := keyOwner(trusted, b.RequesterKey)
+	if requester == nil {
+		return fmt.Errorf("%w: requester key", ErrUntrustedKey)
+	}
+	if requester.Name != b.Requester {
+		return fmt.Errorf("%w: key of %s used to request as %s", ErrUntrustedKey, requester.Name, b.Requester)
+	}
+	if !ed25519.Verify(b.RequesterKey, b.request(), b.RequesterSignature) {
+		return fmt.Errorf("%w: requester signature does not match", ErrNotApproved)
+	}
+	approver := keyOwner(trusted, a.PublicKey)
+	if approver == nil {
+		return ErrUntrustedKey
+	}
+	if approver.Name != a.Approver {
+		return fmt.Errorf("%w: key of %s used to approve as %s", ErrUntrustedKey, approver.Name, a.Approver)
+	}
+	if approver.Name == requester.Name {
+		return fmt.Errorf("%w: approver is the requester", ErrNotApproved)
+	}
+	if !ed25519.Verify(a.PublicKey, b.payload(a.Approver, a.Approved), a.Signature) {
+		return fmt.Errorf("%w: signature does not match", ErrNotApproved)
+	}
+	cur, err := os.ReadFile(b.Target)
+	if err != nil {
+		return err
+	}
+	if sha256Hex(cur) != b.BaseSHA256 {
+		return ErrStaleBundle
+	}
+	if util.UnifiedDiff(b.Target, b.Target+" (proposed)", cur, b.Proposed) != b.Diff {
+		return fmt.Errorf("%w: diff does not match the proposed file", ErrNotApproved)
+	}
+	return nil
+}
+
+func keyOwner(trusted []TrustedKey, key ed25519.PublicKey) *TrustedKey {
+	for i, k := range trusted {
+		if k.Key.Equal(key) {
+			return &trusted[i]
+		}
+	}
+	return nil
+}
+
+// ApplyBundle verifies the approval and that the target has not changed
+// since the request, validates the proposed file and applies it.
+func ApplyBundle(ctx context.Context, b *Bundle, trusted []TrustedKey) error {
+	if err := b.Verify(trusted); err != nil {
+		return err
+	}
+	if b.Target != SudoersPath() {
+		return fmt.Errorf("bundle targets %s, not %s", b.Target, SudoersPath())
+	}
+	return change(ctx, "apply bundle", "proposed sudoers failed validation", func(tmp string) error {
+		cur, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		if sha256Hex(cur) != b.BaseSHA256 {
+			return ErrStaleBundle
+		}
+		return os.WriteFile(tmp, b.Proposed, 0o600)
+	})
+}
+
+func ReadBundle(path string) (*Bundle, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var b Bundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &b, nil
+}
+
+func (b *Bundle) WriteFile(path string) error {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o600)
+}
+
+// GenerateKey writes a new approver key pair as base64: the private key to
+// path (0600) and the public key to path.pub.
+func GenerateKey(path string) (ed25519.PublicKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(base64.StdEncoding.EncodeToString(priv.Seed())+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return pub, os.WriteFile(path+".pub", []byte(base64.StdEncoding.EncodeToString(pub)+"\n"), 0o644)
+}
+
+func LoadPrivateKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	seed, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("%s: not an approver key", path)
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// LoadTrustedKeys reads "name key" lines: the approver's name and their
+// base64 public key. Text after the key is a comment.
+func LoadTrustedKeys(path string) ([]TrustedKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys := []TrustedKey{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	n := 0
+	for sc.Scan() {
+		n++
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("%s:%d: want an approver name and a public key", path, n)
+		}
+		k, err := base64.StdEncoding.DecodeString(fields[1])
+		if err != nil || len(k) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("%s:%d: invalid public key", path, n)
+		}
+		for _, o := range keys {
+			if o.Key.Equal(ed25519.PublicKey(k)) && o.Name != fields[0] {
+				return nil, fmt.Errorf("%s:%d: key already belongs to %s", path, n, o.Name)
+			}
+		}
+		keys = append(keys, TrustedKey{Name: fields[0], Key: ed25519.PublicKey(k)})
+	}
+	return keys, sc.Err()
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,262 @@
+package sudoers
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Decision explains whether a user may run a command.
+type Decision struct {
+	User     string
+	Command  string
+	Allowed  bool
+	NoPasswd bool
+	RunAs    string
+	Rule     *Entry  // the rule that decided, when one matched the command
+	Rules    []Entry // every rule applying to the user on this host
+	Reason   string
+}
+
+// Can evaluates the live sudoers file (with includes) for username. An
+// empty command only reports which rules apply to the user.
+func Can(username, command string) (Decision, error) {
+	return CanFile(SudoersPath(), username, command)
+}
+
+// CanFile evaluates an alternate sudoers file, such as a backup.
+func CanFile(path, username, command string) (Decision, error) {
+	entries, err := loadFile(path, 0, map[string]bool{})
+	if err != nil {
+		return Decision{}, err
+	}
+	if command != "" {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			return Decision{}, fmt.Errorf("empty command: %w", util.ErrUsage)
+		}
+		if !filepath.IsAbs(fields[0]) {
+			p, err := exec.LookPath(fields[0])
+			if err != nil {
+				return Decision{}, fmt.Errorf("resolve %s: %w", fields[0], err)
+			}
+			fields[0] = p
+		}
+		command = strings.Join(fields, " ")
+	}
+	ev := newEvaluator(entries, username)
+	d := Decision{User: username, Command: command}
+	for i := range entries {
+		e := entries[i]
+		if e.Kind != KindRule || !ev.matchList(e.Users, "User_Alias", ev.matchUser) ||
+			!ev.matchList(e.Hosts, "Host_Alias", matchHost) {
+			continue
+		}
+		d.Rules = append(d.Rules, e)
+		if command == "" {
+			continue
+		}
+		// later rules override earlier ones, as in sudo
+		if allowed, tags, ok := ev.matchCommands(e, command); ok {
+			d.Allowed, d.Rule = allowed, &entries[i]
+			d.NoPasswd = containsStr(tags, "NOPASSWD")
+			d.RunAs = e.RunAs
+		}
+	}
+	if d.RunAs == "" {
+		d.RunAs = "root"
+	}
+	switch {
+	case command == "" && len(d.Rules) > 0:
+		d.Allowed = true
+		d.Reason = fmt.Sprintf("%d rule(s) apply to %s", len(d.Rules), username)
+	case command == "":
+		d.Reason = "no rule applies to " + username
+	case d.Rule == nil:
+		d.Reason = "no rule allows " + username + " to run " + command
+	case d.Allowed:
+		d.Reason = fmt.Sprintf("allowed by %s:%d: %s", d.Rule.Source, d.Rule.Line, d.Rule.Raw)
+	default:
+		d.Reason = fmt.Sprintf("denied by %s:%d: %s", d.Rule.Source, d.Rule.Line, d.Rule.Raw)
+	}
+	return d, nil
+}
+
+type evaluator struct {
+	aliases map[string]map[string][]string // kind -> name -> members
+	user    string
+	uid     string
+	groups  map[string]bool // names and "#gid" forms
+}
+
+func newEvaluator(entries []Entry, username string) *evaluator {
+	ev := &evaluator{aliases: map[string]map[string][]string{}, user: username, groups: map[string]bool{}}
+	for _, e := range entries {
+		if e.Kind != KindAlias {
+			continue
+		}
+		kind, defs := parseAliasDefs(e.Raw)
+		if ev.aliases[kind] == nil {
+			ev.aliases[kind] = map[string][]string{}
+		}
+		for name, members := range defs {
+			ev.aliases[kind][name] = members
+		}
+	}
+	if u, err := user.Lookup(username); err == nil {
+		ev.uid = u.Uid
+		ids, _ := u.GroupIds()
+		for _, id := range ids {
+			ev.groups["#"+id] = true
+			if g, err := user.LookupGroupId(id); err == nil {
+				ev.groups[g.Name] = true
+			}
+		}
+	}
+	return ev
+}
+
+// matchList applies sudo list semantics: the last matching item decides,
+// and a '!' prefix negates it. Alias names of kind expand recursively; an
+// alias that refers back to itself matches nothing.
+func (ev *evaluator) matchList(items []string, kind string, match func(string) bool) bool {
+	return ev.matchIn(items, kind, match, map[string]bool{})
+}
+
+// matchIn is matchList with the aliases being expanded, to stop at cycles.
+func (ev *evaluator) matchIn(items []string, kind string, match func(string) bool, expanding map[string]bool) bool {
+	result := false
+	for _, it := range items {
+		neg := false
+		for strings.HasPrefix(it, "!") {
+			neg, it = !neg, strings.TrimSpace(it[1:])
+		}
+		var ok bool
+		if members, isAlias := ev.aliases[kind][it]; isAlias {
+			if !expanding[it] {
+				expanding[it] = true
+				ok = ev.matchIn(members, kind, match, expanding)
+				delete(expanding, it)
+			}
+		} else {
+			ok = it == "ALL" || match(it)
+		}
+		if ok {
+			result = !neg
+		}
+	}
+	return result
+}
+
+func (ev *evaluator) matchUser(item string) bool {
+	switch {
+	case strings.HasPrefix(item, "%#"):
+		return ev.groups["#"+item[2:]]
+	case strings.HasPrefix(item, "%"):
+		return ev.groups[item[1:]]
+	case strings.HasPrefix(item, "#"):
+		return ev.uid != "" && item[1:] == ev.uid
+	}
+	return item == ev.user
+}
+
+func matchHost(item string) bool {
+	host, _ := os.Hostname()
+	short, _, _ := strings.Cut(host, ".")
+	return strings.EqualFold(item, host) || strings.EqualFold(item, short)
+}
+
+// matchCommands reports whether any command in the rule matches and, if
+// so, whether it allows or denies it and with which tags. As in sudo, a
+// tag applies to the command it precedes and those after it, until the
+// opposite tag, such as PASSWD after NOPASSWD, replaces it.
+func (ev *evaluator) matchCommands(e Entry, command string) (allowed bool, tags []string, ok bool) {
+	var cur []string
+	set := func(tag string) {
+		cur = slices.DeleteFunc(cur, func(t string) bool { return t == tag || t == oppositeTag(tag) })
+		cur = append(cur, tag)
+	}
+	for _, t := range e.Tags {
+		set(t)
+	}
+	for _, c := range e.Commands {
+		ctags, c := commandTags(c)
+		for _, t := range ctags {
+			set(t)
+		}
+		neg := strings.HasPrefix(c, "!")
+		item := strings.TrimSpace(strings.TrimLeft(c, "!"))
+		if ev.matchList([]string{item}, "Cmnd_Alias", func(spec string) bool { return matchCommand(spec, command) }) {
+			allowed, tags, ok = !neg, slices.Clone(cur), true
+		}
+	}
+	return allowed, tags, ok
+}
+
+// oppositeTag pairs a tag with the one that cancels it: NOPASSWD and
+// PASSWD, NOEXEC and EXEC, and so on.
+func oppositeTag(tag string) string {
+	if rest, ok := strings.CutPrefix(tag, "NO"); ok {
+		return rest
+	}
+	return "NO" + tag
+}
+
+// commandTags splits tags such as "NOPASSWD:" off the front of a command.
+func commandTags(c string) ([]string, string) {
+	tags := []string{}
+	for {
+		colon := strings.Index(c, ":")
+		if colon < 0 || !tagNames[strings.TrimSpace(c[:colon])] {
+			return tags, strings.TrimSpace(c)
+		}
+		tags = append(tags, strings.TrimSpace(c[:colon]))
+		c = c[colon+1:]
+	}
+}
+
+// matchCommand matches a sudoers command spec against a command line. A
+// spec without arguments allows any; "" allows none.
+func matchCommand(spec, command string) bool {
+	sf, cf := strings.Fields(spec), strings.Fields(command)
+	if len(sf) == 0 || len(cf) == 0 {
+		return false
+	}
+	if ok, _ := filepath.Match(sf[0], cf[0]); !ok {
+		return false
+	}
+	if len(sf) == 1 {
+		return true
+	}
+	if len(sf) == 2 && sf[1] == `""` {
+		return len(cf) == 1
+	}
+	// sudo matches arguments without FNM_PATHNAME: * also crosses /
+	slash := strings.NewReplacer("/", "\x00")
+	ok, _ := filepath.Match(slash.Replace(strings.Join(sf[1:], " ")), slash.Replace(strings.Join(cf[1:], " ")))
+	return ok
+}
+
+// parseAliasDefs parses "Cmnd_Alias A = x, y : B = z" into its kind and
+// definitions.
+func parseAliasDefs(raw string) (kind string, defs map[string][]string) {
+	defs = map[string][]string{}
+	kind, rest, _ := strings.Cut(strings.TrimSpace(raw), " ")
+	if kind == "Cmd_Alias" {
+		kind = "Cmnd_Alias"
+	}
+	for _, def := range strings.Split(rest, ":") {
+		name, members, ok := strings.Cut(def, "=")
+		if !ok {
+			continue
+		}
+		defs[strings.TrimSpace(name)] = splitCommands(members)
+	}
+	return kind, defs
+}
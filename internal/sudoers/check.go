@@ -0,0 +1,55 @@
+package sudoers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+
+	"github.com/yourusername/shctl/internal/output"
+)
+
+// Check validates the sudoers file and everything it includes without
+// changing anything. Parser and visudo errors are returned; audit findings
+// are printed to w as warnings only.
+func Check(ctx context.Context, w io.Writer) error {
+	path := SudoersPath()
+	entries, err := AllEntries()
+	if err != nil {
+		return err
+	}
+	errs := validateEntries(entries, true)
+	if _, err := exec.LookPath("visudo"); err == nil {
+		// visudo -c follows includes itself
+		if err := visudoValidate(ctx, path); err != nil {
+			errs = append(errs, err)
+		}
+	} else if RequireVisudo {
+		errs = append(errs, fmt.Errorf("visudo not found and strict validation is required: %w", err))
+	}
+
+	findings, err := Audit()
+	if err != nil {
+		return err
+	}
+	for _, f := range findings {
+		loc := f.Source
+		if f.Line > 0 {
+			loc = fmt.Sprintf("%s:%d", f.Source, f.Line)
+		}
+		output.Warn(w, "%s: [%s] %s", loc, f.Severity, f.Message)
+	}
+	for _, e := range errs {
+		output.Error(w, "%v", e)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s: %d problem(s) found: %w", path, len(errs), errors.Join(errs...))
+	}
+	files, err := Files()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "%s: ok (%d file(s), %d warning(s))\n", path, len(files), len(findings))
+	return nil
+}
@@ -0,0 +1,221 @@
+package sudoers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+var envVarRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\*?$`)
+
+// setting is one comma-separated item of a Defaults line.
+type setting struct {
+	name, op, value string // op is "", "=", "+=" or "-="; "!" negates
+}
+
+func parseSetting(s string) setting {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "!") {
+		return setting{name: strings.TrimSpace(s[1:]), op: "!"}
+	}
+	for _, op := range []string{"+=", "-=", "="} {
+		if name, v, ok := strings.Cut(s, op); ok {
+			return setting{strings.TrimSpace(name), op, strings.TrimSpace(v)}
+		}
+	}
+	return setting{name: s}
+}
+
+func (s setting) String() string {
+	switch s.op {
+	case "":
+		return s.name
+	case "!":
+		return "!" + s.name
+	}
+	return s.name + s.op + s.value
+}
+
+// splitSettings splits a Defaults body on commas outside double quotes.
+func splitSettings(body string) []setting {
+	out := []setting{}
+	start, quoted := 0, false
+	for i, c := range body {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			out = append(out, parseSetting(body[start:i]))
+			start = i + 1
+		}
+	}
+	if strings.TrimSpace(body[start:]) != "" {
+		out = append(out, parseSetting(body[start:]))
+	}
+	return out
+}
+
+// globalDefaults returns the settings of an unqualified "Defaults" entry.
+func globalDefaults(e Entry) ([]setting, bool) {
+	if e.Kind != KindDefaults {
+		return nil, false
+	}
+	body, ok := strings.CutPrefix(e.Raw, "Defaults")
+	if !ok || body == "" || (body[0] != ' ' && body[0] != '\t') {
+		return nil, false
+	}
+	return splitSettings(body), true
+}
+
+func joinSettings(ss []setting) string {
+	parts := make([]string, len(ss))
+	for i, s := range ss {
+		parts[i] = s.String()
+	}
+	return "Defaults " + strings.Join(parts, ", ")
+}
+
+// SetTimeout sets timestamp_timeout, the minutes sudo caches credentials
+// (0 always asks, negative never expires). An existing global setting is
+// replaced in place; otherwise a Defaults line is appended.
+func SetTimeout(ctx context.Context, minutes float64) error {
+	value := strconv.FormatFloat(minutes, 'f', -1, 64)
+	return change(ctx, "timeout set", "visudo validation failed", func(tmp string) error {
+		entries, err := parseFile(tmp)
+		if err != nil {
+			return err
+		}
+		edits := []edit{}
+		for _, e := range entries {
+			ss, ok := globalDefaults(e)
+			if !ok {
+				continue
+			}
+			changed := false
+			for i := range ss {
+				if ss[i].name == "timestamp_timeout" {
+					ss[i] = setting{"timestamp_timeout", "=", value}
+					changed = true
+				}
+			}
+			if changed {
+				edits = append(edits, edit{e, joinSettings(ss)})
+			}
+		}
+		if len(edits) > 0 {
+			return rewrite(tmp, edits)
+		}
+		return util.AppendFileAtomic(tmp, []byte("\nDefaults timestamp_timeout="+value+"\n"))
+	})
+}
+
+// EnvKeep lists variables added to env_keep by global Defaults lines.
+func EnvKeep() ([]string, error) {
+	entries, err := AllEntries()
+	if err != nil {
+		return nil, err
+	}
+	vars := []string{}
+	for _, e := range entries {
+		ss, _ := globalDefaults(e)
+		for _, s := range ss {
+			if s.name != "env_keep" {
+				continue
+			}
+			list := strings.Fields(strings.Trim(s.value, `"`))
+			switch s.op {
+			case "=":
+				vars = list
+			case "+=":
+				for _, v := range list {
+					if !containsStr(vars, v) {
+						vars = append(vars, v)
+					}
+				}
+			case "-=":
+				for _, v := range list {
+					vars = removeStr(vars, v)
+				}
+			case "!":
+				vars = []string{}
+			}
+		}
+	}
+	return vars, nil
+}
+
+// EnvKeepAdd appends `Defaults env_keep += "..."` for the variables not
+// already kept.
+func EnvKeepAdd(ctx context.Context, vars ...string) error {
+	for _, v := range vars {
+		if !envVarRe.MatchString(v) {
+			return fmt.Errorf("invalid environment variable name %q", v)
+		}
+	}
+	kept, err := EnvKeep()
+	if err != nil {
+		return err
+	}
+	add := []string{}
+	for _, v := range vars {
+		if !containsStr(kept, v) && !containsStr(add, v) {
+			add = append(add, v)
+		}
+	}
+	if len(add) == 0 {
+		return fmt.Errorf("%s already kept", strings.Join(vars, ", "))
+	}
+	line := fmt.Sprintf("\nDefaults env_keep += \"%s\"\n", strings.Join(add, " "))
+	return change(ctx, "env-keep add", "visudo validation failed", func(tmp string) error {
+		return util.AppendFileAtomic(tmp, []byte(line))
+	})
+}
+
+// EnvKeepRemove drops name from every global env_keep += setting in the
+// sudoers file. Settings left empty are removed.
+func EnvKeepRemove(ctx context.Context, name string) error {
+	return change(ctx, "env-keep remove", "visudo validation failed after removal", func(tmp string) error {
+		entries, err := parseFile(tmp)
+		if err != nil {
+			return err
+		}
+		edits := []edit{}
+		for _, e := range entries {
+			ss, ok := globalDefaults(e)
+			if !ok {
+				continue
+			}
+			keep := []setting{}
+			changed := false
+			for _, s := range ss {
+				if s.name == "env_keep" && (s.op == "+=" || s.op == "=") {
+					list := strings.Fields(strings.Trim(s.value, `"`))
+					if containsStr(list, name) {
+						changed = true
+						list = removeStr(list, name)
+						if len(list) == 0 {
+							continue
+						}
+						s.value = `"` + strings.Join(list, " ") + `"`
+					}
+				}
+				keep = append(keep, s)
+			}
+			switch {
+			case !changed:
+			case len(keep) == 0:
+				edits = append(edits, edit{e, ""})
+			default:
+				edits = append(edits, edit{e, joinSettings(keep)})
+			}
+		}
+		if len(edits) == 0 {
+			return fmt.Errorf("%s is not in env_keep in %s", name, SudoersPath())
+		}
+		return rewrite(tmp, edits)
+	})
+}
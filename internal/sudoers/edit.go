@@ -0,0 +1,102 @@
+package sudoers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/sysfile"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+var errorLineRe = regexp.MustCompile(`(?:near line |:)(\d+)(?::| <<<|$)`)
+
+// Edit opens a copy of the sudoers file in $VISUAL/$EDITOR. After each
+// save the copy is validated; on failure the editor is reopened at the
+// offending line, as visudo does. A backup is taken before anything is
+// applied, and other shctl runs wait until the editor is closed.
+func Edit(ctx context.Context) error {
+	orig := SudoersPath()
+	unlock, err := util.LockTarget(ctx, orig)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := Backup(ctx); err != nil {
+		return fmt.Errorf("backup before edit: %w", err)
+	}
+	tmp, err := util.CopyToTemp(orig)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	before, err := os.ReadFile(orig)
+	if err != nil {
+		return err
+	}
+
+	line := 0
+	for {
+		if err := runEditor(ctx, tmp, line); err != nil {
+			return err
+		}
+		after, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(before, after) {
+			fmt.Fprintln(prompt.Out, "no changes to", orig)
+			return nil
+		}
+		verr := visudoValidate(ctx, tmp)
+		if verr == nil {
+			return sysfile.Apply(ctx, "sudoers", "edit", tmp, orig, copyBack)
+		}
+		fmt.Fprintln(prompt.Out, verr)
+		again, err := prompt.Confirm("Edit again?")
+		if err != nil {
+			return err
+		}
+		if !again {
+			return fmt.Errorf("sudoers left unchanged: %w", verr)
+		}
+		line = errorLine(verr)
+	}
+}
+
+// errorLine extracts the first line number from a validation error.
+func errorLine(err error) int {
+	m := errorLineRe.FindStringSubmatch(err.Error())
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+func runEditor(ctx context.Context, path string, line int) error {
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+	args := strings.Fields(editor)
+	if line > 0 {
+		args = append(args, "+"+strconv.Itoa(line))
+	}
+	args = append(args, path)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("editor %s: %w", args[0], err)
+	}
+	return nil
+}
@@ -0,0 +1,397 @@
+package sudoers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/sysfile"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// GrantSpec describes a rule added with Grant.
+type GrantSpec struct {
+	Users       []string
+	Group       string   // granted as %group alongside Users
+	CreateGroup bool     // create Group (and other %groups) when missing
+	Hosts       []string // defaults to ALL
+	RunAs       string   // defaults to ALL
+	NoPasswd    bool
+	Commands    []string
+	TTL         time.Duration // zero means the grant never expires
+}
+
+// Rule renders the spec as a sudoers rule line.
+func (g GrantSpec) Rule() string {
+	e := Entry{Kind: KindRule, Users: g.principals(), Hosts: g.Hosts, RunAs: g.RunAs, Commands: g.Commands}
+	if len(e.Hosts) == 0 {
+		e.Hosts = []string{"ALL"}
+	}
+	if e.RunAs == "" {
+		e.RunAs = "ALL"
+	}
+	if g.NoPasswd {
+		e.Tags = []string{"NOPASSWD"}
+	}
+	return e.String()
+}
+
+// principals is Users plus Group in %group form.
+func (g GrantSpec) principals() []string {
+	users := append([]string{}, g.Users...)
+	if g.Group != "" {
+		users = append(users, "%"+strings.TrimPrefix(g.Group, "%"))
+	}
+	return users
+}
+
+func (g GrantSpec) validate() error {
+	if len(g.principals()) == 0 {
+		return errors.New("grant needs at least one user or group")
+	}
+	if len(g.Commands) == 0 {
+		return errors.New("grant needs at least one command")
+	}
+	for _, c := range g.Commands {
+		c = strings.TrimPrefix(strings.TrimSpace(c), "!")
+		if c != "ALL" && !strings.HasPrefix(c, "/") && !isAliasName(c) {
+			return fmt.Errorf("command %q must be an absolute path, ALL or a Cmnd_Alias", c)
+		}
+	}
+	return nil
+}
+
+// checkAliases makes sure every alias the spec refers to is defined.
+func (g GrantSpec) checkAliases() error {
+	all, err := Aliases()
+	if err != nil {
+		return err
+	}
+	check := func(kind string, items []string) error {
+		for _, it := range items {
+			it = strings.TrimPrefix(strings.TrimSpace(it), "!")
+			if it == "ALL" || !isAliasName(it) {
+				continue
+			}
+			if _, ok := all[kind][it]; !ok {
+				return fmt.Errorf("%s %s is not defined", kind, it)
+			}
+		}
+		return nil
+	}
+	if err := check("User_Alias", g.principals()); err != nil {
+		return err
+	}
+	if err := check("Host_Alias", g.Hosts); err != nil {
+		return err
+	}
+	return check("Cmnd_Alias", g.Commands)
+}
+
+// missingGroups returns the %groups in the spec that do not exist on this
+// system. They are an error unless CreateGroup is set.
+func (g GrantSpec) missingGroups() ([]string, error) {
+	var missing []string
+	for _, p := range g.principals() {
+		name, ok := strings.CutPrefix(strings.TrimPrefix(p, "!"), "%")
+		if !ok || strings.HasPrefix(name, "#") || strings.HasPrefix(name, ":") {
+			continue
+		}
+		_, err := user.LookupGroup(name)
+		var unknown user.UnknownGroupError
+		switch {
+		case err == nil:
+		case errors.As(err, &unknown) && g.CreateGroup:
+			missing = append(missing, name)
+		case errors.As(err, &unknown):
+			return nil, fmt.Errorf("group %s does not exist (create it first or use --create-group)", name)
+		default:
+			return nil, err
+		}
+	}
+	return missing, nil
+}
+
+// withGroups wraps write so the groups are created with groupadd only
+// once the change is confirmed, and removed again when writing the
+// sudoers file fails.
+func withGroups(groups []string, write sysfile.Writer) sysfile.Writer {
+	return func(ctx context.Context, tmp, dest string) error {
+		created := []string{}
+		undo := func() {
+			for _, name := range created {
+				if err := runCmd(ctx, "groupdel", name); err != nil {
+					logging.Warn("could not remove group created for the grant", "group", name, "err", err)
+				}
+			}
+		}
+		for _, name := range groups {
+			if err := runCmd(ctx, "groupadd", name); err != nil {
+				undo()
+				return fmt.Errorf("create group %s: %w", name, err)
+			}
+			created = append(created, name)
+		}
+		if err := write(ctx, tmp, dest); err != nil {
+			undo()
+			return err
+		}
+		return nil
+	}
+}
+
+func isAliasName(s string) bool {
+	if s == "" || s[0] < 'A' || s[0] > 'Z' {
+		return false
+	}
+	for _, r := range s {
+		if !(r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Grant adds the rule described by spec. Grants with a TTL are recorded
+// in the grants state file so Expire can revoke them later.
+func Grant(ctx context.Context, spec GrantSpec) error {
+	if err := spec.validate(); err != nil {
+		return err
+	}
+	if err := spec.checkAliases(); err != nil {
+		return err
+	}
+	groups, err := spec.missingGroups()
+	if err != nil {
+		return err
+	}
+	rule := spec.Rule()
+	if spec.TTL <= 0 {
+		return add(ctx, rule, withGroups(groups, copyBack))
+	}
+	unlock, err := util.LockTarget(ctx, grantsPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	grants, err := loadGrants()
+	if err != nil {
+		return err
+	}
+	if err := add(ctx, rule, withGroups(groups, copyBack)); err != nil {
+		return err
+	}
+	grants = append(grants, grantRecord{
+		File:    SudoersPath(),
+		Rule:    rule,
+		Expires: time.Now().Add(spec.TTL).UTC(),
+	})
+	if err := saveGrants(ctx, grants); err != nil {
+		// an unrecorded grant would never expire, so take it back
+		if rerr := revoke(ctx, "grant rollback", map[string]bool{rule: true}); rerr != nil {
+			return fmt.Errorf("record grant: %w; the rule %q was added and could not be removed: %v", err, rule, rerr)
+		}
+		return fmt.Errorf("record grant: %w", err)
+	}
+	return nil
+}
+
+type grantRecord struct {
+	File    string    `json:"file"`
+	Rule    string    `json:"rule"`
+	Expires time.Time `json:"expires"`
+}
+
+// grantsPath is where grants with a TTL are recorded. It is a system
+// path rather than the invoking user's state directory, so the reaper,
+// running as root, finds the grants any user made.
+func grantsPath() string {
+	return config.Get("grants_file")
+}
+
+func loadGrants() ([]grantRecord, error) {
+	b, err := fsys.Current.ReadFile(grantsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []grantRecord
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, fmt.Errorf("%s: %w", grantsPath(), err)
+	}
+	return out, nil
+}
+
+func saveGrants(ctx context.Context, grants []grantRecord) error {
+	b, err := json.MarshalIndent(grants, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := stage(append(b, '\n'))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return sysfile.WritePrivate(ctx, tmp, grantsPath())
+}
+
+// stage writes data to a temporary file for sysfile to put in place.
+func stage(data []byte) (string, error) {
+	f, err := os.CreateTemp("", "shctl_sudoers_*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), f.Close()
+}
+
+// Expire removes every recorded grant for the current sudoers file that
+// expired before now and returns the revoked rules.
+func Expire(ctx context.Context, now time.Time) ([]string, error) {
+	unlock, err := util.LockTarget(ctx, grantsPath())
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	grants, err := loadGrants()
+	if err != nil {
+		return nil, err
+	}
+	path := SudoersPath()
+	expired := map[string]bool{}
+	keep := []grantRecord{}
+	for _, g := range grants {
+		if g.File == path && !now.Before(g.Expires) {
+			expired[g.Rule] = true
+			continue
+		}
+		keep = append(keep, g)
+	}
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	if err := revoke(ctx, "expire", expired); err != nil {
+		return nil, err
+	}
+	revoked := make([]string, 0, len(expired))
+	for r := range expired {
+		revoked = append(revoked, r)
+	}
+	return revoked, saveGrants(ctx, keep)
+}
+
+// revoke removes the rules in the sudoers file that are in rules. Rules
+// that are already gone are not an error.
+func revoke(ctx context.Context, op string, rules map[string]bool) error {
+	entries, err := Entries()
+	if err != nil {
+		return err
+	}
+	present := false
+	for _, e := range entries {
+		if e.Kind == KindRule && rules[e.String()] {
+			present = true
+		}
+	}
+	if !present {
+		return nil
+	}
+	return change(ctx, op, "visudo validation failed after removal", func(tmp string) error {
+		entries, err := parseFile(tmp)
+		if err != nil {
+			return err
+		}
+		edits := []edit{}
+		for _, e := range entries {
+			if e.Kind == KindRule && rules[e.String()] {
+				edits = append(edits, edit{e, ""})
+			}
+		}
+		return rewrite(tmp, edits)
+	})
+}
+
+// ReaperCron returns an /etc/cron.d entry running `shctl sudoers expire`
+// every five minutes.
+func ReaperCron(bin string) string {
+	// cron turns an unescaped % into a newline
+	bin = strings.ReplaceAll(util.ShellQuote(bin), "%", `\%`)
+	return fmt.Sprintf("*/5 * * * * root %s sudoers expire --yes\n", bin)
+}
+
+// ReaperSystemd returns a oneshot service and a timer running
+// `shctl sudoers expire` every five minutes.
+func ReaperSystemd(bin string) (service, timer string) {
+	service = fmt.Sprintf(`[Unit]
+Description=Revoke expired shctl sudoers grants
+
+[Service]
+Type=oneshot
+ExecStart=%s sudoers expire --yes
+`, util.ExecQuote(bin))
+	timer = `[Unit]
+Description=Revoke expired shctl sudoers grants periodically
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec=5min
+
+[Install]
+WantedBy=timers.target
+`
+	return service, timer
+}
+
+// InstallReaper installs the reaper as a systemd timer (enabling it) or,
+// when systemd is false, as shctl-sudoers-expire in the cron.d directory.
+// The files go through the same diff, confirmation, backup and audit as
+// sudoers changes.
+func InstallReaper(ctx context.Context, bin string, systemd bool) error {
+	files := [][2]string{{filepath.Join(config.Get("cron_dir"), "shctl-sudoers-expire"), ReaperCron(bin)}}
+	if systemd {
+		service, timer := ReaperSystemd(bin)
+		dir := config.Get("systemd_dir")
+		files = [][2]string{
+			{filepath.Join(dir, "shctl-sudoers-expire.service"), service},
+			{filepath.Join(dir, "shctl-sudoers-expire.timer"), timer},
+		}
+	}
+	for _, f := range files {
+		if err := installFile(ctx, f[0], f[1]); err != nil {
+			return err
+		}
+	}
+	if !systemd {
+		return nil
+	}
+	return runCmd(ctx, "systemctl", "enable", "--now", "shctl-sudoers-expire.timer")
+}
+
+func installFile(ctx context.Context, dest, content string) error {
+	unlock, err := util.LockTarget(ctx, dest)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	tmp, err := stage([]byte(content))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return sysfile.Apply(ctx, "sudoers", "install reaper", tmp, dest, sysfile.Write)
+}
@@ -0,0 +1,181 @@
+package sudoers
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// maxIncludeDepth matches sudo's own nesting limit.
+const maxIncludeDepth = 128
+
+// AllEntries parses the sudoers file and every file it includes through
+// @include, @includedir, #include and #includedir, in the order sudo
+// reads them. Each entry's Source names the file it came from.
+func AllEntries() ([]Entry, error) {
+	seen := map[string]bool{}
+	return loadFile(SudoersPath(), 0, seen)
+}
+
+// Files returns the sudoers file followed by every file it includes. A
+// missing sudoers file gives none, and files the user may not read, such
+// as sudoers.d outside a root shell, are left out with a warning, so
+// snapshots and status still cover everything else.
+func Files() ([]string, error) {
+	files := []string{}
+	seen := map[string]bool{}
+	var walk func(path string, depth int) error
+	walk = func(path string, depth int) error {
+		if depth > maxIncludeDepth {
+			return fmt.Errorf("%s: includes nested too deeply", path)
+		}
+		if seen[path] {
+			return nil
+		}
+		seen[path] = true
+		entries, err := parseLive(path)
+		if skip(path, err) {
+			return nil
+		}
+		files = append(files, path)
+		for _, e := range entries {
+			if e.Kind != KindInclude {
+				continue
+			}
+			target, dir := includeTarget(path, e)
+			if target == "" {
+				continue
+			}
+			sub := []string{target}
+			if dir {
+				var err error
+				if sub, err = includeDirFiles(target); skip(target, err) {
+					continue
+				}
+			}
+			for _, f := range sub {
+				if err := walk(f, depth+1); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := walk(SudoersPath(), 0); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// skip reports whether err leaves path out of Files: a file that is not
+// there, or one the user may not read, which gets a warning. A file that
+// does not parse is still listed.
+func skip(path string, err error) bool {
+	if errors.Is(err, fs.ErrPermission) {
+		logging.Warn("sudoers: skipped", "file", path, "err", err)
+	}
+	return errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrPermission)
+}
+
+func loadFile(path string, depth int, seen map[string]bool) ([]Entry, error) {
+	if depth > maxIncludeDepth {
+		return nil, fmt.Errorf("%s: includes nested too deeply", path)
+	}
+	if seen[path] {
+		return nil, nil
+	}
+	seen[path] = true
+	entries, err := parseLive(path)
+	if err != nil {
+		return nil, err
+	}
+	out := []Entry{}
+	for _, e := range entries {
+		out = append(out, e)
+		if e.Kind != KindInclude {
+			continue
+		}
+		target, dir := includeTarget(path, e)
+		if target == "" {
+			continue
+		}
+		files := []string{target}
+		if dir {
+			if files, err = includeDirFiles(target); err != nil {
+				return nil, err
+			}
+		}
+		for _, f := range files {
+			sub, err := loadFile(f, depth+1, seen)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, e.Line, err)
+			}
+			out = append(out, sub...)
+		}
+	}
+	return out, nil
+}
+
+// includeDirFiles lists the files sudo reads from an include directory:
+// names containing a '.' or ending in '~' are skipped.
+func includeDirFiles(dir string) ([]string, error) {
+	ents, err := fsys.Current.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	out := []string{}
+	for _, d := range ents {
+		name := d.Name()
+		if d.IsDir() || strings.Contains(name, ".") || strings.HasSuffix(name, "~") {
+			continue
+		}
+		out = append(out, filepath.Join(dir, name))
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// includeTarget returns the path named by an include directive, resolved
+// relative to the including file, with %h expanded to the short hostname.
+func includeTarget(from string, e Entry) (path string, dir bool) {
+	// the old "#include" spelling would otherwise lex as a comment
+	fields, err := util.Sudoers.Words(strings.TrimPrefix(e.Raw, "#"))
+	if err != nil || len(fields) < 2 {
+		return "", false
+	}
+	dir = strings.HasSuffix(fields[0], "includedir")
+	path = strings.Join(fields[1:], " ")
+	if strings.Contains(path, "%h") {
+		host, _ := os.Hostname()
+		host, _, _ = strings.Cut(host, ".")
+		path = strings.ReplaceAll(path, "%h", host)
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from), path)
+	}
+	return path, dir
+}
+
+func includeDirs(entries []Entry) []string {
+	out := []string{}
+	for _, e := range entries {
+		if e.Kind != KindInclude {
+			continue
+		}
+		if p, dir := includeTarget(e.Source, e); dir {
+			out = append(out, p)
+		}
+	}
+	return out
+}
@@ -0,0 +1,290 @@
+package sudoers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+const (
+	KindRule     = "rule"
+	KindDefaults = "defaults"
+	KindAlias    = "alias"
+	KindInclude  = "include"
+	KindInvalid  = "invalid"
+)
+
+// Entry is one logical sudoers line (continuations joined).
+type Entry struct {
+	Kind    string `json:"kind"`
+	Source  string `json:"source"` // file the entry was read from
+	Line    int    `json:"line"`   // first physical line, 1-based
+	EndLine int    `json:"end_line"`
+	Raw     string `json:"raw"`
+	Err     string `json:"error,omitempty"` // why a KindInvalid entry failed to parse
+
+	// rule fields
+	Users    []string `json:"users,omitempty"`
+	Hosts    []string `json:"hosts,omitempty"`
+	RunAs    string   `json:"runas,omitempty"` // contents of the (...) runas spec, without parens
+	Tags     []string `json:"tags,omitempty"`
+	Commands []string `json:"commands,omitempty"`
+}
+
+var (
+	commaRe    = regexp.MustCompile(`\s*,\s*`)
+	aliasKinds = []string{"User_Alias", "Runas_Alias", "Host_Alias", "Cmnd_Alias", "Cmd_Alias"}
+	tagNames   = map[string]bool{
+		"NOPASSWD": true, "PASSWD": true, "NOEXEC": true, "EXEC": true,
+		"SETENV": true, "NOSETENV": true, "LOG_INPUT": true, "NOLOG_INPUT": true,
+		"LOG_OUTPUT": true, "NOLOG_OUTPUT": true, "MAIL": true, "NOMAIL": true,
+		"FOLLOW": true, "NOFOLLOW": true, "INTERCEPT": true, "NOINTERCEPT": true,
+	}
+)
+
+// Entries parses the current sudoers file.
+func Entries() ([]Entry, error) {
+	return parseLive(SudoersPath())
+}
+
+// Parse reads sudoers content. Lines it cannot make sense of are returned
+// with KindInvalid rather than failing the whole parse.
+func Parse(r io.Reader) ([]Entry, error) {
+	sc := bufio.NewScanner(r)
+	out := []Entry{}
+	n := 0
+	var cur strings.Builder
+	start := 0
+	for sc.Scan() {
+		n++
+		line := sc.Text()
+		if cur.Len() == 0 {
+			start = n
+		}
+		if strings.HasSuffix(line, "\\") {
+			cur.WriteString(strings.TrimSuffix(line, "\\"))
+			cur.WriteByte(' ')
+			continue
+		}
+		cur.WriteString(line)
+		logical := cur.String()
+		cur.Reset()
+		if e, ok := parseLine(logical); ok {
+			e.Line, e.EndLine = start, n
+			out = append(out, e)
+		}
+	}
+	if cur.Len() > 0 {
+		if e, ok := parseLine(cur.String()); ok {
+			e.Line, e.EndLine = start, n
+			out = append(out, e)
+		}
+	}
+	return out, sc.Err()
+}
+
+func parseLine(line string) (Entry, bool) {
+	s := strings.TrimSpace(line)
+	switch {
+	case s == "":
+		return Entry{}, false
+	case strings.HasPrefix(s, "#include") || strings.HasPrefix(s, "@include"):
+		return Entry{Kind: KindInclude, Raw: s}, true
+	case strings.HasPrefix(s, "#"):
+		return Entry{}, false
+	}
+	s = stripComment(s)
+	e := Entry{Raw: s}
+	first := strings.Fields(s)[0]
+	switch {
+	case first == "Defaults" || strings.HasPrefix(first, "Defaults:") || strings.HasPrefix(first, "Defaults@") ||
+		strings.HasPrefix(first, "Defaults>") || strings.HasPrefix(first, "Defaults!"):
+		e.Kind = KindDefaults
+		return e, true
+	case isAliasKind(first):
+		e.Kind = KindAlias
+		return e, true
+	}
+	if err := parseRule(s, &e); err != nil {
+		e.Kind, e.Err = KindInvalid, err.Error()
+		return e, true
+	}
+	e.Kind = KindRule
+	return e, true
+}
+
+func isAliasKind(word string) bool {
+	for _, k := range aliasKinds {
+		if word == k {
+			return true
+		}
+	}
+	return false
+}
+
+// stripComment drops a trailing comment; '#' followed by a digit is a
+// uid/gid reference, not a comment, and '#' inside quotes is literal.
+func stripComment(s string) string {
+	return util.Sudoers.StripComment(s)
+}
+
+func parseRule(s string, e *Entry) error {
+	eq := strings.Index(s, "=")
+	if eq < 0 {
+		return fmt.Errorf("missing '='")
+	}
+	left := strings.Fields(commaRe.ReplaceAllString(strings.TrimSpace(s[:eq]), ","))
+	if len(left) != 2 {
+		return fmt.Errorf("expected user and host lists before '='")
+	}
+	e.Users = strings.Split(left[0], ",")
+	e.Hosts = strings.Split(left[1], ",")
+
+	right := strings.TrimSpace(s[eq+1:])
+	if strings.HasPrefix(right, "(") {
+		end := strings.Index(right, ")")
+		if end < 0 {
+			return fmt.Errorf("unterminated runas spec")
+		}
+		e.RunAs = strings.TrimSpace(right[1:end])
+		right = strings.TrimSpace(right[end+1:])
+	}
+	for {
+		colon := strings.Index(right, ":")
+		if colon < 0 || !tagNames[strings.TrimSpace(right[:colon])] {
+			break
+		}
+		e.Tags = append(e.Tags, strings.TrimSpace(right[:colon]))
+		right = strings.TrimSpace(right[colon+1:])
+	}
+	e.Commands = splitCommands(right)
+	if len(e.Commands) == 0 {
+		return fmt.Errorf("missing command list")
+	}
+	return nil
+}
+
+// splitCommands splits a Cmnd_List on commas that are not escaped.
+func splitCommands(s string) []string {
+	out := []string{}
+	var cur strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\' && i+1 < len(s) && s[i+1] == ',':
+			cur.WriteString(`\,`)
+			i++
+		case s[i] == ',':
+			if c := strings.TrimSpace(cur.String()); c != "" {
+				out = append(out, c)
+			}
+			cur.Reset()
+		default:
+			cur.WriteByte(s[i])
+		}
+	}
+	if c := strings.TrimSpace(cur.String()); c != "" {
+		out = append(out, c)
+	}
+	return out
+}
+
+// String renders a rule entry back into sudoers syntax.
+func (e Entry) String() string {
+	if e.Kind != KindRule {
+		return e.Raw
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%s %s=", strings.Join(e.Users, ", "), strings.Join(e.Hosts, ", "))
+	if e.RunAs != "" {
+		fmt.Fprintf(&sb, "(%s) ", e.RunAs)
+	}
+	for _, t := range e.Tags {
+		sb.WriteString(t + ": ")
+	}
+	sb.WriteString(strings.Join(e.Commands, ", "))
+	return sb.String()
+}
+
+func normalizeCommand(c string) string {
+	return strings.Join(strings.Fields(c), " ")
+}
+
+// parseFile parses a file on the host, such as a staged copy.
+func parseFile(path string) ([]Entry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseNamed(path, f)
+}
+
+// parseLive parses a live sudoers file through the current file system.
+func parseLive(path string) ([]Entry, error) {
+	f, err := fsys.Current.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseNamed(path, f)
+}
+
+func parseNamed(path string, r io.Reader) ([]Entry, error) {
+	entries, err := Parse(r)
+	for i := range entries {
+		entries[i].Source = path
+	}
+	return entries, err
+}
+
+type edit struct {
+	entry Entry
+	text  string // replacement; empty drops the entry
+}
+
+// rewrite replaces the physical lines of each edited entry.
+func rewrite(path string, edits []edit) error {
+	drop := map[int]bool{}
+	repl := map[int]string{}
+	for _, ed := range edits {
+		for n := ed.entry.Line; n <= ed.entry.EndLine; n++ {
+			drop[n] = true
+		}
+		if ed.text != "" {
+			repl[ed.entry.Line] = ed.text
+			drop[ed.entry.Line] = false
+		}
+	}
+	_, err := util.EditLines(fsys.OS, path, func(n int, l string) (string, bool) {
+		if text, ok := repl[n]; ok {
+			return text, true
+		}
+		return l, !drop[n]
+	})
+	return err
+}
+
+func containsStr(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeStr(list []string, s string) []string {
+	out := []string{}
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -1,140 +1,534 @@
 package sudoers
 
 import (
-	"bufio"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"time"
 
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/escalate"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/sysfile"
+	"github.com/yourusername/shctl/internal/trash"
 	"github.com/yourusername/shctl/internal/util"
 )
 
-func getenv(key, def string) string {
-	if v := os.Getenv(key); v != "" {
-		return v
-	}
-	return def
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name:     "sudoers",
+		Files:    Files,
+		Validate: func(ctx context.Context, _, staged string) error { return visudoValidate(ctx, staged) },
+		Apply:    copyBack,
+	})
+	trash.Register("sudoers", restoreLines)
 }
 
 func SudoersPath() string {
-	if v := getenv("BASM_SUDOERS_PATH", ""); v != "" {
-		return v
-	}
-	return "/etc/sudoers"
+	return config.Get("sudoers_file")
 }
 
 func BackupDir() string {
-	if v := getenv("BASM_BACKUP_DIR", ""); v != "" {
-		return v
-	}
-	return "/tmp"
+	return config.Get("backup_dir")
 }
 
+// List prints every rule and setting from the sudoers file and the files
+// it includes, each prefixed with its source file and line.
 func List(w io.Writer) error {
-	f, err := os.Open(SudoersPath())
+	entries, err := AllEntries()
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	sc := bufio.NewScanner(f)
-	for sc.Scan() {
-		line := sc.Text()
-		s := strings.TrimSpace(line)
-		if s == "" || strings.HasPrefix(s, "#") {
+	return printEntries(w, entries)
+}
+
+func printEntries(w io.Writer, entries []Entry) error {
+	for _, e := range entries {
+		if e.Kind == KindInclude {
 			continue
 		}
-		if _, err := fmt.Fprintln(w, line); err != nil {
+		if _, err := fmt.Fprintf(w, "%s:%d: %s\n", e.Source, e.Line, e.Raw); err != nil {
 			return err
 		}
 	}
-	return sc.Err()
+	return nil
 }
 
-func Add(entry string) error {
-	orig := SudoersPath()
-	tmp, err := util.CopyToTemp(orig)
+// ListFormat is List with a --output format; json and yaml emit the
+// parsed entries as records. output.List narrows and orders the entries
+// by their text and source file.
+func ListFormat(w io.Writer, format string) error {
+	if !output.Structured(format) && output.List == (output.Query{}) {
+		return List(w)
+	}
+	all, err := AllEntries()
 	if err != nil {
 		return err
 	}
-	defer os.Remove(tmp)
+	entries := all[:0:0]
+	for _, e := range all {
+		if output.Structured(format) || e.Kind != KindInclude {
+			entries = append(entries, e)
+		}
+	}
+	idx, err := output.List.Apply(len(entries), func(i int) output.Key {
+		return output.Key{Name: entries[i].Raw, File: entries[i].Source}
+	})
+	if err != nil {
+		return err
+	}
+	out := make([]Entry, len(idx))
+	for i, j := range idx {
+		out[i] = entries[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, out)
+	}
+	return printEntries(w, out)
+}
+
+// Add appends entry to the sudoers file. An identical entry already in
+// it or its includes is reported as ErrEntryExists.
+func Add(ctx context.Context, entry string) error {
+	return add(ctx, entry, copyBack)
+}
 
-	if err := util.AppendFileAtomic(tmp, []byte("\n"+entry+"\n")); err != nil {
+// add is Add writing the sudoers file with write.
+func add(ctx context.Context, entry string, write sysfile.Writer) error {
+	entries, err := AllEntries()
+	if err != nil {
 		return err
 	}
-	if err := visudoValidate(tmp); err != nil {
-		return fmt.Errorf("visudo validation failed: %w", err)
+	for _, e := range entries {
+		if sameEntry(e.Raw, entry) {
+			return fmt.Errorf("%s: %w in %s", entry, ErrEntryExists, e.Source)
+		}
+	}
+	return changeWith(ctx, "add", "visudo validation failed", func(tmp string) error {
+		return util.AppendFileAtomic(tmp, []byte("\n"+entry+"\n"))
+	}, write)
+}
+
+// sameEntry reports whether two entries differ only in spacing.
+func sameEntry(a, b string) bool {
+	return strings.Join(strings.Fields(a), " ") == strings.Join(strings.Fields(b), " ")
+}
+
+// ListNumbered prints every entry with the number RemoveNumber accepts.
+func ListNumbered(w io.Writer) error {
+	entries, err := Entries()
+	if err != nil {
+		return err
 	}
-	return copyBack(tmp, orig)
+	for i, e := range entries {
+		if _, err := fmt.Fprintf(w, "%3d  %s\n", i+1, e.Raw); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove revokes user's rule for command. A rule that also names other
+// users or commands is rewritten without them instead of being dropped.
+// The revoked grant is kept in the trash.
+func Remove(ctx context.Context, user, command string) error {
+	command = normalizeCommand(command)
+	var removed []string
+	err := change(ctx, "remove", "visudo validation failed after removal", func(tmp string) error {
+		removed = nil
+		entries, err := parseFile(tmp)
+		if err != nil {
+			return err
+		}
+		edits := []edit{}
+		for _, e := range entries {
+			if e.Kind != KindRule || !containsStr(e.Users, user) {
+				continue
+			}
+			ci := -1
+			for i, c := range e.Commands {
+				if normalizeCommand(c) == command {
+					ci = i
+				}
+			}
+			if ci < 0 {
+				continue
+			}
+			gone := e
+			gone.Users, gone.Commands = []string{user}, []string{e.Commands[ci]}
+			removed = append(removed, gone.String())
+			switch {
+			case len(e.Users) > 1:
+				e.Users = removeStr(e.Users, user)
+				rest := e
+				rest.Users = []string{user}
+				rest.Commands = append(append([]string{}, e.Commands[:ci]...), e.Commands[ci+1:]...)
+				text := e.String()
+				if len(rest.Commands) > 0 {
+					text += "\n" + rest.String()
+				}
+				edits = append(edits, edit{e, text})
+			case len(e.Commands) > 1:
+				e.Commands = append(append([]string{}, e.Commands[:ci]...), e.Commands[ci+1:]...)
+				edits = append(edits, edit{e, e.String()})
+			default:
+				edits = append(edits, edit{e, ""})
+			}
+		}
+		if len(edits) == 0 {
+			return fmt.Errorf("no sudoers rule grants %s to %s", command, user)
+		}
+		return rewrite(tmp, edits)
+	})
+	return discard("remove", removed, err)
 }
 
-func Remove(pattern string) error {
+// RemoveNumber removes the entry numbered n by ListNumbered, keeping it
+// in the trash.
+func RemoveNumber(ctx context.Context, n int) error {
+	var removed []string
+	err := change(ctx, "remove", "visudo validation failed after removal", func(tmp string) error {
+		entries, err := parseFile(tmp)
+		if err != nil {
+			return err
+		}
+		if n < 1 || n > len(entries) {
+			return fmt.Errorf("no sudoers entry numbered %d", n)
+		}
+		removed = []string{entries[n-1].Raw}
+		return rewrite(tmp, []edit{{entries[n-1], ""}})
+	})
+	return discard("remove", removed, err)
+}
+
+// PickRemove lets the user choose entries to remove from a
+// fuzzy-searchable list, for `sudoers remove` without arguments, and
+// removes them, keeping them in the trash. Choosing nothing aborts.
+func PickRemove(ctx context.Context) error {
+	var removed []string
+	err := change(ctx, "remove", "visudo validation failed after removal", func(tmp string) error {
+		entries, err := parseFile(tmp)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			return util.NotFound("no sudoers entries to remove")
+		}
+		shown := make([]string, len(entries))
+		for i, e := range entries {
+			shown[i] = e.Raw
+		}
+		sel, err := prompt.Pick("Remove which entries?", shown)
+		if err != nil {
+			return err
+		}
+		if len(sel) == 0 {
+			return prompt.ErrAborted
+		}
+		removed = nil
+		edits := make([]edit, len(sel))
+		for i, n := range sel {
+			removed = append(removed, entries[n].Raw)
+			edits[i] = edit{entries[n], ""}
+		}
+		return rewrite(tmp, edits)
+	})
+	return discard("remove", removed, err)
+}
+
+// RemovePattern deletes lines containing pattern. Prefer Remove or
+// RemoveNumber; this is only for explicit --pattern use. The matching
+// lines are listed first and the user picks which ones go; they are kept
+// in the trash.
+func RemovePattern(ctx context.Context, pattern string) error {
+	var removed []string
+	err := change(ctx, "remove", "visudo validation failed after removal", func(tmp string) error {
+		matches := []int{}
+		shown := []string{}
+		if err := util.ScanLines(fsys.OS, tmp, func(n int, l string) {
+			if pattern != "" && strings.Contains(l, pattern) {
+				matches = append(matches, n)
+				shown = append(shown, fmt.Sprintf("line %d: %s", n, l))
+			}
+		}); err != nil {
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("no sudoers lines contain %q", pattern)
+		}
+		sel, err := prompt.Select("Remove which lines?", shown)
+		if err != nil {
+			return err
+		}
+		if len(sel) == 0 {
+			return prompt.ErrAborted
+		}
+		drop := map[int]bool{}
+		for _, i := range sel {
+			drop[matches[i]] = true
+		}
+		removed = nil
+		_, err = util.EditLines(fsys.OS, tmp, func(n int, l string) (string, bool) {
+			if drop[n] {
+				removed = append(removed, l)
+			}
+			return l, !drop[n]
+		})
+		return err
+	})
+	return discard("remove", removed, err)
+}
+
+// discard keeps the entries op removed in the trash once the change went
+// through; err is the change's result. Failing to keep them does not undo
+// the change, so it is only reported.
+func discard(op string, removed []string, err error) error {
+	if err != nil {
+		return err
+	}
+	if terr := trash.Put("sudoers", SudoersPath(), "sudoers "+op, removed); terr != nil {
+		output.Warn(prompt.Out, "trash: %v", terr)
+	}
+	return nil
+}
+
+// restoreLines appends entries from the trash back to the sudoers file.
+func restoreLines(ctx context.Context, path string, lines []string) error {
+	if path != SudoersPath() {
+		return fmt.Errorf("%s is no longer the sudoers file in use (%s)", path, SudoersPath())
+	}
+	return change(ctx, "trash restore", "visudo validation failed", func(tmp string) error {
+		return util.AppendFileAtomic(tmp, []byte("\n"+strings.Join(lines, "\n")+"\n"))
+	})
+}
+
+// change applies fn to a temporary copy of the sudoers file, validates
+// the copy and then applies it, holding the target lock throughout.
+func change(ctx context.Context, op, failMsg string, fn func(tmp string) error) error {
+	return changeWith(ctx, op, failMsg, fn, copyBack)
+}
+
+// changeWith is change writing the result with write.
+func changeWith(ctx context.Context, op, failMsg string, fn func(tmp string) error, write sysfile.Writer) error {
 	orig := SudoersPath()
+	unlock, err := util.LockTarget(ctx, orig)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	tmp, err := util.CopyToTemp(orig)
 	if err != nil {
 		return err
 	}
 	defer os.Remove(tmp)
+	logging.Info("sudoers file", "op", op, "path", orig, "tmp", tmp)
 
-	if err := util.RemoveLinesContaining(tmp, pattern); err != nil {
+	if err := fn(tmp); err != nil {
 		return err
 	}
-	if err := visudoValidate(tmp); err != nil {
-		return fmt.Errorf("visudo validation failed after removal: %w", err)
+	if err := visudoValidate(ctx, tmp); err != nil {
+		return fmt.Errorf("%s: %w", failMsg, err)
 	}
-	return copyBack(tmp, orig)
+	return sysfile.Apply(ctx, "sudoers", op, tmp, orig, write)
 }
 
-func Backup() error {
-	dir := BackupDir()
-	if err := os.MkdirAll(dir, 0o755); err != nil {
+func Backup(ctx context.Context) error {
+	_, err := backup.Save(ctx, SudoersPath())
+	return err
+}
+
+// PreviewRestore prints what Restore would do without changing anything.
+func PreviewRestore(ctx context.Context, w io.Writer) error {
+	store, err := backup.Default(ctx)
+	if err != nil {
 		return err
 	}
-	src := SudoersPath()
-	dst := filepath.Join(dir, filepath.Base(src)+".bak."+time.Now().Format("20060102_150405"))
-	return util.CopyFile(src, dst)
+	latest, err := backup.Latest(ctx, store, SudoersPath())
+	if err != nil {
+		return err
+	}
+	return backup.Preview(ctx, w, store, latest, SudoersPath())
 }
 
-func Restore() error {
-	dir := BackupDir()
-	pattern := filepath.Join(dir, filepath.Base(SudoersPath())+".bak.*")
-	matches, _ := filepath.Glob(pattern)
-	if len(matches) == 0 {
-		return fmt.Errorf("no sudoers backup found in %s", dir)
+func Restore(ctx context.Context) error {
+	unlock, err := util.LockTarget(ctx, SudoersPath())
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return err
+	}
+	latest, err := backup.Latest(ctx, store, SudoersPath())
+	if err != nil {
+		return err
+	}
+	if err := backup.Announce(prompt.Out, latest, SudoersPath()); err != nil {
+		return err
 	}
-	latest := util.LatestFile(matches)
 	// Validate before applying
-	tmp, err := util.CopyToTemp(latest)
+	tmp, err := backup.ExtractToTemp(ctx, store, latest)
 	if err != nil {
 		return err
 	}
 	defer os.Remove(tmp)
-	if err := visudoValidate(tmp); err != nil {
+	if err := visudoValidate(ctx, tmp); err != nil {
 		return fmt.Errorf("backup sudoers failed validation: %w", err)
 	}
-	return copyBack(tmp, SudoersPath())
+	return sysfile.Apply(ctx, "sudoers", "restore", tmp, SudoersPath(), copyBack)
+}
+
+// RequireVisudo makes validation fail when visudo is not installed
+// instead of falling back to the built-in checker.
+var RequireVisudo = false
+
+func visudoValidate(ctx context.Context, path string) error {
+	if _, err := exec.LookPath("visudo"); err != nil {
+		if RequireVisudo {
+			return fmt.Errorf("visudo not found and strict validation is required: %w", err)
+		}
+		if err := ValidateFile(path); err != nil {
+			return &ValidationError{Path: path, Output: err.Error(), Err: err}
+		}
+		return nil
+	}
+	if dryrun.Skip("visudo", "-c", "-f", path) {
+		// checked by the built-in parser instead
+		if err := ValidateFile(path); err != nil {
+			return &ValidationError{Path: path, Output: err.Error(), Err: err}
+		}
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "visudo", "-c", "-f", path)
+	logging.Debug("running", "argv", cmd.Args)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err != nil {
+		return &ValidationError{Path: path, Tool: "visudo", Output: strings.TrimSpace(string(out)), Err: err}
+	}
+	return nil
 }
 
-func visudoValidate(path string) error {
-	cmd := exec.Command("visudo", "-c", "-f", path)
+// runCmd runs a privileged command through the configured escalator. A
+// dry run only reports it.
+func runCmd(ctx context.Context, name string, args ...string) error {
+	cmd, err := escalate.Command(ctx, name, args...)
+	if err != nil {
+		return err
+	}
+	if dryrun.Skip(cmd.Args...) {
+		return nil
+	}
+	logging.Debug("running", "argv", cmd.Args)
 	out, err := cmd.CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("visudo: %s: %w", strings.TrimSpace(string(out)), err)
+		return fmt.Errorf("%s: %s: %w", name, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// Errors callers can match with errors.Is; see util for their meaning.
+var (
+	ErrAliasNotFound    = util.ErrAliasNotFound
+	ErrEntryExists      = util.ErrEntryExists
+	ErrValidationFailed = util.ErrValidationFailed
+	ErrPermission       = util.ErrPermission
+)
+
+// ValidationError carries visudo's output when it rejects a change.
+type ValidationError = util.ValidationError
+
+// NeedRootError is returned when the sudoers file cannot be replaced by
+// the current user. It matches ErrPermission.
+type NeedRootError struct {
+	Path string
+	Err  error
+}
+
+func (e *NeedRootError) Error() string {
+	return fmt.Sprintf("cannot write %s as uid %d (%v); re-run as root or configure an escalator", e.Path, os.Geteuid(), e.Err)
+}
+
+func (e *NeedRootError) Unwrap() error { return e.Err }
+
+func (e *NeedRootError) Is(target error) bool { return target == ErrPermission }
+
+// copyBack writes tmp over dest: as root by replacing it with writeRoot,
+// otherwise through sysfile.Copy.
+func copyBack(ctx context.Context, tmp, dest string) error {
+	if fsys.IsOS() && os.Geteuid() == 0 {
+		logging.Debug("replacing as root", "dest", dest)
+		return writeRoot(tmp, dest)
+	}
+	mode, err := config.SudoersMode(config.Get("sudoers_mode"))
+	if err != nil {
+		return err
+	}
+	if err := sysfile.Copy(ctx, tmp, dest, mode); errors.Is(err, util.ErrPermission) {
+		return &NeedRootError{Path: dest, Err: err}
+	} else if err != nil {
+		return err
 	}
 	return nil
 }
 
-func copyBack(tmp, dest string) error {
-	if dest == "/etc/sudoers" {
-		// use sudo cp so file ownership/permissions preserved
-		c := exec.Command("sudo", "cp", tmp, dest)
-		c.Stdout = os.Stdout
-		c.Stderr = os.Stderr
-		return c.Run()
+// writeRoot replaces dest with tmp's content, owned by root:root with the
+// sudoers_mode setting, by writing a sibling temp file and renaming it
+// into place. dest's extended attributes carry over and its SELinux label
+// is restored.
+func writeRoot(tmp, dest string) error {
+	mode, err := config.SudoersMode(config.Get("sudoers_mode"))
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".shctl-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chown(0, 0); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(dest); err == nil {
+		if err := util.CopyXattrs(dest, f.Name()); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(f.Name(), dest); err != nil {
+		return err
 	}
-	// normal copy
-	return util.CopyFile(tmp, dest)
+	return util.RestoreSecurityContext(dest)
 }
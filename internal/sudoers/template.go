@@ -0,0 +1,114 @@
+package sudoers
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Template is a named, reviewed rule set. Rules are text/template strings
+// rendered with the template parameters; "user" is always required and
+// may be a %group.
+type Template struct {
+	Name        string
+	Description string
+	Params      []string // required parameters besides user
+	Rules       []string
+}
+
+var Templates = map[string]Template{
+	"package-management": {
+		Name:        "package-management",
+		Description: "refresh package lists and upgrade the installed packages",
+		// no install: it also takes local package files, whose scripts run
+		// as root
+		Rules: []string{
+			"{{.user}} ALL=(root) /usr/bin/apt-get update, /usr/bin/apt-get upgrade, /usr/bin/apt-get upgrade -y",
+			"{{.user}} ALL=(root) /usr/bin/dnf check-update, /usr/bin/dnf upgrade, /usr/bin/dnf upgrade -y",
+		},
+	},
+	"service-restart": {
+		Name:        "service-restart",
+		Description: "restart, reload and inspect a single systemd service",
+		Params:      []string{"service"},
+		Rules: []string{
+			"{{.user}} ALL=(root) /usr/bin/systemctl restart {{.service}}, /usr/bin/systemctl reload {{.service}}, /usr/bin/systemctl status {{.service}}",
+		},
+	},
+	"log-reading": {
+		Name:        "log-reading",
+		Description: "read the system journal without a pager shell escape",
+		// a fixed list: a sudo * also matches / and .., so a path glob
+		// such as /var/log/* reaches any file
+		Rules: []string{
+			"{{.user}} ALL=(root) NOEXEC: /usr/bin/journalctl --no-pager, /usr/bin/journalctl --no-pager -b, /usr/bin/journalctl --no-pager -k, /usr/bin/journalctl --no-pager -p err",
+		},
+	},
+	"docker-admin": {
+		Name:        "docker-admin",
+		Description: "inspect and start/stop/restart containers without full docker access",
+		Rules: []string{
+			"{{.user}} ALL=(root) /usr/bin/docker ps, /usr/bin/docker ps *, /usr/bin/docker logs *, /usr/bin/docker start *, /usr/bin/docker stop *, /usr/bin/docker restart *",
+		},
+	},
+}
+
+var templateParamRe = regexp.MustCompile(`^%?[A-Za-z0-9_.@-]+$`)
+
+func TemplateNames() []string {
+	names := make([]string, 0, len(Templates))
+	for n := range Templates {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RenderTemplate returns the rules template name produces for params.
+func RenderTemplate(name string, params map[string]string) ([]string, error) {
+	t, ok := Templates[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown template %q (available: %s)", name, strings.Join(TemplateNames(), ", "))
+	}
+	for _, p := range append([]string{"user"}, t.Params...) {
+		v, ok := params[p]
+		if !ok || v == "" {
+			return nil, fmt.Errorf("template %s needs --%s", name, p)
+		}
+		// parameters land inside rules; refuse anything that could change
+		// their structure
+		if !templateParamRe.MatchString(v) {
+			return nil, fmt.Errorf("invalid value %q for %s", v, p)
+		}
+	}
+	rules := make([]string, 0, len(t.Rules))
+	for _, r := range t.Rules {
+		tt, err := template.New(name).Option("missingkey=error").Parse(r)
+		if err != nil {
+			return nil, err
+		}
+		var sb strings.Builder
+		if err := tt.Execute(&sb, params); err != nil {
+			return nil, err
+		}
+		rules = append(rules, sb.String())
+	}
+	return rules, nil
+}
+
+// ApplyTemplate adds every rule of the template as one validated change.
+func ApplyTemplate(ctx context.Context, name string, params map[string]string) error {
+	rules, err := RenderTemplate(name, params)
+	if err != nil {
+		return err
+	}
+	block := fmt.Sprintf("\n# shctl template %s\n%s\n", name, strings.Join(rules, "\n"))
+	return change(ctx, "template "+name, "visudo validation failed", func(tmp string) error {
+		return util.AppendFileAtomic(tmp, []byte(block))
+	})
+}
@@ -0,0 +1,97 @@
+package sudoers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var aliasDefRe = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+// ValidateFile checks a sudoers file against the common grammar subset
+// understood by Parse. It is a fallback for hosts without visudo and
+// catches obviously broken files rather than every error visudo would.
+func ValidateFile(path string) error {
+	entries, err := parseFile(path)
+	if err != nil {
+		return err
+	}
+	return errors.Join(validateEntries(entries, false)...)
+}
+
+// validateEntries checks parsed entries. Undefined Cmnd_Aliases are only
+// reported when every file the aliases could live in has been loaded,
+// i.e. when complete is set or there are no includes.
+func validateEntries(entries []Entry, complete bool) []error {
+	aliases := map[string]bool{}
+	hasIncludes := false
+	for _, e := range entries {
+		switch e.Kind {
+		case KindAlias:
+			_, defs := parseAliasDefs(e.Raw)
+			for name := range defs {
+				aliases[name] = true
+			}
+		case KindInclude:
+			hasIncludes = true
+		}
+	}
+
+	errs := []error{}
+	bad := func(e Entry, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s:%d: %s", e.Source, e.Line, fmt.Sprintf(format, args...)))
+	}
+	for _, e := range entries {
+		switch e.Kind {
+		case KindInvalid:
+			bad(e, "syntax error: %s", e.Err)
+		case KindInclude:
+			if len(strings.Fields(e.Raw)) < 2 {
+				bad(e, "include directive without a path")
+			}
+		case KindDefaults:
+			if len(strings.Fields(e.Raw)) < 2 {
+				bad(e, "Defaults without any setting")
+			}
+		case KindAlias:
+			_, rest, _ := strings.Cut(e.Raw, " ")
+			for _, def := range strings.Split(rest, ":") {
+				name, members, ok := strings.Cut(def, "=")
+				name = strings.TrimSpace(name)
+				switch {
+				case !ok:
+					bad(e, "alias definition %q is missing '='", strings.TrimSpace(def))
+				case !aliasDefRe.MatchString(name):
+					bad(e, "alias name %q must be upper case", name)
+				case len(splitCommands(members)) == 0:
+					bad(e, "alias %s has no members", name)
+				}
+			}
+		case KindRule:
+			if strings.Count(e.Raw, "(") != strings.Count(e.Raw, ")") {
+				bad(e, "unbalanced parentheses")
+			}
+			for _, c := range e.Commands {
+				_, c = commandTags(c)
+				c = strings.TrimSpace(strings.TrimLeft(c, "!"))
+				fields := strings.Fields(c)
+				if len(fields) == 0 {
+					bad(e, "syntax error: empty command")
+					continue
+				}
+				word := fields[0]
+				switch {
+				case word == "ALL" || word == "sudoedit" || strings.HasPrefix(word, "/"):
+				case isAliasName(word):
+					if !aliases[word] && (complete || !hasIncludes) {
+						bad(e, "Cmnd_Alias %s is used but not defined", word)
+					}
+				default:
+					bad(e, "command %q must be a fully qualified path", word)
+				}
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,97 @@
+package sysenv
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Var is one NAME=value line of the environment file.
+type Var struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Line  int    `json:"line"`
+}
+
+// parse reads the variables of an environment file the way pam_env does:
+// one NAME=value per line, with a pair of quotes around the whole value
+// removed and nothing expanded. A leading export, which pam_env skips, is
+// accepted but never written.
+func parse(data []byte) ([]Var, error) {
+	var out []Var
+	var errs []error
+	for i, l := range splitLines(data) {
+		t := strings.TrimSpace(l)
+		if t == "" || strings.HasPrefix(t, "#") {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(t, "export "); ok {
+			t = strings.TrimSpace(rest)
+		}
+		k, v, ok := strings.Cut(t, "=")
+		if !ok || !nameRe.MatchString(k) {
+			errs = append(errs, fmt.Errorf("line %d: want NAME=value", i+1))
+			continue
+		}
+		out = append(out, Var{Name: k, Value: unquote(v), Line: i + 1})
+	}
+	return out, errors.Join(errs...)
+}
+
+func unquote(v string) string {
+	if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// quote writes v so parse reads it back. pam_env knows no escapes, so a
+// value is quoted with whichever quote it does not hold, and one holding
+// both cannot be written.
+func quote(v string) (string, error) {
+	if strings.ContainsAny(v, "\n\r\x00") {
+		return "", errors.New("values are one line")
+	}
+	if v != "" && !strings.ContainsAny(v, " \t\"'#") {
+		return v, nil
+	}
+	switch {
+	case !strings.Contains(v, `"`):
+		return `"` + v + `"`, nil
+	case !strings.Contains(v, "'"):
+		return "'" + v + "'", nil
+	}
+	return "", errors.New("a value cannot hold both quote characters")
+}
+
+// ValidateFile checks that every line of an environment file is a
+// NAME=value assignment.
+func ValidateFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if _, err := parse(data); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
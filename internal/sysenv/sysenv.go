@@ -0,0 +1,239 @@
+// Package sysenv manages /etc/environment, which pam_env reads at login
+// for every session, graphical and non-interactive ones included. Unlike
+// an rc file it holds bare NAME=value lines: no export, no expansion, and
+// quotes only around a whole value. Changes go through the same diff,
+// confirm, backup and audit steps as the hosts file.
+package sysenv
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/sysfile"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name: "sysenv",
+		Files: func() ([]string, error) {
+			if _, err := fsys.Current.Stat(Path()); err != nil {
+				return nil, nil
+			}
+			return []string{Path()}, nil
+		},
+		Validate: func(_ context.Context, _, staged string) error {
+			return ValidateFile(staged)
+		},
+		Apply: sysfile.Write,
+	})
+	trash.Register("sysenv", restoreLines)
+}
+
+// Path is the environment file to manage.
+func Path() string {
+	return config.Get("environment_file")
+}
+
+// Vars returns the variables of the environment file in file order.
+func Vars() ([]Var, error) {
+	data, err := fsys.Current.ReadFile(Path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Var{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	vars, err := parse(data)
+	if vars == nil {
+		vars = []Var{}
+	}
+	if err != nil {
+		err = fmt.Errorf("%s: %w", Path(), err)
+	}
+	return vars, err
+}
+
+// List prints the variables as NAME=value, or as JSON or YAML records.
+// output.List narrows and orders them by name.
+func List(w io.Writer, format string) error {
+	all, err := Vars()
+	if err != nil {
+		if all == nil {
+			return err
+		}
+		output.Warn(prompt.Out, "%v", err)
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name, File: Path()}
+	})
+	if err != nil {
+		return err
+	}
+	vars := make([]Var, len(idx))
+	for i, j := range idx {
+		vars[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, vars)
+	}
+	for _, v := range vars {
+		if _, err := fmt.Fprintf(w, "%s=%s\n", output.Name(w, v.Name), output.Value(w, v.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Set sets name to value, replacing the line that sets it or appending
+// one. Later lines for the same name, which would win, are dropped.
+func Set(ctx context.Context, name, value string) error {
+	if !nameRe.MatchString(name) {
+		return fmt.Errorf("invalid variable name %q: %w", name, util.ErrUsage)
+	}
+	q, err := quote(value)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", name, err, util.ErrUsage)
+	}
+	return change(ctx, "set", func(lines []string, vars []Var) ([]string, error) {
+		return set(lines, vars, name, q), nil
+	})
+}
+
+// set puts name=q on the first line setting name, or at the end.
+func set(lines []string, vars []Var, name, q string) []string {
+	line, done := name+"="+q, false
+	drop := map[int]bool{}
+	for _, v := range vars {
+		if v.Name != name {
+			continue
+		}
+		if done {
+			drop[v.Line-1] = true
+			continue
+		}
+		lines[v.Line-1], done = line, true
+	}
+	if !done {
+		return append(lines, line)
+	}
+	out := lines[:0]
+	for i, l := range lines {
+		if !drop[i] {
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// Unset removes the lines setting names, keeping them in the trash. A
+// name the file does not set is reported as ErrNotFound.
+func Unset(ctx context.Context, names []string) error {
+	var removed []string
+	err := change(ctx, "unset", func(lines []string, vars []Var) ([]string, error) {
+		drop := map[int]bool{}
+		for _, n := range names {
+			found := false
+			for _, v := range vars {
+				if v.Name == n {
+					drop[v.Line-1], found = true, true
+					removed = append(removed, strings.TrimSpace(lines[v.Line-1]))
+				}
+			}
+			if !found {
+				return nil, util.NotFound(fmt.Sprintf("%s is not set in %s", n, Path()))
+			}
+		}
+		var out []string
+		for i, l := range lines {
+			if !drop[i] {
+				out = append(out, l)
+			}
+		}
+		return out, nil
+	})
+	if err != nil {
+		return err
+	}
+	if terr := trash.Put("sysenv", Path(), "sysenv unset", removed); terr != nil {
+		output.Warn(prompt.Out, "trash: %v", terr)
+	}
+	return nil
+}
+
+// restoreLines sets the variables of removed NAME=value lines again.
+func restoreLines(ctx context.Context, path string, removed []string) error {
+	if path != Path() {
+		return fmt.Errorf("%s is no longer the environment file in use (%s)", path, Path())
+	}
+	return change(ctx, "trash restore", func(lines []string, _ []Var) ([]string, error) {
+		for _, r := range removed {
+			vars, err := parse([]byte(r))
+			if err != nil || len(vars) != 1 {
+				return nil, fmt.Errorf("cannot restore %q", r)
+			}
+			cur, err := parse(joinLines(lines))
+			if err != nil {
+				return nil, err
+			}
+			q, err := quote(vars[0].Value)
+			if err != nil {
+				return nil, err
+			}
+			lines = set(lines, cur, vars[0].Name, q)
+		}
+		return lines, nil
+	})
+}
+
+// change lets fn rewrite the lines of the environment file, given its
+// variables, then validates the result and applies it.
+func change(ctx context.Context, op string, fn func(lines []string, vars []Var) ([]string, error)) error {
+	path := Path()
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	vars, _ := parse(data)
+	lines, err := fn(splitLines(data), vars)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp("", "shctl_environment_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(joinLines(lines)); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	logging.Info("environment file", "op", op, "path", path, "tmp", tmp.Name())
+	// a file already broken by hand is not shctl's to refuse
+	if _, err := parse(data); err == nil {
+		if err := ValidateFile(tmp.Name()); err != nil {
+			return fmt.Errorf("environment file validation failed: %w", err)
+		}
+	}
+	return sysfile.Apply(ctx, "sysenv", op, tmp.Name(), path, sysfile.Write)
+}
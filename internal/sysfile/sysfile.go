@@ -0,0 +1,176 @@
+// Package sysfile applies changes to system files such as /etc/sudoers or
+// /etc/hosts: it shows the diff, asks for confirmation, backs the file up,
+// writes it, going through the escalator when the current user cannot,
+// and records the outcome in the audit log.
+package sysfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/auditlog"
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/escalate"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Writer puts tmp's content at dest.
+type Writer func(ctx context.Context, tmp, dest string) error
+
+// Apply shows the change from dest to tmp as a unified diff and, once the
+// user confirms it, backs dest up and writes tmp over it with write.
+// subsystem names the change in the audit log and, with op, in the
+// backup.
+func Apply(ctx context.Context, subsystem, op, tmp, dest string, write Writer) error {
+	cur, err := fsys.Current.ReadFile(dest)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	next, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	diff := util.UnifiedDiff(dest, dest+" (proposed)", cur, next)
+	if diff == "" {
+		fmt.Fprintln(prompt.Out, "no changes to", dest)
+		return nil
+	}
+	return Confirm(ctx, subsystem, op, dest, diff, "Apply these changes to "+dest+"?", func() error {
+		return write(ctx, tmp, dest)
+	})
+}
+
+// Confirm shows diff and asks question; once the user agrees it backs
+// dest up and runs do. The outcome, a refusal included, goes to the audit
+// log.
+func Confirm(ctx context.Context, subsystem, op, dest, diff, question string, do func() error) error {
+	output.WriteDiff(prompt.Out, diff)
+	ok, err := prompt.Confirm(question)
+	if err != nil {
+		return err
+	}
+	rec := auditlog.New(subsystem, dest, diff)
+	if !ok {
+		audit(&rec, nil, true)
+		return prompt.ErrAborted
+	}
+	if err := backup.AutoSave(ctx, dest, subsystem+" "+op); err != nil {
+		return err
+	}
+	err = do()
+	audit(&rec, err, false)
+	return err
+}
+
+// audit sends the record of a change to the system log.
+func audit(rec *auditlog.Record, err error, aborted bool) {
+	if lerr := rec.Finish(err, aborted); lerr != nil {
+		output.Warn(prompt.Out, "audit log: %v", lerr)
+	}
+	logging.Debug("audit", "record", rec.Message())
+}
+
+// Write puts tmp's content at dest as a root-owned 0644 file, going
+// through the escalator when the current user cannot. An existing file is
+// overwritten with cp, which keeps its owner and mode and works on a bind
+// mount.
+func Write(ctx context.Context, tmp, dest string) error {
+	return install(ctx, tmp, dest, 0o644)
+}
+
+// WritePrivate is Write for state only root may read: a new dest is
+// created 0600, along with its directory.
+func WritePrivate(ctx context.Context, tmp, dest string) error {
+	return install(ctx, tmp, dest, 0o600)
+}
+
+func install(ctx context.Context, tmp, dest string, mode fs.FileMode) error {
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	argv := []string{"cp", tmp, dest}
+	if _, err := fsys.Current.Stat(dest); errors.Is(err, fs.ErrNotExist) {
+		argv = []string{"install", "-D", "-m", fmt.Sprintf("%04o", mode), "-o", "root", "-g", "root", tmp, dest}
+	}
+	if !fsys.IsOS() || os.Geteuid() == 0 {
+		if !fsys.IsOS() && os.Geteuid() != 0 {
+			if esc, err := escalate.Get(); err == nil && esc != escalate.None {
+				dryrun.Skip(esc.Command(ctx, argv[0], argv[1:]...).Args...)
+			}
+		}
+		if err := fsys.Current.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+			return err
+		}
+		return fsys.Current.WriteFile(dest, data, mode)
+	}
+	esc, err := escalate.Get()
+	if err != nil {
+		return err
+	}
+	if esc == escalate.None {
+		return fmt.Errorf("cannot write %s as uid %d; re-run as root or configure an escalator: %w", dest, os.Geteuid(), util.ErrPermission)
+	}
+	out, err := esc.Command(ctx, argv[0], argv[1:]...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s %s: %s: %w", esc.Name(), argv[0], strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+// Copy writes tmp over the existing file dest, going through the
+// escalator when dest is not writable. Copying onto the file keeps its
+// owner and mode; mode only applies to a dry run's overlay.
+func Copy(ctx context.Context, tmp, dest string, mode fs.FileMode) error {
+	if !fsys.IsOS() {
+		data, err := os.ReadFile(tmp)
+		if err != nil {
+			return err
+		}
+		planCopy(ctx, tmp, dest)
+		return fsys.Current.WriteFile(dest, data, mode)
+	}
+	f, err := os.OpenFile(dest, os.O_WRONLY, 0)
+	if err == nil {
+		f.Close()
+		return util.CopyFile(tmp, dest)
+	}
+	esc, eerr := escalate.Get()
+	if eerr != nil {
+		return eerr
+	}
+	if esc == escalate.None {
+		return fmt.Errorf("cannot write %s (%v); re-run as root or configure an escalator: %w", dest, err, util.ErrPermission)
+	}
+	cmd := esc.Command(ctx, "cp", tmp, dest)
+	logging.Debug("running", "argv", cmd.Args)
+	if out, cerr := cmd.CombinedOutput(); cerr != nil {
+		return fmt.Errorf("%s cp: %s: %w", esc.Name(), strings.TrimSpace(string(out)), cerr)
+	}
+	return nil
+}
+
+// planCopy reports to a dry run the escalated cp a real run would use to
+// write tmp over dest.
+func planCopy(ctx context.Context, tmp, dest string) {
+	if os.Geteuid() == 0 {
+		return
+	}
+	if f, err := os.OpenFile(dest, os.O_WRONLY, 0); err == nil {
+		f.Close()
+		return
+	}
+	if esc, err := escalate.Get(); err == nil && esc != escalate.None {
+		dryrun.Skip(esc.Command(ctx, "cp", tmp, dest).Args...)
+	}
+}
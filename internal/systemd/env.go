@@ -0,0 +1,571 @@
+// Package systemd manages the environment of systemd units through
+// drop-ins: Environment= lines in <unit>.d/override.conf, or a shctl.env
+// file next to it that the override loads with EnvironmentFile=. It is
+// the systemd counterpart of the rc file's exports.
+package systemd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/escalate"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/sysfile"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name:  "systemd",
+		Files: files,
+		Validate: func(_ context.Context, path, staged string) error {
+			return validate(path, staged)
+		},
+		Apply: func(ctx context.Context, staged, path string) error {
+			if err := write(ctx, staged, path); err != nil {
+				return err
+			}
+			return daemonReload(ctx)
+		},
+	})
+	trash.Register("systemd", restoreLines)
+}
+
+const (
+	overrideName = "override.conf"
+	envFileName  = "shctl.env"
+)
+
+// sections maps the unit types that take Environment= to the section it
+// goes in.
+var sections = map[string]string{
+	"service": "Service",
+	"socket":  "Socket",
+	"mount":   "Mount",
+	"swap":    "Swap",
+}
+
+var unitRe = regexp.MustCompile(`^[A-Za-z0-9:_.\\@-]+$`)
+
+// Dir is the directory holding the unit drop-ins.
+func Dir() string {
+	return config.Get("systemd_dir")
+}
+
+// UnitName completes unit with .service when it has no type, as
+// systemctl does, and checks that its type takes an environment.
+func UnitName(unit string) (string, error) {
+	if !unitRe.MatchString(unit) {
+		return "", fmt.Errorf("invalid unit name %q: %w", unit, util.ErrUsage)
+	}
+	if !strings.Contains(unit, ".") {
+		unit += ".service"
+	}
+	typ := unit[strings.LastIndex(unit, ".")+1:]
+	if _, ok := sections[typ]; !ok {
+		return "", fmt.Errorf("%s units have no environment (want service, socket, mount or swap): %w", typ, util.ErrUsage)
+	}
+	return unit, nil
+}
+
+// OverridePath is the drop-in shctl edits for unit.
+func OverridePath(unit string) string {
+	return filepath.Join(Dir(), unit+".d", overrideName)
+}
+
+// EnvFilePath is the environment file shctl keeps for unit.
+func EnvFilePath(unit string) string {
+	return filepath.Join(Dir(), unit+".d", envFileName)
+}
+
+func section(unit string) string {
+	return sections[unit[strings.LastIndex(unit, ".")+1:]]
+}
+
+// files lists every override and environment file in Dir.
+func files() ([]string, error) {
+	units, err := Units()
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, u := range units {
+		for _, p := range []string{OverridePath(u), EnvFilePath(u)} {
+			if _, err := fsys.Current.Stat(p); err == nil {
+				out = append(out, p)
+			}
+		}
+	}
+	return out, nil
+}
+
+// Units returns the units with an override or environment file, sorted.
+func Units() ([]string, error) {
+	entries, err := fsys.Current.ReadDir(Dir())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		unit, ok := strings.CutSuffix(e.Name(), ".d")
+		if !ok || !e.IsDir() {
+			continue
+		}
+		if _, err := UnitName(unit); err != nil || !strings.Contains(unit, ".") {
+			continue
+		}
+		for _, name := range []string{overrideName, envFileName} {
+			if _, err := fsys.Current.Stat(filepath.Join(Dir(), e.Name(), name)); err == nil {
+				out = append(out, unit)
+				break
+			}
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// Vars returns the variables the drop-ins set for unit, or for every unit
+// when unit is empty, in file order.
+func Vars(unit string) ([]Var, error) {
+	units := []string{unit}
+	if unit == "" {
+		var err error
+		if units, err = Units(); err != nil {
+			return nil, err
+		}
+	} else if u, err := UnitName(unit); err != nil {
+		return nil, err
+	} else {
+		units[0] = u
+	}
+	out := []Var{}
+	for _, u := range units {
+		vars, err := unitVars(u)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, vars...)
+	}
+	return out, nil
+}
+
+func unitVars(unit string) ([]Var, error) {
+	var out []Var
+	path := OverridePath(unit)
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	settings, _ := parseUnit(data)
+	for _, s := range settings {
+		if s.section != section(unit) || s.key != "Environment" {
+			continue
+		}
+		words, _ := splitWords(s.value)
+		for _, w := range words {
+			if k, v, ok := strings.Cut(w, "="); ok {
+				out = append(out, Var{Unit: unit, Name: k, Value: v, Source: path, Line: s.start})
+			}
+		}
+	}
+	path = EnvFilePath(unit)
+	data, err = fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+	_, vars, _ := parseEnvFile(data)
+	for _, v := range vars {
+		v.Unit, v.Source = unit, path
+		out = append(out, v)
+	}
+	return out, nil
+}
+
+// List prints the variables set for unit, or every unit when it is
+// empty, as "unit NAME=value", or as JSON or YAML records. output.List
+// narrows and orders them by name and file.
+func List(w io.Writer, format, unit string) error {
+	all, err := Vars(unit)
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name, File: all[i].Source}
+	})
+	if err != nil {
+		return err
+	}
+	vars := make([]Var, len(idx))
+	for i, j := range idx {
+		vars[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, vars)
+	}
+	for _, v := range vars {
+		if _, err := fmt.Fprintf(w, "%s %s=%s\n", v.Unit, output.Name(w, v.Name), output.Value(w, v.Value)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseAssignments splits NAME=VALUE arguments.
+func parseAssignments(assignments []string) ([][2]string, error) {
+	out := make([][2]string, len(assignments))
+	for i, a := range assignments {
+		k, v, ok := strings.Cut(a, "=")
+		if !ok || !nameRe.MatchString(k) {
+			return nil, fmt.Errorf("%q is not NAME=VALUE: %w", a, util.ErrUsage)
+		}
+		out[i] = [2]string{k, v}
+	}
+	return out, nil
+}
+
+// Set sets NAME=VALUE assignments for unit, replacing earlier values,
+// then reloads systemd. They go in Environment= lines of the override,
+// or with envFile in the unit's environment file, which the override is
+// made to load.
+func Set(ctx context.Context, unit string, assignments []string, envFile bool) error {
+	unit, err := UnitName(unit)
+	if err != nil {
+		return err
+	}
+	vars, err := parseAssignments(assignments)
+	if err != nil {
+		return err
+	}
+	if len(vars) == 0 {
+		return fmt.Errorf("nothing to set: %w", util.ErrUsage)
+	}
+	if !envFile {
+		names := map[string]bool{}
+		var add []string
+		for _, v := range vars {
+			names[v[0]] = true
+			add = append(add, "Environment="+quoteAssignment(v[0], v[1]))
+		}
+		err = change(ctx, OverridePath(unit), "env set", func(data []byte) ([]byte, error) {
+			out, _ := editOverride(data, section(unit), names, add)
+			return out, nil
+		})
+		return reload(ctx, unit, err)
+	}
+
+	load := "EnvironmentFile=-" + EnvFilePath(unit)
+	err = change(ctx, OverridePath(unit), "env set", func(data []byte) ([]byte, error) {
+		settings, _ := parseUnit(data)
+		for _, s := range settings {
+			if s.section == section(unit) && s.key == "EnvironmentFile" && strings.TrimPrefix(s.value, "-") == EnvFilePath(unit) {
+				return data, nil
+			}
+		}
+		out, _ := editOverride(data, section(unit), nil, []string{load})
+		return out, nil
+	})
+	if err != nil {
+		return err
+	}
+	err = change(ctx, EnvFilePath(unit), "env set", func(data []byte) ([]byte, error) {
+		lines, _, _ := parseEnvFile(data)
+		text := splitLines(data)
+		var add []string
+		for _, v := range vars {
+			if n, ok := lines[v[0]]; ok {
+				text[n-1] = quoteEnvFile(v[0], v[1])
+			} else {
+				add = append(add, quoteEnvFile(v[0], v[1]))
+			}
+		}
+		return joinLines(append(text, add...)), nil
+	})
+	return reload(ctx, unit, err)
+}
+
+// Remove unsets names for unit in both its override and environment
+// file, keeping the removed lines in the trash, then reloads systemd.
+func Remove(ctx context.Context, unit string, names []string) error {
+	unit, err := UnitName(unit)
+	if err != nil {
+		return err
+	}
+	drop := map[string]bool{}
+	for _, n := range names {
+		drop[n] = true
+	}
+	found := map[string]bool{}
+	vars, err := unitVars(unit)
+	if err != nil {
+		return err
+	}
+	for _, v := range vars {
+		found[v.Name] = true
+	}
+	for _, n := range names {
+		if !found[n] {
+			return util.NotFound(fmt.Sprintf("%s sets no %s", unit, n))
+		}
+	}
+	changed := false
+	for _, path := range []string{OverridePath(unit), EnvFilePath(unit)} {
+		var removed []string
+		err := change(ctx, path, "env remove", func(data []byte) ([]byte, error) {
+			var out []byte
+			if path == OverridePath(unit) {
+				out, removed = editOverride(data, section(unit), drop, nil)
+				return out, nil
+			}
+			removed = nil
+			lines, _, _ := parseEnvFile(data)
+			text := splitLines(data)
+			gone := map[int]bool{}
+			for name, n := range lines {
+				if drop[name] {
+					gone[n] = true
+					removed = append(removed, text[n-1])
+				}
+			}
+			var keep []string
+			for i, l := range text {
+				if !gone[i+1] {
+					keep = append(keep, l)
+				}
+			}
+			return joinLines(keep), nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(removed) > 0 {
+			changed = true
+			if terr := trash.Put("systemd", path, "systemd env remove", removed); terr != nil {
+				output.Warn(prompt.Out, "trash: %v", terr)
+			}
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return reload(ctx, unit, nil)
+}
+
+// restoreLines puts removed lines back: Environment= lines into the
+// override, assignments into the environment file.
+func restoreLines(ctx context.Context, path string, lines []string) error {
+	unit := strings.TrimSuffix(filepath.Base(filepath.Dir(path)), ".d")
+	if _, err := UnitName(unit); err != nil {
+		return err
+	}
+	err := change(ctx, path, "trash restore", func(data []byte) ([]byte, error) {
+		if filepath.Base(path) == envFileName {
+			return joinLines(append(splitLines(data), lines...)), nil
+		}
+		out, _ := editOverride(data, section(unit), nil, lines)
+		return out, nil
+	})
+	return reload(ctx, unit, err)
+}
+
+// editOverride removes the assignments of drop from the Environment=
+// lines of section and appends add at the end of the section, creating
+// it when missing. It returns the new content and the removed
+// assignments as Environment= lines.
+func editOverride(data []byte, section string, drop map[string]bool, add []string) ([]byte, []string) {
+	text := splitLines(data)
+	settings, _ := parseUnit(data)
+	var removed []string
+	// rewrite from the bottom so earlier line numbers stay valid
+	for i := len(settings) - 1; i >= 0; i-- {
+		s := settings[i]
+		if s.section != section || s.key != "Environment" {
+			continue
+		}
+		words, err := splitWords(s.value)
+		if err != nil {
+			continue
+		}
+		var keep []string
+		for _, w := range words {
+			k, v, _ := strings.Cut(w, "=")
+			if drop[k] {
+				removed = append(removed, "Environment="+quoteAssignment(k, v))
+				continue
+			}
+			keep = append(keep, quoteAssignment(k, v))
+		}
+		if len(keep) == len(words) {
+			continue
+		}
+		var repl []string
+		if len(keep) > 0 {
+			repl = []string{"Environment=" + strings.Join(keep, " ")}
+		}
+		text = append(text[:s.start-1], append(repl, text[s.end:]...)...)
+	}
+	if len(add) > 0 {
+		text = insertInSection(text, section, add)
+	}
+	return joinLines(text), removed
+}
+
+// insertInSection adds lines after the last setting of section, or in a
+// new section at the end.
+func insertInSection(text []string, section string, lines []string) []string {
+	at, in := -1, false
+	for i, l := range text {
+		t := strings.TrimSpace(l)
+		if strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]") {
+			in = t == "["+section+"]"
+			if in {
+				at = i + 1
+			}
+			continue
+		}
+		if in && t != "" && !strings.HasPrefix(t, "#") && !strings.HasPrefix(t, ";") {
+			at = i + 1
+		}
+	}
+	if at < 0 {
+		if len(text) > 0 && strings.TrimSpace(text[len(text)-1]) != "" {
+			text = append(text, "")
+		}
+		return append(append(text, "["+section+"]"), lines...)
+	}
+	return append(text[:at], append(append([]string{}, lines...), text[at:]...)...)
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// change lets fn rewrite path, validates the result, shows it as a diff
+// and writes it once confirmed, backing path up first. Content that fn
+// leaves as it was is not written.
+func change(ctx context.Context, path, op string, fn func(data []byte) ([]byte, error)) error {
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	cur, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	next, err := fn(cur)
+	if err != nil {
+		return err
+	}
+	if string(next) == string(cur) {
+		return nil
+	}
+	tmp, err := os.CreateTemp("", "shctl_systemd_*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(next); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	logging.Info("systemd drop-in", "op", op, "path", path, "tmp", tmp.Name())
+	if err := validate(path, tmp.Name()); err != nil {
+		return fmt.Errorf("drop-in validation failed: %w", err)
+	}
+	diff := util.UnifiedDiff(path, path+" (proposed)", cur, next)
+	return sysfile.Confirm(ctx, "systemd", op, path, diff, "Apply these changes to "+path+"?", func() error {
+		return write(ctx, tmp.Name(), path)
+	})
+}
+
+// write puts tmp's content at dest, creating the drop-in directory, and
+// goes through the escalator when the current user may not.
+func write(ctx context.Context, tmp, dest string) error {
+	data, err := os.ReadFile(tmp)
+	if err != nil {
+		return err
+	}
+	err = fsys.Current.MkdirAll(filepath.Dir(dest), 0o755)
+	if err == nil {
+		err = fsys.Current.WriteFile(dest, data, 0o644)
+	}
+	if !errors.Is(err, fs.ErrPermission) {
+		return err
+	}
+	cmd, eerr := escalate.Command(ctx, "install", "-D", "-m", "0644", tmp, dest)
+	if eerr != nil {
+		return eerr
+	}
+	logging.Debug("running", "argv", cmd.Args)
+	if out, cerr := cmd.CombinedOutput(); cerr != nil {
+		return fmt.Errorf("cannot write %s (%v); install: %s: %w", dest, err, strings.TrimSpace(string(out)), cerr)
+	}
+	return nil
+}
+
+// reload runs daemon-reload after a change that went through, so the next
+// start of unit sees it, and reminds the user that a running unit keeps
+// its old environment until restarted.
+func reload(ctx context.Context, unit string, err error) error {
+	if err != nil {
+		return err
+	}
+	if err := daemonReload(ctx); err != nil {
+		return err
+	}
+	fmt.Fprintf(prompt.Out, "restart %s for running processes to see the change\n", unit)
+	return nil
+}
+
+func daemonReload(ctx context.Context) error {
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		output.Warn(prompt.Out, "systemctl not found; run systemctl daemon-reload where systemd runs")
+		return nil
+	}
+	cmd, err := escalate.Command(ctx, "systemctl", "daemon-reload")
+	if err != nil {
+		return err
+	}
+	if dryrun.Skip(cmd.Args...) {
+		return nil
+	}
+	logging.Debug("running", "argv", cmd.Args)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("systemctl daemon-reload: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package systemd
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Var is one environment variable set for a unit by a drop-in.
+type Var struct {
+	Unit   string `json:"unit"`
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"` // the override or environment file
+	Line   int    `json:"line"`
+}
+
+var nameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// setting is one Key=value line of a unit file, continuation lines
+// joined, with the section it is in.
+type setting struct {
+	section    string
+	key, value string
+	start, end int // physical lines, 1-based
+}
+
+// parseUnit reads the settings of a unit file or drop-in. Lines that are
+// neither sections, comments nor settings are errors.
+func parseUnit(data []byte) ([]setting, error) {
+	var out []setting
+	var errs []error
+	section := ""
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(make([]byte, 64*1024), 1<<20)
+	var cur *setting
+	for n := 1; sc.Scan(); n++ {
+		line := sc.Text()
+		if cur != nil {
+			cur.end = n
+			cont := strings.HasSuffix(line, "\\")
+			cur.value += " " + strings.TrimSpace(strings.TrimSuffix(line, "\\"))
+			if !cont {
+				out, cur = append(out, *cur), nil
+			}
+			continue
+		}
+		t := strings.TrimSpace(line)
+		switch {
+		case t == "" || strings.HasPrefix(t, "#") || strings.HasPrefix(t, ";"):
+		case strings.HasPrefix(t, "[") && strings.HasSuffix(t, "]"):
+			section = t[1 : len(t)-1]
+		default:
+			k, v, ok := strings.Cut(t, "=")
+			if !ok || strings.TrimSpace(k) == "" {
+				errs = append(errs, fmt.Errorf("line %d: not a section or Key=value setting", n))
+				continue
+			}
+			if section == "" {
+				errs = append(errs, fmt.Errorf("line %d: %s is outside any section", n, strings.TrimSpace(k)))
+			}
+			s := setting{section, strings.TrimSpace(k), strings.TrimSpace(v), n, n}
+			if strings.HasSuffix(s.value, "\\") {
+				s.value = strings.TrimSpace(strings.TrimSuffix(s.value, "\\"))
+				cur = &s
+				continue
+			}
+			out = append(out, s)
+		}
+	}
+	if cur != nil {
+		out = append(out, *cur)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out, errors.Join(errs...)
+}
+
+// splitWords splits an Environment= value into its assignments, as
+// systemd does: by whitespace, with double and single quotes and
+// backslash escapes. "%%" stands for a percent sign.
+func splitWords(s string) ([]string, error) {
+	var out []string
+	var sb strings.Builder
+	in, quote := false, byte(0)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '\\' && i+1 < len(s):
+			i++
+			sb.WriteByte(unescape(s[i]))
+			in = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			sb.WriteByte(c)
+		case c == '"' || c == '\'':
+			quote, in = c, true
+		case c == ' ' || c == '\t':
+			if in {
+				out = append(out, sb.String())
+				sb.Reset()
+				in = false
+			}
+		default:
+			sb.WriteByte(c)
+			in = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote in %q", s)
+	}
+	if in {
+		out = append(out, sb.String())
+	}
+	for i, w := range out {
+		out[i] = strings.ReplaceAll(w, "%%", "%")
+	}
+	return out, nil
+}
+
+func unescape(c byte) byte {
+	switch c {
+	case 'n':
+		return '\n'
+	case 't':
+		return '\t'
+	}
+	return c
+}
+
+// quoteAssignment renders NAME=value for an Environment= line.
+func quoteAssignment(name, value string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "%", "%%")
+	return `"` + r.Replace(name+"="+value) + `"`
+}
+
+// parseEnvFile reads the KEY=value lines of an EnvironmentFile.
+func parseEnvFile(data []byte) (map[string]int, []Var, error) {
+	lines := map[string]int{}
+	var vars []Var
+	var errs []error
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		t := strings.TrimSpace(sc.Text())
+		if t == "" || strings.HasPrefix(t, "#") || strings.HasPrefix(t, ";") {
+			continue
+		}
+		k, v, ok := strings.Cut(t, "=")
+		k = strings.TrimSpace(strings.TrimPrefix(k, "export "))
+		if !ok || !nameRe.MatchString(k) {
+			errs = append(errs, fmt.Errorf("line %d: not a NAME=value assignment", n))
+			continue
+		}
+		words, err := splitWords(strings.TrimSpace(v))
+		if err != nil {
+			errs = append(errs, fmt.Errorf("line %d: %w", n, err))
+			continue
+		}
+		lines[k] = n
+		vars = append(vars, Var{Name: k, Value: strings.Join(words, " "), Line: n})
+	}
+	return lines, vars, errors.Join(errs...)
+}
+
+// quoteEnvFile renders NAME=value for an EnvironmentFile.
+func quoteEnvFile(name, value string) string {
+	if value != "" && !strings.ContainsAny(value, " \t\"'\\#;$`\n") {
+		return name + "=" + value
+	}
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return name + `="` + r.Replace(value) + `"`
+}
+
+// validate checks staged, a copy of the drop-in or environment file path.
+func validate(path, staged string) error {
+	data, err := os.ReadFile(staged)
+	if err != nil {
+		return err
+	}
+	if strings.HasSuffix(path, ".env") {
+		_, _, err = parseEnvFile(data)
+	} else {
+		var settings []setting
+		settings, err = parseUnit(data)
+		for _, s := range settings {
+			if s.key != "Environment" || err != nil {
+				continue
+			}
+			words, werr := splitWords(s.value)
+			if werr != nil {
+				err = fmt.Errorf("line %d: %w", s.start, werr)
+				break
+			}
+			for _, w := range words {
+				if k, _, ok := strings.Cut(w, "="); !ok || !nameRe.MatchString(k) {
+					err = fmt.Errorf("line %d: %q is not a NAME=value assignment", s.start, w)
+				}
+			}
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
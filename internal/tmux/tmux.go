@@ -0,0 +1,343 @@
+// Package tmux manages options and key bindings in a block of the tmux
+// config. Lines outside the block are the user's and stay untouched;
+// since tmux applies the config top to bottom, the block is appended at
+// the end so its settings win. After a change a running tmux server
+// sources the config again.
+package tmux
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func init() {
+	snapshot.Register(snapshot.Subsystem{
+		Name: "tmux",
+		Files: func() ([]string, error) {
+			if _, err := fsys.Current.Stat(ConfigPath()); err != nil {
+				return nil, nil
+			}
+			return []string{ConfigPath()}, nil
+		},
+	})
+}
+
+// The markers around the lines shctl manages.
+const (
+	beginMarker = "# BEGIN shctl tmux"
+	endMarker   = "# END shctl tmux"
+)
+
+// Kinds of entries.
+const (
+	Option = "option"
+	Bind   = "bind"
+)
+
+var (
+	optionRe = regexp.MustCompile(`^@?[a-z][a-z0-9-]*(\[[0-9]+\])?$`)
+	tableRe  = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	setRe    = regexp.MustCompile(`^set(-option)?\s+-g\s+(\S+)\s*(.*)$`)
+	bindRe   = regexp.MustCompile(`^bind(-key)?((?:\s+-r)?(?:\s+-n|\s+-T\s+\S+)?(?:\s+-r)?)\s+(\S+)\s+(.+)$`)
+	bareRe   = regexp.MustCompile(`^[A-Za-z0-9_.,:/@%+=-]+$`)
+)
+
+// Entry is an option or a key binding of the managed block.
+type Entry struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`            // option name or key
+	Value string `json:"value"`           // option value or command
+	Table string `json:"table,omitempty"` // key table of a binding
+	Line  int    `json:"line"`
+}
+
+// ConfigPath is the tmux config to manage.
+func ConfigPath() string {
+	return config.Get("tmux_conf")
+}
+
+// parseLine reads a line of the block; ok is false for comments and
+// lines it does not know.
+func parseLine(l string) (Entry, bool) {
+	t := strings.TrimSpace(l)
+	if m := setRe.FindStringSubmatch(t); m != nil {
+		return Entry{Kind: Option, Name: m[2], Value: unquote(m[3])}, true
+	}
+	if m := bindRe.FindStringSubmatch(t); m != nil {
+		table := "prefix"
+		flags := strings.Fields(m[2])
+		for i, f := range flags {
+			switch {
+			case f == "-n":
+				table = "root"
+			case f == "-T" && i+1 < len(flags):
+				table = flags[i+1]
+			}
+		}
+		return Entry{Kind: Bind, Name: m[3], Value: m[4], Table: table}, true
+	}
+	return Entry{}, false
+}
+
+// Entries returns the options and bindings of the managed block.
+func Entries() ([]Entry, error) {
+	data, err := fsys.Current.ReadFile(ConfigPath())
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Entry{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	lines := splitLines(data)
+	begin, end, err := markers(lines)
+	if err != nil {
+		return nil, err
+	}
+	return entries(lines, begin, end), nil
+}
+
+func entries(lines []string, begin, end int) []Entry {
+	out := []Entry{}
+	for i := begin + 1; begin >= 0 && i < end; i++ {
+		if e, ok := parseLine(lines[i]); ok {
+			e.Line = i + 1
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// List prints the options as "name value" and the bindings as
+// "table key -> command", or as JSON or YAML records. output.List
+// narrows and orders them by name.
+func List(w io.Writer, format string) error {
+	all, err := Entries()
+	if err != nil {
+		return err
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: all[i].Name, File: ConfigPath()}
+	})
+	if err != nil {
+		return err
+	}
+	list := make([]Entry, len(idx))
+	for i, j := range idx {
+		list[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, list)
+	}
+	for _, e := range list {
+		line := fmt.Sprintf("%s %s", output.Name(w, e.Name), output.Value(w, e.Value))
+		if e.Kind == Bind {
+			line = fmt.Sprintf("%s %s -> %s", e.Table, output.Name(w, e.Name), output.Value(w, e.Value))
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SetOption sets the global option name to value, replacing the value
+// the block had.
+func SetOption(ctx context.Context, name, value string) error {
+	if !optionRe.MatchString(name) {
+		return fmt.Errorf("invalid tmux option %q: %w", name, util.ErrUsage)
+	}
+	if strings.ContainsAny(value, "\n\x00") {
+		return fmt.Errorf("%s: values are one line: %w", name, util.ErrUsage)
+	}
+	line := "set -g " + name + " " + quote(value)
+	return change(ctx, "tmux option set", func(lines []string, es []Entry) ([]string, error) {
+		for _, e := range es {
+			if e.Kind == Option && e.Name == name {
+				lines[e.Line-1] = line
+				return lines, nil
+			}
+		}
+		return insert(lines, line), nil
+	})
+}
+
+// AddBind binds key in table, "prefix" when empty, to the tmux command
+// command, which is written as given. A key the block already binds in
+// that table is reported as ErrEntryExists.
+func AddBind(ctx context.Context, table, key, command string) error {
+	if table == "" {
+		table = "prefix"
+	}
+	if !tableRe.MatchString(table) {
+		return fmt.Errorf("invalid key table %q: %w", table, util.ErrUsage)
+	}
+	if key == "" || strings.ContainsAny(key, " \t\n") {
+		return fmt.Errorf("invalid key %q: %w", key, util.ErrUsage)
+	}
+	command = strings.TrimSpace(command)
+	if command == "" || strings.ContainsAny(command, "\n\x00") {
+		return fmt.Errorf("%s: want one tmux command line: %w", key, util.ErrUsage)
+	}
+	line := "bind-key "
+	switch table {
+	case "prefix":
+	case "root":
+		line += "-n "
+	default:
+		line += "-T " + table + " "
+	}
+	line += key + " " + command
+	return change(ctx, "tmux bind add", func(lines []string, es []Entry) ([]string, error) {
+		for _, e := range es {
+			if e.Kind == Bind && e.Table == table && e.Name == key {
+				return nil, fmt.Errorf("%s %s -> %s: %w at line %d", table, key, e.Value, util.ErrEntryExists, e.Line)
+			}
+		}
+		return insert(lines, line), nil
+	})
+}
+
+// quote writes an option value as tmux reads it back: bare when it can
+// be, else in double quotes with \, " and $ escaped.
+func quote(v string) string {
+	if bareRe.MatchString(v) {
+		return v
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, `$`, `\$`).Replace(v) + `"`
+}
+
+func unquote(v string) string {
+	if len(v) >= 2 && v[0] == '"' && v[len(v)-1] == '"' {
+		return strings.NewReplacer(`\\`, `\`, `\"`, `"`, `\$`, `$`).Replace(v[1 : len(v)-1])
+	}
+	if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+		return v[1 : len(v)-1]
+	}
+	return v
+}
+
+// markers returns the line indexes of the managed block's markers, -1
+// when there is no block.
+func markers(lines []string) (int, int, error) {
+	begin, end := -1, -1
+	for i, l := range lines {
+		switch strings.TrimSpace(l) {
+		case beginMarker:
+			if begin >= 0 {
+				return 0, 0, fmt.Errorf("%s: more than one shctl block", ConfigPath())
+			}
+			begin = i
+		case endMarker:
+			if begin < 0 || end >= 0 {
+				return 0, 0, fmt.Errorf("%s:%d: stray end of the shctl block", ConfigPath(), i+1)
+			}
+			end = i
+		}
+	}
+	if begin >= 0 && end < 0 {
+		return 0, 0, fmt.Errorf("%s:%d: the shctl block is not closed", ConfigPath(), begin+1)
+	}
+	return begin, end, nil
+}
+
+// insert adds line at the end of the managed block, creating the block
+// at the end of the file.
+func insert(lines []string, line string) []string {
+	begin, end, _ := markers(lines)
+	if begin >= 0 {
+		return append(lines[:end], append([]string{line}, lines[end:]...)...)
+	}
+	if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+		lines = append(lines, "")
+	}
+	return append(lines, beginMarker, line, endMarker)
+}
+
+// change lets fn rewrite the lines of the config, given the entries of
+// the block, writes the result and reloads a running server.
+func change(ctx context.Context, op string, fn func(lines []string, es []Entry) ([]string, error)) error {
+	path := ConfigPath()
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	data, err := fsys.Current.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	lines := splitLines(data)
+	begin, end, err := markers(lines)
+	if err != nil {
+		return err
+	}
+	if lines, err = fn(lines, entries(lines, begin, end)); err != nil {
+		return err
+	}
+	if err := backup.AutoSave(ctx, path, op); err != nil {
+		return err
+	}
+	logging.Info("tmux config", "op", op, "path", path)
+	if err := fsys.Current.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := fsys.Current.WriteFile(path, joinLines(lines), 0o644); err != nil {
+		return err
+	}
+	if err := Reload(ctx); err != nil {
+		output.Warn(prompt.Out, "%s is written but not loaded: %v", path, err)
+	}
+	return nil
+}
+
+// Reload has a running tmux server source the config again. Without a
+// server, or without tmux, there is nothing to reload.
+func Reload(ctx context.Context) error {
+	if _, err := exec.LookPath("tmux"); err != nil {
+		return nil
+	}
+	if err := exec.CommandContext(ctx, "tmux", "list-sessions").Run(); err != nil {
+		logging.Debug("no tmux server to reload")
+		return nil
+	}
+	cmd := exec.CommandContext(ctx, "tmux", "source-file", ConfigPath())
+	if dryrun.Skip(cmd.Args...) {
+		return nil
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("tmux source-file: %s: %w", strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
+
+func splitLines(data []byte) []string {
+	s := strings.TrimSuffix(string(data), "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+func joinLines(lines []string) []byte {
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
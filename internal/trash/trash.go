@@ -0,0 +1,201 @@
+// Package trash keeps lines removed from managed files so an accidental
+// remove can be undone without going through backups. Packages register
+// how lines of their kind are put back.
+package trash
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Keep is how many items the trash holds; older ones are dropped.
+var Keep = 200
+
+// Item is one removal.
+type Item struct {
+	ID      int       `json:"id"`
+	Deleted time.Time `json:"deleted"`
+	Kind    string    `json:"kind"` // registered kind, such as rc or sudoers
+	Path    string    `json:"path"`
+	Op      string    `json:"op"`
+	Lines   []string  `json:"lines"`
+}
+
+// RestoreFunc puts lines back into path.
+type RestoreFunc func(ctx context.Context, path string, lines []string) error
+
+var restorers = map[string]RestoreFunc{}
+
+// Register sets how items of kind are restored.
+func Register(kind string, fn RestoreFunc) {
+	restorers[kind] = fn
+}
+
+// ErrNotFound is returned for an unknown item id.
+var ErrNotFound = util.NotFound("no such trash item")
+
+// Path is the file holding the trash.
+func Path() string {
+	return filepath.Join(util.StateDir(), "trash.json")
+}
+
+// Put records lines removed from path by op. Nothing is kept in a dry run.
+func Put(kind, path, op string, lines []string) error {
+	if len(lines) == 0 || !fsys.IsOS() {
+		return nil
+	}
+	return update(func(items []Item) []Item {
+		id := 1
+		if len(items) > 0 {
+			id = items[len(items)-1].ID + 1
+		}
+		items = append(items, Item{id, time.Now().UTC(), kind, path, op, lines})
+		if len(items) > Keep {
+			items = items[len(items)-Keep:]
+		}
+		return items
+	})
+}
+
+// Items returns the trash, oldest first.
+func Items() ([]Item, error) {
+	b, err := os.ReadFile(Path())
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var items []Item
+	if err := json.Unmarshal(b, &items); err != nil {
+		return nil, fmt.Errorf("%s: %w", Path(), err)
+	}
+	return items, nil
+}
+
+// List prints the trash, newest first.
+func List(w io.Writer) error {
+	items, err := Items()
+	if err != nil {
+		return err
+	}
+	newest := make([]Item, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		newest = append(newest, items[i])
+	}
+	return printItems(w, newest)
+}
+
+func printItems(w io.Writer, items []Item) error {
+	for _, it := range items {
+		if _, err := fmt.Fprintf(w, "%4d  %s  %-8s %s (%s)\n", it.ID, it.Deleted.Local().Format("2006-01-02 15:04"), it.Kind, it.Path, it.Op); err != nil {
+			return err
+		}
+		for _, l := range it.Lines {
+			if _, err := fmt.Fprintf(w, "      %s\n", l); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// ListFormat prints the trash as text, JSON or YAML. output.List
+// narrows and orders the items by their lines, file and deletion time.
+func ListFormat(w io.Writer, format string) error {
+	if !output.Structured(format) && output.List == (output.Query{}) {
+		return List(w)
+	}
+	all, err := Items()
+	if err != nil {
+		return err
+	}
+	if !output.Structured(format) {
+		for i, j := 0, len(all)-1; i < j; i, j = i+1, j-1 {
+			all[i], all[j] = all[j], all[i] // newest first, as List
+		}
+	}
+	idx, err := output.List.Apply(len(all), func(i int) output.Key {
+		return output.Key{Name: strings.Join(all[i].Lines, " "), File: all[i].Path, Mtime: all[i].Deleted}
+	})
+	if err != nil {
+		return err
+	}
+	items := make([]Item, len(idx))
+	for i, j := range idx {
+		items[i] = all[j]
+	}
+	if output.Structured(format) {
+		return output.Write(w, format, items)
+	}
+	return printItems(w, items)
+}
+
+// Restore puts item id back into its file and takes it out of the trash.
+func Restore(ctx context.Context, id int) error {
+	items, err := Items()
+	if err != nil {
+		return err
+	}
+	var it *Item
+	for i := range items {
+		if items[i].ID == id {
+			it = &items[i]
+		}
+	}
+	if it == nil {
+		return fmt.Errorf("%d: %w", id, ErrNotFound)
+	}
+	fn, ok := restorers[it.Kind]
+	if !ok {
+		return fmt.Errorf("cannot restore %s lines", it.Kind)
+	}
+	if err := fn(ctx, it.Path, it.Lines); err != nil {
+		return fmt.Errorf("restore trash item %d: %w", id, err)
+	}
+	if !fsys.IsOS() {
+		return nil
+	}
+	return update(func(items []Item) []Item {
+		out := items[:0]
+		for _, i := range items {
+			if i.ID != id {
+				out = append(out, i)
+			}
+		}
+		return out
+	})
+}
+
+// update rewrites the trash under its lock.
+func update(fn func([]Item) []Item) error {
+	if err := os.MkdirAll(util.StateDir(), 0o700); err != nil {
+		return err
+	}
+	unlock, err := util.Lock(Path()+".lock", true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	items, err := Items()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(fn(items), "", "  ")
+	if err != nil {
+		return err
+	}
+	return fsys.OS.WriteFile(Path(), append(b, '\n'), 0o600)
+}
@@ -0,0 +1,144 @@
+package undo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"sort"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/journal"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// ErrNothing is returned by Last when no operation is left to undo.
+var ErrNothing = util.NotFound("nothing to undo")
+
+// Last reverts the last n operations of the history, newest first, by
+// putting back the content every file had before the oldest of them, and
+// returns the operations undone. The files are taken from the automatic
+// pre-change backups and written in one journaled transaction. The undo is
+// itself an operation, so undoing again redoes.
+func Last(ctx context.Context, n int) ([]backup.Operation, error) {
+	if n < 1 {
+		n = 1
+	}
+	history, err := backup.Operations()
+	if err != nil {
+		return nil, err
+	}
+	var ops []backup.Operation
+	for i := len(history) - 1; i >= 0 && len(ops) < n; i-- {
+		if !history[i].Undone {
+			ops = append(ops, history[i])
+		}
+	}
+	if len(ops) == 0 {
+		return nil, ErrNothing
+	}
+
+	// the oldest operation's state of a file is the one to go back to
+	want := map[string]backup.FileState{}
+	for _, op := range ops {
+		for _, f := range op.Files {
+			want[f.Path] = f
+		}
+	}
+	paths := make([]string, 0, len(want))
+	for p := range want {
+		paths = append(paths, p)
+	}
+	// sorted so concurrent runs take the locks in the same order
+	sort.Strings(paths)
+	for _, p := range paths {
+		unlock, err := util.LockTarget(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+		defer unlock()
+	}
+
+	store, err := backup.Default(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tx := journal.New("undo")
+	defer tx.Discard()
+	var remove []string
+	for _, p := range paths {
+		st := want[p]
+		if !st.Existed {
+			remove = append(remove, p)
+			continue
+		}
+		data, err := backup.ReadState(ctx, store, st)
+		if err != nil {
+			return nil, fmt.Errorf("cannot undo: %w", err)
+		}
+		perm := fs.FileMode(0o644)
+		if fi, err := fsys.Current.Stat(p); err == nil {
+			perm = fi.Mode().Perm()
+		}
+		if err := tx.WriteWith(p, data, perm, snapshot.Writer(p)); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := Report(prompt.Out, ops, "will undo"); err != nil {
+		return nil, err
+	}
+	ok, err := prompt.Confirm(fmt.Sprintf("Undo %d operation(s)?", len(ops)))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, prompt.ErrAborted
+	}
+	for _, p := range paths {
+		if err := backup.AutoSave(ctx, p, "undo"); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("undo: %w", err)
+	}
+	for _, p := range remove {
+		if err := fsys.Current.Remove(p); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, fmt.Errorf("undo: remove %s: %w", p, err)
+		}
+	}
+	ids := make([]int, len(ops))
+	for i, op := range ops {
+		ids[i] = op.ID
+	}
+	if fsys.IsOS() {
+		if err := backup.MarkUndone(ids...); err != nil {
+			return ops, err
+		}
+	}
+	return ops, nil
+}
+
+// Report lists ops and the files each touched, prefixed by verb.
+func Report(w io.Writer, ops []backup.Operation, verb string) error {
+	for _, op := range ops {
+		if _, err := fmt.Fprintf(w, "%s #%d %s (%s)\n", verb, op.ID, op.Op, op.Time.Local().Format("2006-01-02 15:04:05")); err != nil {
+			return err
+		}
+		for _, f := range op.Files {
+			what := "restore"
+			if !f.Existed {
+				what = "remove"
+			}
+			if _, err := fmt.Fprintf(w, "  %-7s %s\n", what, f.Path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
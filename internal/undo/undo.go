@@ -0,0 +1,129 @@
+package undo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Script records the state of files before they are changed and renders
+// a standalone POSIX sh script restoring them, so changes can be reverted
+// on hosts where shctl is not installed.
+type Script struct {
+	files []fileState
+	seen  map[string]bool
+}
+
+type fileState struct {
+	path    string
+	existed bool
+	content []byte
+	mode    os.FileMode
+}
+
+// Record captures path as it is now. Only the first call for a path
+// counts, so record before every write without worrying about order.
+func (s *Script) Record(path string) error {
+	if s.seen == nil {
+		s.seen = map[string]bool{}
+	}
+	if s.seen[path] {
+		return nil
+	}
+	st := fileState{path: path}
+	fi, err := os.Stat(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		st.existed, st.content, st.mode = true, b, fi.Mode().Perm()
+	}
+	s.seen[path] = true
+	s.files = append(s.files, st)
+	return nil
+}
+
+// Len is the number of recorded files.
+func (s *Script) Len() int { return len(s.files) }
+
+// WriteTo renders the undo script. Files are restored in reverse order of
+// recording.
+func (s *Script) WriteTo(w io.Writer) (int64, error) {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "#!/bin/sh\n# Generated by shctl on %s.\n# Restores the files below to their state before the change.\nset -e\n",
+		time.Now().Format(time.RFC3339))
+	for i := len(s.files) - 1; i >= 0; i-- {
+		f := s.files[i]
+		p := util.ShellQuote(f.path)
+		sb.WriteByte('\n')
+		if !f.existed {
+			fmt.Fprintf(&sb, "rm -f %s\n", p)
+			continue
+		}
+		fmt.Fprintf(&sb, ": > %s\n", p)
+		for _, chunk := range chunks(f.content, 512) {
+			fmt.Fprintf(&sb, "printf '%s' >> %s\n", printfEscape(chunk), p)
+		}
+		fmt.Fprintf(&sb, "chmod %o %s\n", f.mode, p)
+	}
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+// WriteFile writes the undo script to path as an executable file.
+func (s *Script) WriteFile(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return err
+	}
+	if _, err := s.WriteTo(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func chunks(b []byte, n int) [][]byte {
+	out := [][]byte{}
+	for len(b) > n {
+		out = append(out, b[:n])
+		b = b[n:]
+	}
+	if len(b) > 0 {
+		out = append(out, b)
+	}
+	return out
+}
+
+// printfEscape turns b into a printf format string that reproduces it
+// byte for byte inside single quotes.
+func printfEscape(b []byte) string {
+	var sb strings.Builder
+	for _, c := range b {
+		switch {
+		case c == '%':
+			sb.WriteString("%%")
+		case c == '\\':
+			sb.WriteString(`\\`)
+		case c == '\'':
+			sb.WriteString(`'\''`)
+		case c == '\n':
+			sb.WriteString(`\n`)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&sb, `\%03o`, c)
+		default:
+			sb.WriteByte(c)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,18 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// StateDir is where shctl keeps its own bookkeeping files.
+func StateDir() string {
+	if v := os.Getenv("BASM_STATE_DIR"); v != "" {
+		return v
+	}
+	if v := os.Getenv("XDG_STATE_HOME"); v != "" {
+		return filepath.Join(v, "shctl")
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".local", "state", "shctl")
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+)
+
+// Errors shared by the managed-file packages, which re-export them, so
+// callers can tell failures apart with errors.Is.
+var (
+	// ErrNotFound matches every error reporting that something to act on
+	// does not exist, including the more specific ones below.
+	ErrNotFound = errors.New("not found")
+	// ErrNoBackup reports that there is no backup to choose from.
+	ErrNoBackup = NotFound("no backup found")
+	// ErrAliasNotFound reports that an alias to change is not defined.
+	ErrAliasNotFound = NotFound("alias not found")
+	// ErrEntryExists reports that an entry being added is already there.
+	ErrEntryExists = errors.New("entry already exists")
+	// ErrValidationFailed matches every ValidationError.
+	ErrValidationFailed = errors.New("validation failed")
+	// ErrPermission is fs.ErrPermission, so plain permission errors from
+	// the file system match it too.
+	ErrPermission = fs.ErrPermission
+	// ErrAborted reports that the user declined a confirmation.
+	ErrAborted = errors.New("aborted by user")
+	// ErrPartial matches every PartialError.
+	ErrPartial = errors.New("partial failure")
+	// ErrUsage reports a bad command line.
+	ErrUsage = errors.New("usage error")
+)
+
+// NotFound returns a sentinel error with message msg that also matches
+// ErrNotFound.
+func NotFound(msg string) error {
+	return &kindError{msg, ErrNotFound}
+}
+
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string { return e.msg }
+
+func (e *kindError) Is(target error) bool { return target == e.kind }
+
+// PartialError is returned when an operation on several items did some
+// of them and failed on others; Err holds the failures.
+type PartialError struct {
+	Done   int
+	Failed int
+	Err    error
+}
+
+func (e *PartialError) Error() string {
+	return fmt.Sprintf("%d of %d failed: %v", e.Failed, e.Done+e.Failed, e.Err)
+}
+
+func (e *PartialError) Unwrap() error { return e.Err }
+
+func (e *PartialError) Is(target error) bool { return target == ErrPartial }
+
+// ValidationError is returned when a checker such as visudo rejects a
+// file. Output is what the checker printed.
+type ValidationError struct {
+	Path   string
+	Tool   string // the external checker, or "" for the built-in one
+	Output string
+	Err    error
+}
+
+func (e *ValidationError) Error() string {
+	if e.Tool == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %s: %v", e.Tool, e.Output, e.Err)
+}
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+func (e *ValidationError) Is(target error) bool { return target == ErrValidationFailed }
@@ -0,0 +1,46 @@
+package util
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Exit codes of shctl. They are part of its interface: scripts branch on
+// them, so a code keeps its meaning once released.
+const (
+	ExitOK         = 0
+	ExitFailure    = 1 // any error not listed below
+	ExitUsage      = 2 // bad command line
+	ExitNotFound   = 3 // an alias, backup, item or file to act on does not exist
+	ExitValidation = 4 // visudo or a built-in checker rejected a file
+	ExitPermission = 5 // root or an escalator is needed
+	ExitLocked     = 6 // another shctl run held the lock too long
+	ExitPartial    = 7 // some items were done, others failed
+	ExitAborted    = 8 // the user declined a confirmation
+)
+
+// ExitCode maps err to the exit code shctl ends with. When err matches
+// several kinds, the first in the order of the checks below wins: a
+// partial failure is reported as such whatever its causes.
+func ExitCode(err error) int {
+	var locked *LockedError
+	switch {
+	case err == nil:
+		return ExitOK
+	case errors.Is(err, ErrPartial):
+		return ExitPartial
+	case errors.Is(err, ErrUsage):
+		return ExitUsage
+	case errors.Is(err, ErrAborted):
+		return ExitAborted
+	case errors.As(err, &locked):
+		return ExitLocked
+	case errors.Is(err, ErrValidationFailed):
+		return ExitValidation
+	case errors.Is(err, ErrPermission):
+		return ExitPermission
+	case errors.Is(err, ErrNotFound), errors.Is(err, fs.ErrNotExist):
+		return ExitNotFound
+	}
+	return ExitFailure
+}
@@ -1,90 +1,105 @@
 package util
 
 import (
+	"errors"
 	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
-	"sort"
+	"strings"
 	"time"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
 )
 
-// AppendFileAtomic appends bytes to file safely (open append -> write).
-func AppendFileAtomic(path string, data []byte) error {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0o644)
+// AppendLines appends lines to path on the current file system using the
+// file's own line ending, first ending an unterminated last line so
+// nothing is glued onto it.
+func AppendLines(path string, lines ...string) error {
+	unlock, err := lockCurrent(path, true)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	_, err = f.Write(data)
-	return err
-}
-
-func AppendFileAtomicNoCreate(path string, data []byte) error {
-	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0o644)
-	if err != nil {
+	defer unlock()
+	eol, open, err := fileEnding(fsys.Current, path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
 		return err
 	}
-	defer f.Close()
-	_, err = f.Write(data)
-	return err
+	var sb strings.Builder
+	if open {
+		sb.WriteString(eol)
+	}
+	for _, l := range lines {
+		sb.WriteString(l + eol)
+	}
+	return fsys.Current.AppendFile(path, []byte(sb.String()), 0o644)
 }
 
-// RemoveLinesWithPrefix rewrites file excluding lines that start with prefix.
-func RemoveLinesWithPrefix(path, prefix string) error {
-	b, err := os.ReadFile(path)
+// AppendFileAtomic appends bytes to a file on the host, such as a staged
+// copy (open append -> write).
+func AppendFileAtomic(path string, data []byte) error {
+	unlock, err := Lock(path, true)
 	if err != nil {
 		return err
 	}
-	lines := splitLines(string(b))
-	out := []string{}
-	for _, l := range lines {
-		if len(l) >= len(prefix) && l[:len(prefix)] == prefix {
-			continue
-		}
-		out = append(out, l)
-	}
-	return atomicWrite(path, []byte(joinLines(out)))
+	defer unlock()
+	return fsys.OS.AppendFile(path, data, 0o644)
 }
 
-func RemoveLinesContaining(path, pattern string) error {
-	b, err := os.ReadFile(path)
+func AppendFileAtomicNoCreate(path string, data []byte) error {
+	unlock, err := Lock(path, false)
 	if err != nil {
 		return err
 	}
-	lines := splitLines(string(b))
-	out := []string{}
-	for _, l := range lines {
-		if contains(l, pattern) {
-			continue
-		}
-		out = append(out, l)
+	defer unlock()
+	if _, err := os.Stat(path); err != nil {
+		return err
 	}
-	return atomicWrite(path, []byte(joinLines(out)))
+	return fsys.OS.AppendFile(path, data, 0o644)
 }
 
-func contains(s, sub string) bool {
-	return len(sub) > 0 && (len(s) >= len(sub) && (index(s, sub) >= 0))
+// lockCurrent locks path on the current file system. Only host files
+// need it; the in-memory one serializes writes itself.
+func lockCurrent(path string, create bool) (func(), error) {
+	if !fsys.IsOS() {
+		return func() {}, nil
+	}
+	return Lock(path, create)
 }
 
-// Helpers (simple to avoid imports)
-func splitLines(s string) []string {
-	if s == "" {
-		return []string{}
-	}
-	return stringsSplit(s, "\n")
+// RemoveLinesWithPrefix rewrites file excluding lines that start with prefix.
+func RemoveLinesWithPrefix(path, prefix string) error {
+	_, err := RemoveLinesFunc(path, func(l string) bool { return strings.HasPrefix(l, prefix) })
+	return err
 }
 
-func joinLines(lines []string) string {
-	return stringsJoin(lines, "\n")
+func RemoveLinesContaining(path, pattern string) error {
+	_, err := RemoveLinesFunc(path, func(l string) bool { return pattern != "" && strings.Contains(l, pattern) })
+	return err
 }
 
-func atomicWrite(path string, data []byte) error {
-	dir := filepath.Dir(path)
-	tmp := filepath.Join(dir, ".tmp_"+filepath.Base(path))
-	if err := os.WriteFile(tmp, data, 0o644); err != nil {
-		return err
+// RemoveLinesFunc rewrites file, on the current file system, without the
+// lines drop selects and returns the removed lines. drop sees lines
+// without their line ending; the file is streamed, not read whole.
+func RemoveLinesFunc(path string, drop func(line string) bool) ([]string, error) {
+	unlock, err := lockCurrent(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	var removed []string
+	_, err = EditLines(fsys.Current, path, func(_ int, l string) (string, bool) {
+		if drop(l) {
+			removed = append(removed, l)
+			return l, false
+		}
+		return l, true
+	})
+	if err != nil {
+		return nil, err
 	}
-	return os.Rename(tmp, path)
+	return removed, nil
 }
 
 func CopyFile(src, dst string) error {
@@ -107,8 +122,10 @@ func CopyFile(src, dst string) error {
 	return out.Sync()
 }
 
+// CopyToTemp copies src from the current file system to a temp file on
+// the host, where external tools such as visudo can check it.
 func CopyToTemp(src string) (string, error) {
-	b, err := os.ReadFile(src)
+	b, err := fsys.Current.ReadFile(src)
 	if err != nil {
 		return "", err
 	}
@@ -121,24 +138,31 @@ func CopyToTemp(src string) (string, error) {
 		return "", err
 	}
 	f.Close()
-	if fi, err := os.Stat(src); err == nil {
+	if fi, err := fsys.Current.Stat(src); err == nil {
 		_ = os.Chmod(f.Name(), fi.Mode())
 	}
+	logging.Debug("copied to temp file", "src", src, "tmp", f.Name())
 	return f.Name(), nil
 }
 
-func LatestFile(files []string) string {
-	sort.Slice(files, func(i, j int) bool {
-		ti := modTime(files[i])
-		tj := modTime(files[j])
-		return ti.After(tj)
-	})
-	return files[0]
-}
-
-func modTime(path string) time.Time {
-	if fi, err := os.Stat(path); err == nil {
-		return fi.ModTime()
+// SelectLatest returns the most recently modified of files. It fails with
+// ErrNoBackup when files is empty and with the Stat error when one of
+// them cannot be examined. Files with the same time are told apart by
+// name, the later name winning.
+func SelectLatest(files []string) (string, error) {
+	if len(files) == 0 {
+		return "", ErrNoBackup
+	}
+	latest, latestTime := "", time.Time{}
+	for _, f := range files {
+		fi, err := os.Stat(f)
+		if err != nil {
+			return "", err
+		}
+		t := fi.ModTime()
+		if latest == "" || t.After(latestTime) || (t.Equal(latestTime) && f > latest) {
+			latest, latestTime = f, t
+		}
 	}
-	return time.Time{}
+	return latest, nil
 }
@@ -0,0 +1,207 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/fsys"
+)
+
+// Line is one line of a file and the ending that followed it: "\n",
+// "\r\n", or "" for a last line without one.
+type Line struct {
+	Text string
+	EOL  string
+}
+
+// SplitLines splits s into lines, keeping each line's ending so the file
+// can be put back together unchanged.
+func SplitLines(s string) []Line {
+	out := []Line{}
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			out = append(out, Line{s, ""})
+			break
+		}
+		text, eol := s[:i], "\n"
+		if strings.HasSuffix(text, "\r") {
+			text, eol = text[:len(text)-1], "\r\n"
+		}
+		out = append(out, Line{text, eol})
+		s = s[i+1:]
+	}
+	return out
+}
+
+// JoinLines reassembles lines. final says whether the result should end
+// with a line ending, so removing an unterminated last line does not add
+// one to the line before it.
+func JoinLines(lines []Line, final bool) string {
+	var sb strings.Builder
+	for i, l := range lines {
+		sb.WriteString(l.Text)
+		if i < len(lines)-1 || final {
+			eol := l.EOL
+			if eol == "" {
+				eol = "\n"
+			}
+			sb.WriteString(eol)
+		}
+	}
+	return sb.String()
+}
+
+// DetectEOL returns the line ending b uses, "\n" when it has none yet.
+func DetectEOL(b []byte) string {
+	i := bytes.IndexByte(b, '\n')
+	if i > 0 && b[i-1] == '\r' {
+		return "\r\n"
+	}
+	return "\n"
+}
+
+func finalNewline(b []byte) bool {
+	return len(b) > 0 && b[len(b)-1] == '\n'
+}
+
+// errUnchanged aborts a streamed rewrite that would not change anything.
+var errUnchanged = errors.New("unchanged")
+
+// EditLines streams path on f through edit, one line at a time, and
+// atomically replaces it with the result, so files of any size can be
+// edited without loading them whole. edit gets the 1-based line number
+// and the text without its line ending; it returns the new text and
+// whether to keep the line. Kept lines keep their own line ending and the
+// file keeps its final-newline state. EditLines returns how many lines
+// were changed or dropped and leaves the file alone when that is none.
+func EditLines(f fsys.FS, path string, edit func(n int, text string) (string, bool)) (int, error) {
+	fi, err := f.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	changed := 0
+	err = f.WriteStream(path, fi.Mode().Perm(), func(w io.Writer) error {
+		in, err := f.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		r := bufio.NewReader(in)
+		// a kept line's ending is written only once another kept line
+		// follows it, or at the end when the file had a final newline
+		pending, wrote, final := "", false, false
+		for n := 1; ; n++ {
+			l, err := readLine(r)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			final = l.EOL != ""
+			text, keep := edit(n, l.Text)
+			if !keep || text != l.Text {
+				changed++
+			}
+			if !keep {
+				continue
+			}
+			if wrote {
+				if _, err := io.WriteString(w, pending); err != nil {
+					return err
+				}
+			}
+			if _, err := io.WriteString(w, text); err != nil {
+				return err
+			}
+			pending, wrote = l.EOL, true
+		}
+		if changed == 0 {
+			return errUnchanged
+		}
+		if wrote && final {
+			_, err := io.WriteString(w, pending)
+			return err
+		}
+		return nil
+	})
+	if errors.Is(err, errUnchanged) {
+		return 0, nil
+	}
+	return changed, err
+}
+
+// ScanLines streams path on f, calling visit with each line's 1-based
+// number and its text without the line ending.
+func ScanLines(f fsys.FS, path string, visit func(n int, text string)) error {
+	in, err := f.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	r := bufio.NewReader(in)
+	for n := 1; ; n++ {
+		l, err := readLine(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		visit(n, l.Text)
+	}
+}
+
+// readLine reads one line and its ending from r, or io.EOF when there
+// are none left.
+func readLine(r *bufio.Reader) (Line, error) {
+	s, err := r.ReadString('\n')
+	if s == "" {
+		if err == nil {
+			err = io.EOF
+		}
+		return Line{}, err
+	}
+	if err != nil && err != io.EOF {
+		return Line{}, err
+	}
+	return SplitLines(s)[0], nil
+}
+
+// fileEnding reports the line ending path on f uses and whether its last
+// line is unterminated, reading only its first line and last byte.
+func fileEnding(f fsys.FS, path string) (eol string, open bool, err error) {
+	in, err := f.Open(path)
+	if err != nil {
+		return "\n", false, err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return "\n", false, err
+	}
+	first, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "\n", false, err
+	}
+	eol = DetectEOL([]byte(first))
+	switch ra, ok := in.(io.ReaderAt); {
+	case fi.Size() == 0:
+		return eol, false, nil
+	case int64(len(first)) == fi.Size():
+		return eol, !finalNewline([]byte(first)), nil
+	case !ok:
+		b, err := f.ReadFile(path)
+		return eol, len(b) > 0 && !finalNewline(b), err
+	default:
+		last := make([]byte, 1)
+		if _, err := ra.ReadAt(last, fi.Size()-1); err != nil {
+			return eol, false, err
+		}
+		return eol, last[0] != '\n', nil
+	}
+}
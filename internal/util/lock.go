@@ -0,0 +1,239 @@
+package util
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/logging"
+)
+
+// LockTimeout bounds how long Lock waits for another process.
+var LockTimeout = 5 * time.Second
+
+// TargetLockTimeout bounds how long LockTarget waits for another shctl
+// run. It is longer than LockTimeout: the other run may be waiting for
+// its user to confirm a change.
+var TargetLockTimeout = 2 * time.Minute
+
+// LockedError reports that another process held the lock on Path for
+// longer than LockTimeout.
+type LockedError struct {
+	Path string
+	Wait time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("%s is locked by another process (waited %s); try again once it has finished", e.Path, e.Wait)
+}
+
+// Lock takes an exclusive advisory lock on path, creating the file when
+// create is set, and returns the function releasing it. Writers replace
+// files by renaming, so the lock is retried when path no longer names
+// the file that was locked.
+func Lock(path string, create bool) (func(), error) {
+	return lockWait(context.Background(), path, create, LockTimeout)
+}
+
+// LockTarget serializes changes to the managed file target across shctl
+// processes, so parallel runs take turns instead of overwriting each
+// other's staged copies. The lock lives in a file in LockDirs rather
+// than on target itself, which writers replace, and is removed again on
+// unlock. Nothing is locked in a dry run.
+func LockTarget(ctx context.Context, target string) (func(), error) {
+	if !fsys.IsOS() {
+		return func() {}, nil
+	}
+	path, err := TargetLockPath(target)
+	if err != nil {
+		return nil, err
+	}
+	logging.Debug("locking", "target", target, "lock", path)
+	unlock, err := lockWait(ctx, path, true, TargetLockTimeout)
+	var le *LockedError
+	if errors.As(err, &le) {
+		le.Path = target
+	}
+	if err != nil {
+		return nil, err
+	}
+	return func() {
+		// removed while still held, so a waiter finds it gone and
+		// takes a fresh file instead
+		os.Remove(path)
+		unlock()
+	}, nil
+}
+
+// SharedLockDir is where the locks for system files such as /etc/sudoers
+// live, so that runs by different users, and the root reaper, take turns
+// on them. It is world-writable and sticky, like /tmp, and owned by root.
+func SharedLockDir() string {
+	if v := os.Getenv("SHCTL_LOCK_DIR"); v != "" {
+		return v
+	}
+	return "/run/shctl"
+}
+
+// LockDirs are the directories target locks may be in: the user's own
+// and the shared one.
+func LockDirs() []string {
+	return []string{filepath.Join(StateDir(), "locks"), SharedLockDir()}
+}
+
+// lockDir is the directory for target's lock: the user's state directory
+// for files in their home, the shared one for the rest. It falls back to
+// the user's directory when the shared one cannot be created, as on a
+// host where only root may write to /run, or cannot be trusted: root
+// only uses a shared directory that root owns.
+func lockDir(abs string) (string, error) {
+	own := filepath.Join(StateDir(), "locks")
+	home, _ := os.UserHomeDir()
+	if rel, err := filepath.Rel(home, abs); home == "" || err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		shared := SharedLockDir()
+		fi, err := os.Lstat(shared)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			if err := os.MkdirAll(shared, 0o755); err == nil {
+				return shared, os.Chmod(shared, 0o1777)
+			}
+			logging.Debug("no shared lock directory; locking for this user only", "dir", shared)
+		case err == nil && fi.IsDir() && (ownedBy(fi, 0) || os.Geteuid() != 0 && ownedBy(fi, os.Geteuid())):
+			return shared, nil
+		default:
+			logging.Warn("shared lock directory is not a directory owned by root; locking for this user only", "dir", shared)
+		}
+	}
+	return own, os.MkdirAll(own, 0o700)
+}
+
+// Held reports whether another process holds the lock on path, without
+// waiting for it. A missing lock file is not held.
+func Held(path string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|oNoFollow, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	err = tryLock(f)
+	if errors.Is(err, errWouldBlock) {
+		return true, nil
+	}
+	return false, err
+}
+
+// TargetLockPath is the lock file LockTarget takes for target.
+func TargetLockPath(target string) (string, error) {
+	abs, err := filepath.Abs(target)
+	if err != nil {
+		return "", err
+	}
+	dir, err := lockDir(abs)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(dir, filepath.Base(abs)+"-"+hex.EncodeToString(sum[:6])+".lock"), nil
+}
+
+// CleanLocks removes the target lock files no run holds, which runs that
+// died leave behind, and returns those still held. Each file is removed
+// while locked, so a run cannot take it between the check and the
+// removal.
+func CleanLocks() (held []string, removed int, err error) {
+	for _, dir := range LockDirs() {
+		locks, _ := filepath.Glob(filepath.Join(dir, "*.lock"))
+		for _, l := range locks {
+			ok, rerr := removeUnheld(l)
+			switch {
+			case rerr != nil:
+				err = errors.Join(err, rerr)
+			case !ok:
+				held = append(held, l)
+			default:
+				removed++
+			}
+		}
+	}
+	return held, removed, err
+}
+
+// removeUnheld removes the lock file at path unless another process holds
+// it, and reports whether it did. A file already gone counts as removed;
+// one replaced meanwhile belongs to a run that just took it.
+func removeUnheld(path string) (bool, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|oNoFollow, 0)
+	if errors.Is(err, fs.ErrNotExist) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	err = tryLock(f)
+	if errors.Is(err, errWouldBlock) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("lock %s: %w", path, err)
+	}
+	cur, serr := os.Stat(path)
+	locked, lerr := f.Stat()
+	if errors.Is(serr, fs.ErrNotExist) {
+		return true, nil
+	}
+	if serr != nil || lerr != nil || !os.SameFile(cur, locked) {
+		return false, errors.Join(serr, lerr)
+	}
+	return true, os.Remove(path)
+}
+
+// lockWait is Lock giving up after timeout or when ctx is done.
+func lockWait(ctx context.Context, path string, create bool, timeout time.Duration) (func(), error) {
+	flags := os.O_RDONLY | oNoFollow
+	if create {
+		flags |= os.O_CREATE
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		f, err := os.OpenFile(path, flags, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		err = tryLock(f)
+		if err == nil {
+			cur, serr := os.Stat(path)
+			held, herr := f.Stat()
+			if serr == nil && herr == nil && os.SameFile(cur, held) {
+				return func() { f.Close() }, nil
+			}
+			// replaced while we waited; lock the new file instead
+			f.Close()
+			continue
+		}
+		f.Close()
+		if !errors.Is(err, errWouldBlock) {
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		if time.Now().After(deadline) {
+			return nil, &LockedError{path, timeout}
+		}
+		logging.Debug("waiting for lock", "lock", path)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+}
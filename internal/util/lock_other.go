@@ -0,0 +1,22 @@
+//go:build !unix
+
+package util
+
+import (
+	"errors"
+	"os"
+)
+
+var errWouldBlock = errors.New("would block")
+
+const oNoFollow = 0
+
+// tryLock is a no-op where flock is not available.
+func tryLock(f *os.File) error {
+	return nil
+}
+
+// ownedBy is always true where files have no owning uid.
+func ownedBy(fi os.FileInfo, uid int) bool {
+	return true
+}
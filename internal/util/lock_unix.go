@@ -0,0 +1,24 @@
+//go:build unix
+
+package util
+
+import (
+	"os"
+	"syscall"
+)
+
+var errWouldBlock error = syscall.EWOULDBLOCK
+
+// oNoFollow keeps lock files planted as symlinks from being followed.
+const oNoFollow = syscall.O_NOFOLLOW
+
+// tryLock takes an exclusive flock on f without waiting.
+func tryLock(f *os.File) error {
+	return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+// ownedBy reports whether fi belongs to uid.
+func ownedBy(fi os.FileInfo, uid int) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == uid
+}
@@ -0,0 +1,74 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/yourusername/shctl/internal/fsys"
+)
+
+type xattrError struct {
+	op, name, path string
+	err            error
+}
+
+func (e *xattrError) Error() string {
+	return fmt.Sprintf("%s xattr %s on %s: %v", e.op, e.name, e.path, e.err)
+}
+
+func (e *xattrError) Unwrap() error { return e.err }
+
+// CopyFilePreserve copies src to dst like CopyFile and then gives dst the
+// owner, group, mode, mtime and extended attributes of src. The owner is
+// only changed when the process is allowed to. On SELinux systems dst is
+// relabelled for its new location.
+func CopyFilePreserve(src, dst string) error {
+	fi, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if err := CopyFile(src, dst); err != nil {
+		return err
+	}
+	if err := os.Chmod(dst, fi.Mode().Perm()); err != nil {
+		return err
+	}
+	if uid, gid, ok := fsys.FileOwner(fi); ok {
+		if err := os.Lchown(dst, uid, gid); err != nil && !errors.Is(err, fs.ErrPermission) {
+			return err
+		}
+	}
+	if err := os.Chtimes(dst, fi.ModTime(), fi.ModTime()); err != nil {
+		return err
+	}
+	if err := CopyXattrs(src, dst); err != nil {
+		return err
+	}
+	return RestoreSecurityContext(dst)
+}
+
+// SELinuxEnabled reports whether the kernel has SELinux turned on.
+var SELinuxEnabled = func() bool {
+	_, err := os.Stat("/sys/fs/selinux/enforce")
+	return err == nil
+}
+
+// RestoreSecurityContext resets the SELinux label of path to the policy
+// default with restorecon. Without SELinux or restorecon it does nothing.
+func RestoreSecurityContext(path string) error {
+	if !SELinuxEnabled() {
+		return nil
+	}
+	bin, err := exec.LookPath("restorecon")
+	if err != nil {
+		return nil
+	}
+	if out, err := exec.Command(bin, path).CombinedOutput(); err != nil {
+		return fmt.Errorf("restorecon %s: %s: %w", path, strings.TrimSpace(string(out)), err)
+	}
+	return nil
+}
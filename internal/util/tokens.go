@@ -0,0 +1,151 @@
+package util
+
+import (
+	"errors"
+	"strings"
+)
+
+// Token is one word of a line.
+type Token struct {
+	Text       string // the word with quotes and escapes resolved
+	Start, End int    // byte offsets of the word as written
+}
+
+// Lexer splits lines into words. The zero value follows sh: blanks
+// (spaces, tabs and other ASCII whitespace) separate words, '...' is
+// literal, "..." keeps backslash escapes of " \ $ and `, a backslash
+// escapes the next byte elsewhere, and an unquoted # that starts a word
+// begins a comment.
+type Lexer struct {
+	// NoSingleQuotes makes ' an ordinary character, as in sudoers.
+	NoSingleQuotes bool
+	// NumericHash keeps a # followed by a digit as a word, as sudoers
+	// does for uid and gid references.
+	NumericHash bool
+}
+
+// Shell is the lexer for shell rc files; Sudoers the one for sudoers.
+var (
+	Shell   = Lexer{}
+	Sudoers = Lexer{NoSingleQuotes: true, NumericHash: true}
+)
+
+// ShellQuote returns s as one shell word: unchanged when it holds only
+// characters no shell treats specially, otherwise in single quotes. A
+// newline or any other control character is quoted too, so the word
+// cannot end the command it is part of.
+func ShellQuote(s string) string {
+	safe := s != ""
+	for i := 0; i < len(s) && safe; i++ {
+		c := s[i]
+		safe = c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.IndexByte("@%+=:,./_-", c) >= 0
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// ExecQuote quotes a path for a systemd ExecStart= line: in double quotes,
+// with systemd's % specifiers and $ expansion escaped.
+func ExecQuote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "%", "%%", "$", "$$").Replace(s)
+	return `"` + s + `"`
+}
+
+// ErrUnterminatedQuote is returned for a quote that is never closed.
+var ErrUnterminatedQuote = errors.New("unterminated quote")
+
+// Tokens splits line into words. comment is the offset of the comment,
+// or len(line) when there is none.
+func (l Lexer) Tokens(line string) (tokens []Token, comment int, err error) {
+	n := len(line)
+	i := 0
+	for {
+		for i < n && isBlank(line[i]) {
+			i++
+		}
+		if i >= n {
+			return tokens, n, nil
+		}
+		if line[i] == '#' && !(l.NumericHash && i+1 < n && line[i+1] >= '0' && line[i+1] <= '9') {
+			return tokens, i, nil
+		}
+		start := i
+		var sb strings.Builder
+		for i < n && !isBlank(line[i]) {
+			switch c := line[i]; {
+			case c == '\\':
+				if i+1 < n {
+					i++
+				}
+				sb.WriteByte(line[i])
+				i++
+			case c == '\'' && !l.NoSingleQuotes:
+				j := strings.IndexByte(line[i+1:], '\'')
+				if j < 0 {
+					return tokens, n, ErrUnterminatedQuote
+				}
+				sb.WriteString(line[i+1 : i+1+j])
+				i += j + 2
+			case c == '"':
+				i++
+				for i < n && line[i] != '"' {
+					if line[i] == '\\' && i+1 < n && strings.IndexByte("\"\\$`", line[i+1]) >= 0 {
+						i++
+					}
+					sb.WriteByte(line[i])
+					i++
+				}
+				if i >= n {
+					return tokens, n, ErrUnterminatedQuote
+				}
+				i++
+			default:
+				sb.WriteByte(c)
+				i++
+			}
+		}
+		tokens = append(tokens, Token{sb.String(), start, i})
+	}
+}
+
+// Words returns the text of line's words, without any comment.
+func (l Lexer) Words(line string) ([]string, error) {
+	tokens, _, err := l.Tokens(line)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]string, len(tokens))
+	for i, t := range tokens {
+		out[i] = t.Text
+	}
+	return out, nil
+}
+
+// StripComment returns line without its comment and surrounding blanks.
+// A line that does not lex is only trimmed.
+func (l Lexer) StripComment(line string) string {
+	_, comment, err := l.Tokens(line)
+	if err != nil {
+		return strings.TrimSpace(line)
+	}
+	return strings.TrimSpace(line[:comment])
+}
+
+// HasWords reports whether words begins with prefix.
+func HasWords(words []string, prefix ...string) bool {
+	if len(words) < len(prefix) {
+		return false
+	}
+	for i, p := range prefix {
+		if words[i] != p {
+			return false
+		}
+	}
+	return true
+}
+
+func isBlank(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n' || c == '\v' || c == '\f'
+}
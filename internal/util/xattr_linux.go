@@ -0,0 +1,66 @@
+//go:build linux
+
+package util
+
+import (
+	"errors"
+	"strings"
+	"syscall"
+)
+
+// CopyXattrs copies the extended attributes of src, such as ACLs, to
+// dst. The SELinux label is left to RestoreSecurityContext, since the
+// right label depends on where dst lives rather than where src came from.
+func CopyXattrs(src, dst string) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		if name == "security.selinux" {
+			continue
+		}
+		val, err := getXattr(src, name)
+		if err != nil {
+			return err
+		}
+		if err := syscall.Setxattr(dst, name, val, 0); err != nil {
+			if unsupported(err) {
+				return nil
+			}
+			return &xattrError{"set", name, dst, err}
+		}
+	}
+	return nil
+}
+
+func listXattrs(path string) ([]string, error) {
+	n, err := syscall.Listxattr(path, nil)
+	if unsupported(err) {
+		return nil, nil
+	}
+	if err != nil || n == 0 {
+		return nil, err
+	}
+	buf := make([]byte, n)
+	if n, err = syscall.Listxattr(path, buf); err != nil {
+		return nil, err
+	}
+	return strings.FieldsFunc(string(buf[:n]), func(r rune) bool { return r == 0 }), nil
+}
+
+func getXattr(path, name string) ([]byte, error) {
+	n, err := syscall.Getxattr(path, name, nil)
+	if err != nil {
+		return nil, &xattrError{"get", name, path, err}
+	}
+	buf := make([]byte, n)
+	if n, err = syscall.Getxattr(path, name, buf); err != nil {
+		return nil, &xattrError{"get", name, path, err}
+	}
+	return buf[:n], nil
+}
+
+func unsupported(err error) bool {
+	return errors.Is(err, syscall.ENOTSUP) || errors.Is(err, syscall.EOPNOTSUPP)
+}
@@ -0,0 +1,8 @@
+//go:build !linux
+
+package util
+
+// CopyXattrs is a no-op where extended attributes are not supported.
+func CopyXattrs(src, dst string) error {
+	return nil
+}
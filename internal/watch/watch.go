@@ -0,0 +1,219 @@
+// Package watch notices when someone edits the managed files by hand. It
+// polls them rather than relying on inotify, which does not follow the
+// rename most editors and shctl itself replace files with. Changes made
+// by shctl, seen in its operation log, are not reported.
+package watch
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"time"
+
+	"github.com/yourusername/shctl/internal/auditlog"
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/journal"
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/plugin"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// Options control Watch.
+type Options struct {
+	// Interval between polls; a second when zero.
+	Interval time.Duration
+	// Reapply puts back the content shctl last left when a file is
+	// edited by hand. The edit is backed up first.
+	Reapply bool
+	// Hook is a shell command run after every outside edit, with
+	// SHCTL_WATCH_FILE and SHCTL_WATCH_EVENT set.
+	Hook string
+}
+
+// event is one outside edit of a managed file.
+type event struct {
+	Time      time.Time
+	Path      string
+	Kind      string   // created, modified or removed
+	Problems  []string // what validating the edited file found
+	Reapplied bool
+}
+
+type file struct {
+	exists bool
+	data   []byte
+	// good is the content to reapply: as found at start or last left by
+	// shctl.
+	good       []byte
+	goodExists bool
+	// pending is a change seen once, classified when the next poll
+	// finds it unchanged, so a shctl run has finished logging it.
+	pending *[sha256.Size]byte
+}
+
+// Watch polls the managed files until ctx is done, reporting every
+// outside edit to w.
+func Watch(ctx context.Context, w io.Writer, opts Options) error {
+	if opts.Interval <= 0 {
+		opts.Interval = time.Second
+	}
+	files := map[string]*file{}
+	if err := poll(ctx, w, opts, files); err != nil {
+		return err
+	}
+	t := time.NewTicker(opts.Interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-t.C:
+		}
+		if err := poll(ctx, w, opts, files); err != nil {
+			return err
+		}
+	}
+}
+
+// poll reads every managed file once. Files seen for the first time,
+// including ones that start being managed, become the baseline.
+func poll(ctx context.Context, w io.Writer, opts Options, files map[string]*file) error {
+	paths, err := snapshot.Managed()
+	if err != nil {
+		return err
+	}
+	for _, p := range paths {
+		data, err := os.ReadFile(p)
+		exists := err == nil
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			logging.Warn("cannot read managed file", "path", p, "err", err)
+			continue
+		}
+		f, ok := files[p]
+		if !ok {
+			files[p] = &file{exists: exists, data: data, good: data, goodExists: exists}
+			continue
+		}
+		if exists == f.exists && bytes.Equal(data, f.data) {
+			f.pending = nil
+			continue
+		}
+		sum := sha256.Sum256(data)
+		if lock, err := util.TargetLockPath(p); err == nil {
+			if held, _ := util.Held(lock); held {
+				continue // shctl is writing it
+			}
+		}
+		if f.pending == nil || *f.pending != sum {
+			f.pending = &sum
+			continue
+		}
+		f.pending = nil
+		kind := "modified"
+		switch {
+		case !exists:
+			kind = "removed"
+		case !f.exists:
+			kind = "created"
+		}
+		f.exists, f.data = exists, data
+		if edited, known, _, _ := auditlog.EditedOutside(p); known && !edited {
+			f.good, f.goodExists = data, exists
+			continue
+		}
+		report(ctx, w, opts, f, event{Time: time.Now(), Path: p, Kind: kind})
+	}
+	return nil
+}
+
+// report handles an outside edit of f: validates it, reapplies the good
+// content and runs the hook as opts ask, and prints what happened.
+func report(ctx context.Context, w io.Writer, opts Options, f *file, e event) {
+	if e.Kind != "removed" {
+		e.Problems = validate(ctx, e.Path)
+	}
+	logging.Warn("managed file edited outside shctl", "path", e.Path, "kind", e.Kind)
+	if opts.Reapply && f.goodExists {
+		if err := reapply(ctx, e.Path, f.good); err != nil {
+			output.Error(w, "reapply %s: %v", e.Path, err)
+		} else {
+			e.Reapplied = true
+			f.exists, f.data = true, f.good
+		}
+	}
+	fmt.Fprintf(w, "%s %s %s outside shctl\n", e.Time.Format("2006-01-02 15:04:05"), e.Path, e.Kind)
+	for _, p := range e.Problems {
+		output.Warn(w, "%s: %s", e.Path, p)
+	}
+	if e.Reapplied {
+		fmt.Fprintf(w, "%s: reapplied the content shctl left\n", e.Path)
+	}
+	if opts.Hook != "" {
+		cmd := exec.CommandContext(ctx, "sh", "-c", opts.Hook)
+		cmd.Stdout, cmd.Stderr = w, w
+		cmd.Env = append(append(os.Environ(), plugin.Environ()...), "SHCTL_WATCH_FILE="+e.Path, "SHCTL_WATCH_EVENT="+e.Kind)
+		logging.Debug("running hook", "argv", cmd.Args)
+		if err := cmd.Run(); err != nil {
+			output.Warn(w, "hook: %v", err)
+		}
+	}
+}
+
+// validate checks the managed entries of an rc file, or runs the
+// subsystem's own check on anything else.
+func validate(ctx context.Context, path string) []string {
+	for _, p := range config.RCFiles() {
+		if p != path {
+			continue
+		}
+		problems, err := rc.Problems(path)
+		if err != nil {
+			return []string{err.Error()}
+		}
+		out := make([]string, len(problems))
+		for i, pr := range problems {
+			out[i] = fmt.Sprintf("line %d: %s", pr.Line, pr.Reason)
+		}
+		return out
+	}
+	if check := snapshot.Validator(path); check != nil {
+		if err := check(ctx, path, path); err != nil {
+			return []string{err.Error()}
+		}
+	}
+	return nil
+}
+
+// reapply writes good over path as one journaled change, after backing
+// up the edited file.
+func reapply(ctx context.Context, path string, good []byte) error {
+	unlock, err := util.LockTarget(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	if err := backup.AutoSave(ctx, path, "watch reapply"); err != nil {
+		return err
+	}
+	perm := fs.FileMode(0o644)
+	if fi, err := fsys.Current.Stat(path); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	tx := journal.New("watch reapply")
+	defer tx.Discard()
+	if err := tx.WriteWith(path, good, perm, snapshot.Writer(path)); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
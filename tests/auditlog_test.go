@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/auditlog"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/sudoers"
+)
+
+func TestSudoersChangeIsAudited(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	records := []auditlog.Record{}
+	old := auditlog.Sink
+	auditlog.Sink = func(r auditlog.Record) error { records = append(records, r); return nil }
+	t.Cleanup(func() { auditlog.Sink = old })
+
+	if err := sudoers.Add(context.Background(), "alice ALL=(ALL) /usr/bin/id"); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected one audit record, got %+v", records)
+	}
+	r := records[0]
+	if r.Subsystem != "sudoers" || r.Target != path || r.Result != auditlog.ResultApplied || len(r.DiffSHA256) != 64 {
+		t.Fatalf("unexpected record %+v", r)
+	}
+	if strings.Join(r.Changes, "\n") != "+alice ALL=(ALL) /usr/bin/id" {
+		t.Fatalf("unexpected changes %q", r.Changes)
+	}
+}
+
+func TestAuditActor(t *testing.T) {
+	t.Setenv("SUDO_USER", "mallory")
+	t.Setenv("DOAS_USER", "")
+	want := "mallory"
+	if os.Geteuid() != 0 {
+		// only sudo running us as root may name the caller
+		u, err := user.Current()
+		if err != nil {
+			t.Skip(err)
+		}
+		want = u.Username
+	}
+	if r := auditlog.New("sudoers", "/etc/sudoers", ""); r.Actor != want {
+		t.Fatalf("actor %q, want %q", r.Actor, want)
+	}
+}
+
+func TestOperationLog(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	ctx := context.Background()
+
+	auditlog.Start([]string{"shctl", "rc", "alias", "add", "ll", "ls -l"})
+	auditlog.Done(rc.AddAlias(ctx, "ll", "ls -l"), false)
+	auditlog.Start([]string{"shctl", "rc", "alias", "remove", "nope"})
+	auditlog.Done(rc.RemoveAlias(ctx, "nope"), false)
+	// commands that are not started are not logged
+	rc.AddAlias(ctx, "la", "ls -a")
+
+	all, err := auditlog.Entries(auditlog.Filter{})
+	if err != nil || len(all) != 2 {
+		t.Fatalf("expected two entries, got %+v, %v", all, err)
+	}
+	e := all[0]
+	if e.Command != "shctl rc alias add ll 'ls -l'" || e.Result != auditlog.ResultApplied || len(e.Files) != 1 || e.Files[0] != rcPath || len(e.DiffSHA256) != 64 {
+		t.Fatalf("unexpected entry %+v", e)
+	}
+	failed, _ := auditlog.Entries(auditlog.Filter{Result: auditlog.ResultFailed})
+	if len(failed) != 1 || !strings.Contains(failed[0].Error, "nope") {
+		t.Fatalf("expected the failed remove, got %+v", failed)
+	}
+	if got, _ := auditlog.Entries(auditlog.Filter{File: "/etc/sudoers"}); len(got) != 0 {
+		t.Fatalf("file filter matched %+v", got)
+	}
+
+	var buf bytes.Buffer
+	if err := auditlog.WriteEntries(&buf, output.Text, all); err != nil || !strings.Contains(buf.String(), "applied") {
+		t.Fatalf("unexpected table %q, %v", buf.String(), err)
+	}
+	if fi, err := os.Stat(auditlog.LogPath()); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("log should be private: %v %v", fi, err)
+	}
+}
@@ -0,0 +1,807 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestBackupList(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SHCTL_BACKUP_DIR", dir)
+	t.Setenv("SHCTL_RC_FILE", "/home/u/.bashrc")
+	os.WriteFile(filepath.Join(dir, ".bashrc.bak.20240101_100000"), []byte("old\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, ".bashrc.bak.20240301_100000"), []byte("newer\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "sudoers.bak.20240201_100000"), []byte("root ALL=(ALL) ALL\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "unrelated.txt"), []byte("x"), 0o644)
+
+	list, err := backup.List(context.Background(), backup.Local(backup.Dir()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 {
+		t.Fatalf("expected 3 backups, got %+v", list)
+	}
+	b := list[0]
+	if b.ID != 1 || b.Source != "/home/u/.bashrc" || b.Size != 6 || b.Time.Month() != 3 {
+		t.Fatalf("unexpected newest backup %+v", b)
+	}
+	if list[1].Source != "/etc/sudoers" || len(list[1].SHA256) != 64 {
+		t.Fatalf("unexpected sudoers backup %+v", list[1])
+	}
+	var sb strings.Builder
+	if err := backup.Write(&sb, output.Text, list); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "2024-03-01 10:00:00") {
+		t.Fatalf("unexpected listing:\n%s", sb.String())
+	}
+}
+
+func TestLatestBySource(t *testing.T) {
+	dir, tmp := t.TempDir(), t.TempDir()
+	t.Setenv("SHCTL_BACKUP_DIR", dir)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	ctx := context.Background()
+	alice, bob := filepath.Join(tmp, "alice", ".bashrc"), filepath.Join(tmp, "bob", ".bashrc")
+	for _, p := range []string{alice, bob} {
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		os.WriteFile(p, []byte("# "+p+"\n"), 0o644)
+		if _, err := backup.Save(ctx, p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store := backup.Local(dir)
+	b, err := backup.Latest(ctx, store, alice)
+	if err != nil || b.Source != alice {
+		t.Fatalf("expected alice's backup, not the newer one of a file with the same name: %+v, %v", b, err)
+	}
+	if data, _ := backup.Read(ctx, store, b); string(data) != "# "+alice+"\n" {
+		t.Fatalf("wrong content %q", data)
+	}
+
+	// .bak files from before the manifest only have their name
+	os.WriteFile(filepath.Join(dir, ".zshrc.bak.20240101_100000"), []byte("legacy\n"), 0o644)
+	if b, err := backup.Latest(ctx, store, filepath.Join(tmp, "carol", ".zshrc")); err != nil || b.Name != ".zshrc.bak.20240101_100000" {
+		t.Fatalf("legacy backup not found: %+v, %v", b, err)
+	}
+	if _, err := backup.Latest(ctx, store, filepath.Join(tmp, "carol", ".bashrc")); !errors.Is(err, backup.ErrNoBackup) {
+		t.Fatalf("expected no backup for an unrelated .bashrc, got %v", err)
+	}
+}
+
+func TestBackupPrune(t *testing.T) {
+	assumeYes(t)
+	dir := t.TempDir()
+	t.Setenv("SHCTL_BACKUP_DIR", dir)
+	src := filepath.Join(t.TempDir(), ".bashrc")
+	t.Setenv("SHCTL_RC_FILE", src)
+	for _, d := range []string{"01", "02", "03", "04"} {
+		os.WriteFile(filepath.Join(dir, ".bashrc.bak.202401"+d+"_100000"), []byte(d), 0o644)
+	}
+	os.WriteFile(filepath.Join(dir, "sudoers.bak.20230101_100000"), []byte("old"), 0o644)
+
+	removed, err := backup.Prune(context.Background(), backup.Local(dir), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 2 || removed[0].Name != ".bashrc.bak.20240102_100000" {
+		t.Fatalf("unexpected removals %+v", removed)
+	}
+	list, _ := backup.List(context.Background(), backup.Local(dir))
+	if len(list) != 3 {
+		t.Fatalf("expected 3 backups left, got %+v", list)
+	}
+
+	// saving a new backup prunes automatically
+	os.WriteFile(src, []byte("live"), 0o644)
+	t.Setenv("SHCTL_BACKUP_KEEP", "1")
+	if _, err := backup.Save(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+	list, _ = backup.List(context.Background(), backup.Local(dir))
+	if len(list) != 2 {
+		t.Fatalf("expected one backup per source after auto prune, got %+v", list)
+	}
+}
+
+func TestPruneConfirmAndPins(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SHCTL_BACKUP_DIR", dir)
+	t.Setenv("SHCTL_BACKUP_KEEP", "0")
+	src := filepath.Join(t.TempDir(), ".bashrc")
+	t.Setenv("SHCTL_RC_FILE", src)
+	store := backup.Local(dir)
+	ctx := context.Background()
+	for _, v := range []string{"1", "2", "3"} {
+		os.WriteFile(src, []byte(v), 0o644)
+		if _, err := backup.Save(ctx, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// without a terminal, destructive commands need --yes
+	in, _ := os.Open(src)
+	defer in.Close()
+	prompt.In, prompt.Out = in, io.Discard
+	t.Cleanup(func() { prompt.In, prompt.Out = os.Stdin, os.Stdout })
+	if _, err := backup.Prune(ctx, store, 1); !errors.Is(err, prompt.ErrNotInteractive) || util.ExitCode(err) != util.ExitAborted {
+		t.Fatalf("expected ErrNotInteractive, got %v", err)
+	}
+
+	assumeYes(t)
+	if err := backup.Pin(ctx, store, 3, true); err != nil {
+		t.Fatal(err)
+	}
+	removed, err := backup.Prune(ctx, store, 1)
+	if err != nil || len(removed) != 1 || removed[0].ID != 2 {
+		t.Fatalf("the pinned oldest backup should be kept, removed %+v, %v", removed, err)
+	}
+	prompt.Force = true
+	t.Cleanup(func() { prompt.Force = false })
+	if removed, _ := backup.Prune(ctx, store, 1); len(removed) != 1 || !removed[0].Pinned {
+		t.Fatalf("--force should prune the pinned backup, removed %+v", removed)
+	}
+}
+
+func TestBackupRetentionPolicy(t *testing.T) {
+	assumeYes(t)
+	dir := t.TempDir()
+	t.Setenv("SHCTL_BACKUP_DIR", dir)
+	t.Setenv("SHCTL_RC_FILE", filepath.Join(t.TempDir(), ".bashrc"))
+	day := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	for i := 0; i < 60; i++ {
+		name := ".bashrc.bak." + day.AddDate(0, 0, i).Format(backup.TimeFormat)
+		os.WriteFile(filepath.Join(dir, name), []byte{byte(i)}, 0o644)
+	}
+
+	if _, err := backup.ParsePolicy("daily=7,fortnightly=2"); err == nil {
+		t.Fatal("expected an unknown period to be rejected")
+	}
+	p, err := backup.ParsePolicy("daily=7, weekly=4, monthly=3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backup.PrunePolicy(context.Background(), backup.Local(dir), p); err != nil {
+		t.Fatal(err)
+	}
+	list, _ := backup.List(context.Background(), backup.Local(dir))
+	got := []string{}
+	for _, b := range list {
+		got = append(got, b.Time.Format("0102"))
+	}
+	// seven days, the Sundays closing two older weeks, and January's last day
+	want := "0229 0228 0227 0226 0225 0224 0223 0218 0211 0131"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("kept %v, want %s", got, want)
+	}
+}
+
+func TestDefaultBackupDirMigration(t *testing.T) {
+	state, legacy := t.TempDir(), t.TempDir()
+	t.Setenv("SHCTL_CONFIG", filepath.Join(state, "none.toml"))
+	t.Setenv("XDG_STATE_HOME", state)
+	t.Setenv("BASM_STATE_DIR", "")
+	t.Setenv("SHCTL_BACKUP_DIR", "")
+	t.Setenv("BASM_BACKUP_DIR", "")
+	t.Setenv("SHCTL_RC_FILE", "/home/u/.bashrc")
+	old := backup.LegacyDir
+	backup.LegacyDir = legacy
+	t.Cleanup(func() { backup.LegacyDir = old })
+	os.WriteFile(filepath.Join(legacy, ".bashrc.bak.20240101_100000"), []byte("old\n"), 0o644)
+	os.WriteFile(filepath.Join(legacy, "other.bak.20240101_100000"), []byte("x"), 0o644)
+
+	want := filepath.Join(state, "shctl", "backups")
+	if backup.Dir() != want {
+		t.Fatalf("default backup dir is %s, want %s", backup.Dir(), want)
+	}
+	store, err := backup.Default(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, err := os.Stat(want); err != nil || fi.Mode().Perm() != 0o700 {
+		t.Fatalf("backup dir should be private: %v %v", fi.Mode(), err)
+	}
+	list, _ := backup.List(context.Background(), store)
+	if len(list) != 1 || list[0].Source != "/home/u/.bashrc" {
+		t.Fatalf("expected the legacy backup to move, got %+v", list)
+	}
+	if _, err := os.Stat(filepath.Join(legacy, "other.bak.20240101_100000")); err != nil {
+		t.Fatal("unrelated files must stay in the legacy dir")
+	}
+
+	t.Setenv("SHCTL_BACKUP_DIR", legacy)
+	if backup.Dir() != legacy {
+		t.Fatal("env override no longer respected")
+	}
+}
+
+func TestCompressedBackupRestore(t *testing.T) {
+	assumeYes(t)
+	dir := t.TempDir()
+	rcFile := filepath.Join(dir, ".bashrc")
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(dir, "backups"))
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("SHCTL_BACKUP_COMPRESS", "gzip")
+	content := strings.Repeat("alias ll='ls -l'\n", 100)
+	os.WriteFile(rcFile, []byte(content), 0o644)
+
+	path, err := backup.Save(context.Background(), rcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(path, ".gz") {
+		t.Fatalf("expected a .gz backup, got %s", path)
+	}
+	list, _ := backup.List(context.Background(), backup.Local(backup.Dir()))
+	if len(list) != 1 || list[0].Compression != backup.CompressGzip || list[0].Size >= int64(len(content)) {
+		t.Fatalf("unexpected backup listing %+v", list)
+	}
+
+	os.WriteFile(rcFile, []byte("broken\n"), 0o644)
+	if err := rc.Restore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(rcFile)
+	if string(b) != content {
+		t.Fatalf("restore did not decompress, got %q", b)
+	}
+
+	t.Setenv("SHCTL_BACKUP_COMPRESS", "lz4")
+	if _, err := backup.Save(context.Background(), rcFile); err == nil {
+		t.Fatal("expected unknown compression to fail")
+	}
+}
+
+func TestRestoreWithoutBackup(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(dir, "backups"))
+	t.Setenv("SHCTL_RC_FILE", filepath.Join(dir, ".bashrc"))
+	os.WriteFile(filepath.Join(dir, ".bashrc"), []byte("alias a='1'\n"), 0o644)
+
+	err := rc.Restore(context.Background())
+	var nb *backup.NoBackupError
+	if !errors.Is(err, backup.ErrNoBackup) || !errors.As(err, &nb) || nb.Source != filepath.Join(dir, ".bashrc") {
+		t.Fatalf("expected a NoBackupError, got %v", err)
+	}
+
+	if _, err := util.SelectLatest(nil); !errors.Is(err, util.ErrNoBackup) {
+		t.Fatalf("empty selection: %v", err)
+	}
+	old, recent := filepath.Join(dir, "old"), filepath.Join(dir, "recent")
+	os.WriteFile(old, nil, 0o644)
+	os.WriteFile(recent, nil, 0o644)
+	os.Chtimes(old, time.Now().Add(-time.Hour), time.Now().Add(-time.Hour))
+	if got, err := util.SelectLatest([]string{recent, old}); err != nil || got != recent {
+		t.Fatalf("SelectLatest = %q, %v", got, err)
+	}
+	if _, err := util.SelectLatest([]string{old, filepath.Join(dir, "gone")}); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("missing file: %v", err)
+	}
+}
+
+// fakeS3 is a path-style, in-memory S3 endpoint that insists on SigV4
+// authorization headers.
+func fakeS3(t *testing.T) *httptest.Server {
+	objects := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") ||
+			r.Header.Get("X-Amz-Content-Sha256") == "" {
+			http.Error(w, "unsigned", http.StatusForbidden)
+			return
+		}
+		key := strings.TrimPrefix(r.URL.Path, "/bucket/")
+		switch {
+		case r.Method == http.MethodGet && r.URL.Query().Get("list-type") == "2":
+			var sb strings.Builder
+			sb.WriteString("<ListBucketResult>")
+			for k, v := range objects {
+				if strings.HasPrefix(k, r.URL.Query().Get("prefix")) {
+					fmt.Fprintf(&sb, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>2024-01-01T00:00:00Z</LastModified></Contents>", k, len(v))
+				}
+			}
+			sb.WriteString("<IsTruncated>false</IsTruncated></ListBucketResult>")
+			io.WriteString(w, sb.String())
+		case r.Method == http.MethodPut:
+			b, _ := io.ReadAll(r.Body)
+			objects[key] = b
+		case r.Method == http.MethodGet:
+			b, ok := objects[key]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write(b)
+		case r.Method == http.MethodDelete:
+			delete(objects, key)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestS3BackupStore(t *testing.T) {
+	assumeYes(t)
+	srv := fakeS3(t)
+	dir := t.TempDir()
+	rcFile := filepath.Join(dir, ".bashrc")
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("BASM_BACKUP_URL", "s3://bucket/hosts/web1")
+	t.Setenv("SHCTL_S3_ENDPOINT", srv.URL)
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	os.WriteFile(rcFile, []byte("export A=1\n"), 0o644)
+
+	loc, err := backup.Save(context.Background(), rcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc, "s3://bucket/hosts/web1/blob.") {
+		t.Fatalf("unexpected location %s", loc)
+	}
+	store, err := backup.Default(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := backup.List(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Source != rcFile || list[0].Size != 11 {
+		t.Fatalf("unexpected remote listing %+v", list)
+	}
+	os.WriteFile(rcFile, []byte("broken\n"), 0o644)
+	if err := rc.Restore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "export A=1\n" {
+		t.Fatalf("restore from s3 gave %q", b)
+	}
+}
+
+// stubSFTP puts an sftp on PATH that runs batch commands against root
+// and fails the first session to exercise retries.
+func stubSFTP(t *testing.T, root string) {
+	bin := t.TempDir()
+	script := `#!/bin/sh
+state=` + bin + `/attempted
+if [ ! -e "$state" ]; then : > "$state"; echo "Connection reset" >&2; exit 255; fi
+case "$*" in *StrictHostKeyChecking=yes*) ;; *) echo "host key checking disabled" >&2; exit 1 ;; esac
+while IFS= read -r line; do
+	eval "set -- $line"
+	cmd=$1; shift
+	case "$cmd" in
+	-mkdir) mkdir -p "` + root + `$1" ;;
+	put) cp "$1" "` + root + `$2" ;;
+	get) cp "` + root + `$1" "$2" || exit 1 ;;
+	rm) rm "` + root + `$1" || exit 1 ;;
+	-ls) for f in "` + root + `$2"/* "` + root + `$2"/.[!.]*; do [ -f "$f" ] && echo "-rw-------    1 0 0 $(wc -c < "$f") Jan  1 00:00 $2/$(basename "$f")"; done ;;
+	esac
+done
+exit 0
+`
+	if err := os.WriteFile(filepath.Join(bin, "sftp"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestSFTPBackupStore(t *testing.T) {
+	assumeYes(t)
+	remote := t.TempDir()
+	stubSFTP(t, remote)
+	backup.SFTPBackoff = time.Millisecond
+	t.Cleanup(func() { backup.SFTPBackoff = time.Second })
+
+	dir := t.TempDir()
+	rcFile := filepath.Join(dir, ".zshrc")
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("SHCTL_BACKUP_URL", "ssh://backup@vault:2222/srv/shctl")
+	os.WriteFile(rcFile, []byte("alias g=git\n"), 0o644)
+
+	loc, err := backup.Save(context.Background(), rcFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(loc, "ssh://backup@vault:2222/srv/shctl/blob.") {
+		t.Fatalf("unexpected location %s", loc)
+	}
+	store, _ := backup.Default(context.Background())
+	list, err := backup.List(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Size != 12 {
+		t.Fatalf("unexpected listing %+v", list)
+	}
+	os.WriteFile(rcFile, nil, 0o644)
+	if err := rc.Restore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "alias g=git\n" {
+		t.Fatalf("restore over sftp gave %q", b)
+	}
+}
+
+func TestConcurrentBackupsAreAllRecorded(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(dir, "backups"))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		p := filepath.Join(dir, fmt.Sprintf("file%d", i))
+		os.WriteFile(p, []byte(p+"\n"), 0o644)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := backup.Save(context.Background(), p); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	store, _ := backup.Default(context.Background())
+	m, err := backup.ReadManifest(context.Background(), store)
+	if err != nil || len(m) != 8 {
+		t.Fatalf("manifest has %d of 8 backups (%v)", len(m), err)
+	}
+}
+
+func TestBackupVerify(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(dir, "backups"))
+	t.Setenv("SHCTL_BACKUP_COMPRESS", "gzip")
+	files := []string{}
+	for _, name := range []string{".bashrc", "sudoers", "doas.conf"} {
+		p := filepath.Join(dir, name)
+		os.WriteFile(p, []byte(strings.Repeat(name+"\n", 50)), 0o644)
+		loc, err := backup.Save(context.Background(), p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, loc)
+	}
+	os.WriteFile(filepath.Join(dir, "backups", "legacy.bak.20200101_000000"), []byte("x"), 0o644)
+	store, _ := backup.Default(context.Background())
+	checks, err := backup.Verify(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range checks {
+		if !c.OK {
+			t.Fatalf("fresh backup failed verification: %+v", c)
+		}
+	}
+
+	b, _ := os.ReadFile(files[0])
+	os.WriteFile(files[0], b[:len(b)/2], 0o600)
+	os.Remove(files[1])
+	checks, _ = backup.Verify(context.Background(), store)
+	failed := map[string]string{}
+	for _, c := range checks {
+		if !c.OK {
+			failed[strings.Split(c.Name, ".bak.")[0]] = c.Message
+		}
+	}
+	if len(failed) != 2 || !strings.Contains(failed[".bashrc"], "truncated") || !strings.Contains(failed["sudoers"], "missing") {
+		t.Fatalf("unexpected verification failures %v", failed)
+	}
+}
+
+func TestBackupDiff(t *testing.T) {
+	dir := t.TempDir()
+	rcFile := filepath.Join(dir, ".bashrc")
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(dir, "backups"))
+	os.WriteFile(rcFile, []byte("alias a=1\nalias b=2\n"), 0o644)
+	if _, err := backup.Save(context.Background(), rcFile); err != nil {
+		t.Fatal(err)
+	}
+	store, _ := backup.Default(context.Background())
+	var sb strings.Builder
+	if err := backup.Diff(context.Background(), &sb, store, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "unchanged") {
+		t.Fatalf("expected no changes, got:\n%s", sb.String())
+	}
+	os.WriteFile(rcFile, []byte("alias a=1\nalias c=3\n"), 0o644)
+	sb.Reset()
+	if err := backup.Diff(context.Background(), &sb, store, 1); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), "-alias b=2\n+alias c=3\n") || !strings.Contains(sb.String(), "+++ "+rcFile) {
+		t.Fatalf("unexpected diff:\n%s", sb.String())
+	}
+	if err := backup.Diff(context.Background(), &sb, store, 2); err == nil {
+		t.Fatal("expected unknown id to fail")
+	}
+}
+
+func TestBackupContentAddressed(t *testing.T) {
+	assumeYes(t)
+	dir := t.TempDir()
+	rcFile := filepath.Join(dir, ".bashrc")
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(dir, "backups"))
+	store, _ := backup.Default(context.Background())
+
+	os.WriteFile(rcFile, []byte("v1\n"), 0o644)
+	first, _ := backup.Save(context.Background(), rcFile)
+	if again, _ := backup.Save(context.Background(), rcFile); again != first {
+		t.Fatalf("unchanged file was backed up again: %s vs %s", again, first)
+	}
+	if list, _ := backup.List(context.Background(), store); len(list) != 1 {
+		t.Fatalf("expected one backup for unchanged content, got %+v", list)
+	}
+
+	// v1 -> v2 -> v1 keeps three backups but only two stored objects
+	os.WriteFile(rcFile, []byte("v2\n"), 0o644)
+	backup.Save(context.Background(), rcFile)
+	os.WriteFile(rcFile, []byte("v1\n"), 0o644)
+	if loc, _ := backup.Save(context.Background(), rcFile); loc != first {
+		t.Fatalf("identical content should reuse %s, got %s", first, loc)
+	}
+	list, _ := backup.List(context.Background(), store)
+	if len(list) != 3 || fmt.Sprint(list[0].SameAs) != "[3]" || len(list[1].SameAs) != 0 {
+		t.Fatalf("unexpected sharing %+v", list)
+	}
+	blobs, _ := filepath.Glob(filepath.Join(dir, "backups", "blob.*"))
+	if len(blobs) != 2 {
+		t.Fatalf("expected 2 stored objects, got %v", blobs)
+	}
+
+	// pruning the older v1 must keep the shared object
+	if _, err := backup.Prune(context.Background(), store, 2); err != nil {
+		t.Fatal(err)
+	}
+	list, _ = backup.List(context.Background(), store)
+	if len(list) != 2 {
+		t.Fatalf("expected 2 backups after prune, got %+v", list)
+	}
+	if b, err := backup.Read(context.Background(), store, list[0]); err != nil || string(b) != "v1\n" {
+		t.Fatalf("shared object lost by prune: %q, %v", b, err)
+	}
+}
+
+func TestRestoreDryRun(t *testing.T) {
+	dir := t.TempDir()
+	rcFile := filepath.Join(dir, ".bashrc")
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(dir, "backups"))
+	os.WriteFile(rcFile, []byte("alias a=1\n"), 0o644)
+	backup.Save(context.Background(), rcFile)
+	os.WriteFile(rcFile, []byte("alias a=2\n"), 0o644)
+
+	var sb strings.Builder
+	if err := rc.PreviewRestore(context.Background(), &sb); err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+	if !strings.Contains(out, "would overwrite "+rcFile+" with backup 1") || !strings.Contains(out, "-alias a=2\n+alias a=1\n") {
+		t.Fatalf("unexpected preview:\n%s", out)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "alias a=2\n" {
+		t.Fatalf("dry run changed the file: %q", b)
+	}
+}
+
+func TestBackupMetadata(t *testing.T) {
+	assumeYes(t)
+	dir := t.TempDir()
+	rcFile := filepath.Join(dir, ".bashrc")
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(dir, "backups"))
+	os.WriteFile(rcFile, []byte("alias a=1\n"), 0o640)
+	if err := backup.AutoSave(context.Background(), rcFile, "rc alias add"); err != nil {
+		t.Fatal(err)
+	}
+
+	store, _ := backup.Default(context.Background())
+	list, err := backup.List(context.Background(), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Meta == nil {
+		t.Fatalf("expected one backup with metadata, got %+v", list)
+	}
+	m := list[0].Meta
+	host, _ := os.Hostname()
+	if m.Host != host || m.Source != rcFile || m.Mode != 0o640 || m.Op != "rc alias add" || m.Version == "" {
+		t.Fatalf("unexpected metadata %+v", m)
+	}
+
+	var sb strings.Builder
+	if err := rc.PreviewRestore(context.Background(), &sb); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(sb.String(), `during "rc alias add"`) || !strings.Contains(sb.String(), "mode 0640") {
+		t.Fatalf("preview does not show metadata:\n%s", sb.String())
+	}
+
+	if _, err := backup.Prune(context.Background(), store, 1); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(rcFile, []byte("alias a=2\n"), 0o640)
+	backup.Save(context.Background(), rcFile)
+	backup.Prune(context.Background(), store, 1)
+	objs, _ := store.List(context.Background())
+	metas := 0
+	for _, o := range objs {
+		if strings.HasSuffix(o.Name, ".meta.json") {
+			metas++
+		}
+	}
+	if metas != 1 {
+		t.Fatalf("pruned backups should lose their sidecars, got %+v", objs)
+	}
+}
+
+func TestBackupExportImport(t *testing.T) {
+	dir := t.TempDir()
+	rcFile := filepath.Join(dir, ".bashrc")
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(dir, "old"))
+	for _, c := range []string{"alias a=1\n", "alias a=2\n"} {
+		os.WriteFile(rcFile, []byte(c), 0o644)
+		if err := backup.AutoSave(context.Background(), rcFile, "rc alias add"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old, _ := backup.Default(context.Background())
+	archive := filepath.Join(dir, "backups.tar.gz")
+	if _, err := backup.Export(context.Background(), old, filepath.Join(dir, "backups.zip")); err == nil {
+		t.Fatal("expected unknown archive type to be rejected")
+	}
+	if _, err := backup.Export(context.Background(), old, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	fresh := backup.Local(filepath.Join(dir, "new"))
+	os.WriteFile(rcFile, []byte("alias a=3\n"), 0o644)
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(dir, "new"))
+	backup.Save(context.Background(), rcFile)
+	if _, err := backup.Import(context.Background(), fresh, archive); err != nil {
+		t.Fatal(err)
+	}
+	list, err := backup.List(context.Background(), fresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 3 || list[2].Meta == nil || list[2].Op != "rc alias add" {
+		t.Fatalf("expected imported history next to the local backup, got %+v", list)
+	}
+	checks, _ := backup.Verify(context.Background(), fresh)
+	for _, c := range checks {
+		if !c.OK {
+			t.Fatalf("imported backup fails verification: %+v", c)
+		}
+	}
+	if n, err := backup.Import(context.Background(), fresh, archive); err != nil || n != 0 {
+		t.Fatalf("importing twice should add nothing: %d %v", n, err)
+	}
+}
+
+func TestBackupScheduleCrontab(t *testing.T) {
+	bin := t.TempDir()
+	tab := filepath.Join(bin, "tab")
+	script := "#!/bin/sh\nif [ \"$1\" = -l ]; then [ -f " + tab + " ] || { echo 'no crontab for test' >&2; exit 1; }; cat " + tab + "; else cat > " + tab + "; fi\n"
+	os.WriteFile(filepath.Join(bin, "crontab"), []byte(script), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	if err := backup.Schedule(context.Background(), "/usr/local/bin/shctl", "monthly", false); err == nil {
+		t.Fatal("expected unknown frequency to fail")
+	}
+	user := "MAILTO=root\n\n# nightly\n0 1 * * * true\n\n"
+	os.WriteFile(tab, []byte(user), 0o600)
+	if err := backup.Schedule(context.Background(), "/usr/local/bin/shctl", "daily", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := backup.Schedule(context.Background(), "/usr/local/bin/shctl", "hourly", false); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(tab)
+	if string(b) != user+"0 * * * * /usr/local/bin/shctl snapshot create # shctl:backup-schedule\n" {
+		t.Fatalf("unexpected crontab:\n%s", b)
+	}
+	if err := backup.Unschedule(context.Background(), false); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(tab); string(b) != user {
+		t.Fatalf("schedule not removed:\n%s", b)
+	}
+
+	// a binary path with spaces or % stays one word
+	line, _ := backup.ScheduleCron("/opt/my tools/shctl%1", "daily")
+	if line != `30 3 * * * '/opt/my tools/shctl\%1' snapshot create # shctl:backup-schedule` {
+		t.Fatalf("unexpected cron line %q", line)
+	}
+	service, _, _ := backup.ScheduleSystemd("/opt/my tools/shctl%1", "daily")
+	if !strings.Contains(service, `ExecStart="/opt/my tools/shctl%%1" snapshot create`) {
+		t.Fatalf("unexpected service:\n%s", service)
+	}
+}
+
+func TestGitBackupStore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	dir := t.TempDir()
+	repo := filepath.Join(dir, "repo")
+	remote := filepath.Join(dir, "remote.git")
+	if out, err := exec.Command("git", "init", "-q", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatalf("%s: %v", out, err)
+	}
+	rcFile := filepath.Join(dir, ".bashrc")
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("SHCTL_BACKUP_URL", "git://"+repo)
+
+	os.WriteFile(rcFile, []byte("alias a=1\n"), 0o644)
+	if _, err := backup.Save(context.Background(), rcFile); err != nil {
+		t.Fatal(err)
+	}
+	exec.Command("git", "-C", repo, "remote", "add", "origin", remote).Run()
+	os.WriteFile(rcFile, []byte("alias a=2\n"), 0o644)
+	if err := backup.AutoSave(context.Background(), rcFile, "rc alias add"); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := exec.Command("git", "-C", repo, "log", "--format=%s").Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "rc alias add: "+rcFile+"\nbackup: "+rcFile+"\n" {
+		t.Fatalf("unexpected history:\n%s", out)
+	}
+	tracked := filepath.Join("files", strings.TrimPrefix(rcFile, "/"))
+	if b, _ := os.ReadFile(filepath.Join(repo, tracked)); string(b) != "alias a=2\n" {
+		t.Fatalf("live copy not tracked: %q", b)
+	}
+	if out, err := exec.Command("git", "-C", remote, "log", "--oneline").Output(); err != nil || strings.Count(string(out), "\n") != 2 {
+		t.Fatalf("commits not pushed: %s %v", out, err)
+	}
+	store, _ := backup.Default(context.Background())
+	if list, _ := backup.List(context.Background(), store); len(list) != 2 {
+		t.Fatalf("expected 2 backups in git store, got %+v", list)
+	}
+}
+
+func TestShowDiffAfterWrite(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	os.WriteFile(rcPath, []byte("alias a='1'\n"), 0o644)
+	backup.WriteChanges(io.Discard)
+
+	ctx := context.Background()
+	rc.AddAlias(ctx, "b", "2")
+	rc.RemoveAlias(ctx, "a")
+	var buf bytes.Buffer
+	if err := backup.WriteChanges(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.Contains(got, "-alias a='1'\n+alias b='2'\n") || strings.Count(got, "+++ ") != 1 {
+		t.Fatalf("expected one diff covering both writes, got %q", got)
+	}
+	buf.Reset()
+	if backup.WriteChanges(&buf); buf.Len() != 0 {
+		t.Fatalf("changes should be forgotten once shown, got %q", buf.String())
+	}
+}
@@ -0,0 +1,92 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/batch"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestBatch(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	sudoersPath := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	os.WriteFile(rcPath, []byte("alias old='true'\n"), 0o644)
+	ctx := context.Background()
+
+	script := `# provisioning
+alias add ll 'ls -l'
+shctl alias add gs "git status"
+export add EDITOR vim
+alias remove old
+sudoers add "alice ALL=(ALL) /usr/bin/id"
+`
+	undoScript := filepath.Join(tmp, "undo.sh")
+	t.Setenv("SHCTL_UNDO_SCRIPT", undoScript)
+	results, err := batch.Run(ctx, strings.NewReader(script))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SHCTL_UNDO_SCRIPT", "")
+	if len(results) != 5 || results[1].Line != 3 || results[1].Status != batch.OK {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if b, _ := os.ReadFile(rcPath); string(b) != "alias ll='ls -l'\nalias gs='git status'\nexport EDITOR=vim\n" {
+		t.Fatalf("unexpected rc: %q", b)
+	}
+	if b, _ := os.ReadFile(sudoersPath); !strings.Contains(string(b), "alice ALL=(ALL) /usr/bin/id") {
+		t.Fatalf("sudoers entry not added: %q", b)
+	}
+	ops, err := backup.Operations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0].Op != "batch" || len(ops[0].Files) != 2 {
+		t.Fatalf("expected one batch operation over both files, got %+v", ops)
+	}
+
+	// the undo script puts both files back without shctl
+	if b, err := os.ReadFile(undoScript); err != nil || !strings.Contains(string(b), rcPath) || !strings.Contains(string(b), sudoersPath) {
+		t.Fatalf("undo script does not cover the batch: %q, %v", b, err)
+	}
+	if os.Geteuid() == 0 {
+		saved, _ := os.ReadFile(rcPath)
+		if out, err := exec.Command("sh", undoScript).CombinedOutput(); err != nil {
+			t.Fatalf("undo script: %v: %s", err, out)
+		}
+		if b, _ := os.ReadFile(rcPath); string(b) != "alias old='true'\n" {
+			t.Fatalf("rc not restored: %q", b)
+		}
+		if b, _ := os.ReadFile(sudoersPath); string(b) != "root ALL=(ALL) ALL\n" {
+			t.Fatalf("sudoers not restored: %q", b)
+		}
+		os.WriteFile(rcPath, saved, 0o644)
+	}
+
+	// a failing line stops the batch and leaves every file alone
+	before, _ := os.ReadFile(rcPath)
+	results, err = batch.Run(ctx, strings.NewReader("alias add x 'echo x'\nalias remove nope\nexport add A 1\nfrobnicate\n"))
+	if err == nil || !strings.Contains(err.Error(), "line 2:") {
+		t.Fatalf("expected the error of line 2, got %v", err)
+	}
+	if results[1].Status != batch.Failed || results[2].Status != batch.Skipped || results[3].Status != batch.Skipped {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+	if b, _ := os.ReadFile(rcPath); string(b) != string(before) {
+		t.Fatalf("failed batch changed the rc file: %q", b)
+	}
+
+	if _, err := batch.Run(ctx, strings.NewReader("frobnicate now\n")); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a usage error for an unknown command, got %v", err)
+	}
+}
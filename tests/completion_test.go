@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/completion"
+)
+
+func TestCompletion(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	os.WriteFile(rcPath, []byte("alias ll='ls -l'\nalias la='ls -a'\nexport EDITOR=vi\n"), 0o644)
+
+	root := completion.Command{Name: "shctl", Flags: []string{"dry-run"}, Subs: []completion.Command{
+		{Name: "rc", Subs: []completion.Command{
+			{Name: "alias", Subs: []completion.Command{
+				{Name: "add"},
+				{Name: "remove", Flags: []string{"force"}, Args: completion.Aliases},
+			}},
+			{Name: "export", Subs: []completion.Command{{Name: "remove", Args: completion.Exports}}},
+		}},
+		{Name: "restore", Args: completion.Backups},
+	}}
+	ctx := context.Background()
+	for _, c := range []struct {
+		words []string
+		want  []string
+	}{
+		{[]string{""}, []string{"rc", "restore"}},
+		{[]string{"rc", "a"}, []string{"alias"}},
+		{[]string{"rc", "alias", "remove", "l"}, []string{"la", "ll"}},
+		{[]string{"rc", "alias", "remove", "--"}, []string{"--dry-run", "--force"}},
+		{[]string{"rc", "export", "remove", ""}, []string{"EDITOR"}},
+		{[]string{"restore", ""}, nil},
+	} {
+		if got := completion.Complete(ctx, root, c.words); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Complete(%q) = %q, want %q", c.words, got, c.want)
+		}
+	}
+
+	for _, sh := range completion.Shells {
+		var buf bytes.Buffer
+		if err := completion.Script(&buf, sh, "shctl"); err != nil || !bytes.Contains(buf.Bytes(), []byte("shctl __complete")) {
+			t.Fatalf("%s script: %q, %v", sh, buf.String(), err)
+		}
+		if sh == "bash" {
+			if _, err := exec.LookPath("bash"); err == nil {
+				if out, err := exec.Command("bash", "-n", "-c", buf.String()).CombinedOutput(); err != nil {
+					t.Fatalf("bash script does not parse: %s", out)
+				}
+			}
+		}
+	}
+	if err := completion.Script(&bytes.Buffer{}, "tcsh", "shctl"); err == nil {
+		t.Fatal("expected an error for an unsupported shell")
+	}
+}
@@ -0,0 +1,137 @@
+package tests
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/sudoers"
+)
+
+func TestExplainPrecedence(t *testing.T) {
+	tmp := t.TempDir()
+	cfg := filepath.Join(tmp, "config.toml")
+	if err := os.WriteFile(cfg, []byte("# shctl\nrc_file = \"/from/file\"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SHCTL_CONFIG", cfg)
+	t.Setenv("SHCTL_RC_FILE", "")
+	t.Setenv("BASM_RC_FILE", "")
+
+	e, err := config.Explain("rc_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Value != "/from/file" || e.Winner != "config "+cfg {
+		t.Fatalf("expected config file to win, got %+v", e)
+	}
+
+	t.Setenv("BASM_RC_FILE", "/from/env")
+	if got := config.Get("rc_file"); got != "/from/env" {
+		t.Fatalf("expected env to override file, got %q", got)
+	}
+
+	config.SetFlag("rc_file", "/from/flag")
+	defer config.SetFlag("rc_file", "")
+	e, _ = config.Explain("rc_file")
+	if e.Value != "/from/flag" || e.Winner != "flag --rc-file" {
+		t.Fatalf("expected flag to win, got %+v", e)
+	}
+
+	if _, err := config.Explain("nope"); err == nil {
+		t.Fatal("expected unknown key error")
+	}
+}
+
+func TestPathFlags(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("SHCTL_CONFIG", filepath.Join(tmp, "config.toml"))
+	t.Setenv("BASM_SUDOERS_PATH", "/from/env")
+
+	names := map[string]string{}
+	for _, f := range config.Flags() {
+		if f.Usage == "" {
+			t.Errorf("flag --%s has no usage", f.Name)
+		}
+		names[f.Name] = f.Key
+	}
+	for flag, key := range map[string]string{"rc-file": "rc_file", "sudoers-file": "sudoers_file", "backup-dir": "backup_dir"} {
+		if names[flag] != key {
+			t.Fatalf("--%s should set %s, got %q", flag, key, names[flag])
+		}
+	}
+
+	path := filepath.Join(tmp, "sudoers")
+	config.SetFlag("sudoers_file", path)
+	defer config.SetFlag("sudoers_file", "")
+	if got := sudoers.SudoersPath(); got != path {
+		t.Fatalf("flag should beat env, got %q", got)
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	tmp := t.TempDir()
+	cfg := filepath.Join(tmp, "shctl", "config.toml")
+	t.Setenv("SHCTL_CONFIG", cfg)
+	t.Setenv("SHCTL_RC_FILE", "")
+	t.Setenv("BASM_RC_FILE", "")
+	t.Setenv("HOME", tmp)
+
+	if err := config.Set("shell", "zsh"); err != nil {
+		t.Fatal(err)
+	}
+	if got := config.Get("rc_file"); got != filepath.Join(tmp, ".zshrc") {
+		t.Fatalf("zsh should default to .zshrc, got %q", got)
+	}
+	os.WriteFile(cfg, []byte("# keep me\nshell = \"zsh\"\n\n[profiles.work]\nshell = \"bash\"\n"), 0o644)
+	if err := config.Set("backup_keep", "5"); err != nil {
+		t.Fatal(err)
+	}
+	if err := config.Set("shell", ""); err != nil {
+		t.Fatal(err)
+	}
+	want := "# keep me\n\nbackup_keep = \"5\"\n[profiles.work]\nshell = \"bash\"\n"
+	if b, _ := os.ReadFile(cfg); string(b) != want {
+		t.Fatalf("unexpected config file %q", b)
+	}
+	if got := config.Get("backup_keep"); got != "5" {
+		t.Fatalf("backup_keep not read back: %q", got)
+	}
+
+	for key, bad := range map[string]string{"shell": "fish", "sudoers_mode": "0666", "output": "xml", "color": "sometimes", "nope": "1"} {
+		if err := config.Set(key, bad); err == nil {
+			t.Errorf("expected %s = %q to be rejected", key, bad)
+		}
+	}
+}
+
+func TestVersionOutput(t *testing.T) {
+	version, commit, date := config.Version, config.Commit, config.Date
+	t.Cleanup(func() { config.Version, config.Commit, config.Date = version, commit, date })
+	config.Version, config.Commit, config.Date = "1.4.0", "0123456789abcdef", "2026-10-01T12:00:00Z"
+
+	b := config.BuildInfo()
+	if b.Version != "1.4.0" || b.Commit != "0123456789abcdef" || b.GoVersion != runtime.Version() {
+		t.Fatalf("unexpected build info: %+v", b)
+	}
+	var buf bytes.Buffer
+	if err := config.WriteVersion(&buf, "text", b); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "shctl 1.4.0 (commit 0123456789ab") {
+		t.Fatalf("unexpected version line: %q", buf.String())
+	}
+	buf.Reset()
+	if err := config.WriteVersion(&buf, "json", b); err != nil {
+		t.Fatal(err)
+	}
+	var got config.Build
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil || got != b {
+		t.Fatalf("json round trip: %+v (%v)", got, err)
+	}
+}
@@ -0,0 +1,191 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/cron"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// setupCron puts a stub crontab on PATH that keeps the installed crontab
+// in a file, starting with content, or with none when content is empty.
+func setupCron(t *testing.T, content string) string {
+	t.Helper()
+	assumeYes(t)
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.Out = os.Stdout })
+	tmp := t.TempDir()
+	spool := filepath.Join(tmp, "spool")
+	if content != "" {
+		os.WriteFile(spool, []byte(content), 0o600)
+	}
+	bin := filepath.Join(tmp, "bin")
+	os.Mkdir(bin, 0o755)
+	stub := "#!/bin/sh\ncase \"$1\" in\n" +
+		"-l) [ -f " + spool + " ] || { echo \"no crontab for $USER\" >&2; exit 1; }; cat " + spool + " ;;\n" +
+		"-) cat > " + spool + " ;;\nesac\n"
+	if err := os.WriteFile(filepath.Join(bin, "crontab"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	return spool
+}
+
+func TestCronAddListRemove(t *testing.T) {
+	spool := setupCron(t, "")
+	ctx := context.Background()
+	if err := cron.Add(ctx, "*/5 * * * *", "/usr/local/bin/sync-mail"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.Add(ctx, "@daily", "backup.sh  --quiet"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.Add(ctx, "@daily", "backup.sh  --quiet"); !errors.Is(err, cron.ErrJobExists) {
+		t.Fatalf("expected ErrJobExists, got %v", err)
+	}
+	for _, sched := range []string{"60 * * * *", "* * * 13 *", "* * *", "@often", "5-1 * * * *", "*/0 * * * *"} {
+		if err := cron.Add(ctx, sched, "true"); !errors.Is(err, util.ErrUsage) {
+			t.Fatalf("%q: expected a usage error, got %v", sched, err)
+		}
+	}
+	b, _ := os.ReadFile(spool)
+	if string(b) != "*/5 * * * * /usr/local/bin/sync-mail\n@daily backup.sh  --quiet\n" {
+		t.Fatalf("unexpected crontab:\n%s", b)
+	}
+
+	var out strings.Builder
+	if err := cron.List(ctx, &out); err != nil || out.String() != "  1  1: */5 * * * * /usr/local/bin/sync-mail\n  2  2: @daily backup.sh  --quiet\n" {
+		t.Fatalf("unexpected list %q (%v)", out.String(), err)
+	}
+	jobs, err := cron.Jobs(ctx)
+	if err != nil || jobs[1].Schedule != "@daily" || jobs[1].Command != "backup.sh  --quiet" {
+		t.Fatalf("unexpected jobs %+v (%v)", jobs, err)
+	}
+
+	if err := cron.Remove(ctx, "/usr/local/bin/sync-mail"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.Remove(ctx, "missing"); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	b, _ = os.ReadFile(spool)
+	if string(b) != "@daily backup.sh  --quiet\n" {
+		t.Fatalf("unexpected crontab after remove:\n%s", b)
+	}
+	items, _ := trash.Items()
+	if len(items) != 1 || items[0].Kind != "cron" || items[0].Lines[0] != "*/5 * * * * /usr/local/bin/sync-mail" {
+		t.Fatalf("expected the removed job in the trash, got %+v", items)
+	}
+}
+
+func TestCronBackupRestore(t *testing.T) {
+	spool := setupCron(t, "MAILTO=ops\n0 3 * * mon-fri /opt/report\n")
+	ctx := context.Background()
+	if err := cron.Backup(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// edited with crontab -e behind shctl's back
+	os.WriteFile(spool, []byte("MAILTO=ops\n"), 0o600)
+
+	var out strings.Builder
+	if err := cron.Diff(ctx, &out, 0); err != nil || !strings.Contains(out.String(), "-0 3 * * mon-fri /opt/report") {
+		t.Fatalf("unexpected diff %q (%v)", out.String(), err)
+	}
+	if err := cron.Restore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(spool)
+	if string(b) != "MAILTO=ops\n0 3 * * mon-fri /opt/report\n" {
+		t.Fatalf("unexpected crontab after restore:\n%s", b)
+	}
+
+	os.WriteFile(spool, []byte("0 3 * * * ok\nnonsense\n"), 0o600)
+	if err := cron.RemoveNumber(ctx, 1); err == nil || !strings.Contains(err.Error(), "validation failed") {
+		t.Fatalf("expected the invalid crontab to be refused, got %v", err)
+	}
+}
+
+func TestCronSystemDropIns(t *testing.T) {
+	assumeYes(t)
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.Out = os.Stdout })
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "cron.d")
+	os.Mkdir(dir, 0o755)
+	t.Setenv("SHCTL_CRON_DIR", dir)
+	t.Setenv("SHCTL_SYSTEM_CRONTAB", filepath.Join(tmp, "crontab"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	ctx := context.Background()
+
+	if err := cron.AddSystem(ctx, "nightly", "30 2 * * *", "root", "/usr/local/sbin/rotate"); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "nightly")
+	b, _ := os.ReadFile(path)
+	if string(b) != "30 2 * * * root /usr/local/sbin/rotate\n" {
+		t.Fatalf("unexpected drop-in:\n%s", b)
+	}
+	if fi, _ := os.Stat(path); fi.Mode().Perm() != cron.SystemMode {
+		t.Fatalf("drop-in has mode %v", fi.Mode())
+	}
+	if err := cron.AddSystem(ctx, "nightly.bak", "@daily", "root", "true"); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a name cron ignores to be refused, got %v", err)
+	}
+	if err := cron.AddSystem(ctx, "nightly", "@daily", "no-such-user-shctl", "true"); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected an unknown user to be refused, got %v", err)
+	}
+
+	jobs, err := cron.SystemJobs()
+	if err != nil || len(jobs) != 1 || jobs[0].User != "root" || jobs[0].Source != path || jobs[0].Command != "/usr/local/sbin/rotate" {
+		t.Fatalf("unexpected system jobs %+v (%v)", jobs, err)
+	}
+	var out strings.Builder
+	if err := cron.CheckSystem(&out); err != nil {
+		t.Fatalf("check failed: %v\n%s", err, out.String())
+	}
+
+	if err := cron.BackupSystem(ctx, "nightly"); err != nil {
+		t.Fatal(err)
+	}
+	// a line without a user field is the classic mistake in cron.d
+	os.WriteFile(path, []byte("30 2 * * * /usr/local/sbin/rotate\n"), 0o644)
+	os.WriteFile(filepath.Join(dir, "loose"), []byte("@hourly root true\n"), 0o666)
+	os.Chmod(filepath.Join(dir, "loose"), 0o666)
+	out.Reset()
+	if err := cron.CheckSystem(&out); err == nil || !strings.Contains(out.String(), "want five time fields and a user") || !strings.Contains(out.String(), "lets others write it") {
+		t.Fatalf("expected check to fail, got %v\n%s", err, out.String())
+	}
+	os.Remove(filepath.Join(dir, "loose"))
+	if err := cron.RemoveSystem(ctx, "nightly", "/usr/local/sbin/rotate"); err == nil {
+		t.Fatal("expected the invalid drop-in to be refused")
+	}
+	if err := cron.RestoreSystem(ctx, "nightly"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cron.DeleteSystem(ctx, "nightly"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("expected the drop-in to be deleted, got %v", err)
+	}
+	items, _ := trash.Items()
+	if len(items) != 1 || items[0].Path != path {
+		t.Fatalf("expected the deleted jobs in the trash, got %+v", items)
+	}
+	if err := trash.Restore(ctx, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "30 2 * * * root /usr/local/sbin/rotate\n" {
+		t.Fatalf("unexpected drop-in after trash restore:\n%s", b)
+	}
+}
@@ -0,0 +1,20 @@
+package tests
+
+import (
+	"testing"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestUnifiedDiff(t *testing.T) {
+	a := []byte("root ALL=(ALL) ALL\nalice ALL=(ALL) ALL\nbob ALL=(ALL) ALL\n")
+	b := []byte("root ALL=(ALL) ALL\nbob ALL=(ALL) ALL\ncarol ALL=(ALL) ALL\n")
+
+	want := "--- old\n+++ new\n@@ -1,3 +1,3 @@\n root ALL=(ALL) ALL\n-alice ALL=(ALL) ALL\n bob ALL=(ALL) ALL\n+carol ALL=(ALL) ALL\n"
+	if got := util.UnifiedDiff("old", "new", a, b); got != want {
+		t.Fatalf("unexpected diff:\n%s", got)
+	}
+	if got := util.UnifiedDiff("old", "new", a, a); got != "" {
+		t.Fatalf("expected empty diff for equal input, got %q", got)
+	}
+}
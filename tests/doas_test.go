@@ -0,0 +1,80 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/doas"
+	"github.com/yourusername/shctl/internal/prompt"
+)
+
+func setupDoas(t *testing.T, content string) string {
+	t.Helper()
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "doas.conf")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SHCTL_DOAS_FILE", path)
+	t.Setenv("SHCTL_BACKUP_DIR", tmp)
+	prompt.AssumeYes = true
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.AssumeYes = false; prompt.Out = os.Stdout })
+	return path
+}
+
+func TestParseDoas(t *testing.T) {
+	src := "# ops\npermit persist setenv { PATH -LANG } :wheel\n" +
+		"permit nopass alice as root cmd /usr/bin/systemctl args restart \"my svc\"\n" +
+		"deny bob\npermit nopass\n"
+	rules, err := doas.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rules) != 4 {
+		t.Fatalf("expected 4 rules, got %+v", rules)
+	}
+	if r := rules[0]; r.Identity != ":wheel" || strings.Join(r.SetEnv, ",") != "PATH,-LANG" {
+		t.Fatalf("unexpected rule %+v", r)
+	}
+	r := rules[1]
+	if r.Target != "root" || r.Cmd != "/usr/bin/systemctl" || !r.HasArgs || strings.Join(r.Args, "|") != "restart|my svc" {
+		t.Fatalf("unexpected rule %+v", r)
+	}
+	if r.String() != `permit nopass alice as root cmd /usr/bin/systemctl args restart "my svc"` {
+		t.Fatalf("unexpected canonical form %q", r.String())
+	}
+	if rules[3].Err == "" {
+		t.Fatal("rule without identity should not parse")
+	}
+}
+
+func TestDoasAddRemoveCheck(t *testing.T) {
+	path := setupDoas(t, "permit persist :wheel\n")
+	if err := doas.Add(context.Background(), "permit nopass alice cmd /usr/bin/id"); err != nil {
+		t.Fatal(err)
+	}
+	if err := doas.Add(context.Background(), "permit alice cmd"); err == nil {
+		t.Fatal("expected invalid rule to be rejected")
+	}
+	var out strings.Builder
+	if err := doas.Check(context.Background(), &out); err != nil {
+		t.Fatalf("check failed: %v\n%s", err, out.String())
+	}
+	if err := doas.Remove(context.Background(), "alice", "/usr/bin/id"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if string(b) != "permit persist :wheel\n" {
+		t.Fatalf("unexpected doas.conf:\n%s", b)
+	}
+
+	os.WriteFile(path, []byte("permit nopass carol\npermit bob as\n"), 0o600)
+	out.Reset()
+	if err := doas.Check(context.Background(), &out); err == nil || !strings.Contains(out.String(), "warning: "+path+":1:") {
+		t.Fatalf("expected check failure with warning, got %v\n%s", err, out.String())
+	}
+}
@@ -0,0 +1,90 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/shctl/internal/doctor"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestDoctor(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	setupSudoers(t, "root ALL=(ALL) ALL\n")
+	os.WriteFile(rcPath, []byte("alias ll='ls -l'\nalias gs='git status\nalias ll='ls -la'\ngreet() { echo # shctl:args echo\n"), 0o644)
+
+	stale := filepath.Join(tmp, ".rc_test.tmp123")
+	os.WriteFile(stale, nil, 0o600)
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(stale, old, old)
+
+	results, err := doctor.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]doctor.Result{}
+	for _, r := range results {
+		got[r.Check] = r
+	}
+	if r := got["rc file"]; r.Status != doctor.OK {
+		t.Fatalf("rc file: %+v", r)
+	}
+	if r := got["visudo"]; r.Status != doctor.OK {
+		t.Fatalf("visudo: %+v", r)
+	}
+	if r := got["temp files"]; r.Status != doctor.Warn || !strings.Contains(r.Detail, stale) || r.Fix == "" {
+		t.Fatalf("temp files: %+v", r)
+	}
+	r := got["managed entries"]
+	for _, want := range []string{"line 2: does not parse", "line 3: alias ll is already defined on line 1", "line 4: generated function is incomplete"} {
+		if r.Status != doctor.Warn || !strings.Contains(r.Detail, want) {
+			t.Fatalf("managed entries: want %q in %+v", want, r)
+		}
+	}
+
+	// a lock held by another run is reported
+	dir := filepath.Join(tmp, "state", "locks")
+	os.MkdirAll(dir, 0o700)
+	unlock, err := util.Lock(filepath.Join(dir, "sudoers-abc.lock"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, _ = doctor.Run(context.Background())
+	unlock()
+	var buf bytes.Buffer
+	if err := doctor.Write(&buf, "text", results); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "held by another shctl run: sudoers-abc") {
+		t.Fatalf("held lock not reported:\n%s", buf.String())
+	}
+
+	// once released, doctor removes the stale lock file
+	results, _ = doctor.Run(context.Background())
+	for _, r := range results {
+		if r.Check == "locks" && !strings.Contains(r.Detail, "removed 1 stale") {
+			t.Fatalf("locks: %+v", r)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "sudoers-abc.lock")); err == nil {
+		t.Fatal("stale lock file not removed")
+	}
+
+	// a read-only rc file fails the run
+	os.Chmod(rcPath, 0o400)
+	if os.Getuid() != 0 {
+		if _, err := doctor.Run(context.Background()); !errors.Is(err, doctor.ErrProblems) {
+			t.Fatalf("expected ErrProblems for a read-only rc file, got %v", err)
+		}
+	}
+}
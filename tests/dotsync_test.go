@@ -0,0 +1,150 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/dotsync"
+	"github.com/yourusername/shctl/internal/prompt"
+	_ "github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// syncMachine points HOME, the rc file and the sync repository at a
+// fresh directory, as if shctl ran on another machine.
+func syncMachine(t *testing.T, tmp, name, rc string) string {
+	t.Helper()
+	home := filepath.Join(tmp, name)
+	os.MkdirAll(home, 0o755)
+	rcFile := filepath.Join(home, ".bashrc")
+	if rc != "" {
+		os.WriteFile(rcFile, []byte(rc), 0o644)
+	}
+	t.Setenv("HOME", home)
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("SHCTL_SYNC_DIR", filepath.Join(home, "sync"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(home, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(home, "backups"))
+	return rcFile
+}
+
+func TestSyncPushPull(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	assumeYes(t)
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.Out = os.Stdout })
+	tmp := t.TempDir()
+	remote := filepath.Join(tmp, "remote.git")
+	if out, err := exec.Command("git", "init", "-q", "--bare", remote).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	ctx := context.Background()
+
+	laptop := "alias ll='ls -l'\nexport EDITOR=vim\n"
+	syncMachine(t, tmp, "laptop", laptop)
+	if err := dotsync.Push(ctx, false); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound before init, got %v", err)
+	}
+	if err := dotsync.Init(ctx, remote); err != nil {
+		t.Fatal(err)
+	}
+	if err := dotsync.Init(ctx, remote); !errors.Is(err, util.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists, got %v", err)
+	}
+	if err := dotsync.Push(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+
+	rcFile := syncMachine(t, tmp, "desktop", "")
+	if err := dotsync.Init(ctx, remote); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != laptop {
+		t.Fatalf("rc file not taken over: %q", b)
+	}
+
+	// a machine-only change goes to the overlay and stays off the laptop
+	os.WriteFile(rcFile, []byte(laptop+"export DISPLAY=:1\n"), 0o644)
+	if err := dotsync.Push(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	overlay := filepath.Join(dotsync.Dir(), "hosts", dotsync.Host(), "files", "rc", "home", ".bashrc")
+	if _, err := os.Stat(overlay); err != nil {
+		t.Fatalf("no overlay: %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dotsync.Dir(), "files", "rc", "home", ".bashrc")); string(b) != laptop {
+		t.Fatalf("shared copy changed: %q", b)
+	}
+	os.WriteFile(rcFile, []byte("broken\n"), 0o644)
+	if err := dotsync.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != laptop+"export DISPLAY=:1\n" {
+		t.Fatalf("overlay not applied: %q", b)
+	}
+
+	// files the repository names that no subsystem manages stay untouched
+	home := filepath.Dir(rcFile)
+	for _, k := range []string{"files/rc/home/.ssh/authorized_keys", "files/nosuch/home/.profile", "files/tmux/root" + home + "/planted"} {
+		p := filepath.Join(dotsync.Dir(), filepath.FromSlash(k))
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		os.WriteFile(p, []byte("planted\n"), 0o644)
+	}
+	if err := dotsync.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range []string{filepath.Join(home, ".ssh", "authorized_keys"), filepath.Join(home, ".profile"), filepath.Join(home, "planted")} {
+		if _, err := os.Stat(p); err == nil {
+			t.Errorf("sync pull wrote %s", p)
+		}
+	}
+}
+
+func TestSyncBlocks(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	assumeYes(t)
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.Out = os.Stdout })
+	t.Setenv("SHCTL_SYNC_MODE", "blocks")
+	tmp := t.TempDir()
+	ctx := context.Background()
+
+	block := "# BEGIN shctl profile\n# profile: work\nexport AWS_PROFILE=work\n# END shctl profile\n"
+	syncMachine(t, tmp, "laptop", "alias ll='ls -l'\n\n"+block)
+	if err := dotsync.Init(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := dotsync.Push(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	repo := dotsync.Dir()
+	if b, _ := os.ReadFile(filepath.Join(repo, "blocks", "rc", "home", ".bashrc")); string(b) != block {
+		t.Fatalf("unexpected blocks: %q", b)
+	}
+
+	rcFile := syncMachine(t, tmp, "desktop", "alias gs='git status'\n")
+	if out, err := exec.Command("git", "clone", "-q", repo, dotsync.Dir()).CombinedOutput(); err != nil {
+		t.Fatalf("%v: %s", err, out)
+	}
+	if err := dotsync.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "alias gs='git status'\n\n"+block {
+		t.Fatalf("block not merged: %q", b)
+	}
+	if err := dotsync.Pull(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "alias gs='git status'\n\n"+block {
+		t.Fatalf("second pull changed the file: %q", b)
+	}
+}
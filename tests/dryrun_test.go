@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/sudoers"
+)
+
+func TestDryRun(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	rcFile := filepath.Join(filepath.Dir(path), ".bashrc")
+	os.WriteFile(rcFile, []byte("alias a='1'\n"), 0o644)
+	t.Setenv("BASM_RC_FILE", rcFile)
+
+	var sb strings.Builder
+	changes, err := dryrun.Run(&sb, func() error {
+		if err := rc.RemoveAlias(context.Background(), "a"); err != nil {
+			return err
+		}
+		if err := rc.AddAlias(context.Background(), "b", "2"); err != nil {
+			return err
+		}
+		return sudoers.Add(context.Background(), "alice ALL=(root) /usr/bin/id")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+	if len(changes) != 2 || !strings.Contains(out, "2 file(s) would change") ||
+		!strings.Contains(out, "-alias a='1'\n+alias b='2'\n") || !strings.Contains(out, "+alice ALL=(root) /usr/bin/id") {
+		t.Fatalf("unexpected dry run report:\n%s", out)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "alias a='1'\n" {
+		t.Fatalf("dry run changed the rc file: %q", b)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "root ALL=(ALL) ALL\n" {
+		t.Fatalf("dry run changed sudoers: %q", b)
+	}
+	if list, _ := backup.List(context.Background(), backup.Local(backup.Dir())); len(list) != 0 {
+		t.Fatalf("dry run took backups: %+v", list)
+	}
+
+	// afterwards everything is real again
+	if err := rc.AddAlias(context.Background(), "c", "3"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcFile); !strings.Contains(string(b), "alias c='3'") {
+		t.Fatalf("write after the dry run went nowhere: %q", b)
+	}
+}
+
+func TestDryRunFlagReportsCommands(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	tmp := filepath.Dir(path)
+	marker := filepath.Join(tmp, "visudo-ran")
+	os.WriteFile(filepath.Join(tmp, "bin", "visudo"), []byte("#!/bin/sh\ntouch "+marker+"\n"), 0o755)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(tmp, "config"))
+	dryrun.Enabled = true
+	t.Cleanup(func() { dryrun.Enabled = false })
+
+	var sb strings.Builder
+	err := dryrun.Maybe(&sb, func() error {
+		if err := sudoers.Add(context.Background(), "alice ALL=(root) /usr/bin/id"); err != nil {
+			return err
+		}
+		return backup.Schedule(context.Background(), "/usr/local/bin/shctl", "daily", true)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := sb.String()
+	for _, want := range []string{
+		"+alice ALL=(root) /usr/bin/id",
+		"shctl-snapshot.timer (dry run)",
+		"would run: visudo -c -f ",
+		"would run: systemctl --user enable --now shctl-snapshot.timer\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("dry run report misses %q:\n%s", want, out)
+		}
+	}
+	if _, err := os.Stat(marker); err == nil {
+		t.Fatal("visudo ran during the dry run")
+	}
+	if _, err := os.Stat(filepath.Join(tmp, "config", "systemd")); err == nil {
+		t.Fatal("unit files written during the dry run")
+	}
+}
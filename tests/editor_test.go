@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/editor"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestEditorVimBlock(t *testing.T) {
+	tmp := t.TempDir()
+	vimrc := filepath.Join(tmp, ".vimrc")
+	os.WriteFile(vimrc, []byte("nnoremap <leader>w :w<CR>\n"), 0o644)
+	t.Setenv("SHCTL_VIMRC", vimrc)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	ctx := context.Background()
+
+	for _, o := range []string{"number", "tabstop=4", "leader= ", "bootstrap=vim-plug", "listchars=tab:> ,trail:-", "nonumber"} {
+		if err := editor.Set(ctx, editor.Vim, o); err != nil {
+			t.Fatalf("%s: %v", o, err)
+		}
+	}
+	b, _ := os.ReadFile(vimrc)
+	got := string(b)
+	want := "\" BEGIN shctl editor\nlet mapleader = \" \"\nset nonumber\nset tabstop=4\nset listchars=tab:>\\ ,trail:-\n\" bootstrap: vim-plug\n"
+	if !strings.HasPrefix(got, want) {
+		t.Fatalf("unexpected vimrc:\n%s", got)
+	}
+	if !strings.HasSuffix(got, "endif\n\" END shctl editor\n\nnnoremap <leader>w :w<CR>\n") {
+		t.Fatalf("block not at the top of the vimrc:\n%s", got)
+	}
+	settings, err := editor.Settings(editor.Vim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals := map[string]string{}
+	for _, s := range settings {
+		vals[s.Name] = s.Value
+	}
+	if len(settings) != 5 || vals["leader"] != " " || vals["number"] != "false" || vals["listchars"] != "tab:> ,trail:-" || vals["bootstrap"] != "vim-plug" {
+		t.Fatalf("unexpected settings: %+v", settings)
+	}
+	if err := editor.Set(ctx, editor.Vim, "bootstrap=lazy"); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("want a usage error for a bootstrap vim lacks, got %v", err)
+	}
+	if err := editor.Set(ctx, "emacs", "number"); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("want a usage error for an unknown editor, got %v", err)
+	}
+}
+
+func TestEditorNeovimBlock(t *testing.T) {
+	tmp := t.TempDir()
+	init := filepath.Join(tmp, "nvim", "init.lua")
+	t.Setenv("SHCTL_NVIM_INIT", init)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	ctx := context.Background()
+
+	for _, o := range []string{"leader=,", "relativenumber", "shiftwidth=2", "bootstrap=vim-plug", "signcolumn=yes", "bootstrap=lazy"} {
+		if err := editor.Set(ctx, editor.Neovim, o); err != nil {
+			t.Fatalf("%s: %v", o, err)
+		}
+	}
+	b, _ := os.ReadFile(init)
+	got := string(b)
+	want := "-- BEGIN shctl editor\nvim.g.mapleader = \",\"\nvim.opt.relativenumber = true\nvim.opt.shiftwidth = 2\nvim.opt.signcolumn = \"yes\"\n-- bootstrap: lazy\n"
+	if !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "vim.opt.rtp:prepend(lazypath)\n-- END shctl editor\n") {
+		t.Fatalf("unexpected init.lua:\n%s", got)
+	}
+	if strings.Contains(got, "vim-plug") {
+		t.Fatalf("the earlier bootstrap was kept:\n%s", got)
+	}
+	if err := editor.Set(ctx, editor.Neovim, "leader"); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("want a usage error for a leader without a key, got %v", err)
+	}
+}
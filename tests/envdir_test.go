@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/envdir"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestEnvdirAllowSetExport(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	t.Setenv("SHCTL_ENVDIR", "")
+	t.Setenv("SHCTL_ENVDIR_WARNED", "")
+	proj := filepath.Join(tmp, "proj")
+	sub := filepath.Join(proj, "src")
+	os.MkdirAll(sub, 0o755)
+	ctx := context.Background()
+
+	if err := envdir.Set(ctx, proj, "API_URL", "http://localhost:8080"); err != nil {
+		t.Fatal(err)
+	}
+	if err := envdir.Set(ctx, proj, "GREETING", "it's here"); err != nil {
+		t.Fatal(err)
+	}
+	if err := envdir.Set(ctx, proj, "1BAD", "x"); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+	vars, err := envdir.Vars(proj)
+	if err != nil || len(vars) != 2 || vars[1].Value != "it's here" {
+		t.Fatalf("unexpected vars %+v (%v)", vars, err)
+	}
+
+	t.Setenv("GREETING", "before")
+	var out, errOut strings.Builder
+	if err := envdir.Export(&out, &errOut, "shctl", sub); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "export API_URL=http://localhost:8080\n") ||
+		!strings.Contains(out.String(), "export SHCTL_ENVDIR="+proj+"\n") {
+		t.Fatalf("unexpected export code:\n%s", out.String())
+	}
+	if bash, err := exec.LookPath("bash"); err == nil {
+		script := out.String() + "echo \"$GREETING\"\neval \"$SHCTL_ENVDIR_RESTORE\"\necho \"$GREETING ${API_URL-unset}\"\n"
+		got, err := exec.Command(bash, "-c", script).CombinedOutput()
+		if err != nil || string(got) != "it's here\nbefore unset\n" {
+			t.Fatalf("bash ran %q (%v)", got, err)
+		}
+	}
+
+	// leaving the tree unloads it
+	t.Setenv("SHCTL_ENVDIR", proj)
+	t.Setenv("SHCTL_ENVDIR_SUM", "stale")
+	out.Reset()
+	if err := envdir.Export(&out, &errOut, "shctl", tmp); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "eval \"$SHCTL_ENVDIR_RESTORE\"\nunset SHCTL_ENVDIR SHCTL_ENVDIR_SUM SHCTL_ENVDIR_RESTORE\n" {
+		t.Fatalf("unexpected unload code:\n%s", out.String())
+	}
+	t.Setenv("SHCTL_ENVDIR", "")
+
+	// a file edited by hand is not loaded until allowed again
+	f, _ := os.OpenFile(filepath.Join(proj, envdir.FileName), os.O_APPEND|os.O_WRONLY, 0)
+	f.WriteString("export EVIL=1\n")
+	f.Close()
+	out.Reset()
+	if err := envdir.Export(&out, &errOut, "shctl", proj); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "EVIL") || !strings.Contains(errOut.String(), "is changed") {
+		t.Fatalf("changed file loaded:\n%s\n%s", out.String(), errOut.String())
+	}
+	if err := envdir.Set(ctx, proj, "X", "y"); !errors.Is(err, util.ErrPermission) {
+		t.Fatalf("expected a permission error, got %v", err)
+	}
+	if err := envdir.Allow(proj); err != nil {
+		t.Fatal(err)
+	}
+	var list strings.Builder
+	if err := envdir.List(&list, "text", ""); err != nil || list.String() != proj+" (allowed)\n" {
+		t.Fatalf("unexpected list %q (%v)", list.String(), err)
+	}
+	if err := envdir.Deny(proj); err != nil {
+		t.Fatal(err)
+	}
+	list.Reset()
+	if err := envdir.List(&list, "text", ""); err != nil || list.String() != "" {
+		t.Fatalf("unexpected list %q (%v)", list.String(), err)
+	}
+}
@@ -0,0 +1,31 @@
+package tests
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/escalate"
+)
+
+func TestEscalatorConfigured(t *testing.T) {
+	t.Setenv("SHCTL_ESCALATOR", "doas")
+	cmd, err := escalate.Command(context.Background(), "cp", "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(cmd.Args, " "); got != "doas cp a b" {
+		t.Fatalf("unexpected command %q", got)
+	}
+
+	t.Setenv("SHCTL_ESCALATOR", "none")
+	cmd, _ = escalate.Command(context.Background(), "cp", "a", "b")
+	if got := strings.Join(cmd.Args, " "); got != "cp a b" {
+		t.Fatalf("unexpected command %q", got)
+	}
+
+	t.Setenv("SHCTL_ESCALATOR", "su")
+	if _, err := escalate.Get(); err == nil {
+		t.Fatal("expected unknown escalator to fail")
+	}
+}
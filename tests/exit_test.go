@@ -0,0 +1,52 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/fleet"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/sudoers"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestExitCodes(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	os.WriteFile(filepath.Join(filepath.Dir(path), "bin", "visudo"), []byte("#!/bin/sh\necho 'syntax error' >&2\nexit 1\n"), 0o755)
+	tmp := t.TempDir()
+	t.Setenv("BASM_RC_FILE", filepath.Join(tmp, "rc_test"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	ctx := context.Background()
+
+	os.WriteFile(filepath.Join(tmp, "a.json"), []byte(`{"host":"a"}`), 0o644)
+	os.WriteFile(filepath.Join(tmp, "b.json"), []byte(`{`), 0o644)
+	_, fleetErr := fleet.Load(tmp)
+
+	for _, c := range []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"success", nil, util.ExitOK},
+		{"other", errors.New("boom"), util.ExitFailure},
+		{"missing alias", rc.RemoveAlias(ctx, "nope"), util.ExitNotFound},
+		{"missing trash item", trash.Restore(ctx, 42), util.ExitNotFound},
+		{"invalid rule", sudoers.Add(ctx, "alice ALL=(ALL) /usr/bin/id"), util.ExitValidation},
+		{"permission", fmt.Errorf("write: %w", fs.ErrPermission), util.ExitPermission},
+		{"locked", fmt.Errorf("x: %w", &util.LockedError{Path: "/etc/sudoers"}), util.ExitLocked},
+		{"partial", fleetErr, util.ExitPartial},
+		{"aborted", prompt.ErrAborted, util.ExitAborted},
+		{"usage", fmt.Errorf("unknown flag: %w", util.ErrUsage), util.ExitUsage},
+	} {
+		if got := util.ExitCode(c.err); got != c.want {
+			t.Errorf("%s: ExitCode(%v) = %d, want %d", c.name, c.err, got, c.want)
+		}
+	}
+}
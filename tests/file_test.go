@@ -0,0 +1,130 @@
+package tests
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestRewriteKeepsModeAndLeavesNoTemp(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "rc")
+	os.WriteFile(path, []byte("a\nb\n"), 0o600)
+	os.Chmod(path, 0o600)
+
+	if err := util.RemoveLinesWithPrefix(path, "a"); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o600 {
+		t.Fatalf("mode changed to %v", fi.Mode().Perm())
+	}
+	if b, _ := os.ReadFile(path); string(b) != "b\n" {
+		t.Fatalf("unexpected content %q", b)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 1 {
+		t.Fatalf("temp files left behind: %v", entries)
+	}
+}
+
+func TestCopyFilePreserve(t *testing.T) {
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "sub", "dst")
+	os.WriteFile(src, []byte("root ALL=(ALL) ALL\n"), 0o440)
+	os.Chmod(src, 0o440)
+	mtime := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	os.Chtimes(src, mtime, mtime)
+
+	if err := util.CopyFilePreserve(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o440 || !fi.ModTime().Equal(mtime) {
+		t.Fatalf("metadata not preserved: %v %v", fi.Mode(), fi.ModTime())
+	}
+	if b, _ := os.ReadFile(dst); string(b) != "root ALL=(ALL) ALL\n" {
+		t.Fatalf("unexpected content %q", b)
+	}
+}
+
+func TestLineEditsKeepLineEndings(t *testing.T) {
+	dir := t.TempDir()
+	cases := []struct{ in, drop, want string }{
+		{"a\r\nb\r\nc\r\n", "b", "a\r\nc\r\n"},
+		{"a\nb", "a", "b"},
+		{"a\nb", "b", "a"},
+		{"a\r\nb\n\nc", "c", "a\r\nb\n"},
+	}
+	for i, c := range cases {
+		path := filepath.Join(dir, fmt.Sprint(i))
+		os.WriteFile(path, []byte(c.in), 0o644)
+		if err := util.RemoveLinesWithPrefix(path, c.drop); err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := os.ReadFile(path); string(b) != c.want {
+			t.Errorf("removing %q from %q gave %q, want %q", c.drop, c.in, b, c.want)
+		}
+	}
+
+	path := filepath.Join(dir, "crlf")
+	os.WriteFile(path, []byte("alias a='1'\r\nalias b='2'"), 0o644)
+	if err := util.AppendLines(path, "alias c='3'"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "alias a='1'\r\nalias b='2'\r\nalias c='3'\r\n" {
+		t.Fatalf("append did not follow the file's line endings: %q", b)
+	}
+}
+
+func TestEditLinesStreamsLargeFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history")
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bufio.NewWriter(f)
+	const lines = 200000
+	for i := 1; i <= lines; i++ {
+		fmt.Fprintf(w, "cmd %d\r\n", i)
+	}
+	w.Flush()
+	f.Close()
+
+	n, err := util.EditLines(fsys.OS, path, func(n int, l string) (string, bool) {
+		switch {
+		case n%2 == 0:
+			return l, false
+		case n == 1:
+			return "first", true
+		}
+		return l, true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != lines/2+1 {
+		t.Fatalf("changed %d lines, want %d", n, lines/2+1)
+	}
+	b, _ := os.ReadFile(path)
+	if !strings.HasPrefix(string(b), "first\r\ncmd 3\r\ncmd 5\r\n") || !strings.HasSuffix(string(b), "cmd 199999\r\n") {
+		t.Fatalf("unexpected result: %q ... %q", b[:30], b[len(b)-30:])
+	}
+
+	if n, err := util.EditLines(fsys.OS, path, func(_ int, l string) (string, bool) { return l, true }); err != nil || n != 0 {
+		t.Fatalf("no-op edit: %d, %v", n, err)
+	}
+}
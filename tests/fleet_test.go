@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/shctl/internal/fleet"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestFleetReport(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 10, 12, 0, 0, 0, time.UTC)
+	recent, old := now.Add(-time.Hour).Format(time.RFC3339), now.Add(-10*24*time.Hour).Format(time.RFC3339)
+	states := map[string]string{
+		"web1.json": `{"host": "web1", "group": "web", "collected": "` + recent + `"}`,
+		"web2.json": `{"host": "web2", "group": "web", "collected": "` + recent + `",
+			"drift": [{"file": "/etc/sudoers", "kind": "sudoers", "name": "deploy", "expected": "deploy ALL=(root) /usr/bin/systemctl", "actual": "deploy ALL=(ALL) ALL"}]}`,
+		"db/db1.json": `{"group": "db", "collected": "` + old + `"}`,
+		"lab.json":    `{"host": "lab", "collected": "` + recent + `", "errors": ["cannot read /etc/sudoers"]}`,
+		"notes.txt":   "not a state",
+	}
+	for name, content := range states {
+		p := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(p), 0o755)
+		os.WriteFile(p, []byte(content), 0o644)
+	}
+
+	got, err := fleet.Load(dir)
+	if err != nil || len(got) != 4 {
+		t.Fatalf("expected four host states, got %+v, %v", got, err)
+	}
+	hosts := map[string]fleet.HostState{}
+	for _, st := range got {
+		hosts[st.Host] = st
+	}
+	if hosts["db1"].Group != "db" || hosts["lab"].Group != "ungrouped" {
+		t.Fatalf("host names and groups not filled in: %+v", hosts)
+	}
+	if !hosts["web1"].Compliant() || hosts["web2"].Compliant() || hosts["lab"].Compliant() {
+		t.Fatalf("unexpected compliance: %+v", hosts)
+	}
+
+	var out strings.Builder
+	if err := fleet.Report(&out, got, now); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(out.String(), "\n")
+	summary := map[string]string{}
+	for _, l := range lines[1:] {
+		if f := strings.Fields(l); len(f) == 5 {
+			summary[f[0]] = strings.Join(f[1:], " ")
+		}
+	}
+	// hosts, compliant, drifted, stale
+	for group, want := range map[string]string{"db": "1 1 0 1", "ungrouped": "1 0 1 0", "web": "2 1 1 0"} {
+		if summary[group] != want {
+			t.Errorf("group %s: got %q, want %q\n%s", group, summary[group], want, out.String())
+		}
+	}
+	for _, want := range []string{
+		"[db] db1 (collected " + old + ")\n  stale: no state for 240h0m0s\n",
+		"[ungrouped] lab (collected " + recent + ")\n  error: cannot read /etc/sudoers\n",
+		`[web] web2 (collected ` + recent + ")\n  drift: sudoers deploy in /etc/sudoers (expected \"deploy ALL=(root) /usr/bin/systemctl\", found \"deploy ALL=(ALL) ALL\")\n",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("report lacks %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "web1 (") {
+		t.Errorf("compliant host listed:\n%s", out.String())
+	}
+
+	// a state that does not parse is reported, the others still load
+	os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{"), 0o644)
+	got, err = fleet.Load(dir)
+	var partial *util.PartialError
+	if !errors.As(err, &partial) || partial.Failed != 1 || len(got) != 4 {
+		t.Fatalf("expected a partial load, got %d states, %v", len(got), err)
+	}
+}
@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/fsys"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/sudoers"
+)
+
+func TestMemoryFS(t *testing.T) {
+	m := fsys.NewMemory()
+	if err := m.WriteFile("/etc/x", []byte("a"), 0o644); err == nil {
+		t.Fatal("expected writing without a parent dir to fail")
+	}
+	m.MkdirAll("/etc/sudoers.d", 0o755)
+	m.WriteFile("/etc/sudoers.d/ops", []byte("x"), 0o440)
+	m.AppendFile("/etc/sudoers.d/ops", []byte("y"), 0o644)
+	if b, _ := m.ReadFile("/etc/sudoers.d/ops"); string(b) != "xy" {
+		t.Fatalf("unexpected content %q", b)
+	}
+	if fi, _ := m.Stat("/etc/sudoers.d/ops"); fi.Mode().Perm() != 0o440 {
+		t.Fatalf("append changed the mode to %v", fi.Mode())
+	}
+	ents, err := m.ReadDir("/etc")
+	if err != nil || len(ents) != 1 || !ents[0].IsDir() {
+		t.Fatalf("unexpected listing %v %v", ents, err)
+	}
+	if err := m.Remove("/etc"); err == nil {
+		t.Fatal("expected removing a non-empty dir to fail")
+	}
+}
+
+func TestEditsStayInMemory(t *testing.T) {
+	host := setupSudoers(t, "")
+	os.Remove(host)
+	mem := fsys.NewMemory()
+	t.Cleanup(fsys.Use(mem))
+
+	rcFile := filepath.Join(filepath.Dir(host), "home", ".bashrc")
+	t.Setenv("BASM_RC_FILE", rcFile)
+	if err := rc.AddAlias(context.Background(), "ll", "ls -l"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	rc.ListAliases(&buf)
+	if buf.String() != "alias ll='ls -l'\n" {
+		t.Fatalf("unexpected aliases %q", buf.String())
+	}
+
+	mem.MkdirAll(filepath.Dir(host), 0o755)
+	mem.WriteFile(host, []byte("root ALL=(ALL) ALL\n"), 0o440)
+	if err := sudoers.Add(context.Background(), "alice ALL=(root) /usr/bin/id"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := mem.ReadFile(host)
+	if !strings.Contains(string(b), "alice ALL=(root) /usr/bin/id") {
+		t.Fatalf("rule not added in memory: %q", b)
+	}
+	for _, p := range []string{host, rcFile} {
+		if _, err := os.Stat(p); err == nil {
+			t.Fatalf("%s was written to the host", p)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/gitconf"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestGitAliasesAndScopedConfig(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "gitconfig")
+	t.Setenv("SHCTL_GIT_CONFIG", path)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	ctx := context.Background()
+
+	if err := gitconf.AddAlias(ctx, "st", "status -sb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitconf.AddAlias(ctx, "lg", `log --graph --format="%h %s"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitconf.AddAlias(ctx, "st", "stash"); !errors.Is(err, util.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists, got %v", err)
+	}
+	if err := gitconf.AddAlias(ctx, "bad name", "x"); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+	if err := gitconf.Set(ctx, "user.name", "Pat Doe", ""); err != nil {
+		t.Fatal(err)
+	}
+	work := filepath.Join(tmp, "work")
+	if err := gitconf.Set(ctx, "user.email", "pat@work.example", work); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitconf.Set(ctx, "user.email", "pat@corp.example", work); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitconf.Set(ctx, "nodot", "x", ""); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+	scoped, err := gitconf.ScopePath(ctx, work)
+	if err != nil || scoped != filepath.Join(tmp, ".gitconfig.d", strings.ReplaceAll(strings.Trim(filepath.ToSlash(work), "/"), "/", "-")) {
+		t.Fatalf("unexpected scope file %s (%v)", scoped, err)
+	}
+
+	// git itself picks the scoped identity inside the tree only
+	repo := filepath.Join(work, "repo")
+	for _, argv := range [][]string{{"init", "-q", repo}, {"init", "-q", filepath.Join(tmp, "other")}} {
+		if out, err := exec.Command("git", argv...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %s", argv, out)
+		}
+	}
+	for dir, want := range map[string]string{repo: "pat@corp.example", filepath.Join(tmp, "other"): ""} {
+		cmd := exec.Command("git", "-C", dir, "config", "user.email")
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_GLOBAL="+path, "GIT_CONFIG_NOSYSTEM=1")
+		out, _ := cmd.Output()
+		if strings.TrimSpace(string(out)) != want {
+			t.Fatalf("%s: user.email is %q, want %q", dir, out, want)
+		}
+	}
+
+	var out strings.Builder
+	if err := gitconf.ListAliases(ctx, &out, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "lg = log --graph --format=\"%h %s\"\nst = status -sb\n" {
+		t.Fatalf("unexpected list %q", out.String())
+	}
+
+	if err := gitconf.RemoveAliases(ctx, []string{"st"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitconf.RemoveAliases(ctx, []string{"st"}); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	items, _ := trash.Items()
+	if len(items) != 1 {
+		t.Fatalf("unexpected trash %+v", items)
+	}
+	if err := trash.Restore(ctx, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if v, ok, err := gitconf.Get(ctx, "alias.st"); err != nil || !ok || v != "status -sb" {
+		t.Fatalf("st not restored: %q %v %v", v, ok, err)
+	}
+}
+
+func TestGlobalGitignore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "gitconfig")
+	t.Setenv("SHCTL_GIT_CONFIG", path)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	ctx := context.Background()
+
+	if err := gitconf.AddIgnore(ctx, []string{".DS_Store", "*.swp"}); err != nil {
+		t.Fatal(err)
+	}
+	ignore := filepath.Join(tmp, ".gitignore_global")
+	if v, ok, err := gitconf.Get(ctx, "core.excludesFile"); err != nil || !ok || v != ignore {
+		t.Fatalf("core.excludesFile is %q %v %v", v, ok, err)
+	}
+	if err := gitconf.AddIgnore(ctx, []string{"*.swp"}); !errors.Is(err, util.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists, got %v", err)
+	}
+	if err := gitconf.AddIgnore(ctx, []string{"# comment"}); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+
+	// a key already set is followed, not replaced
+	other := filepath.Join(tmp, "ignore")
+	os.WriteFile(other, []byte("# mine\nnode_modules/\n"), 0o644)
+	if err := gitconf.Set(ctx, "core.excludesFile", other, ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitconf.AddIgnore(ctx, []string{".env"}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(other); string(b) != "# mine\nnode_modules/\n.env\n" {
+		t.Fatalf("unexpected gitignore:\n%s", b)
+	}
+	var out strings.Builder
+	if err := gitconf.ListIgnore(ctx, &out, "text"); err != nil || out.String() != "node_modules/\n.env\n" {
+		t.Fatalf("unexpected list %q (%v)", out.String(), err)
+	}
+
+	if err := gitconf.RemoveIgnore(ctx, []string{"node_modules/"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := gitconf.RemoveIgnore(ctx, []string{"node_modules/"}); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	items, _ := trash.Items()
+	if len(items) != 1 {
+		t.Fatalf("unexpected trash %+v", items)
+	}
+	if err := trash.Restore(ctx, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(other); string(b) != "# mine\n.env\nnode_modules/\n" {
+		t.Fatalf("pattern not restored:\n%s", b)
+	}
+}
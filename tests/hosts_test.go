@@ -0,0 +1,89 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/hosts"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestHostsAddRemove(t *testing.T) {
+	assumeYes(t)
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.Out = os.Stdout })
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "hosts")
+	t.Setenv("SHCTL_HOSTS_FILE", path)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	os.WriteFile(path, []byte("127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost\n10.0.0.5 nas # hand-written\n"), 0o644)
+	ctx := context.Background()
+
+	if err := hosts.Add(ctx, "192.168.1.10", []string{"web.lan", "web"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hosts.Add(ctx, "192.168.1.10", []string{"api.lan"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hosts.Add(ctx, "fd00::10", []string{"web.lan"}); err != nil {
+		t.Fatalf("another address family should not conflict: %v", err)
+	}
+	want := "127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost\n10.0.0.5 nas # hand-written\n\n" +
+		"# BEGIN shctl managed hosts\n192.168.1.10\tweb.lan web api.lan\nfd00::10\tweb.lan\n# END shctl managed hosts\n"
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Fatalf("unexpected hosts file:\n%s", b)
+	}
+
+	if err := hosts.Add(ctx, "192.168.1.10", []string{"WEB"}); !errors.Is(err, util.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists, got %v", err)
+	}
+	if err := hosts.Add(ctx, "192.168.1.99", []string{"nas"}); !errors.Is(err, hosts.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	for _, bad := range [][]string{{"300.1.1.1", "x"}, {"10.0.0.1", "-bad"}, {"10.0.0.1", "under_score"}, {"10.0.0.1"}} {
+		if err := hosts.Add(ctx, bad[0], bad[1:]); !errors.Is(err, util.ErrUsage) {
+			t.Fatalf("%q: expected a usage error, got %v", bad, err)
+		}
+	}
+
+	if err := hosts.Remove(ctx, "10.0.0.5", nil); !errors.Is(err, hosts.ErrUnmanaged) {
+		t.Fatalf("expected ErrUnmanaged, got %v", err)
+	}
+	if err := hosts.Remove(ctx, "192.168.1.10", []string{"web"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := hosts.Remove(ctx, "192.168.1.10", []string{"web"}); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := hosts.Remove(ctx, "fd00::10", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var out strings.Builder
+	if err := hosts.List(&out, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "127.0.0.1       localhost (line 1)\n::1             localhost ip6-localhost (line 2)\n"+
+		"10.0.0.5        nas (line 3)\n192.168.1.10    web.lan api.lan\n" {
+		t.Fatalf("unexpected list %q", out.String())
+	}
+
+	items, _ := trash.Items()
+	if len(items) != 2 {
+		t.Fatalf("unexpected trash %+v", items)
+	}
+	if err := trash.Restore(ctx, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := hosts.Entries()
+	if err != nil || strings.Join(entries[3].Names, " ") != "web.lan api.lan web" {
+		t.Fatalf("web not restored: %+v (%v)", entries, err)
+	}
+}
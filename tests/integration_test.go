@@ -4,6 +4,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -13,7 +14,7 @@ func TestCLIHelp(t *testing.T) {
 	if err != nil {
 		t.Fatalf("help failed: %v\n%s", err, out)
 	}
-	if !contains(string(out), "Usage:") && !contains(string(out), "shctl") {
+	if !strings.Contains(string(out), "Usage:") && !strings.Contains(string(out), "shctl") {
 		t.Fatalf("unexpected help output: %s", out)
 	}
 }
@@ -38,7 +39,7 @@ func TestBinaryAliasAddList(t *testing.T) {
 	// list alias
 	if out, err := exec.Command(bin, "alias", "list").CombinedOutput(); err != nil {
 		t.Fatalf("alias list failed: %v\n%s", err, out)
-	} else if !contains(string(out), "alias hi='echo hi'") {
+	} else if !strings.Contains(string(out), "alias hi='echo hi'") {
 		t.Fatalf("unexpected alias list: %s", out)
 	}
 }
@@ -0,0 +1,96 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/journal"
+)
+
+func TestJournalRollsBackFailedCommit(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	rcFile := filepath.Join(tmp, ".bashrc")
+	aliases := filepath.Join(tmp, ".bash_aliases")
+	os.WriteFile(rcFile, []byte("alias ll='ls -l'\n"), 0o600)
+
+	tx := journal.New("split aliases")
+	tx.Write(rcFile, []byte(". ~/.bash_aliases\n"), 0o644)
+	tx.Write(aliases, []byte("alias ll='ls -l'\n"), 0o644)
+	tx.Stage(filepath.Join(tmp, "third"), rcFile, func(context.Context, string, string) error {
+		return errors.New("disk full")
+	})
+	err := tx.Commit(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "disk full") || !strings.Contains(err.Error(), "rolled back 3 file(s)") {
+		t.Fatalf("expected a rolled back failure, got %v", err)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "alias ll='ls -l'\n" {
+		t.Fatalf("rc not rolled back: %q", b)
+	}
+	if fi, _ := os.Stat(rcFile); fi.Mode().Perm() != 0o600 {
+		t.Fatalf("rollback changed the mode to %v", fi.Mode())
+	}
+	if _, err := os.Stat(aliases); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("new file should be removed on rollback: %v", err)
+	}
+	if left, _ := filepath.Glob(filepath.Join(journal.Dir(), "*")); len(left) != 0 {
+		t.Fatalf("journal left behind: %v", left)
+	}
+
+	tx = journal.New("split aliases")
+	tx.Write(rcFile, []byte(". ~/.bash_aliases\n"), 0o644)
+	tx.Write(aliases, []byte("alias ll='ls -l'\n"), 0o644)
+	if err := tx.Commit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(aliases); string(b) != "alias ll='ls -l'\n" {
+		t.Fatalf("commit did not write: %q", b)
+	}
+}
+
+func TestJournalRecoverInterruptedRun(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	rcFile := filepath.Join(tmp, ".bashrc")
+	os.WriteFile(rcFile, []byte("old\n"), 0o644)
+
+	// keep a copy of the journal as it is mid-commit, as a killed run
+	// would leave it
+	crashed := filepath.Join(tmp, "crashed")
+	tx := journal.New("profile apply")
+	tx.Write(rcFile, []byte("new\n"), 0o644)
+	tx.Stage(filepath.Join(tmp, "other"), rcFile, func(context.Context, string, string) error {
+		dirs, _ := filepath.Glob(filepath.Join(journal.Dir(), "tx-*"))
+		if len(dirs) != 1 {
+			t.Fatalf("expected one journal, got %v", dirs)
+		}
+		if out, err := exec.Command("cp", "-R", dirs[0], crashed).CombinedOutput(); err != nil {
+			t.Fatalf("cp: %s", out)
+		}
+		return errors.New("killed")
+	})
+	if err := tx.Commit(context.Background()); err == nil {
+		t.Fatal("expected the commit to fail")
+	}
+	os.WriteFile(rcFile, []byte("new\n"), 0o644)
+	os.Rename(crashed, filepath.Join(journal.Dir(), "tx-crashed"))
+
+	restored, err := journal.Recover(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(restored) != 2 || restored[0] != rcFile {
+		t.Fatalf("unexpected restored paths %v", restored)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "old\n" {
+		t.Fatalf("rc not recovered: %q", b)
+	}
+	if left, _ := filepath.Glob(filepath.Join(journal.Dir(), "*")); len(left) != 0 {
+		t.Fatalf("journal left behind: %v", left)
+	}
+}
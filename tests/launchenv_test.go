@@ -0,0 +1,76 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/envscope"
+	"github.com/yourusername/shctl/internal/launchenv"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestLaunchEnv(t *testing.T) {
+	tmp := t.TempDir()
+	calls := filepath.Join(tmp, "calls")
+	bin := filepath.Join(tmp, "bin")
+	os.Mkdir(bin, 0o755)
+	stub := "#!/bin/sh\necho \"$@\" >> " + calls + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "launchctl"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SHCTL_LAUNCH_AGENTS_DIR", filepath.Join(tmp, "LaunchAgents"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	ctx := context.Background()
+
+	if err := launchenv.Set(ctx, "JAVA_HOME", "/Library/Java/Home"); err != nil {
+		t.Fatal(err)
+	}
+	if err := launchenv.Set(ctx, "GREETING", `<hello & "bye">`); err != nil {
+		t.Fatal(err)
+	}
+	if err := launchenv.Set(ctx, "JAVA_HOME", "/opt/jdk"); err != nil {
+		t.Fatal(err)
+	}
+	if err := launchenv.Set(ctx, "bad-name", "x"); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+	b, err := os.ReadFile(launchenv.Path())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "<string>&lt;hello &amp; &#34;bye&#34;&gt;</string>") || !strings.Contains(string(b), "<key>RunAtLoad</key>") {
+		t.Fatalf("unexpected plist:\n%s", b)
+	}
+	vars, err := launchenv.Vars()
+	if err != nil || len(vars) != 2 || vars[0].Value != "/opt/jdk" || vars[1].Value != `<hello & "bye">` {
+		t.Fatalf("unexpected vars %+v, %v", vars, err)
+	}
+
+	if err := launchenv.Unset(ctx, []string{"GREETING", "NOPE"}); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := launchenv.Unset(ctx, []string{"GREETING"}); err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	if err := launchenv.List(&out, "text"); err != nil || out.String() != "JAVA_HOME=/opt/jdk\n" {
+		t.Fatalf("unexpected list %q, %v", out.String(), err)
+	}
+	want := "setenv JAVA_HOME /Library/Java/Home\nsetenv GREETING <hello & \"bye\">\nsetenv JAVA_HOME /opt/jdk\nunsetenv GREETING\n"
+	if c, _ := os.ReadFile(calls); string(c) != want {
+		t.Fatalf("unexpected launchctl calls %q", c)
+	}
+
+	if runtime.GOOS != "darwin" {
+		if err := envscope.AddExport(ctx, "gui", "X", "1"); !errors.Is(err, util.ErrUsage) {
+			t.Fatalf("expected a usage error off macOS, got %v", err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/link"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestLinks(t *testing.T) {
+	tmp := t.TempDir()
+	home := filepath.Join(tmp, "home")
+	repo := filepath.Join(home, "dotfiles")
+	os.MkdirAll(filepath.Join(repo, "nvim"), 0o755)
+	os.WriteFile(filepath.Join(repo, ".vimrc"), []byte("set number\n"), 0o644)
+	os.WriteFile(filepath.Join(repo, "nvim", "init.lua"), []byte("vim.o.number = true\n"), 0o644)
+	os.WriteFile(filepath.Join(repo, "gitconfig"), []byte("[user]\n"), 0o644)
+	os.WriteFile(filepath.Join(home, ".gitconfig"), []byte("[core]\n"), 0o644)
+	t.Setenv("HOME", home)
+	t.Setenv("SHCTL_LINK_DIR", repo)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	ctx := context.Background()
+
+	if err := link.Add(ctx, filepath.Join(repo, ".vimrc"), "", false); err != nil {
+		t.Fatal(err)
+	}
+	if dest, err := os.Readlink(filepath.Join(home, ".vimrc")); err != nil || dest != filepath.Join(repo, ".vimrc") {
+		t.Fatalf("not linked: %q, %v", dest, err)
+	}
+	if err := link.Add(ctx, filepath.Join(repo, "nvim", "init.lua"), "~/.config/nvim/init.lua", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := link.Add(ctx, filepath.Join(repo, ".vimrc"), "~/.vimrc", false); !errors.Is(err, util.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists, got %v", err)
+	}
+	if err := link.Add(ctx, filepath.Join(tmp, "elsewhere"), "", false); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+	if err := link.Add(ctx, filepath.Join(repo, "gitconfig"), "~/.gitconfig", false); !errors.Is(err, link.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(home, ".gitconfig")); string(b) != "[core]\n" {
+		t.Fatalf("conflicting file changed: %q", b)
+	}
+
+	var out strings.Builder
+	if err := link.List(&out, "text"); err != nil {
+		t.Fatal(err)
+	}
+	want := "~/.vimrc -> .vimrc (linked)\n~/.config/nvim/init.lua -> nvim/init.lua (linked)\n~/.gitconfig -> gitconfig (conflict)\n"
+	if out.String() != want {
+		t.Fatalf("unexpected list:\n%s", out.String())
+	}
+
+	// on a fresh machine apply makes every link, replacing files only
+	// when asked
+	os.Remove(filepath.Join(home, ".vimrc"))
+	os.RemoveAll(filepath.Join(home, ".config"))
+	if err := link.Apply(ctx, false); !errors.Is(err, link.ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+	if _, err := os.Readlink(filepath.Join(home, ".config", "nvim", "init.lua")); err != nil {
+		t.Fatalf("missing link not made: %v", err)
+	}
+	if err := link.Apply(ctx, true); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(home, ".gitconfig")); string(b) != "[user]\n" {
+		t.Fatalf("conflict not replaced: %q", b)
+	}
+	entries, _ := os.ReadDir(filepath.Join(tmp, "backups"))
+	if len(entries) == 0 {
+		t.Fatal("replaced file not backed up")
+	}
+
+	if err := link.Remove(ctx, []string{"~/.vimrc", "~/.nope"}); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := link.Remove(ctx, []string{"~/.vimrc"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".vimrc")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatalf("link not removed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(repo, ".vimrc")); err != nil {
+		t.Fatalf("repository file removed: %v", err)
+	}
+	links, err := link.Links()
+	if err != nil || len(links) != 2 {
+		t.Fatalf("unexpected links %+v, %v", links, err)
+	}
+	if err := link.Add(ctx, filepath.Join(repo, "gitconfig"), filepath.Join(tmp, "outside"), false); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a target outside home to be refused, got %v", err)
+	}
+
+	// a cloned mapping may not reach out of the repository or home
+	os.WriteFile(filepath.Join(tmp, "secret"), []byte("secret\n"), 0o600)
+	mapping := `[{"source": "../../secret", "target": "~/.secret"}, {"source": "gitconfig", "target": "~/../../planted"}, {"source": "gitconfig", "target": "planted"}]`
+	os.WriteFile(filepath.Join(repo, link.MapFile), []byte(mapping), 0o644)
+	if err := link.Apply(ctx, true); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected escaping entries to be refused, got %v", err)
+	}
+	for _, p := range []string{filepath.Join(home, ".secret"), filepath.Join(tmp, "planted"), filepath.Join(filepath.Dir(tmp), "planted")} {
+		if _, err := os.Lstat(p); err == nil {
+			t.Errorf("link made at %s", p)
+		}
+	}
+}
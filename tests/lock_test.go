@@ -0,0 +1,141 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yourusername/shctl/internal/sudoers"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestConcurrentWritersAreSerialized(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rc")
+	os.WriteFile(path, []byte("keep\n"), 0o644)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			if err := util.AppendFileAtomic(path, []byte(fmt.Sprintf("line %d\n", i))); err != nil {
+				t.Error(err)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			if err := util.RemoveLinesWithPrefix(path, "nothing"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+	b, _ := os.ReadFile(path)
+	if n := strings.Count(string(b), "line "); n != 20 || !strings.HasPrefix(string(b), "keep\n") {
+		t.Fatalf("lost writes (%d of 20):\n%s", n, b)
+	}
+}
+
+func TestLockContention(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rc")
+	os.WriteFile(path, []byte("a\n"), 0o644)
+	old := util.LockTimeout
+	util.LockTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { util.LockTimeout = old })
+
+	unlock, err := util.Lock(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = util.AppendFileAtomic(path, []byte("b\n"))
+	var locked *util.LockedError
+	if !errors.As(err, &locked) || locked.Path != path {
+		t.Fatalf("expected a LockedError, got %v", err)
+	}
+	unlock()
+	if err := util.AppendFileAtomic(path, []byte("b\n")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTargetLockSerializesRuns(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	t.Setenv("BASM_STATE_DIR", t.TempDir())
+	old := util.TargetLockTimeout
+	util.TargetLockTimeout = 100 * time.Millisecond
+	t.Cleanup(func() { util.TargetLockTimeout = old })
+
+	// another run holding the lock; flock conflicts across descriptors
+	unlock, err := util.LockTarget(context.Background(), path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a system file is locked in the shared directory, for every user
+	lock, err := util.TargetLockPath(path)
+	if err != nil || filepath.Dir(lock) != util.SharedLockDir() {
+		t.Fatalf("lock for %s at %s (%v), want it in %s", path, lock, err, util.SharedLockDir())
+	}
+	var locked *util.LockedError
+	if err := sudoers.Add(context.Background(), "alice ALL=(ALL) ALL"); !errors.As(err, &locked) || locked.Path != path {
+		t.Fatalf("expected a LockedError for %s, got %v", path, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sudoers.Add(ctx, "alice ALL=(ALL) ALL"); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the wait to stop with the context, got %v", err)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "root ALL=(ALL) ALL\n" {
+		t.Fatalf("sudoers changed while locked: %q", b)
+	}
+	unlock()
+	if _, err := os.Stat(lock); err == nil {
+		t.Fatal("lock file left behind after unlock")
+	}
+	if err := sudoers.Add(context.Background(), "alice ALL=(ALL) ALL"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTargetLockIgnoresPlantedFiles(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no symlinks or owners to plant")
+	}
+	target := filepath.Join(t.TempDir(), "sudoers")
+	shared := t.TempDir()
+	t.Setenv("SHCTL_LOCK_DIR", shared)
+	t.Setenv("BASM_STATE_DIR", t.TempDir())
+
+	// a symlink planted at the lock's name is not followed
+	lock, err := util.TargetLockPath(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	victim := filepath.Join(t.TempDir(), "victim")
+	if err := os.Symlink(victim, lock); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := util.LockTarget(context.Background(), target); err == nil {
+		t.Fatal("lock taken through a planted symlink")
+	}
+	if _, err := os.Lstat(victim); err == nil {
+		t.Fatal("symlink target created")
+	}
+
+	// root keeps its locks to itself when the shared directory is not root's
+	if os.Geteuid() != 0 {
+		return
+	}
+	if err := os.Chown(shared, 65534, 65534); err != nil {
+		t.Fatal(err)
+	}
+	if lock, err := util.TargetLockPath(target); err != nil || filepath.Dir(lock) == shared {
+		t.Fatalf("root locked %s in a directory another user owns (%v)", lock, err)
+	}
+}
@@ -0,0 +1,55 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/logging"
+	"github.com/yourusername/shctl/internal/rc"
+)
+
+func TestVerboseLogging(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", tmp)
+	old := logging.Logger
+	t.Cleanup(func() { logging.Logger = old })
+
+	for _, c := range []struct {
+		verbose int
+		quiet   bool
+		want    slog.Level
+	}{{0, false, slog.LevelWarn}, {1, false, slog.LevelInfo}, {2, false, slog.LevelDebug}, {3, false, slog.LevelDebug}, {2, true, slog.LevelError}} {
+		if got := logging.Level(c.verbose, c.quiet); got != c.want {
+			t.Errorf("Level(%d, %v) = %v, want %v", c.verbose, c.quiet, got, c.want)
+		}
+	}
+
+	var buf bytes.Buffer
+	logging.Setup(&buf, logging.Level(1, false), false)
+	if err := rc.AddAlias(context.Background(), "ll", "ls -l"); err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); !strings.Contains(got, `msg="rc file"`) || !strings.Contains(got, "path="+rcPath) || strings.Contains(got, "locking") {
+		t.Fatalf("unexpected -v log %q", got)
+	}
+
+	buf.Reset()
+	logging.Setup(&buf, logging.Level(2, false), false)
+	rc.AddAlias(context.Background(), "la", "ls -a")
+	if !strings.Contains(buf.String(), "msg=locking") {
+		t.Fatalf("-vv should log the lock, got %q", buf.String())
+	}
+
+	buf.Reset()
+	logging.Setup(&buf, logging.Level(0, true), false)
+	rc.AddAlias(context.Background(), "l", "ls")
+	if buf.Len() != 0 {
+		t.Fatalf("quiet should log nothing here, got %q", buf.String())
+	}
+}
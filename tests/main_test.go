@@ -0,0 +1,37 @@
+package tests
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestMain keeps every test away from the host's system crontabs,
+// systemd drop-ins, hosts and environment files, LaunchAgents, ssh, git,
+// tmux and editor config, which snapshots and status pick up wherever
+// they are configured, and from the user's shctl state: backups, audit
+// records, journals and locks.
+func TestMain(m *testing.M) {
+	tmp, err := os.MkdirTemp("", "shctl-tests-*")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	os.Setenv("SHCTL_LOCK_DIR", filepath.Join(tmp, "locks"))
+	os.Setenv("SHCTL_CRON_DIR", filepath.Join(tmp, "cron.d"))
+	os.Setenv("SHCTL_SYSTEM_CRONTAB", filepath.Join(tmp, "crontab"))
+	os.Setenv("SHCTL_SYSTEMD_DIR", filepath.Join(tmp, "systemd"))
+	os.Setenv("SHCTL_GIT_CONFIG", filepath.Join(tmp, "gitconfig"))
+	os.Setenv("SHCTL_HOSTS_FILE", filepath.Join(tmp, "hosts"))
+	os.Setenv("SHCTL_ENVIRONMENT_FILE", filepath.Join(tmp, "environment"))
+	os.Setenv("SHCTL_ENVIRONMENT_D_DIR", filepath.Join(tmp, "environment.d"))
+	os.Setenv("SHCTL_LAUNCH_AGENTS_DIR", filepath.Join(tmp, "LaunchAgents"))
+	os.Setenv("SHCTL_TMUX_CONF", filepath.Join(tmp, "tmux.conf"))
+	os.Setenv("SHCTL_VIMRC", filepath.Join(tmp, "vimrc"))
+	os.Setenv("SHCTL_NVIM_INIT", filepath.Join(tmp, "nvim", "init.lua"))
+	os.Setenv("SHCTL_GRANTS_FILE", filepath.Join(tmp, "sudoers-grants.json"))
+	os.Setenv("SHCTL_SSH_CONFIG", filepath.Join(tmp, "ssh", "config"))
+	code := m.Run()
+	os.RemoveAll(tmp)
+	os.Exit(code)
+}
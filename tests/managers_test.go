@@ -0,0 +1,29 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/managers"
+)
+
+func TestDetectChezmoi(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_DATA_HOME", filepath.Join(home, ".local", "share"))
+
+	src := filepath.Join(home, ".local", "share", "chezmoi")
+	if err := os.MkdirAll(src, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(src, "dot_bashrc.tmpl"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	found := managers.Detect(context.Background(), []string{filepath.Join(home, ".bashrc"), filepath.Join(home, ".zshrc")})
+	if len(found) != 1 || found[0].Manager != "chezmoi" {
+		t.Fatalf("expected one chezmoi finding, got %+v", found)
+	}
+}
@@ -0,0 +1,252 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/output"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/sudoers"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestSudoersListYAML(t *testing.T) {
+	path := setupSudoers(t, "Defaults env_reset\nalice ALL=(root) NOPASSWD: /usr/bin/id, /usr/bin/who\n")
+
+	var buf strings.Builder
+	if err := sudoers.ListFormat(&buf, output.YAML); err != nil {
+		t.Fatal(err)
+	}
+	want := `- kind: defaults
+  source: ` + path + `
+  line: 1
+  end_line: 1
+  raw: Defaults env_reset
+- kind: rule
+  source: ` + path + `
+  line: 2
+  end_line: 2
+  raw: "alice ALL=(root) NOPASSWD: /usr/bin/id, /usr/bin/who"
+  users:
+    - alice
+  hosts:
+    - ALL
+  runas: root
+  tags:
+    - NOPASSWD
+  commands:
+    - /usr/bin/id
+    - /usr/bin/who
+`
+	if buf.String() != want {
+		t.Fatalf("unexpected yaml:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	if err := sudoers.ListFormat(&buf, output.JSON); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"commands": [`) {
+		t.Fatalf("unexpected json:\n%s", buf.String())
+	}
+}
+
+func TestYAMLQuoting(t *testing.T) {
+	var buf strings.Builder
+	v := map[string]any{"a": "yes", "b": "1.5", "c": "", "d": "- x", "e": []string{}, "f": "plain text"}
+	if err := output.Write(&buf, output.YAML, v); err != nil {
+		t.Fatal(err)
+	}
+	want := "a: \"yes\"\nb: \"1.5\"\nc: \"\"\nd: \"- x\"\ne: []\nf: plain text\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected yaml:\n%s", buf.String())
+	}
+}
+
+func TestRCListJSON(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", tmp)
+	os.WriteFile(rcPath, []byte("alias ll='ls -l'\nexport PATH=\"$HOME/bin:$PATH\"\n# alias no=1\n"), 0o644)
+	if err := rc.AddAliasWithArgs(context.Background(), "greet", "echo {{1}}"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.AddExportExpiring(context.Background(), "TMP", "1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf strings.Builder
+	if err := rc.ListAliasesFormat(&buf, output.JSON); err != nil {
+		t.Fatal(err)
+	}
+	var aliases []rc.Alias
+	if err := json.Unmarshal([]byte(buf.String()), &aliases); err != nil {
+		t.Fatalf("%v in %s", err, buf.String())
+	}
+	if len(aliases) != 2 || aliases[0] != (rc.Alias{Name: "ll", Command: "ls -l", Line: 1}) ||
+		aliases[1].Name != "greet" || !aliases[1].Function || aliases[1].Command != "echo {{1}}" {
+		t.Fatalf("unexpected aliases %+v", aliases)
+	}
+
+	buf.Reset()
+	if err := rc.ListExportsFormat(&buf, output.JSON); err != nil {
+		t.Fatal(err)
+	}
+	var exports []rc.Export
+	if err := json.Unmarshal([]byte(buf.String()), &exports); err != nil {
+		t.Fatalf("%v in %s", err, buf.String())
+	}
+	if len(exports) != 2 || exports[0].Value != "$HOME/bin:$PATH" || exports[1].Name != "TMP" || exports[1].Expires == nil {
+		t.Fatalf("unexpected exports %+v", exports)
+	}
+}
+
+func TestSudoersAliasesJSON(t *testing.T) {
+	setupSudoers(t, "Cmnd_Alias PKG = /usr/bin/apt, /usr/bin/dpkg\nUser_Alias OPS = alice, bob\n")
+	var buf strings.Builder
+	if err := sudoers.ListAliasesFormat(&buf, output.JSON); err != nil {
+		t.Fatal(err)
+	}
+	var defs []sudoers.AliasDef
+	if err := json.Unmarshal([]byte(buf.String()), &defs); err != nil {
+		t.Fatal(err)
+	}
+	if len(defs) != 2 || defs[0].Name != "PKG" || len(defs[0].Members) != 2 || defs[1].Kind != "User_Alias" {
+		t.Fatalf("unexpected alias records %+v", defs)
+	}
+}
+
+func TestYAMLOutputs(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("SHCTL_CONFIG", filepath.Join(tmp, "config.toml"))
+	os.WriteFile(rcPath, []byte("alias gs='git status'\n"), 0o644)
+
+	var buf strings.Builder
+	if err := rc.ListAliasesFormat(&buf, output.YAML); err != nil {
+		t.Fatal(err)
+	}
+	if want := "- name: gs\n  command: git status\n  line: 1\n"; buf.String() != want {
+		t.Fatalf("unexpected alias yaml:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	e, err := config.Explain("rc_file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := config.WriteExplanation(&buf, output.YAML, e); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "key: rc_file\nvalue: "+rcPath+"\nwinner: env BASM_RC_FILE\nlayers:\n  - source: flag --rc-file\n") {
+		t.Fatalf("unexpected explanation yaml:\n%s", buf.String())
+	}
+
+	buf.Reset()
+	findings := []sudoers.Finding{{Severity: sudoers.SeverityHigh, Source: "/etc/sudoers", Line: 3, Message: "NOPASSWD: ALL"}}
+	if err := sudoers.WriteAudit(&buf, output.YAML, findings); err != nil {
+		t.Fatal(err)
+	}
+	if want := "- severity: high\n  source: /etc/sudoers\n  line: 3\n  message: \"NOPASSWD: ALL\"\n"; buf.String() != want {
+		t.Fatalf("unexpected audit yaml:\n%s", buf.String())
+	}
+}
+
+func TestColorOutput(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	os.WriteFile(rcPath, []byte("alias ll='ls -l'\nexport EDITOR=vi\n"), 0o644)
+	t.Cleanup(func() { output.Color = "auto" })
+
+	var buf strings.Builder
+	output.Color = "always"
+	rc.ListAliases(&buf)
+	output.Warn(&buf, "careful")
+	output.WriteDiff(&buf, "--- a\n+++ b\n-old\n+new\n same\n")
+	want := "alias \x1b[36mll\x1b[0m=\x1b[32m'ls -l'\x1b[0m\n" +
+		"\x1b[33mwarning:\x1b[0m careful\n" +
+		"\x1b[1m--- a\x1b[0m\n\x1b[1m+++ b\x1b[0m\n\x1b[31m-old\x1b[0m\n\x1b[32m+new\x1b[0m\n same\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected colored output %q", buf.String())
+	}
+
+	// auto only colors terminals
+	for _, c := range []string{"auto", "never"} {
+		buf.Reset()
+		output.Color = c
+		rc.ListExports(&buf)
+		if buf.String() != "export EDITOR=vi\n" {
+			t.Fatalf("%s: expected plain output, got %q", c, buf.String())
+		}
+	}
+	names := map[string]config.Flag{}
+	for _, f := range config.Flags() {
+		names[f.Name] = f
+	}
+	if f := names["no-color"]; f.Key != "color" || f.Value != "never" {
+		t.Fatalf("unexpected --no-color flag %+v", f)
+	}
+}
+
+func TestListQuery(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	os.WriteFile(rcPath, []byte("alias gs='git status'\nalias ll='ls -l'\nalias gd='git diff'\nalias la='ls -a'\n"), 0o644)
+	t.Cleanup(func() { output.List = output.Query{} })
+
+	list := func(q output.Query) string {
+		t.Helper()
+		output.List = q
+		var buf strings.Builder
+		if err := rc.ListAliasesFormat(&buf, output.Text); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+	if got := list(output.Query{Filter: "g*"}); got != "alias gs='git status'\nalias gd='git diff'\n" {
+		t.Fatalf("glob filter: %q", got)
+	}
+	if got := list(output.Query{Filter: "/^l/", Sort: "name"}); got != "alias la='ls -a'\nalias ll='ls -l'\n" {
+		t.Fatalf("regexp filter sorted by name: %q", got)
+	}
+	if got := list(output.Query{Sort: "name", Offset: 1, Limit: 2}); got != "alias gs='git status'\nalias la='ls -a'\n" {
+		t.Fatalf("offset and limit: %q", got)
+	}
+	if got := list(output.Query{Offset: 10}); got != "" {
+		t.Fatalf("offset past the end: %q", got)
+	}
+
+	output.List = output.Query{Filter: "l?"}
+	var buf strings.Builder
+	if err := rc.ListAliasesFormat(&buf, output.JSON); err != nil {
+		t.Fatal(err)
+	}
+	var aliases []rc.Alias
+	if err := json.Unmarshal([]byte(buf.String()), &aliases); err != nil || len(aliases) != 2 || aliases[0].Line != 2 {
+		t.Fatalf("unexpected filtered JSON %s (%v)", buf.String(), err)
+	}
+
+	for _, q := range []output.Query{{Sort: "size"}, {Filter: "/(/"}, {Limit: -1}} {
+		if err := q.Check(); !errors.Is(err, util.ErrUsage) {
+			t.Fatalf("%+v: expected a usage error, got %v", q, err)
+		}
+	}
+
+	// output that is not a terminal is never paged
+	w, done := output.Page(&buf)
+	if w != io.Writer(&buf) || done() != nil {
+		t.Fatal("expected no pager for a non-terminal writer")
+	}
+}
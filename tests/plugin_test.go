@@ -0,0 +1,53 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/dryrun"
+	"github.com/yourusername/shctl/internal/plugin"
+)
+
+func TestPluginRun(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	tmp := t.TempDir()
+	bin := filepath.Join(tmp, "bin")
+	os.MkdirAll(bin, 0o755)
+	out := filepath.Join(tmp, "out")
+	os.WriteFile(filepath.Join(bin, "shctl-hello"), []byte("#!/bin/sh\necho \"$1|$SHCTL_RC_FILE|$SHCTL_DRY_RUN|$SHCTL_OUTPUT\" > "+out+"\nexit 3\n"), 0o755)
+	os.WriteFile(filepath.Join(bin, "shctl-notexec"), []byte("#!/bin/sh\n"), 0o644)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("BASM_RC_FILE", filepath.Join(tmp, "rc"))
+	t.Setenv("SHCTL_RC_FILE", "")
+	dryrun.Enabled = true
+	t.Cleanup(func() { dryrun.Enabled = false })
+
+	if got := plugin.List(); !reflect.DeepEqual(got, []string{"hello"}) {
+		t.Fatalf("unexpected plugins %q", got)
+	}
+	err := plugin.Run(context.Background(), "hello", "world")
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) || exit.ExitCode() != 3 {
+		t.Fatalf("expected exit status 3, got %v", err)
+	}
+	b, _ := os.ReadFile(out)
+	if want := "world|" + filepath.Join(tmp, "rc") + "|1|text\n"; string(b) != want {
+		t.Fatalf("plugin saw %q, want %q", b, want)
+	}
+	for _, name := range []string{"nope", "notexec", "../bin/shctl-hello"} {
+		if err := plugin.Run(context.Background(), name); !errors.Is(err, plugin.ErrNotFound) {
+			t.Errorf("%s: expected ErrNotFound, got %v", name, err)
+		}
+	}
+	if !strings.HasPrefix(plugin.Environ()[0], "SHCTL_RC_FILE=") {
+		t.Fatalf("settings should lead the plugin environment: %q", plugin.Environ())
+	}
+}
@@ -0,0 +1,82 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/profile"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestProfileSwitch(t *testing.T) {
+	tmp := t.TempDir()
+	rcFile := filepath.Join(tmp, ".bashrc")
+	os.WriteFile(rcFile, []byte("alias ll='ls -l'\n"), 0o644)
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("SHCTL_PROFILE_DIR", filepath.Join(tmp, "profiles"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	ctx := context.Background()
+
+	work := []string{
+		"export AWS_PROFILE=work",
+		"alias k='kubectl --context work'",
+		"vpn() {",
+		"  if [ -n \"$1\" ]; then",
+		"    sudo wg-quick up \"$1\"",
+		"  fi",
+		"}",
+		"source ~/work/env.sh",
+	}
+	if err := profile.Create(ctx, "work", work); err != nil {
+		t.Fatal(err)
+	}
+	if err := profile.Create(ctx, "personal", []string{"export AWS_PROFILE=home"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := profile.Create(ctx, "work", nil); !errors.Is(err, util.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists, got %v", err)
+	}
+	for _, bad := range [][]string{{"rm -rf /tmp/x"}, {"f() {", "  true"}, {"f()", "true"}} {
+		if err := profile.Create(ctx, "bad", bad); !errors.Is(err, util.ErrUsage) {
+			t.Fatalf("%q: expected a usage error, got %v", bad, err)
+		}
+	}
+
+	if err := profile.Switch(ctx, "work"); err != nil {
+		t.Fatal(err)
+	}
+	want := "alias ll='ls -l'\n\n# BEGIN shctl profile\n# profile: work\n" + strings.Join(work, "\n") + "\n# END shctl profile\n"
+	if b, _ := os.ReadFile(rcFile); string(b) != want {
+		t.Fatalf("unexpected rc file:\n%s", b)
+	}
+	if err := profile.Switch(ctx, "personal"); err != nil {
+		t.Fatal(err)
+	}
+	want = "alias ll='ls -l'\n\n# BEGIN shctl profile\n# profile: personal\nexport AWS_PROFILE=home\n# END shctl profile\n"
+	if b, _ := os.ReadFile(rcFile); string(b) != want {
+		t.Fatalf("unexpected rc file:\n%s", b)
+	}
+	if active, err := profile.Active(); err != nil || active != "personal" {
+		t.Fatalf("active profile %q (%v)", active, err)
+	}
+
+	var out strings.Builder
+	if err := profile.List(&out, "text"); err != nil || out.String() != "* personal (1 entries)\n  work (4 entries)\n" {
+		t.Fatalf("unexpected list %q (%v)", out.String(), err)
+	}
+	if err := profile.Switch(ctx, "client-x"); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	if err := profile.Switch(ctx, ""); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "alias ll='ls -l'\n\n" {
+		t.Fatalf("profile block not removed:\n%q", b)
+	}
+}
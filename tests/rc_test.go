@@ -2,11 +2,19 @@ package tests
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/prompt"
 	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/util"
 )
 
 func TestAliasAddListRemove(t *testing.T) {
@@ -16,7 +24,7 @@ func TestAliasAddListRemove(t *testing.T) {
 	os.Setenv("BASM_BACKUP_DIR", tmp)
 
 	// ensure file
-	if err := rc.AddAlias("greet", "echo hello"); err != nil {
+	if err := rc.AddAlias(context.Background(), "greet", "echo hello"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -24,11 +32,11 @@ func TestAliasAddListRemove(t *testing.T) {
 	if err := rc.ListAliases(&buf); err != nil {
 		t.Fatal(err)
 	}
-	if got := buf.String(); !contains(got, "alias greet='echo hello'") {
+	if got := buf.String(); !strings.Contains(got, "alias greet='echo hello'") {
 		t.Fatalf("expected alias in rc, got %q", got)
 	}
 
-	if err := rc.RemoveAlias("greet"); err != nil {
+	if err := rc.RemoveAlias(context.Background(), "greet"); err != nil {
 		t.Fatal(err)
 	}
 
@@ -36,10 +44,263 @@ func TestAliasAddListRemove(t *testing.T) {
 	if err := rc.ListAliases(&buf); err != nil {
 		t.Fatal(err)
 	}
-	if contains(buf.String(), "greet") {
+	if strings.Contains(buf.String(), "greet") {
 		t.Fatalf("alias still present after remove")
 	}
 }
 
-func contains(s, sub string) bool { return len(sub) > 0 && (index(s, sub) >= 0) }
-func index(s, sub string) int { return len([]byte(stringsSplit(s, sub)[0])) } // simple index via split
+func TestAliasWithArgs(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	os.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", tmp)
+
+	if err := rc.AddAliasWithArgs(context.Background(), "greet", "echo {{1:-hello}} {{2}}"); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := rc.ListAliases(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "greet() {") {
+		t.Fatalf("expected generated function in list, got %q", buf.String())
+	}
+
+	if _, err := exec.LookPath("bash"); err == nil {
+		out, err := exec.Command("bash", "-c", ". "+rcPath+"; greet '' world; greet hi there; greet").CombinedOutput()
+		if err == nil {
+			t.Fatalf("expected missing argument to fail, got %q", out)
+		}
+		if want := "hello world\nhi there\nusage: greet [arg1] arg2\n"; string(out) != want {
+			t.Fatalf("unexpected function output %q", out)
+		}
+	}
+
+	if err := rc.RemoveAlias(context.Background(), "greet"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(rcPath)
+	if strings.Contains(string(b), "greet") {
+		t.Fatalf("function still present after remove: %q", b)
+	}
+
+	if err := rc.AddAliasWithArgs(context.Background(), "bad", "echo {{0}}"); err == nil {
+		t.Fatal("expected error for {{0}} placeholder")
+	}
+}
+
+func TestExpiringEntriesGC(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	os.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", tmp)
+
+	ttl, err := util.ParseDuration("30d")
+	if err != nil || ttl != 30*24*time.Hour {
+		t.Fatalf("ParseDuration(30d) = %v, %v", ttl, err)
+	}
+	if err := rc.AddAlias(context.Background(), "keep", "echo keep"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.AddAliasExpiring(context.Background(), "tmp", "echo tmp", ttl); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.AddExportExpiring(context.Background(), "TMP_VAR", "1", time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed, err := rc.GC(context.Background(), time.Now()); err != nil || len(removed) != 0 {
+		t.Fatalf("nothing should expire yet: %v %v", removed, err)
+	}
+	removed, err := rc.GC(context.Background(), time.Now().Add(48*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || !strings.HasPrefix(removed[0], "export TMP_VAR=1 # shctl:expires=") {
+		t.Fatalf("unexpected removed entries %q", removed)
+	}
+	b, _ := os.ReadFile(rcPath)
+	if !strings.Contains(string(b), "alias keep=") || !strings.Contains(string(b), "alias tmp=") {
+		t.Fatalf("unexpected rc after GC:\n%s", b)
+	}
+}
+
+func TestAutoBackupBeforeWrite(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	os.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	os.WriteFile(rcPath, []byte("alias a='1'\n"), 0o644)
+
+	if err := rc.RemoveAlias(context.Background(), "a"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := backup.List(context.Background(), backup.Local(filepath.Join(tmp, "backups")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Op != "rc alias remove" {
+		t.Fatalf("expected one tagged pre-change backup, got %+v", list)
+	}
+	store, _ := backup.Default(context.Background())
+	if b, _ := backup.Read(context.Background(), store, list[0]); string(b) != "alias a='1'\n" {
+		t.Fatalf("backup does not hold the pre-change content: %q", b)
+	}
+
+	t.Setenv("SHCTL_AUTO_BACKUP", "false")
+	if err := rc.AddAlias(context.Background(), "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := backup.List(context.Background(), store); len(list) != 1 {
+		t.Fatalf("auto backup should be off, got %+v", list)
+	}
+}
+
+func TestSymlinkedRCPolicy(t *testing.T) {
+	tmp := t.TempDir()
+	target := filepath.Join(tmp, "dotfiles", "zshrc")
+	os.MkdirAll(filepath.Dir(target), 0o755)
+	os.WriteFile(target, []byte("alias a='1'\n"), 0o644)
+	link := filepath.Join(tmp, ".zshrc")
+	os.Symlink(target, link)
+	t.Setenv("BASM_RC_FILE", link)
+	t.Setenv("BASM_BACKUP_DIR", filepath.Join(tmp, "backups"))
+
+	var serr *rc.SymlinkError
+	if err := rc.RemoveAlias(context.Background(), "a"); !errors.As(err, &serr) || serr.Target != target {
+		t.Fatalf("expected a SymlinkError by default, got %v", err)
+	}
+
+	rc.FollowSymlinks = true
+	err := rc.RemoveAlias(context.Background(), "a")
+	rc.FollowSymlinks = false
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Lstat(link); fi.Mode()&os.ModeSymlink == 0 {
+		t.Fatal("following must keep the symlink")
+	}
+	if b, _ := os.ReadFile(target); string(b) != "" {
+		t.Fatalf("alias not removed from the target: %q", b)
+	}
+
+	t.Setenv("SHCTL_SYMLINKS", "replace")
+	if err := rc.AddAlias(context.Background(), "b", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if fi, _ := os.Lstat(link); fi.Mode()&os.ModeSymlink != 0 {
+		t.Fatal("replace should turn the link into a plain file")
+	}
+	if b, _ := os.ReadFile(target); string(b) != "" {
+		t.Fatalf("replace must not touch the old target: %q", b)
+	}
+}
+
+func TestAliasTypedErrors(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("BASM_RC_FILE", filepath.Join(tmp, "rc_test"))
+	t.Setenv("BASM_BACKUP_DIR", tmp)
+
+	if err := rc.RemoveAlias(context.Background(), "nope"); !errors.Is(err, rc.ErrAliasNotFound) {
+		t.Fatalf("expected ErrAliasNotFound, got %v", err)
+	}
+	if err := rc.AddAlias(context.Background(), "ll", "ls -l"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.AddAlias(context.Background(), "ll", "ls -la"); !errors.Is(err, rc.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists, got %v", err)
+	}
+	if err := rc.AddAliasWithArgs(context.Background(), "ll", "ls {{1}}"); !errors.Is(err, rc.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists for a function, got %v", err)
+	}
+}
+
+func TestRCEntriesWithMixedWhitespace(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", tmp)
+	os.WriteFile(rcPath, []byte("alias\tll='ls -l'\nalias  lla='ls -la'\nexport\t FOO=1\n"), 0o644)
+
+	var buf bytes.Buffer
+	if err := rc.ListAliases(&buf); err != nil || strings.Count(buf.String(), "\n") != 2 {
+		t.Fatalf("expected both aliases listed, got %q (%v)", buf.String(), err)
+	}
+	if err := rc.AddAlias(context.Background(), "ll", "ls"); !errors.Is(err, rc.ErrEntryExists) {
+		t.Fatalf("tab-separated alias not seen: %v", err)
+	}
+	if err := rc.RemoveAlias(context.Background(), "ll"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.RemoveExport(context.Background(), "FOO"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcPath); string(b) != "alias  lla='ls -la'\n" {
+		t.Fatalf("unexpected rc: %q", b)
+	}
+}
+
+func TestPickRemoveAliases(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", tmp)
+	os.WriteFile(rcPath, []byte("alias ll='ls -l'\nalias gs='git status'\nalias gd='git diff'\nexport FOO=1\n"), 0o644)
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.In, prompt.Out = os.Stdin, os.Stdout })
+
+	prompt.In = strings.NewReader("git\n1-2\n\n")
+	names, err := rc.PickRemoveAliases(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, ",") != "gs,gd" {
+		t.Fatalf("picked %v", names)
+	}
+	if b, _ := os.ReadFile(rcPath); string(b) != "alias ll='ls -l'\nexport FOO=1\n" {
+		t.Fatalf("unexpected rc: %q", b)
+	}
+
+	prompt.In = strings.NewReader("\n")
+	if _, err := rc.PickRemoveExports(context.Background()); !errors.Is(err, prompt.ErrAborted) {
+		t.Fatalf("expected ErrAborted when nothing is picked, got %v", err)
+	}
+
+	f, err := os.Open(rcPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	prompt.In = f
+	if _, err := rc.PickRemoveAliases(context.Background()); !errors.Is(err, prompt.ErrNoTerminal) {
+		t.Fatalf("expected ErrNoTerminal without a terminal, got %v", err)
+	}
+}
+
+func TestReloadHelper(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "it's rc")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", tmp)
+	os.WriteFile(rcPath, []byte("GREETING=hello\n"), 0o644)
+
+	var buf bytes.Buffer
+	if err := rc.WriteReload(&buf, "shctl", false); err != nil {
+		t.Fatal(err)
+	}
+	out, err := exec.Command("sh", "-c", strings.TrimSpace(buf.String())+` && echo "$GREETING"`).Output()
+	if err != nil || string(out) != "hello\n" {
+		t.Fatalf("reload command %q did not source the rc file: %q (%v)", buf.String(), out, err)
+	}
+
+	buf.Reset()
+	if err := rc.WriteReload(&buf, "shctl", true); err != nil {
+		t.Fatal(err)
+	}
+	// the wrapper reloads only when shctl succeeds
+	script := buf.String() + "command() { shift; [ \"$1\" = ok ]; }\nshctl fail; echo \"[$GREETING]\"; shctl ok && echo \"[$GREETING]\"\n"
+	out, err = exec.Command("sh", "-c", script).Output()
+	if err != nil || string(out) != "[]\n[hello]\n" {
+		t.Fatalf("wrapper:\n%s\ngave %q (%v)", buf.String(), out, err)
+	}
+}
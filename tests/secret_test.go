@@ -0,0 +1,67 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/secret"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestSecretExport(t *testing.T) {
+	tmp := t.TempDir()
+	bin := filepath.Join(tmp, "bin")
+	os.Mkdir(bin, 0o755)
+	stub := "#!/bin/sh\n[ \"$2\" = work/api ] || { echo \"Error: $2 is not in the password store.\" >&2; exit 1; }\nprintf 's3cr3t\\nuser: me\\n'\n"
+	if err := os.WriteFile(filepath.Join(bin, "pass"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	rcFile := filepath.Join(tmp, ".bashrc")
+	os.WriteFile(rcFile, []byte("export EDITOR=vi\n"), 0o644)
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	ctx := context.Background()
+
+	if v, err := secret.Get(ctx, "pass:work/api"); err != nil || v != "s3cr3t" {
+		t.Fatalf("resolved %q (%v)", v, err)
+	}
+	for _, bad := range []string{"work/api", "lastpass:x", "vault:secret/api", "libsecret:novalue"} {
+		if _, err := secret.Parse(bad); !errors.Is(err, util.ErrUsage) {
+			t.Fatalf("%q: expected a usage error, got %v", bad, err)
+		}
+	}
+	if argv, err := (secret.Spec{Backend: "keychain", Ref: "github/me"}).Command(); err != nil ||
+		len(argv) != 7 || argv[5] != "me" {
+		t.Fatalf("unexpected keychain command %q (%v)", argv, err)
+	}
+
+	if err := rc.AddSecretExport(ctx, "TOKEN", "pass:work/api", "shctl"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.AddSecretExport(ctx, "OTHER", "pass:work/typo", "shctl"); err == nil {
+		t.Fatal("expected an unknown secret to fail")
+	}
+	if err := rc.AddSecretExport(ctx, "EDITOR", "pass:work/api", "shctl"); !errors.Is(err, util.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists, got %v", err)
+	}
+	b, _ := os.ReadFile(rcFile)
+	if string(b) != "export EDITOR=vi\nexport TOKEN=\"$(command shctl secret get pass:work/api)\"\n" {
+		t.Fatalf("unexpected rc file:\n%s", b)
+	}
+
+	// a binary path with spaces stays one word
+	if err := rc.AddSecretExport(ctx, "SPACED", "pass:work/api", "/opt/my tools/shctl"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(rcFile)
+	if !strings.HasSuffix(string(b), "export SPACED=\"$(command '/opt/my tools/shctl' secret get pass:work/api)\"\n") {
+		t.Fatalf("unexpected rc file:\n%s", b)
+	}
+}
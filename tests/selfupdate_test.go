@@ -0,0 +1,107 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/config"
+	"github.com/yourusername/shctl/internal/selfupdate"
+)
+
+func TestSelfUpdate(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, version := selfupdate.PublicKey, config.Version
+	selfupdate.PublicKey = base64.StdEncoding.EncodeToString(pub)
+	config.Version = "v1.2.0"
+	t.Cleanup(func() { selfupdate.PublicKey, config.Version = key, version })
+	assumeYes(t)
+
+	binary := []byte("#!/bin/sh\necho new\n")
+	sum := sha256.Sum256(binary)
+	checksums := hex.EncodeToString(sum[:]) + "  shctl-new\n"
+	var srv *httptest.Server
+	release := "9.9.9"
+	feed := func(sig []byte) []byte {
+		b, _ := json.Marshal(selfupdate.Release{
+			Version:   release,
+			Assets:    map[string]string{runtime.GOOS + "/" + runtime.GOARCH: srv.URL + "/shctl-new"},
+			Checksums: checksums,
+			Signature: sig,
+		})
+		return b
+	}
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/edge.json":
+			w.Write(feed(ed25519.Sign(priv, selfupdate.Payload(release, "edge", checksums))))
+		case "/stable.json":
+			// signed for edge: a release does not carry over to another channel
+			w.Write(feed(ed25519.Sign(priv, selfupdate.Payload(release, "edge", checksums))))
+		case "/shctl-new":
+			w.Write(binary)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("SHCTL_UPDATE_URL", srv.URL)
+
+	exe := filepath.Join(t.TempDir(), "shctl")
+	os.WriteFile(exe, []byte("old"), 0o755)
+
+	t.Setenv("SHCTL_UPDATE_CHANNEL", "stable")
+	if _, _, err := selfupdate.Update(context.Background(), io.Discard, exe); err == nil || !strings.Contains(err.Error(), "not signed") {
+		t.Fatalf("expected a bad signature to be refused, got %v", err)
+	}
+
+	t.Setenv("SHCTL_UPDATE_CHANNEL", "edge")
+	rel, updated, err := selfupdate.Update(context.Background(), io.Discard, exe)
+	if err != nil || !updated || rel.Version != "9.9.9" {
+		t.Fatalf("update: %+v %v %v", rel, updated, err)
+	}
+	if b, _ := os.ReadFile(exe); string(b) != string(binary) {
+		t.Fatalf("binary not replaced: %q", b)
+	}
+	if fi, _ := os.Stat(exe); fi.Mode().Perm() != 0o755 {
+		t.Fatalf("mode not kept: %v", fi.Mode())
+	}
+
+	// the same or an older release is not installed
+	for _, v := range []string{"1.2.0", "v1.2.0-rc1", "1.1.9"} {
+		release = v
+		os.WriteFile(exe, []byte("old"), 0o755)
+		_, updated, err := selfupdate.Update(context.Background(), io.Discard, exe)
+		if updated || (v == "1.2.0") != (err == nil) {
+			t.Fatalf("%s: updated=%v err=%v", v, updated, err)
+		}
+		if b, _ := os.ReadFile(exe); string(b) != "old" {
+			t.Fatalf("%s installed over v1.2.0", v)
+		}
+	}
+	release = "9.9.9"
+
+	// a binary that does not match its signed checksum is not installed
+	binary = []byte("tampered")
+	os.WriteFile(exe, []byte("old"), 0o755)
+	if _, _, err := selfupdate.Update(context.Background(), io.Discard, exe); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	if b, _ := os.ReadFile(exe); string(b) != "old" {
+		t.Fatalf("tampered binary installed: %q", b)
+	}
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/envscope"
+	"github.com/yourusername/shctl/internal/sessionenv"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestSessionExports(t *testing.T) {
+	tmp := t.TempDir()
+	dir := filepath.Join(tmp, "environment.d")
+	rcFile := filepath.Join(tmp, ".bashrc")
+	os.WriteFile(rcFile, nil, 0o644)
+	t.Setenv("SHCTL_ENVIRONMENT_D_DIR", dir)
+	t.Setenv("SHCTL_RC_FILE", rcFile)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	t.Setenv("PATH", tmp) // no systemctl to reload
+	ctx := context.Background()
+
+	if err := envscope.AddExport(ctx, "session", "EDITOR", "nvim"); err != nil {
+		t.Fatal(err)
+	}
+	if err := envscope.AddExport(ctx, "session", "PATH", "$HOME/bin:$PATH"); err != nil {
+		t.Fatal(err)
+	}
+	if err := envscope.AddExport(ctx, "session", "GREETING", `say "hi" \o/`); err != nil {
+		t.Fatal(err)
+	}
+	if err := envscope.AddExport(ctx, "session", "EDITOR", "hx"); err != nil {
+		t.Fatal(err)
+	}
+	want := "EDITOR=hx\nPATH=$HOME/bin:$PATH\nGREETING=\"say \\\"hi\\\" \\\\o/\"\n"
+	if b, _ := os.ReadFile(filepath.Join(dir, sessionenv.FileName)); string(b) != want {
+		t.Fatalf("unexpected environment.d file:\n%s", b)
+	}
+	if b, _ := os.ReadFile(rcFile); len(b) != 0 {
+		t.Fatalf("session export reached the rc file: %q", b)
+	}
+	if err := envscope.AddExport(ctx, "", "PAGER", "less"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "export PAGER=less\n" {
+		t.Fatalf("shell export missing: %q", b)
+	}
+	if err := envscope.AddExport(ctx, "galaxy", "X", "1"); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+
+	os.WriteFile(filepath.Join(dir, "90-local.conf"), []byte("# mine\nBROWSER='firefox --new-window'\n"), 0o644)
+	if err := sessionenv.Set(ctx, "BROWSER", "chromium"); !errors.Is(err, sessionenv.ErrUnmanaged) {
+		t.Fatalf("expected ErrUnmanaged, got %v", err)
+	}
+	var out strings.Builder
+	if err := sessionenv.List(&out, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "EDITOR=hx\nPATH=$HOME/bin:$PATH\nGREETING=say \"hi\" \\o/\nBROWSER=firefox --new-window (90-local.conf:2)\n" {
+		t.Fatalf("unexpected list %q", out.String())
+	}
+
+	if err := envscope.RemoveExports(ctx, "session", []string{"GREETING", "NOPE"}); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := envscope.RemoveExports(ctx, "session", []string{"GREETING"}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, sessionenv.FileName)); string(b) != "EDITOR=hx\nPATH=$HOME/bin:$PATH\n" {
+		t.Fatalf("GREETING not removed:\n%s", b)
+	}
+}
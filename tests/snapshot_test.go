@@ -0,0 +1,120 @@
+package tests
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	_ "github.com/yourusername/shctl/internal/doas"
+	_ "github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/sudoers"
+)
+
+func TestSnapshotCreateRestore(t *testing.T) {
+	sudoersPath := setupSudoers(t, "")
+	dir := filepath.Dir(sudoersPath)
+	dropins := filepath.Join(dir, "sudoers.d")
+	os.MkdirAll(dropins, 0o755)
+	os.WriteFile(filepath.Join(dropins, "ops"), []byte("ops ALL=(root) /usr/bin/id\n"), 0o440)
+	os.WriteFile(sudoersPath, []byte("root ALL=(ALL) ALL\n@includedir "+dropins+"\n"), 0o440)
+	rcFile := filepath.Join(dir, ".bashrc")
+	os.WriteFile(rcFile, []byte("alias ll='ls -l'\n"), 0o644)
+	t.Setenv("BASM_RC_FILE", rcFile)
+	t.Setenv("SHCTL_DOAS_FILE", filepath.Join(dir, "doas.conf")) // absent: skipped
+
+	if names := strings.Join(snapshot.Subsystems(), ","); names != "cron,cron-system,doas,editor,git,hosts,launchd,rc,sessionenv,ssh,sudoers,sysenv,systemd,tmux" {
+		t.Fatalf("unexpected subsystems %s", names)
+	}
+	if _, err := snapshot.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshot.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	list, err := snapshot.List(context.Background())
+	if err != nil || len(list) != 1 {
+		t.Fatalf("expected one snapshot, got %+v, %v", list, err)
+	}
+	_, m, _, err := snapshot.Load(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Files) != 3 {
+		t.Fatalf("expected rc, sudoers and drop-in, got %+v", m.Files)
+	}
+
+	os.WriteFile(rcFile, []byte("broken\n"), 0o644)
+	os.WriteFile(filepath.Join(dropins, "ops"), []byte("ops ALL=(ALL) ALL\n"), 0o440)
+	if err := snapshot.Restore(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcFile); string(b) != "alias ll='ls -l'\n" {
+		t.Fatalf("rc not restored: %q", b)
+	}
+	if b, _ := os.ReadFile(filepath.Join(dropins, "ops")); string(b) != "ops ALL=(root) /usr/bin/id\n" {
+		t.Fatalf("drop-in not restored: %q", b)
+	}
+}
+
+func TestSnapshotRestoreRemapped(t *testing.T) {
+	sudoersPath := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	dir := filepath.Dir(sudoersPath)
+	alice, bob := filepath.Join(dir, "alice"), filepath.Join(dir, "bob")
+	os.MkdirAll(alice, 0o755)
+	rcFile := filepath.Join(alice, ".bashrc")
+	os.WriteFile(rcFile, []byte("shopt -s histappend\nexport NOTES="+alice+"/notes\nexport OTHER="+alice+"2\n"), 0o644)
+	t.Setenv("BASM_RC_FILE", rcFile)
+	t.Setenv("SHCTL_DOAS_FILE", filepath.Join(dir, "doas.conf"))
+	if _, err := snapshot.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := snapshot.ParseMapping("relative=/x"); err == nil {
+		t.Fatal("expected relative mapping to be rejected")
+	}
+	mp, err := snapshot.ParseMapping(alice + "=" + bob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zshrc := filepath.Join(bob, ".zshrc")
+	t.Setenv("BASM_RC_FILE", zshrc)
+	if err := snapshot.Restore(context.Background(), 0, mp); err != nil {
+		t.Fatal(err)
+	}
+	want := "# shctl:untranslated shopt -s histappend\nexport NOTES=" + bob + "/notes\nexport OTHER=" + alice + "2\n"
+	if b, _ := os.ReadFile(zshrc); string(b) != want {
+		t.Fatalf("unexpected remapped rc:\n%s", b)
+	}
+	if b, _ := os.ReadFile(rcFile); !strings.HasPrefix(string(b), "shopt") {
+		t.Fatalf("original rc should be untouched: %q", b)
+	}
+}
+
+func TestSnapshotWithoutSudoers(t *testing.T) {
+	sudoersPath := setupSudoers(t, "")
+	dir := filepath.Dir(sudoersPath)
+	t.Setenv("BASM_SUDOERS_PATH", filepath.Join(dir, "nosuch"))
+	rcFile := filepath.Join(dir, ".bashrc")
+	os.WriteFile(rcFile, []byte("alias ll='ls -l'\n"), 0o644)
+	t.Setenv("BASM_RC_FILE", rcFile)
+	t.Setenv("SHCTL_DOAS_FILE", filepath.Join(dir, "doas.conf"))
+
+	if files, err := sudoers.Files(); err != nil || len(files) != 0 {
+		t.Fatalf("expected no sudoers files, got %v, %v", files, err)
+	}
+	managed, err := snapshot.Managed()
+	if err != nil || !slices.Contains(managed, rcFile) {
+		t.Fatalf("expected the rc file to be managed, got %v, %v", managed, err)
+	}
+	if _, err := snapshot.Create(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	_, m, _, err := snapshot.Load(context.Background(), 0)
+	if err != nil || len(m.Files) != 1 || m.Files[0].Path != rcFile {
+		t.Fatalf("expected only the rc file, got %+v, %v", m.Files, err)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/sshconf"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+const handWritten = `Include config.d/*
+
+Host legacy
+    HostName 10.0.0.9
+
+Host *
+    User fallback
+`
+
+func TestSSHHostAddEditRemove(t *testing.T) {
+	assumeYes(t)
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.Out = os.Stdout })
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "ssh", "config")
+	t.Setenv("SHCTL_SSH_CONFIG", path)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	os.MkdirAll(filepath.Join(tmp, "ssh", "config.d"), 0o700)
+	os.WriteFile(path, []byte(handWritten), 0o600)
+	os.WriteFile(filepath.Join(tmp, "ssh", "config.d", "work"), []byte("Host bastion\n  HostName bastion.example.com\n"), 0o600)
+	ctx := context.Background()
+
+	if err := sshconf.Add(ctx, "web", []string{"HostName=web.example.com", "user=deploy", "Port=2222", "ProxyJump=bastion"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sshconf.Add(ctx, "db", []string{"HostName=db.internal", "IdentityFile=~/.ssh/my key"}); err != nil {
+		t.Fatal(err)
+	}
+	want := `Include config.d/*
+
+# BEGIN shctl managed hosts
+Host web
+    HostName web.example.com
+    User deploy
+    Port 2222
+    ProxyJump bastion
+
+Host db
+    HostName db.internal
+    IdentityFile "~/.ssh/my key"
+# END shctl managed hosts
+
+Host legacy
+    HostName 10.0.0.9
+
+Host *
+    User fallback
+`
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Fatalf("unexpected config:\n%s", b)
+	}
+	for _, name := range []string{"bastion", "legacy", "web"} {
+		if err := sshconf.Add(ctx, name, nil); !errors.Is(err, util.ErrEntryExists) {
+			t.Fatalf("%s: expected ErrEntryExists, got %v", name, err)
+		}
+	}
+	for _, opt := range []string{"Port=0", "Port=ssh", "Compression=yes", "User=a b"} {
+		if err := sshconf.Add(ctx, "new", []string{opt}); !errors.Is(err, util.ErrUsage) {
+			t.Fatalf("%s: expected a usage error, got %v", opt, err)
+		}
+	}
+
+	if err := sshconf.Edit(ctx, "web", []string{"Port=22", "ProxyJump="}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sshconf.Edit(ctx, "legacy", []string{"User=root"}); !errors.Is(err, sshconf.ErrUnmanaged) {
+		t.Fatalf("expected ErrUnmanaged, got %v", err)
+	}
+	hosts, err := sshconf.Hosts()
+	if err != nil || len(hosts) != 5 || hosts[0].Name != "bastion" || !hosts[1].Managed || hosts[1].Port != "22" || hosts[1].ProxyJump != "" {
+		t.Fatalf("unexpected hosts %+v (%v)", hosts, err)
+	}
+
+	var out strings.Builder
+	if err := sshconf.List(&out, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "web -> deploy@web.example.com:22\n") ||
+		!strings.Contains(out.String(), "legacy -> 10.0.0.9 ("+path+":14)\n") {
+		t.Fatalf("unexpected list:\n%s", out.String())
+	}
+
+	if err := sshconf.Remove(ctx, []string{"web"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sshconf.Remove(ctx, []string{"web"}); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	items, err := trash.Items()
+	if err != nil || len(items) != 1 {
+		t.Fatalf("unexpected trash %+v (%v)", items, err)
+	}
+	if err := trash.Restore(ctx, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if h, err := sshconf.Hosts(); err != nil || h[2].Name != "web" || !h[2].Managed || h[2].User != "deploy" {
+		t.Fatalf("web not restored: %+v (%v)", h, err)
+	}
+}
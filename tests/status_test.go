@@ -0,0 +1,70 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/auditlog"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/snapshot"
+	"github.com/yourusername/shctl/internal/status"
+)
+
+func TestStatus(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	setupSudoers(t, "root ALL=(ALL) ALL\n")
+	os.WriteFile(rcPath, []byte("alias ll='ls -l'\nexport EDITOR=vim\n"), 0o644)
+	ctx := context.Background()
+
+	auditlog.Start([]string{"shctl", "rc", "alias", "add", "gs", "git status"})
+	if err := auditlog.Done(rc.AddAliasWithArgs(ctx, "gs", "git status {{1}}"), false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshot.Create(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := status.Collect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f *status.File
+	for i := range s.Files {
+		if s.Files[i].Path == rcPath {
+			f = &s.Files[i]
+		}
+	}
+	if f == nil || f.Counts == nil || *f.Counts != (rc.Counts{Aliases: 1, Exports: 1, Functions: 1}) {
+		t.Fatalf("unexpected rc status: %+v", f)
+	}
+	if f.LastBackup == nil || f.EditedOutside != "no" {
+		t.Fatalf("expected a backup and no outside edit: %+v", f)
+	}
+	if s.Snapshot == nil || len(s.Drift) != 0 {
+		t.Fatalf("expected no drift right after a snapshot: %+v", s)
+	}
+
+	os.WriteFile(rcPath, []byte("alias ll='ls -la'\n"), 0o644)
+	s, err = status.Collect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := status.Write(&buf, "text", s); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{rcPath, "yes", "1 file(s) drifted", "modified  " + rcPath} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("status output lacks %q:\n%s", want, out)
+		}
+	}
+}
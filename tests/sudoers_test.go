@@ -0,0 +1,749 @@
+package tests
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yourusername/shctl/internal/backup"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/sudoers"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// assumeYes answers every confirmation of the test with yes, as --yes
+// does.
+func assumeYes(t *testing.T) {
+	t.Helper()
+	prompt.AssumeYes = true
+	t.Cleanup(func() { prompt.AssumeYes = false })
+}
+
+// setupSudoers points the sudoers package at a scratch file and puts a
+// stub visudo that accepts everything on PATH.
+func setupSudoers(t *testing.T, content string) string {
+	t.Helper()
+	tmp := t.TempDir()
+	bin := filepath.Join(tmp, "bin")
+	if err := os.MkdirAll(bin, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bin, "visudo"), []byte("#!/bin/sh\nexit 0\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	path := filepath.Join(tmp, "sudoers")
+	if err := os.WriteFile(path, []byte(content), 0o440); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("BASM_SUDOERS_PATH", path)
+	t.Setenv("BASM_BACKUP_DIR", tmp)
+	t.Setenv("SHCTL_GRANTS_FILE", filepath.Join(tmp, "grants", "sudoers-grants.json"))
+	prompt.AssumeYes = true
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.AssumeYes = false; prompt.Out = os.Stdout })
+	return path
+}
+
+func TestParseSudoers(t *testing.T) {
+	src := "# comment\nDefaults env_reset\nroot ALL=(ALL:ALL) ALL\n" +
+		"alice, bob ALL = (root) NOPASSWD: /usr/bin/apt update, \\\n  /usr/bin/apt upgrade # trailing\n" +
+		"Cmnd_Alias PKG = /usr/bin/apt\n@includedir /etc/sudoers.d\n"
+	entries, err := sudoers.Parse(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 5 {
+		t.Fatalf("expected 5 entries, got %d: %+v", len(entries), entries)
+	}
+	r := entries[2]
+	if r.Kind != sudoers.KindRule || r.Line != 4 || r.EndLine != 5 {
+		t.Fatalf("unexpected rule entry %+v", r)
+	}
+	if strings.Join(r.Users, "|") != "alice|bob" || r.RunAs != "root" || strings.Join(r.Tags, "|") != "NOPASSWD" {
+		t.Fatalf("unexpected rule fields %+v", r)
+	}
+	if strings.Join(r.Commands, "|") != "/usr/bin/apt update|/usr/bin/apt upgrade" {
+		t.Fatalf("unexpected commands %q", r.Commands)
+	}
+}
+
+func TestRemoveStructured(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\nalice, bob ALL=(ALL) /usr/bin/id, /usr/bin/who\nbob ALL=(ALL) /usr/bin/id\n")
+
+	if err := sudoers.Remove(context.Background(), "alice", "/usr/bin/id"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	want := "root ALL=(ALL) ALL\nbob ALL=(ALL) /usr/bin/id, /usr/bin/who\nalice ALL=(ALL) /usr/bin/who\nbob ALL=(ALL) /usr/bin/id\n"
+	if string(b) != want {
+		t.Fatalf("unexpected sudoers after Remove:\n%s", b)
+	}
+
+	if err := sudoers.RemoveNumber(context.Background(), 4); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(path)
+	if strings.Count(string(b), "bob") != 1 {
+		t.Fatalf("unexpected sudoers after RemoveNumber:\n%s", b)
+	}
+
+	if err := sudoers.Remove(context.Background(), "carol", "/usr/bin/id"); err == nil {
+		t.Fatal("expected error removing a rule that does not exist")
+	}
+}
+
+func TestGrantExpire(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	// the reaper runs as root: grant state must not follow the user
+	t.Setenv("BASM_STATE_DIR", t.TempDir())
+	grants := filepath.Join(t.TempDir(), "lib", "sudoers-grants.json")
+	t.Setenv("SHCTL_GRANTS_FILE", grants)
+
+	spec := sudoers.GrantSpec{Users: []string{"alice"}, NoPasswd: true, Commands: []string{"/usr/bin/systemctl restart nginx"}, TTL: time.Hour}
+	if err := sudoers.Grant(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if !strings.Contains(string(b), "alice ALL=(ALL) NOPASSWD: /usr/bin/systemctl restart nginx") {
+		t.Fatalf("grant not written:\n%s", b)
+	}
+
+	if fi, err := os.Stat(grants); err != nil || fi.Mode().Perm() != 0o600 {
+		t.Fatalf("grant state not recorded privately in %s: %v", grants, err)
+	}
+	t.Setenv("BASM_STATE_DIR", t.TempDir())
+	if revoked, err := sudoers.Expire(context.Background(), time.Now()); err != nil || len(revoked) != 0 {
+		t.Fatalf("nothing should expire yet, got %v, %v", revoked, err)
+	}
+	revoked, err := sudoers.Expire(context.Background(), time.Now().Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revoked) != 1 {
+		t.Fatalf("expected one revoked grant, got %v", revoked)
+	}
+	b, _ = os.ReadFile(path)
+	if strings.Contains(string(b), "alice") {
+		t.Fatalf("expired grant still present:\n%s", b)
+	}
+
+	if err := sudoers.Grant(context.Background(), sudoers.GrantSpec{Users: []string{"alice"}, Commands: []string{"systemctl"}}); err == nil {
+		t.Fatal("expected relative command path to be rejected")
+	}
+
+	// a grant that cannot be recorded would never expire, so it is taken back
+	dir := t.TempDir()
+	broken := filepath.Join(dir, "broken")
+	if err := os.Symlink(filepath.Join(dir, "missing"), broken); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SHCTL_GRANTS_FILE", filepath.Join(broken, "sudoers-grants.json"))
+	if err := sudoers.Grant(context.Background(), spec); err == nil {
+		t.Fatal("expected a grant that cannot be recorded to fail")
+	}
+	b, _ = os.ReadFile(path)
+	if strings.Contains(string(b), "alice") {
+		t.Fatalf("unrecorded grant left in place:\n%s", b)
+	}
+}
+
+func TestInstallReaper(t *testing.T) {
+	setupSudoers(t, "root ALL=(ALL) ALL\n")
+	cronDir := t.TempDir()
+	t.Setenv("SHCTL_CRON_DIR", cronDir)
+	out := &strings.Builder{}
+	prompt.Out = out
+	if err := sudoers.InstallReaper(context.Background(), "/usr/local/bin/shctl", false); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(filepath.Join(cronDir, "shctl-sudoers-expire"))
+	if err != nil || string(b) != sudoers.ReaperCron("/usr/local/bin/shctl") {
+		t.Fatalf("reaper not installed: %q, %v", b, err)
+	}
+	if !strings.Contains(out.String(), "+*/5 * * * * root") {
+		t.Fatalf("reaper installed without showing the diff:\n%s", out)
+	}
+
+	// a binary path with spaces or % stays one word
+	if line := sudoers.ReaperCron("/opt/my tools/shctl%1"); line != "*/5 * * * * root '/opt/my tools/shctl\\%1' sudoers expire --yes\n" {
+		t.Fatalf("unexpected cron line %q", line)
+	}
+	service, _ := sudoers.ReaperSystemd("/opt/my tools/shctl%1")
+	if !strings.Contains(service, `ExecStart="/opt/my tools/shctl%%1" sudoers expire --yes`) {
+		t.Fatalf("unexpected service:\n%s", service)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\nalice ALL=(ALL) NOPASSWD: ALL\nbob ALL=(root) /usr/bin/*\n"+
+		"carol ALL=(ALL) /usr/bin/id, NOPASSWD: ALL\n")
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	findings, err := sudoers.Audit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := map[string]string{}
+	for _, f := range findings {
+		got[f.Message] = f.Severity
+	}
+	for msg, sev := range map[string]string{
+		"NOPASSWD: ALL grants passwordless root to alice":                            sudoers.SeverityHigh,
+		"NOPASSWD: ALL grants passwordless root to carol":                            sudoers.SeverityHigh,
+		"wildcard in command path /usr/bin/*":                                        sudoers.SeverityMedium,
+		"no Defaults secure_path; commands resolve through the invoking user's PATH": sudoers.SeverityMedium,
+		"world-readable sudoers file (mode -rw-r--r--)":                              sudoers.SeverityMedium,
+	} {
+		if got[msg] != sev {
+			t.Errorf("missing %s finding %q in %+v", sev, msg, findings)
+		}
+	}
+}
+
+func TestListFollowsIncludes(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n@includedir sudoers.d\n#include extra\n")
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(filepath.Join(dir, "sudoers.d"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range map[string]string{
+		"sudoers.d/10-alice":  "alice ALL=(ALL) /usr/bin/id\n",
+		"sudoers.d/skip.conf": "mallory ALL=(ALL) ALL\n",
+		"extra":               "bob ALL=(ALL) /usr/bin/who\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o440); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var buf strings.Builder
+	if err := sudoers.List(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := path + ":1: root ALL=(ALL) ALL\n" +
+		filepath.Join(dir, "sudoers.d", "10-alice") + ":1: alice ALL=(ALL) /usr/bin/id\n" +
+		filepath.Join(dir, "extra") + ":1: bob ALL=(ALL) /usr/bin/who\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected list output:\n%s", buf.String())
+	}
+}
+
+func TestCanFile(t *testing.T) {
+	path := setupSudoers(t, "Cmnd_Alias PKG = /usr/bin/apt, /usr/bin/dpkg\n"+
+		"User_Alias OPS = alice, bob\n"+
+		"OPS ALL=(root) NOPASSWD: PKG, /usr/bin/systemctl restart *\n"+
+		"bob ALL=(ALL) !/usr/bin/dpkg\n")
+
+	cases := []struct {
+		user, cmd string
+		allowed   bool
+	}{
+		{"alice", "/usr/bin/apt install vim", true},
+		{"alice", "/usr/bin/systemctl restart nginx", true},
+		{"alice", "/usr/bin/systemctl stop nginx", false},
+		{"bob", "/usr/bin/dpkg -i x.deb", false},
+		{"carol", "/usr/bin/apt", false},
+	}
+	for _, c := range cases {
+		d, err := sudoers.CanFile(path, c.user, c.cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Allowed != c.allowed {
+			t.Errorf("%s %q: allowed=%v (%s)", c.user, c.cmd, d.Allowed, d.Reason)
+		}
+	}
+	d, _ := sudoers.CanFile(path, "alice", "/usr/bin/apt")
+	if !d.NoPasswd || d.RunAs != "root" || d.Rule == nil || d.Rule.Line != 3 {
+		t.Fatalf("unexpected decision %+v", d)
+	}
+
+	if _, err := sudoers.CanFile(path, "alice", "  \t "); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected ErrUsage for a blank command, got %v", err)
+	}
+
+	// tags carry forward to later commands only, until the opposite tag
+	path = setupSudoers(t, "alice ALL=(root) /bin/a, NOPASSWD: /bin/b, /bin/c, PASSWD: /bin/d\n")
+	for cmd, nopasswd := range map[string]bool{"/bin/a": false, "/bin/b": true, "/bin/c": true, "/bin/d": false} {
+		d, err := sudoers.CanFile(path, "alice", cmd)
+		if err != nil || !d.Allowed || d.NoPasswd != nopasswd {
+			t.Errorf("%s: allowed=%v nopasswd=%v, want nopasswd=%v (%v)", cmd, d.Allowed, d.NoPasswd, nopasswd, err)
+		}
+	}
+
+	// aliases that refer to each other match nothing instead of recursing forever
+	path = setupSudoers(t, "User_Alias A = B\nUser_Alias B = A\nA ALL=(ALL) ALL\n")
+	if d, err := sudoers.CanFile(path, "alice", "/usr/bin/id"); err != nil || d.Allowed {
+		t.Fatalf("alias cycle: allowed=%v (%v)", d.Allowed, err)
+	}
+}
+
+func TestValidateFileFallback(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good")
+	os.WriteFile(good, []byte("Defaults env_reset\nCmnd_Alias PKG = /usr/bin/apt\nroot ALL=(ALL:ALL) ALL\n%admin ALL=(ALL) NOPASSWD: PKG, !/usr/bin/su\n"), 0o440)
+	if err := sudoers.ValidateFile(good); err != nil {
+		t.Fatalf("valid file rejected: %v", err)
+	}
+
+	bad := filepath.Join(dir, "bad")
+	os.WriteFile(bad, []byte("root ALL=(ALL ALL\nalice ALL=(ALL) apt\nbob ALL=(ALL) TOOLS\ncarol ALL\n"), 0o440)
+	err := sudoers.ValidateFile(bad)
+	if err == nil {
+		t.Fatal("broken file accepted")
+	}
+	for _, want := range []string{":1: syntax error", ":2: command \"apt\"", ":3: Cmnd_Alias TOOLS", ":4: syntax error"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in %v", want, err)
+		}
+	}
+
+	// commands that are empty once tags and negation are stripped
+	empty := filepath.Join(dir, "empty")
+	os.WriteFile(empty, []byte("alice ALL=(ALL) NOPASSWD:\nbob ALL=(ALL) !\n"), 0o440)
+	err = sudoers.ValidateFile(empty)
+	if err == nil {
+		t.Fatal("empty commands accepted")
+	}
+	for _, want := range []string{":1: syntax error", ":2: syntax error"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("missing %q in %v", want, err)
+		}
+	}
+}
+
+func TestChangeRequestBundle(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	dir := t.TempDir()
+
+	if _, err := sudoers.GenerateKey(filepath.Join(dir, "bob")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sudoers.GenerateKey(filepath.Join(dir, "alice")); err != nil {
+		t.Fatal(err)
+	}
+	bobPub, _ := os.ReadFile(filepath.Join(dir, "bob.pub"))
+	alicePub, _ := os.ReadFile(filepath.Join(dir, "alice.pub"))
+	os.WriteFile(filepath.Join(dir, "trusted"), []byte("# approvers\nbob "+string(bobPub)+"alice "+string(alicePub)), 0o644)
+	trusted, err := sudoers.LoadTrustedKeys(filepath.Join(dir, "trusted"))
+	if err != nil || len(trusted) != 2 {
+		t.Fatalf("trusted keys: %v %v", trusted, err)
+	}
+	key, err := sudoers.LoadPrivateKey(filepath.Join(dir, "bob"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	aliceKey, err := sudoers.LoadPrivateKey(filepath.Join(dir, "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// an approver cannot name someone else as the requester and approve alone
+	forged, err := sudoers.RequestAdd("bob ALL=(ALL) NOPASSWD: ALL", "on-call", "alice", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := forged.Approve("bob", key); err != nil {
+		t.Fatal(err)
+	}
+	if err := sudoers.ApplyBundle(context.Background(), forged, trusted); !errors.Is(err, sudoers.ErrUntrustedKey) {
+		t.Fatalf("expected a request signed with another's key to be refused, got %v", err)
+	}
+
+	b, err := sudoers.RequestAdd("alice ALL=(ALL) /usr/bin/id", "on-call", "alice", aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteFile(filepath.Join(dir, "req.json")); err != nil {
+		t.Fatal(err)
+	}
+	b, err = sudoers.ReadBundle(filepath.Join(dir, "req.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sudoers.ApplyBundle(context.Background(), b, trusted); !errors.Is(err, sudoers.ErrNotApproved) {
+		t.Fatalf("expected unapproved bundle to be refused, got %v", err)
+	}
+	if err := b.Approve("alice", key); err == nil {
+		t.Fatal("requester approved their own change")
+	}
+	// the requester signs with their own trusted key under another name
+	if err := b.Approve("carol", aliceKey); err != nil {
+		t.Fatal(err)
+	}
+	if err := sudoers.ApplyBundle(context.Background(), b, trusted); !errors.Is(err, sudoers.ErrUntrustedKey) {
+		t.Fatalf("expected a key used under another name to be refused, got %v", err)
+	}
+	if err := b.Approve("bob", key); err != nil {
+		t.Fatal(err)
+	}
+	if err := sudoers.ApplyBundle(context.Background(), b, nil); !errors.Is(err, sudoers.ErrUntrustedKey) {
+		t.Fatalf("expected untrusted key error, got %v", err)
+	}
+
+	tampered := *b
+	tampered.Proposed = []byte("alice ALL=(ALL) NOPASSWD: ALL\n")
+	if err := sudoers.ApplyBundle(context.Background(), &tampered, trusted); !errors.Is(err, sudoers.ErrNotApproved) {
+		t.Fatalf("expected tampered bundle to fail verification, got %v", err)
+	}
+
+	// a diff that hides part of the change is refused even when signed
+	honest, err := sudoers.RequestAdd("alice ALL=(ALL) /usr/bin/id", "on-call", "alice", aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	misleading, err := sudoers.RequestAdd("alice ALL=(ALL) NOPASSWD: ALL", "on-call", "alice", aliceKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	misleading.Diff = honest.Diff
+	unsigned := *misleading
+	unsigned.RequesterSignature = nil
+	body, _ := json.Marshal(unsigned)
+	misleading.RequesterSignature = ed25519.Sign(aliceKey, body)
+	if err := misleading.Approve("bob", key); err != nil {
+		t.Fatal(err)
+	}
+	if err := sudoers.ApplyBundle(context.Background(), misleading, trusted); !errors.Is(err, sudoers.ErrNotApproved) || !strings.Contains(err.Error(), "diff") {
+		t.Fatalf("expected a bundle with a misleading diff to be refused, got %v", err)
+	}
+
+	if err := sudoers.ApplyBundle(context.Background(), b, trusted); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	if !strings.Contains(string(got), "alice ALL=(ALL) /usr/bin/id") {
+		t.Fatalf("bundle not applied:\n%s", got)
+	}
+	if err := sudoers.ApplyBundle(context.Background(), b, trusted); !errors.Is(err, sudoers.ErrStaleBundle) {
+		t.Fatalf("expected stale bundle error on reapply, got %v", err)
+	}
+}
+
+func TestSudoersAliases(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\nHost_Alias WEB = web1 : DB = db1\n")
+
+	if err := sudoers.AddAlias(context.Background(), "cmnd", "PKG", []string{"/usr/bin/apt", "/usr/bin/dpkg"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sudoers.AddAlias(context.Background(), "user", "PKG", []string{"alice"}); err == nil {
+		t.Fatal("expected duplicate alias name to be rejected")
+	}
+	if err := sudoers.Grant(context.Background(), sudoers.GrantSpec{Users: []string{"alice"}, Commands: []string{"PKG"}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sudoers.Grant(context.Background(), sudoers.GrantSpec{Users: []string{"alice"}, Commands: []string{"TOOLS"}}); err == nil {
+		t.Fatal("expected grant of undefined Cmnd_Alias to fail")
+	}
+
+	var buf strings.Builder
+	if err := sudoers.ListAliases(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := "Cmnd_Alias PKG = /usr/bin/apt, /usr/bin/dpkg\nHost_Alias DB = db1\nHost_Alias WEB = web1\n"
+	if buf.String() != want {
+		t.Fatalf("unexpected alias list:\n%s", buf.String())
+	}
+
+	if err := sudoers.RemoveAlias(context.Background(), "DB"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if !strings.Contains(string(b), "Host_Alias WEB = web1\n") || strings.Contains(string(b), "DB") {
+		t.Fatalf("unexpected sudoers after RemoveAlias:\n%s", b)
+	}
+}
+
+func TestGrantGroup(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+
+	g, err := user.LookupGroupId(strconv.Itoa(os.Getgid()))
+	if err != nil {
+		t.Skip("current group not resolvable:", err)
+	}
+	if err := sudoers.Grant(context.Background(), sudoers.GrantSpec{Group: "%" + g.Name, Commands: []string{"/usr/bin/id"}}); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if !strings.Contains(string(b), "%"+g.Name+" ALL=(ALL) /usr/bin/id") {
+		t.Fatalf("group rule not written:\n%s", b)
+	}
+	err = sudoers.Grant(context.Background(), sudoers.GrantSpec{Group: "shctl-no-such-group", Commands: []string{"/usr/bin/id"}})
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Fatalf("expected missing group error, got %v", err)
+	}
+
+	// groups are created only once the change is confirmed
+	bin := filepath.Join(t.TempDir(), "bin")
+	os.MkdirAll(bin, 0o755)
+	created := filepath.Join(bin, "created")
+	os.WriteFile(filepath.Join(bin, "groupadd"), []byte("#!/bin/sh\necho \"$1\" >> "+created+"\n"), 0o755)
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	spec := sudoers.GrantSpec{Group: "shctl-no-such-group", CreateGroup: true, Commands: []string{"/usr/bin/id"}}
+	prompt.AssumeYes = false
+	if err := sudoers.Grant(context.Background(), spec); !errors.Is(err, prompt.ErrAborted) {
+		t.Fatalf("expected ErrAborted, got %v", err)
+	}
+	if _, err := os.Stat(created); err == nil {
+		t.Fatal("groupadd ran before the change was confirmed")
+	}
+	prompt.AssumeYes = true
+	if err := sudoers.Grant(context.Background(), spec); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(created); string(b) != "shctl-no-such-group\n" {
+		t.Fatalf("groupadd not run once: %q", b)
+	}
+}
+
+func TestEditRevalidates(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	dir := t.TempDir()
+	// Remove the stub visudo so the built-in validator reports line numbers.
+	t.Setenv("PATH", "/usr/bin:/bin")
+	t.Setenv("VISUAL", "")
+
+	// First run appends a broken rule; the second run (opened at +2)
+	// records the line argument and fixes it.
+	editor := filepath.Join(dir, "editor")
+	script := "#!/bin/sh\nfor f; do :; done\n" +
+		"if grep -q BROKEN \"$f\"; then echo \"$1\" > " + filepath.Join(dir, "arg") + "; " +
+		"printf 'root ALL=(ALL) ALL\\nalice ALL=(ALL) /usr/bin/id\\n' > \"$f\"; " +
+		"else echo 'BROKEN ALL' >> \"$f\"; fi\n"
+	if err := os.WriteFile(editor, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("EDITOR", editor)
+
+	if err := sudoers.Edit(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	arg, _ := os.ReadFile(filepath.Join(dir, "arg"))
+	if strings.TrimSpace(string(arg)) != "+2" {
+		t.Fatalf("editor not reopened at failing line, got %q", arg)
+	}
+	b, _ := os.ReadFile(path)
+	if string(b) != "root ALL=(ALL) ALL\nalice ALL=(ALL) /usr/bin/id\n" {
+		t.Fatalf("unexpected sudoers after edit:\n%s", b)
+	}
+}
+
+func TestApplyTemplate(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+
+	if err := sudoers.ApplyTemplate(context.Background(), "service-restart", map[string]string{"user": "alice"}); err == nil {
+		t.Fatal("expected missing service parameter to fail")
+	}
+	if err := sudoers.ApplyTemplate(context.Background(), "service-restart", map[string]string{"user": "alice", "service": "nginx, ALL"}); err == nil {
+		t.Fatal("expected parameter with a comma to be rejected")
+	}
+	if err := sudoers.ApplyTemplate(context.Background(), "service-restart", map[string]string{"user": "%web", "service": "nginx"}); err != nil {
+		t.Fatal(err)
+	}
+	d, err := sudoers.CanFile(path, "nobody-in-web", "/usr/bin/systemctl restart nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Allowed {
+		t.Fatal("rule should only apply to %web")
+	}
+	b, _ := os.ReadFile(path)
+	if !strings.Contains(string(b), "%web ALL=(root) /usr/bin/systemctl restart nginx, /usr/bin/systemctl reload nginx") {
+		t.Fatalf("template not applied:\n%s", b)
+	}
+}
+
+func TestTemplatesRejectEscapes(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	ctx := context.Background()
+	for _, name := range []string{"log-reading", "package-management"} {
+		if err := sudoers.ApplyTemplate(ctx, name, map[string]string{"user": "alice"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, cmd := range []string{
+		"/usr/bin/cat /var/log/../../etc/shadow",
+		"/usr/bin/tail /var/log/../../etc/shadow",
+		"/usr/bin/journalctl --no-pager --file /var/log/../../etc/shadow",
+		"/usr/bin/apt-get install ./evil.deb",
+		"/usr/bin/dnf install /tmp/evil.rpm",
+	} {
+		d, err := sudoers.CanFile(path, "alice", cmd)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Allowed {
+			t.Errorf("%s: %s", cmd, d.Reason)
+		}
+	}
+	d, err := sudoers.CanFile(path, "alice", "/usr/bin/journalctl --no-pager -b")
+	if err != nil || !d.Allowed {
+		t.Fatalf("journal not readable: %+v %v", d, err)
+	}
+}
+
+func TestRootWriteMode(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs root")
+	}
+	path := setupSudoers(t, "root ALL=(ALL) ALL\n")
+	os.Chmod(path, 0o644)
+	if err := sudoers.Add(context.Background(), "alice ALL=(ALL) /usr/bin/id"); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0o440 {
+		t.Fatalf("expected 0440 after root write, got %v", fi.Mode())
+	}
+}
+
+func TestRemovePatternSelect(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\nalice ALL=(ALL) /usr/bin/id\nbob ALL=(ALL) /usr/bin/id\n")
+	prompt.AssumeYes = false
+	prompt.In = strings.NewReader("2\ny\n")
+	t.Cleanup(func() { prompt.In = os.Stdin })
+	if err := sudoers.RemovePattern(context.Background(), "/usr/bin/id"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if string(b) != "root ALL=(ALL) ALL\nalice ALL=(ALL) /usr/bin/id\n" {
+		t.Fatalf("unexpected sudoers after selective removal:\n%s", b)
+	}
+
+	prompt.In = strings.NewReader("\n")
+	if err := sudoers.RemovePattern(context.Background(), "/usr/bin/id"); !errors.Is(err, prompt.ErrAborted) {
+		t.Fatalf("expected ErrAborted when nothing is selected, got %v", err)
+	}
+}
+
+func TestPickRemove(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\nalice ALL=(ALL) /usr/bin/id\nbob ALL=(ALL) /usr/bin/id\n")
+	prompt.AssumeYes = false
+	// filter down to bob and alice, pick both, then drop alice again
+	prompt.In = strings.NewReader("usrid\nall\n1\n\ny\n")
+	t.Cleanup(func() { prompt.In = os.Stdin })
+	if err := sudoers.PickRemove(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	if string(b) != "root ALL=(ALL) ALL\nalice ALL=(ALL) /usr/bin/id\n" {
+		t.Fatalf("unexpected sudoers after picking:\n%s", b)
+	}
+
+	prompt.In = strings.NewReader("\n")
+	if err := sudoers.PickRemove(context.Background()); !errors.Is(err, prompt.ErrAborted) {
+		t.Fatalf("expected ErrAborted when nothing is picked, got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	path := setupSudoers(t, "Defaults secure_path=\"/usr/bin\"\nCmnd_Alias PKG = /usr/bin/apt\nroot ALL=(ALL) ALL\n")
+	var out strings.Builder
+	if err := sudoers.Check(context.Background(), &out); err != nil {
+		t.Fatalf("clean sudoers failed check: %v\n%s", err, out.String())
+	}
+
+	dir := filepath.Join(filepath.Dir(path), "sudoers.d")
+	os.MkdirAll(dir, 0o755)
+	os.WriteFile(filepath.Join(dir, "ops"), []byte("ops ALL=(root) PKG\nops ALL=(root) systemctl\n"), 0o440)
+	os.WriteFile(path, []byte("Cmnd_Alias PKG = /usr/bin/apt\n@includedir "+dir+"\n"), 0o440)
+	out.Reset()
+	err := sudoers.Check(context.Background(), &out)
+	if err == nil {
+		t.Fatalf("expected check to fail:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), filepath.Join(dir, "ops")+":2:") || strings.Contains(out.String(), "PKG is used") {
+		t.Fatalf("unexpected check output:\n%s", out.String())
+	}
+}
+
+func TestTimeoutAndEnvKeep(t *testing.T) {
+	path := setupSudoers(t, "Defaults env_reset, timestamp_timeout=5\nroot ALL=(ALL) ALL\n")
+	if err := sudoers.SetTimeout(context.Background(), 15); err != nil {
+		t.Fatal(err)
+	}
+	if err := sudoers.EnvKeepAdd(context.Background(), "SSH_AUTH_SOCK", "EDITOR"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sudoers.EnvKeepAdd(context.Background(), "EDITOR"); err == nil {
+		t.Fatal("expected adding a kept variable to fail")
+	}
+	kept, err := sudoers.EnvKeep()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(kept, " ") != "SSH_AUTH_SOCK EDITOR" {
+		t.Fatalf("unexpected env_keep %q", kept)
+	}
+	if err := sudoers.EnvKeepRemove(context.Background(), "EDITOR"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := os.ReadFile(path)
+	want := "Defaults env_reset, timestamp_timeout=15\nroot ALL=(ALL) ALL\n\nDefaults env_keep+=\"SSH_AUTH_SOCK\"\n"
+	if string(b) != want {
+		t.Fatalf("unexpected sudoers:\n%s", b)
+	}
+}
+
+func TestSudoersCanceledValidation(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL:ALL) ALL\n")
+	bin := filepath.Join(filepath.Dir(path), "bin")
+	os.WriteFile(filepath.Join(bin, "visudo"), []byte("#!/bin/sh\nexec sleep 30\n"), 0o755)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := sudoers.Add(ctx, "alice ALL=(ALL) /usr/bin/true"); err == nil {
+		t.Fatal("expected the canceled validation to fail")
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Fatalf("visudo was not stopped, took %s", d)
+	}
+	if b, _ := os.ReadFile(path); strings.Contains(string(b), "alice") {
+		t.Fatal("sudoers changed despite the canceled validation")
+	}
+
+	if _, err := backup.Save(ctx, path); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("backup with an expired context: %v", err)
+	}
+}
+
+func TestSudoersTypedErrors(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL:ALL) ALL\nCmnd_Alias PKG = /usr/bin/apt\n")
+
+	if err := sudoers.Add(context.Background(), "root  ALL=(ALL:ALL)   ALL"); !errors.Is(err, sudoers.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists, got %v", err)
+	}
+	if err := sudoers.AddAlias(context.Background(), "cmnd", "PKG", []string{"/usr/bin/dpkg"}); !errors.Is(err, sudoers.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists for an alias, got %v", err)
+	}
+	if err := sudoers.RemoveAlias(context.Background(), "NOPE"); !errors.Is(err, sudoers.ErrAliasNotFound) {
+		t.Fatalf("expected ErrAliasNotFound, got %v", err)
+	}
+
+	bin := filepath.Join(filepath.Dir(path), "bin")
+	os.WriteFile(filepath.Join(bin, "visudo"), []byte("#!/bin/sh\necho 'syntax error near line 3' >&2\nexit 1\n"), 0o755)
+	err := sudoers.Add(context.Background(), "alice ALL=(ALL) /usr/bin/id")
+	var verr *sudoers.ValidationError
+	if !errors.Is(err, sudoers.ErrValidationFailed) || !errors.As(err, &verr) || verr.Output != "syntax error near line 3" {
+		t.Fatalf("expected a ValidationError with visudo's output, got %v", err)
+	}
+
+	if !errors.Is(&sudoers.NeedRootError{Path: path, Err: errors.New("exit 1")}, sudoers.ErrPermission) {
+		t.Fatal("NeedRootError should match ErrPermission")
+	}
+}
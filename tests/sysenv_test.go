@@ -0,0 +1,79 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/sysenv"
+	"github.com/yourusername/shctl/internal/trash"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestSysenvSetUnset(t *testing.T) {
+	assumeYes(t)
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.Out = os.Stdout })
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "environment")
+	t.Setenv("SHCTL_ENVIRONMENT_FILE", path)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	os.WriteFile(path, []byte("PATH=\"/usr/local/bin:/usr/bin:/bin\"\n# proxy\nhttp_proxy=http://old:3128\nhttp_proxy=http://older:3128\n"), 0o644)
+	ctx := context.Background()
+
+	if err := sysenv.Set(ctx, "http_proxy", "http://proxy:3128"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysenv.Set(ctx, "GREETING", "it's here"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysenv.Set(ctx, "QUOTE", `say "hi"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := sysenv.Set(ctx, "EDITOR", "vim"); err != nil {
+		t.Fatal(err)
+	}
+	want := "PATH=\"/usr/local/bin:/usr/bin:/bin\"\n# proxy\nhttp_proxy=http://proxy:3128\n" +
+		"GREETING=\"it's here\"\nQUOTE='say \"hi\"'\nEDITOR=vim\n"
+	if b, _ := os.ReadFile(path); string(b) != want {
+		t.Fatalf("unexpected environment file:\n%s", b)
+	}
+	for _, bad := range [][2]string{{"1X", "v"}, {"X", "a\nb"}, {"X", `'"`}} {
+		if err := sysenv.Set(ctx, bad[0], bad[1]); !errors.Is(err, util.ErrUsage) {
+			t.Fatalf("%q: expected a usage error, got %v", bad, err)
+		}
+	}
+
+	var out strings.Builder
+	if err := sysenv.List(&out, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "PATH=/usr/local/bin:/usr/bin:/bin\nhttp_proxy=http://proxy:3128\nGREETING=it's here\nQUOTE=say \"hi\"\nEDITOR=vim\n" {
+		t.Fatalf("unexpected list %q", out.String())
+	}
+
+	if err := sysenv.Unset(ctx, []string{"EDITOR", "NOPE"}); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := sysenv.Unset(ctx, []string{"GREETING"}); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(path); strings.Contains(string(b), "GREETING") {
+		t.Fatalf("GREETING not removed:\n%s", b)
+	}
+	items, err := trash.Items()
+	if err != nil || len(items) == 0 {
+		t.Fatalf("nothing in the trash: %v", err)
+	}
+	if err := trash.Restore(ctx, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if vars, _ := sysenv.Vars(); vars[len(vars)-1].Name != "GREETING" || vars[len(vars)-1].Value != "it's here" {
+		t.Fatalf("GREETING not restored: %+v", vars)
+	}
+}
@@ -0,0 +1,59 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/auditlog"
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/sysfile"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestSysfileApply(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	out := &strings.Builder{}
+	prompt.Out = out
+	t.Cleanup(func() { prompt.Out = os.Stdout })
+	records := []auditlog.Record{}
+	old := auditlog.Sink
+	auditlog.Sink = func(r auditlog.Record) error { records = append(records, r); return nil }
+	t.Cleanup(func() { auditlog.Sink = old })
+	ctx := context.Background()
+
+	dest := filepath.Join(tmp, "motd")
+	os.WriteFile(dest, []byte("hello\n"), 0o644)
+	next := filepath.Join(tmp, "next")
+	os.WriteFile(next, []byte("hello\nworld\n"), 0o644)
+	copyFile := func(_ context.Context, tmp, dest string) error { return util.CopyFile(tmp, dest) }
+
+	// declined: nothing written, the refusal audited
+	if err := sysfile.Apply(ctx, "motd", "set", next, dest, copyFile); !errors.Is(err, prompt.ErrAborted) {
+		t.Fatalf("want ErrAborted without confirmation, got %v", err)
+	}
+	assumeYes(t)
+	if err := sysfile.Apply(ctx, "motd", "set", next, dest, copyFile); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(dest); string(b) != "hello\nworld\n" {
+		t.Fatalf("not written: %q", b)
+	}
+	if err := sysfile.Apply(ctx, "motd", "set", next, dest, copyFile); err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].Result != auditlog.ResultAborted || records[1].Result != auditlog.ResultApplied || records[1].Subsystem != "motd" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+	if !strings.Contains(out.String(), "+world") || !strings.Contains(out.String(), "no changes to "+dest) {
+		t.Fatalf("unexpected output:\n%s", out)
+	}
+	if strings.Contains(out.String(), "audit:") {
+		t.Fatalf("audit record echoed to the user:\n%s", out)
+	}
+}
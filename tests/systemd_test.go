@@ -0,0 +1,95 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/systemd"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+// setupSystemd points the drop-in directory at a temp dir and puts a stub
+// systemctl on PATH that records its arguments in the returned file.
+func setupSystemd(t *testing.T) string {
+	t.Helper()
+	assumeYes(t)
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.Out = os.Stdout })
+	tmp := t.TempDir()
+	calls := filepath.Join(tmp, "calls")
+	bin := filepath.Join(tmp, "bin")
+	os.Mkdir(bin, 0o755)
+	stub := "#!/bin/sh\necho \"$@\" >> " + calls + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "systemctl"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("SHCTL_SYSTEMD_DIR", filepath.Join(tmp, "system"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	return calls
+}
+
+func TestSystemdEnvSetRemove(t *testing.T) {
+	calls := setupSystemd(t)
+	ctx := context.Background()
+	if err := systemd.Set(ctx, "web", []string{"PORT=8080", "GREETING=hello world"}, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := systemd.Set(ctx, "web.service", []string{"PORT=9090"}, false); err != nil {
+		t.Fatal(err)
+	}
+	override := systemd.OverridePath("web.service")
+	b, _ := os.ReadFile(override)
+	if string(b) != "[Service]\nEnvironment=\"GREETING=hello world\"\nEnvironment=\"PORT=9090\"\n" {
+		t.Fatalf("unexpected override:\n%s", b)
+	}
+	if c, _ := os.ReadFile(calls); string(c) != "daemon-reload\ndaemon-reload\n" {
+		t.Fatalf("unexpected systemctl calls %q", c)
+	}
+
+	if err := systemd.Set(ctx, "web", []string{"TOKEN=a b"}, true); err != nil {
+		t.Fatal(err)
+	}
+	b, _ = os.ReadFile(systemd.EnvFilePath("web.service"))
+	if string(b) != "TOKEN=\"a b\"\n" {
+		t.Fatalf("unexpected env file:\n%s", b)
+	}
+	b, _ = os.ReadFile(override)
+	if !strings.Contains(string(b), "EnvironmentFile=-"+systemd.EnvFilePath("web.service")+"\n") {
+		t.Fatalf("override does not load the env file:\n%s", b)
+	}
+
+	var out strings.Builder
+	if err := systemd.List(&out, "text", ""); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "web.service GREETING=hello world\nweb.service PORT=9090\nweb.service TOKEN=a b\n" {
+		t.Fatalf("unexpected list %q", out.String())
+	}
+
+	for _, bad := range [][]string{{"NOEQUALS"}, {"1X=y"}} {
+		if err := systemd.Set(ctx, "web", bad, false); !errors.Is(err, util.ErrUsage) {
+			t.Fatalf("%q: expected a usage error, got %v", bad, err)
+		}
+	}
+	if err := systemd.Set(ctx, "daily.timer", []string{"A=b"}, false); !errors.Is(err, util.ErrUsage) {
+		t.Fatalf("expected a usage error for a timer, got %v", err)
+	}
+
+	if err := systemd.Remove(ctx, "web", []string{"PORT", "TOKEN"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := systemd.Remove(ctx, "web", []string{"PORT"}); !errors.Is(err, util.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	vars, err := systemd.Vars("web")
+	if err != nil || len(vars) != 1 || vars[0].Name != "GREETING" {
+		t.Fatalf("unexpected vars %+v (%v)", vars, err)
+	}
+}
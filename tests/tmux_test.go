@@ -0,0 +1,85 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/tmux"
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestTmuxOptionsAndBindings(t *testing.T) {
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.Out = os.Stdout })
+	tmp := t.TempDir()
+	calls := filepath.Join(tmp, "calls")
+	bin := filepath.Join(tmp, "bin")
+	os.Mkdir(bin, 0o755)
+	// a tmux with a server running
+	stub := "#!/bin/sh\necho \"$@\" >> " + calls + "\n"
+	if err := os.WriteFile(filepath.Join(bin, "tmux"), []byte(stub), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	conf := filepath.Join(tmp, ".tmux.conf")
+	os.WriteFile(conf, []byte("set -g prefix C-a\n"), 0o644)
+	t.Setenv("SHCTL_TMUX_CONF", conf)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	t.Setenv("SHCTL_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	ctx := context.Background()
+
+	if err := tmux.SetOption(ctx, "mouse", "on"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmux.SetOption(ctx, "status-right", "#{host} $USER"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmux.AddBind(ctx, "", "|", `split-window -h -c "#{pane_current_path}"`); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmux.AddBind(ctx, "root", "M-Left", "select-pane -L"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmux.AddBind(ctx, "copy-mode-vi", "v", "send -X begin-selection"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tmux.SetOption(ctx, "mouse", "off"); err != nil {
+		t.Fatal(err)
+	}
+	want := "set -g prefix C-a\n\n# BEGIN shctl tmux\nset -g mouse off\nset -g status-right \"#{host} \\$USER\"\n" +
+		"bind-key | split-window -h -c \"#{pane_current_path}\"\nbind-key -n M-Left select-pane -L\n" +
+		"bind-key -T copy-mode-vi v send -X begin-selection\n# END shctl tmux\n"
+	if b, _ := os.ReadFile(conf); string(b) != want {
+		t.Fatalf("unexpected tmux.conf:\n%s", b)
+	}
+
+	if err := tmux.AddBind(ctx, "prefix", "|", "kill-pane"); !errors.Is(err, util.ErrEntryExists) {
+		t.Fatalf("expected ErrEntryExists, got %v", err)
+	}
+	for _, bad := range []error{
+		tmux.SetOption(ctx, "Bad Option", "x"),
+		tmux.AddBind(ctx, "", "a b", "kill-pane"),
+		tmux.AddBind(ctx, "", "x", ""),
+	} {
+		if !errors.Is(bad, util.ErrUsage) {
+			t.Fatalf("expected a usage error, got %v", bad)
+		}
+	}
+
+	var out strings.Builder
+	if err := tmux.List(&out, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "mouse off\nstatus-right #{host} $USER\nprefix | -> split-window -h -c \"#{pane_current_path}\"\n"+
+		"root M-Left -> select-pane -L\ncopy-mode-vi v -> send -X begin-selection\n" {
+		t.Fatalf("unexpected list %q", out.String())
+	}
+	if c, _ := os.ReadFile(calls); strings.Count(string(c), "source-file "+conf+"\n") != 6 {
+		t.Fatalf("unexpected tmux calls %q", c)
+	}
+}
@@ -0,0 +1,116 @@
+package tests
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/util"
+)
+
+func TestShellWords(t *testing.T) {
+	cases := []struct {
+		in   string
+		want []string
+	}{
+		{"alias\tll='ls -l'", []string{"alias", "ll=ls -l"}},
+		{"  \t alias  ll=\"ls \\\"-l\\\"\"  # note", []string{"alias", `ll=ls "-l"`}},
+		{`export A=a\ b#c`, []string{"export", "A=a b#c"}},
+		{"greet() { echo hi; } # shctl:args echo hi", []string{"greet()", "{", "echo", "hi;", "}"}},
+		{"# only a comment", nil},
+		{"", nil},
+	}
+	for _, c := range cases {
+		got, err := util.Shell.Words(c.in)
+		if err != nil {
+			t.Fatalf("%q: %v", c.in, err)
+		}
+		if len(got) == 0 && len(c.want) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%q: got %q, want %q", c.in, got, c.want)
+		}
+	}
+	if _, err := util.Shell.Words("alias x='unterminated"); err != util.ErrUnterminatedQuote {
+		t.Fatalf("expected an unterminated quote error, got %v", err)
+	}
+
+	if got := util.Sudoers.StripComment(`%wheel ALL=(ALL) ALL # admins`); got != "%wheel ALL=(ALL) ALL" {
+		t.Errorf("sudoers comment not stripped: %q", got)
+	}
+	if got := util.Sudoers.StripComment(`#1000 ALL=(ALL) ALL`); got != "#1000 ALL=(ALL) ALL" {
+		t.Errorf("uid reference taken for a comment: %q", got)
+	}
+	if got := util.Sudoers.StripComment(`Defaults badpass_message="no # here"`); got != `Defaults badpass_message="no # here"` {
+		t.Errorf("quoted # taken for a comment: %q", got)
+	}
+}
+
+func FuzzShellWords(f *testing.F) {
+	for _, s := range []string{"alias ll='ls -l'", "export A=\"b c\" # x", "a\\ b\t\tc", `x="\$y"`, "#1 ALL", "'"} {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, line string) {
+		tokens, comment, err := util.Shell.Tokens(line)
+		if err != nil {
+			return
+		}
+		end := 0
+		for _, tok := range tokens {
+			if tok.Start < end || tok.End <= tok.Start || tok.End > comment {
+				t.Fatalf("%q: token %+v out of order", line, tok)
+			}
+			end = tok.End
+		}
+		if comment > len(line) {
+			t.Fatalf("%q: comment offset %d past the end", line, comment)
+		}
+		// plain words split on ASCII blanks only, as the shell does
+		if !strings.ContainsAny(line, `'"\#`) {
+			got, _ := util.Shell.Words(line)
+			want := strings.FieldsFunc(line, func(r rune) bool { return strings.ContainsRune(" \t\r\n\v\f", r) })
+			if len(got)+len(want) > 0 && !reflect.DeepEqual(got, want) {
+				t.Fatalf("%q: got %q, want %q", line, got, want)
+			}
+		}
+		// quoting every word and lexing again gives the same words
+		words, _ := util.Shell.Words(line)
+		quoted := make([]string, len(words))
+		for i, w := range words {
+			quoted[i] = util.ShellQuote(w)
+		}
+		again, err := util.Shell.Words(strings.Join(quoted, " \t"))
+		if err != nil || len(again) != len(words) {
+			t.Fatalf("%q: requoted %q lexed as %q (%v)", line, quoted, again, err)
+		}
+		for i := range words {
+			if again[i] != words[i] {
+				t.Fatalf("%q: word %d changed from %q to %q", line, i, words[i], again[i])
+			}
+		}
+	})
+}
+
+func TestShellQuote(t *testing.T) {
+	for in, want := range map[string]string{
+		"":              "''",
+		"/usr/bin/id":   "/usr/bin/id",
+		"pass:work/api": "pass:work/api",
+		"two words":     "'two words'",
+		"it's":          `'it'\''s'`,
+		"a\nrm -rf ~":   "'a\nrm -rf ~'",
+		"$(id)":         "'$(id)'",
+		"~/x":           "'~/x'",
+		"tab\there":     "'tab\there'",
+		"bell\a":        "'bell\a'",
+		"ünïcode":       "'ünïcode'",
+	} {
+		if got := util.ShellQuote(in); got != want {
+			t.Errorf("ShellQuote(%q) = %s, want %s", in, got, want)
+		}
+		if words, err := util.Shell.Words("x " + util.ShellQuote(in)); err != nil || len(words) != 2 || words[1] != in {
+			t.Errorf("%q does not survive quoting: %q, %v", in, words, err)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/sudoers"
+	"github.com/yourusername/shctl/internal/trash"
+)
+
+func TestTrashRestoresRemovedLines(t *testing.T) {
+	path := setupSudoers(t, "root ALL=(ALL) ALL\nalice ALL=(root) /usr/bin/id, /usr/bin/apt\n")
+	tmp := filepath.Dir(path)
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	os.WriteFile(rcPath, []byte("alias ll='ls -l'\nexport EDITOR=vi\n"), 0o644)
+	ctx := context.Background()
+
+	if err := rc.RemoveAlias(ctx, "ll"); err != nil {
+		t.Fatal(err)
+	}
+	if err := rc.RemoveExport(ctx, "EDITOR"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sudoers.Remove(ctx, "alice", "/usr/bin/apt"); err != nil {
+		t.Fatal(err)
+	}
+	items, err := trash.Items()
+	if err != nil || len(items) != 3 {
+		t.Fatalf("expected three trash items, got %+v, %v", items, err)
+	}
+	if it := items[2]; it.Kind != "sudoers" || it.Lines[0] != "alice ALL=(root) /usr/bin/apt" {
+		t.Fatalf("unexpected sudoers item %+v", it)
+	}
+	var buf bytes.Buffer
+	if err := trash.List(&buf); err != nil || !strings.Contains(buf.String(), "alias ll='ls -l'") {
+		t.Fatalf("list misses the alias: %q, %v", buf.String(), err)
+	}
+
+	if err := trash.Restore(ctx, items[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := trash.Restore(ctx, items[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := os.ReadFile(rcPath); string(b) != "alias ll='ls -l'\n" {
+		t.Fatalf("alias not restored: %q", b)
+	}
+	if b, _ := os.ReadFile(path); !strings.Contains(string(b), "\nalice ALL=(root) /usr/bin/apt\n") {
+		t.Fatalf("sudoers rule not restored: %q", b)
+	}
+	if items, _ := trash.Items(); len(items) != 1 || items[0].Lines[0] != "export EDITOR=vi" {
+		t.Fatalf("restored items should leave the trash, got %+v", items)
+	}
+	if err := trash.Restore(ctx, items[0].ID); !errors.Is(err, trash.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
@@ -0,0 +1,102 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yourusername/shctl/internal/prompt"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/undo"
+)
+
+func TestUndoScriptRestoresFiles(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not available")
+	}
+	tmp := t.TempDir()
+	rc := filepath.Join(tmp, "it's rc")
+	orig := "alias ll='ls -l'\nexport P=\"100%\\n\"\n\ttab\x01"
+	if err := os.WriteFile(rc, []byte(orig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	created := filepath.Join(tmp, "new")
+
+	var s undo.Script
+	if err := s.Record(rc); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Record(created); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(rc, []byte("changed\n"), 0o644)
+	os.WriteFile(created, []byte("x"), 0o644)
+
+	script := filepath.Join(tmp, "undo.sh")
+	if err := s.WriteFile(script); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := exec.Command("sh", script).CombinedOutput(); err != nil {
+		t.Fatalf("undo.sh failed: %v\n%s", err, out)
+	}
+	b, _ := os.ReadFile(rc)
+	if string(b) != orig {
+		t.Fatalf("content not restored: %q", b)
+	}
+	if fi, _ := os.Stat(rc); fi.Mode().Perm() != 0o600 {
+		t.Fatalf("mode not restored: %v", fi.Mode())
+	}
+	if _, err := os.Stat(created); !os.IsNotExist(err) {
+		t.Fatalf("created file not removed: %v", err)
+	}
+}
+
+func TestUndoLastOperations(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	prompt.AssumeYes = true
+	prompt.Out = &strings.Builder{}
+	t.Cleanup(func() { prompt.AssumeYes = false; prompt.Out = os.Stdout })
+	ctx := context.Background()
+
+	if _, err := undo.Last(ctx, 1); !errors.Is(err, undo.ErrNothing) {
+		t.Fatalf("expected ErrNothing, got %v", err)
+	}
+	rc.AddAlias(ctx, "a", "1")
+	rc.AddAlias(ctx, "b", "2")
+	if err := rc.RemoveAlias(ctx, "a"); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := undo.Last(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 1 || ops[0].Op != "rc alias remove" {
+		t.Fatalf("unexpected undone operations %+v", ops)
+	}
+	if b, _ := os.ReadFile(rcPath); string(b) != "alias a='1'\nalias b='2'\n" {
+		t.Fatalf("remove not undone: %q", b)
+	}
+	var buf bytes.Buffer
+	undo.Report(&buf, ops, "undid")
+	if !strings.HasPrefix(buf.String(), "undid #3 rc alias remove (") || !strings.Contains(buf.String(), rcPath) {
+		t.Fatalf("unexpected report %q", buf.String())
+	}
+
+	// the undo itself, the second add and the first add
+	if ops, err = undo.Last(ctx, 3); err != nil || len(ops) != 3 {
+		t.Fatalf("undo 3: %+v, %v", ops, err)
+	}
+	if b, _ := os.ReadFile(rcPath); len(b) != 0 {
+		t.Fatalf("expected the empty rc from before the first add, got %q", b)
+	}
+}
@@ -0,0 +1,98 @@
+package tests
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yourusername/shctl/internal/auditlog"
+	"github.com/yourusername/shctl/internal/rc"
+	"github.com/yourusername/shctl/internal/watch"
+)
+
+// syncBuffer is a bytes.Buffer safe to read while Watch writes to it.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWatchExternalEdits(t *testing.T) {
+	tmp := t.TempDir()
+	rcPath := filepath.Join(tmp, "rc_test")
+	t.Setenv("BASM_RC_FILE", rcPath)
+	t.Setenv("BASM_BACKUP_DIR", filepath.Join(tmp, "backups"))
+	t.Setenv("BASM_STATE_DIR", filepath.Join(tmp, "state"))
+	setupSudoers(t, "root ALL=(ALL) ALL\n")
+	os.WriteFile(rcPath, []byte("alias ll='ls -l'\n"), 0o644)
+	hookOut := filepath.Join(tmp, "hook")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var out syncBuffer
+	done := make(chan error)
+	go func() {
+		done <- watch.Watch(ctx, &out, watch.Options{
+			Interval: 10 * time.Millisecond,
+			Reapply:  true,
+			Hook:     `echo "$SHCTL_WATCH_EVENT $SHCTL_WATCH_FILE" > ` + hookOut,
+		})
+	}()
+	waitFor := func(what string, ok func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(5 * time.Second); !ok(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				cancel()
+				t.Fatalf("timed out waiting for %s; output:\n%s", what, out.String())
+			}
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// a change made through shctl is not reported and becomes the state
+	// to reapply
+	auditlog.Start([]string{"shctl", "alias", "add", "gs", "git status"})
+	if err := auditlog.Done(rc.AddAlias(context.Background(), "gs", "git status"), false); err != nil {
+		t.Fatal(err)
+	}
+	want := "alias ll='ls -l'\nalias gs='git status'\n"
+	time.Sleep(100 * time.Millisecond)
+
+	os.WriteFile(rcPath, []byte("alias ll='ls -l\n"), 0o644)
+	waitFor("the edit to be reapplied", func() bool {
+		b, _ := os.ReadFile(rcPath)
+		return string(b) == want
+	})
+	waitFor("the hook", func() bool {
+		b, _ := os.ReadFile(hookOut)
+		return string(b) == "modified "+rcPath+"\n"
+	})
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	got := out.String()
+	for _, w := range []string{rcPath + " modified outside shctl", "line 1: does not parse", "reapplied"} {
+		if !strings.Contains(got, w) {
+			t.Fatalf("watch output lacks %q:\n%s", w, got)
+		}
+	}
+	if strings.Count(got, "outside shctl") != 1 {
+		t.Fatalf("expected only the hand edit to be reported:\n%s", got)
+	}
+}